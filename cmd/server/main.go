@@ -66,21 +66,46 @@ func main() {
     r.HandleFunc("/send-email", handlers.SendEmailHandler)
 
     // ---------------- WebRTC & Chat ----------------
+    handlers.InitMessaging(db)
+    handlers.InitAttendance()
     r.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
         handlers.Handlewebrtc(w, r, db)
     })
     r.HandleFunc("/record", handlers.RecorduploadHandler).Methods("POST", "OPTIONS")
     // Add this route to main.go
 r.HandleFunc("/live-videos", handlers.LiveVideosHandler).Methods("GET")
-    r.HandleFunc("/chat/history", func(w http.ResponseWriter, r *http.Request) {
-        handlers.ChatHistory(w, r, db)
-    })
+    r.HandleFunc("/chat/history", handlers.ChatHistory)
+    r.HandleFunc("/chat/attachments", handlers.ChatAttachmentHandler).Methods("POST")
+    r.HandleFunc("/chat/media", handlers.ChatMediaHandler).Methods("GET")
+    r.HandleFunc("/chat/reports", handlers.ChatReportsHandler).Methods("GET", "POST")
+    r.HandleFunc("/chat/reports/review", handlers.ChatReportReviewHandler).Methods("POST")
+    r.HandleFunc("/chat/moderation/settings", handlers.ModerationSettingsHandler).Methods("GET", "PUT")
+    r.HandleFunc("/chat/moderation/words", handlers.ModerationWordsHandler).Methods("GET", "POST", "DELETE")
+    r.HandleFunc("/chat/moderation/mutes", handlers.ModerationMutesHandler).Methods("GET", "POST", "DELETE")
+    r.HandleFunc("/chat/moderation/audit", handlers.ModerationAuditHandler).Methods("GET")
+    r.HandleFunc("/chat/unread", handlers.ChatUnreadHandler).Methods("GET")
+    r.HandleFunc("/chat/read", handlers.ChatReadHandler).Methods("GET", "POST")
+    r.HandleFunc("/chat/export", handlers.ChatExportHandler).Methods("GET")
+    r.HandleFunc("/chat/participation", handlers.ChatParticipationHandler).Methods("GET")
+    r.HandleFunc("/meeting/attendance", handlers.MeetingAttendanceHandler).Methods("GET")
 
     // ---------------- Discussion ----------------
-    handlers.InitDiscussion(db)
     r.HandleFunc("/discussion", handlers.ServeDiscussionPage)
     r.HandleFunc("/ws_discussion", handlers.HandleDiscussionWS)
     r.HandleFunc("/discussion/history", handlers.ChatHistoryHandler_discussion)
+    r.HandleFunc("/discussion/channels", handlers.DiscussionChannelsHandler).Methods("GET", "POST")
+    r.HandleFunc("/discussion/enrollments", handlers.DiscussionEnrollHandler).Methods("POST")
+    r.HandleFunc("/discussion/edits", handlers.DiscussionEditsHandler).Methods("GET")
+    r.HandleFunc("/discussion/media", handlers.ChatMediaHandler).Methods("GET")
+
+    // ---------------- Direct Messages & Office Hours ----------------
+    r.HandleFunc("/dm", handlers.ServeDiscussionPage)
+    r.HandleFunc("/ws_dm", handlers.HandleDirectWS)
+    r.HandleFunc("/dm/history", handlers.DirectHistoryHandler).Methods("GET")
+    r.HandleFunc("/dm/conversations", handlers.DirectConversationsHandler).Methods("GET", "POST")
+    r.HandleFunc("/office-hours", handlers.OfficeHoursHandler).Methods("GET", "POST", "DELETE")
+    r.HandleFunc("/office-hours/queue", handlers.OfficeHoursQueueHandler).Methods("GET", "POST", "DELETE")
+    r.HandleFunc("/office-hours/next", handlers.OfficeHoursNextHandler).Methods("POST")
 
     // ---------------- Admin & Auth ----------------
     r.HandleFunc("/welcome", handlers.ServeWelcome)
@@ -92,9 +117,22 @@ r.HandleFunc("/live-videos", handlers.LiveVideosHandler).Methods("GET")
     r.HandleFunc("/labs", handlers.ServeLabs)
 
     // ---------------- Polls ----------------
+    handlers.InitPolls(db)
     r.HandleFunc("/polls", handlers.ServePoll)
     r.HandleFunc("/ws_poll", handlers.HandleConnectionsPoll)
-    go handlers.HandleMessagesPoll()
+    r.HandleFunc("/polls/list", handlers.PollListHandler).Methods("GET")
+    r.HandleFunc("/polls/create", handlers.PollCreateHandler).Methods("POST")
+    r.HandleFunc("/polls/close", handlers.PollCloseHandler).Methods("POST")
+    r.HandleFunc("/polls/snapshots", handlers.PollSnapshotsHandler).Methods("GET", "POST")
+    r.HandleFunc("/polls/export", handlers.PollExportHandler).Methods("GET")
+    r.HandleFunc("/polls/report", handlers.PollReportHandler).Methods("GET")
+    r.HandleFunc("/polls/history", handlers.PollHistoryHandler).Methods("GET")
+    r.HandleFunc("/quiz", handlers.ServeQuiz)
+    r.HandleFunc("/quizzes/list", handlers.QuizListHandler).Methods("GET")
+    r.HandleFunc("/quizzes/create", handlers.QuizCreateHandler).Methods("POST")
+    r.HandleFunc("/quizzes/leaderboard", handlers.QuizLeaderboardHandler).Methods("GET")
+    r.HandleFunc("/quizzes/results", handlers.QuizResultsHandler).Methods("GET")
+    r.HandleFunc("/quizzes/{action:next|end-question|finish}", handlers.QuizControlHandler).Methods("POST")
 
     // ---------------- Code Runner ----------------
     r.HandleFunc("/run", handlers.RunHandler)
@@ -103,8 +141,21 @@ r.HandleFunc("/live-videos", handlers.LiveVideosHandler).Methods("GET")
     r.HandleFunc("/elabs", handlers.Serveelabs)
 
     // ---------------- Exam ----------------
+    handlers.InitExams(db)
     r.HandleFunc("/exam", handlers.ServeExam)
     r.HandleFunc("/api/generate-exam", handlers.GenerateExamHandler)
+    r.HandleFunc("/exam/topics", handlers.ExamTopicsHandler).Methods("GET")
+    r.HandleFunc("/exam/questions", handlers.ExamQuestionsHandler).Methods("GET", "POST")
+    r.HandleFunc("/exam/questions/import", handlers.ExamImportHandler).Methods("POST")
+    r.HandleFunc("/exam/questions/export", handlers.ExamExportHandler).Methods("GET")
+    r.HandleFunc("/exam/questions/{id:[0-9]+}", handlers.ExamQuestionHandler).Methods("GET", "PUT", "DELETE")
+    r.HandleFunc("/exam/exams", handlers.ExamsHandler).Methods("GET", "POST")
+    r.HandleFunc("/exam/attempts", handlers.ExamAttemptsHandler).Methods("GET")
+    r.HandleFunc("/exam/attempts/start", handlers.ExamAttemptStartHandler).Methods("POST")
+    r.HandleFunc("/exam/attempts/{id}", handlers.ExamAttemptHandler).Methods("GET")
+    r.HandleFunc("/exam/attempts/{id}/answers", handlers.ExamAnswersHandler).Methods("PUT")
+    r.HandleFunc("/exam/attempts/{id}/submit", handlers.ExamSubmitHandler).Methods("POST")
+    r.HandleFunc("/exam/attempts/{id}/review", handlers.ExamReviewHandler).Methods("PUT")
 
     // ---------------- Home Page ----------------
     r.HandleFunc("/", handlers.ServeHome)
@@ -0,0 +1,284 @@
+package exams
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// When students see the correct answers and explanations of an attempt.
+const (
+	RevealOnSubmit = "submit" // as soon as they submit
+	RevealOnClose  = "close"  // once the exam closes
+	RevealNever    = "never"  // only their score
+)
+
+// Attempt statuses. An attempt still open when its time runs out is
+// graded on its saved answers and marked expired.
+const (
+	AttemptInProgress = "in_progress"
+	AttemptSubmitted  = "submitted"
+	AttemptExpired    = "expired"
+)
+
+const (
+	// DefaultTimeLimit is the seconds an attempt runs for unless set.
+	DefaultTimeLimit = 600
+
+	minTimeLimit   = 60
+	maxTimeLimit   = 4 * 60 * 60
+	maxAttempts    = 100
+	deadlineGrace  = 5 * time.Second // for answers in flight as time runs out
+	maxTitleLength = 200
+)
+
+var (
+	ErrExamNotFound    = errors.New("exam not found")
+	ErrAttemptNotFound = errors.New("attempt not found")
+	ErrInvalidExam     = errors.New("an exam needs a title, a blueprint, a time limit of 1 to 240 minutes and a known reveal setting, with a closing time to reveal on close")
+	ErrForbidden       = errors.New("not allowed")
+	ErrExamClosed      = errors.New("the exam is not open")
+	ErrNoAttemptsLeft  = errors.New("no attempts left for this exam")
+	ErrTimeUp          = errors.New("time is up for this attempt")
+	ErrSubmitted       = errors.New("the attempt was already submitted")
+	ErrBadAnswer       = errors.New("answer does not fit the question")
+	ErrBadReview       = errors.New("a review needs a graded question of the attempt and points up to its maximum")
+)
+
+// Exam is a staff-defined exam: a blueprint to draw each attempt's
+// questions from and the rules attempts run under. Course limits it to
+// the students of a course. MaxAttempts 0 allows any number.
+type Exam struct {
+	ID          string     `json:"id"`
+	Course      string     `json:"course,omitempty"`
+	Title       string     `json:"title"`
+	Blueprint   Blueprint  `json:"blueprint"`
+	TimeLimit   int        `json:"timeLimit"` // seconds
+	MaxAttempts int        `json:"maxAttempts"`
+	Reveal      string     `json:"reveal"`
+	OpensAt     *time.Time `json:"opensAt,omitempty"`
+	ClosesAt    *time.Time `json:"closesAt,omitempty"`
+	CreatedBy   string     `json:"createdBy"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+// normalize checks an exam's settings, filling in the defaults.
+func (e *Exam) normalize() error {
+	e.Title = strings.TrimSpace(e.Title)
+	if e.TimeLimit == 0 {
+		e.TimeLimit = DefaultTimeLimit
+	}
+	if e.Reveal == "" {
+		e.Reveal = RevealOnSubmit
+	}
+	if e.Title == "" || len(e.Title) > maxTitleLength || e.TimeLimit < minTimeLimit || e.TimeLimit > maxTimeLimit ||
+		e.MaxAttempts < 0 || e.MaxAttempts > maxAttempts ||
+		(e.Reveal != RevealOnSubmit && e.Reveal != RevealOnClose && e.Reveal != RevealNever) ||
+		(e.Reveal == RevealOnClose && e.ClosesAt == nil) ||
+		(e.OpensAt != nil && e.ClosesAt != nil && !e.ClosesAt.After(*e.OpensAt)) {
+		return ErrInvalidExam
+	}
+	if err := e.Blueprint.normalize(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Open reports whether attempts can start at now.
+func (e *Exam) Open(now time.Time) bool {
+	return (e.OpensAt == nil || !now.Before(*e.OpensAt)) && (e.ClosesAt == nil || now.Before(*e.ClosesAt))
+}
+
+// QuestionResult is the grade of one question of an attempt. NeedsReview
+// marks a grade waiting for staff to confirm or change it; Pending a code
+// answer whose test cases are still running.
+type QuestionResult struct {
+	QuestionID  int64   `json:"questionId"`
+	Points      float64 `json:"points"`
+	MaxPoints   float64 `json:"maxPoints"`
+	Correct     bool    `json:"correct"`
+	Feedback    string  `json:"feedback,omitempty"`
+	NeedsReview bool    `json:"needsReview,omitempty"`
+	Pending     bool    `json:"pending,omitempty"`
+	ReviewedBy  string  `json:"reviewedBy,omitempty"`
+}
+
+// Attempt is one student's sitting of an exam, or of a practice exam
+// without an ExamID. The server keeps the drawn questions with their
+// answers; Answers are keyed by question ID.
+type Attempt struct {
+	ID          string              `json:"id"`
+	ExamID      string              `json:"examId,omitempty"`
+	StudentID   string              `json:"studentId"`
+	Title       string              `json:"title"`
+	Questions   []Question          `json:"questions"`
+	Answers     map[string]Response `json:"answers"`
+	Status      string              `json:"status"`
+	StartedAt   time.Time           `json:"startedAt"`
+	Deadline    time.Time           `json:"deadline"`
+	SubmittedAt *time.Time          `json:"submittedAt,omitempty"`
+	Score       float64             `json:"score"`
+	MaxScore    float64             `json:"maxScore"`
+	Results     []QuestionResult    `json:"results,omitempty"`
+	Reveal      string              `json:"reveal"`
+	RevealAt    *time.Time          `json:"revealAt,omitempty"`
+}
+
+// expired reports whether the attempt is still open past its deadline.
+func (a *Attempt) expired(now time.Time) bool {
+	return a.Status == AttemptInProgress && now.After(a.Deadline.Add(deadlineGrace))
+}
+
+// question finds a question of the attempt by ID.
+func (a *Attempt) question(id string) *Question {
+	for i := range a.Questions {
+		if strconv.FormatInt(a.Questions[i].ID, 10) == id {
+			return &a.Questions[i]
+		}
+	}
+	return nil
+}
+
+// grade scores every question on the saved answers. Code answers are
+// left pending for gradeCode, which runs their test cases.
+func (a *Attempt) grade() {
+	a.Results = make([]QuestionResult, 0, len(a.Questions))
+	for _, q := range a.Questions {
+		r := a.Answers[strconv.FormatInt(q.ID, 10)]
+		if q.Kind == KindCode && strings.TrimSpace(r.Code) != "" {
+			a.Results = append(a.Results, QuestionResult{QuestionID: q.ID, MaxPoints: q.Points, Pending: true})
+			continue
+		}
+		a.Results = append(a.Results, q.Grade(r, nil))
+	}
+	a.total()
+}
+
+// gradeCode runs the test cases of the pending code answers with run and
+// returns their results by question ID.
+func (a *Attempt) gradeCode(run Runner) map[int64]QuestionResult {
+	graded := map[int64]QuestionResult{}
+	for _, r := range a.Results {
+		id := strconv.FormatInt(r.QuestionID, 10)
+		if q := a.question(id); r.Pending && q != nil {
+			graded[r.QuestionID] = q.Grade(a.Answers[id], run)
+		}
+	}
+	return graded
+}
+
+// Pending reports whether any code answer of the attempt is still being
+// graded.
+func (a *Attempt) Pending() bool {
+	for _, r := range a.Results {
+		if r.Pending {
+			return true
+		}
+	}
+	return false
+}
+
+// total adds up the score of the results.
+func (a *Attempt) total() {
+	a.Score, a.MaxScore = 0, 0
+	for _, r := range a.Results {
+		a.Score += r.Points
+		a.MaxScore += r.MaxPoints
+	}
+	a.Score = math.Round(a.Score*100) / 100
+}
+
+// NeedsReview reports whether any grade of the attempt waits for staff.
+func (a *Attempt) NeedsReview() bool {
+	for _, r := range a.Results {
+		if r.NeedsReview {
+			return true
+		}
+	}
+	return false
+}
+
+// review sets the points of a question by hand, as staffID.
+func (a *Attempt) review(questionID int64, points float64, feedback, staffID string) error {
+	for i := range a.Results {
+		r := &a.Results[i]
+		if r.QuestionID != questionID {
+			continue
+		}
+		if r.Pending || points < 0 || points > r.MaxPoints {
+			return ErrBadReview
+		}
+		r.Points, r.Correct, r.NeedsReview, r.ReviewedBy = points, points == r.MaxPoints, false, staffID
+		if feedback = strings.TrimSpace(feedback); feedback != "" {
+			r.Feedback = feedback
+		}
+		a.total()
+		return nil
+	}
+	return ErrBadReview
+}
+
+// Revealed reports whether the student may see the answers at now. An
+// attempt to be revealed on close with no closing time never is.
+func (a *Attempt) Revealed(now time.Time) bool {
+	if a.Status == AttemptInProgress {
+		return false
+	}
+	switch a.Reveal {
+	case RevealOnSubmit:
+		return true
+	case RevealOnClose:
+		return a.RevealAt != nil && !now.Before(*a.RevealAt)
+	}
+	return false
+}
+
+// AttemptView is an attempt as a student sees it. Results and answers
+// appear once revealed; the score once submitted.
+type AttemptView struct {
+	ID          string              `json:"id"`
+	ExamID      string              `json:"examId,omitempty"`
+	Title       string              `json:"title"`
+	Status      string              `json:"status"`
+	StartedAt   time.Time           `json:"startedAt"`
+	Deadline    time.Time           `json:"deadline"`
+	Remaining   int                 `json:"remainingSeconds"`
+	SubmittedAt *time.Time          `json:"submittedAt,omitempty"`
+	Questions   []QuestionView      `json:"questions"`
+	Answers     map[string]Response `json:"answers"`
+	Score       *float64            `json:"score,omitempty"`
+	MaxScore    float64             `json:"maxScore"`
+	Results     []QuestionResult    `json:"results,omitempty"`
+	Revealed    bool                `json:"revealed"`
+	RevealAt    *time.Time          `json:"revealAt,omitempty"`
+	Pending     bool                `json:"pending,omitempty"` // code answers still being graded
+}
+
+// View returns the attempt for students at now.
+func (a *Attempt) View(now time.Time) AttemptView {
+	v := AttemptView{
+		ID: a.ID, ExamID: a.ExamID, Title: a.Title, Status: a.Status, StartedAt: a.StartedAt, Deadline: a.Deadline,
+		SubmittedAt: a.SubmittedAt, Answers: a.Answers, Revealed: a.Revealed(now), Pending: a.Pending(),
+	}
+	if a.Reveal == RevealOnClose {
+		v.RevealAt = a.RevealAt
+	}
+	if a.Status == AttemptInProgress {
+		v.Remaining = max(int(a.Deadline.Sub(now).Seconds()), 0)
+		for _, q := range a.Questions {
+			v.MaxScore += q.Points
+		}
+	} else {
+		score := a.Score
+		v.Score, v.MaxScore = &score, a.MaxScore
+	}
+	if v.Revealed {
+		v.Results = a.Results
+	}
+	for _, q := range a.Questions {
+		v.Questions = append(v.Questions, q.View(v.Revealed))
+	}
+	return v
+}
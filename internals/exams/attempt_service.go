@@ -0,0 +1,317 @@
+package exams
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CreateExam stores an exam set up by staffID.
+func (s *Service) CreateExam(staffID string, e Exam) (Exam, error) {
+	if s.Store == nil {
+		return e, ErrUnavailable
+	}
+	if err := e.normalize(); err != nil {
+		return e, err
+	}
+	e.ID = "exam-" + uuid.New().String()
+	e.CreatedBy = staffID
+	return e, s.Store.CreateExam(&e)
+}
+
+// GetExam returns an exam.
+func (s *Service) GetExam(id string) (Exam, error) {
+	if s.Store == nil {
+		return Exam{}, ErrUnavailable
+	}
+	return s.Store.GetExam(id)
+}
+
+// ListExams returns the exams of a course, newest first.
+func (s *Service) ListExams(course string) ([]Exam, error) {
+	if s.Store == nil {
+		return nil, ErrUnavailable
+	}
+	return s.Store.ListExams(course)
+}
+
+// StartAttempt starts studentID on an exam while it is open, drawing
+// their questions from its blueprint, or resumes the attempt they have in
+// progress. The attempt ends at the time limit or when the exam closes,
+// whichever comes first.
+func (s *Service) StartAttempt(examID, studentID string) (Attempt, error) {
+	if s.Store == nil {
+		return Attempt{}, ErrUnavailable
+	}
+	e, err := s.Store.GetExam(examID)
+	if err != nil {
+		return Attempt{}, err
+	}
+	if a, err := s.Store.OpenAttempt(examID, studentID); err == nil {
+		if a, err = s.expire(a); err != nil || a.Status == AttemptInProgress {
+			return a, err
+		}
+	} else if !errors.Is(err, ErrAttemptNotFound) {
+		return Attempt{}, err
+	}
+	now := time.Now()
+	if !e.Open(now) {
+		return Attempt{}, ErrExamClosed
+	}
+	deadline := now.Add(time.Duration(e.TimeLimit) * time.Second)
+	if e.ClosesAt != nil && e.ClosesAt.Before(deadline) {
+		deadline = *e.ClosesAt
+	}
+	a := Attempt{ExamID: e.ID, Title: e.Title, Reveal: e.Reveal, RevealAt: e.ClosesAt, Deadline: deadline}
+	a, err = s.start(a, studentID, e.Blueprint, e.MaxAttempts)
+	if errors.Is(err, errAttemptOpen) {
+		// A concurrent start won; resume its attempt.
+		return s.Store.OpenAttempt(examID, studentID)
+	}
+	return a, err
+}
+
+// StartPractice starts studentID on a practice exam drawn from b, with
+// the default time limit. Practice draws from the same bank as real exams,
+// so its answers are never revealed; students only get their score.
+func (s *Service) StartPractice(studentID, title string, b Blueprint) (Attempt, error) {
+	if s.Store == nil {
+		return Attempt{}, ErrUnavailable
+	}
+	a := Attempt{Title: title, Reveal: RevealNever, Deadline: time.Now().Add(DefaultTimeLimit * time.Second)}
+	return s.start(a, studentID, b, 0)
+}
+
+func (s *Service) start(a Attempt, studentID string, b Blueprint, maxAttempts int) (Attempt, error) {
+	questions, err := s.Generate(b)
+	if err != nil {
+		return a, err
+	}
+	a.ID = "attempt-" + uuid.New().String()
+	a.StudentID = studentID
+	a.Questions = questions
+	a.Answers = map[string]Response{}
+	a.Status = AttemptInProgress
+	a.StartedAt = time.Now()
+	if err := s.Store.CreateAttempt(&a, maxAttempts); err != nil {
+		return a, err
+	}
+	s.watch(a)
+	return a, nil
+}
+
+// watch grades an attempt left open when its time runs out.
+func (s *Service) watch(a Attempt) {
+	s.timersMu.Lock()
+	defer s.timersMu.Unlock()
+	if s.timers == nil {
+		s.timers = make(map[string]*time.Timer)
+	}
+	s.timers[a.ID] = time.AfterFunc(time.Until(a.Deadline)+deadlineGrace, func() {
+		if _, err := s.Attempt(a.ID); err != nil {
+			log.Println("exam deadline:", err)
+		}
+	})
+}
+
+// expire grades an attempt left open past its deadline, such as across a
+// restart, on its saved answers.
+func (s *Service) expire(a Attempt) (Attempt, error) {
+	if !a.expired(time.Now()) {
+		return a, nil
+	}
+	return s.finish(a, AttemptExpired)
+}
+
+// CloseDuplicateAttempts grades and expires the older attempts a student
+// has in progress of the same exam, left from before a student could have
+// only one, then builds the index that keeps it so.
+func (s *Service) CloseDuplicateAttempts() error {
+	if s.Store == nil {
+		return ErrUnavailable
+	}
+	list, err := s.Store.DuplicateAttempts()
+	if err != nil {
+		return err
+	}
+	for _, a := range list {
+		if _, err := s.finish(a, AttemptExpired); err != nil {
+			return err
+		}
+	}
+	return s.Store.IndexOpenAttempts()
+}
+
+func (s *Service) finish(a Attempt, status string) (Attempt, error) {
+	s.timersMu.Lock()
+	if t := s.timers[a.ID]; t != nil {
+		t.Stop()
+		delete(s.timers, a.ID)
+	}
+	s.timersMu.Unlock()
+
+	now := time.Now()
+	a.grade()
+	a.Status, a.SubmittedAt = status, &now
+	if err := s.Store.FinishAttempt(&a); errors.Is(err, ErrSubmitted) {
+		return s.Store.GetAttempt(a.ID)
+	} else if err != nil {
+		return a, err
+	}
+	s.gradeLater(a)
+	return a, nil
+}
+
+// codeRuns limits how many attempts have code answers running at once.
+var codeRuns = make(chan struct{}, 2)
+
+// gradeLater runs the pending code answers of a closed attempt in the
+// background and saves their results. Attempts left pending by a restart
+// are picked up again when loaded.
+func (s *Service) gradeLater(a Attempt) {
+	if a.Status == AttemptInProgress || !a.Pending() {
+		return
+	}
+	s.gradingMu.Lock()
+	if s.grading == nil {
+		s.grading = make(map[string]bool)
+	}
+	if s.grading[a.ID] {
+		s.gradingMu.Unlock()
+		return
+	}
+	s.grading[a.ID] = true
+	s.gradingMu.Unlock()
+
+	go func() {
+		defer func() {
+			s.gradingMu.Lock()
+			delete(s.grading, a.ID)
+			s.gradingMu.Unlock()
+		}()
+		codeRuns <- struct{}{}
+		graded := a.gradeCode(s.Runner)
+		<-codeRuns
+
+		// Staff may have reviewed other questions meanwhile.
+		fresh, err := s.Store.GetAttempt(a.ID)
+		if err != nil {
+			log.Println("exam code grading:", err)
+			return
+		}
+		for i, r := range fresh.Results {
+			if res, ok := graded[r.QuestionID]; ok && r.Pending {
+				fresh.Results[i] = res
+			}
+		}
+		fresh.total()
+		if err := s.Store.SaveResults(&fresh); err != nil {
+			log.Println("exam code grading:", err)
+		}
+	}()
+}
+
+// Attempt returns an attempt, graded first if its time ran out. Code
+// answers are graded in the background and show as pending until then.
+func (s *Service) Attempt(id string) (Attempt, error) {
+	if s.Store == nil {
+		return Attempt{}, ErrUnavailable
+	}
+	a, err := s.Store.GetAttempt(id)
+	if err != nil {
+		return a, err
+	}
+	s.gradeLater(a)
+	return s.expire(a)
+}
+
+// attemptOf loads studentID's attempt in progress.
+func (s *Service) attemptOf(id, studentID string) (Attempt, error) {
+	a, err := s.Attempt(id)
+	if err != nil {
+		return a, err
+	}
+	if a.StudentID != studentID {
+		return a, ErrForbidden
+	}
+	if a.Status != AttemptInProgress {
+		return a, ErrSubmitted
+	}
+	return a, nil
+}
+
+// SaveAnswers keeps answers to an attempt in progress, replacing earlier
+// answers to the same questions. Answers after the deadline are refused.
+func (s *Service) SaveAnswers(id, studentID string, answers map[string]Response) (Attempt, error) {
+	a, err := s.attemptOf(id, studentID)
+	if err != nil {
+		return a, err
+	}
+	for qid, r := range answers {
+		q := a.question(qid)
+		if q == nil {
+			return a, ErrBadAnswer
+		}
+		if err := q.check(r); err != nil {
+			return a, err
+		}
+	}
+	if err := s.Store.SaveAnswers(id, answers, time.Now().Add(-deadlineGrace)); err != nil {
+		return a, err
+	}
+	for qid, r := range answers {
+		a.Answers[qid] = r
+	}
+	return a, nil
+}
+
+// Submit saves any last answers and grades the attempt.
+func (s *Service) Submit(id, studentID string, answers map[string]Response) (Attempt, error) {
+	a, err := s.attemptOf(id, studentID)
+	if err != nil {
+		return a, err
+	}
+	if len(answers) > 0 {
+		if a, err = s.SaveAnswers(id, studentID, answers); err != nil {
+			return a, err
+		}
+	}
+	return s.finish(a, AttemptSubmitted)
+}
+
+// Attempts returns attempts newest first, of one exam and/or one student,
+// grading any whose time ran out.
+func (s *Service) Attempts(examID, studentID string) ([]Attempt, error) {
+	if s.Store == nil {
+		return nil, ErrUnavailable
+	}
+	list, err := s.Store.Attempts(examID, studentID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list {
+		s.gradeLater(list[i])
+		if list[i], err = s.expire(list[i]); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// Review sets the points of one question of a graded attempt by hand, as
+// staffID, such as a short answer flagged for review.
+func (s *Service) Review(id, staffID string, questionID int64, points float64, feedback string) (Attempt, error) {
+	a, err := s.Attempt(id)
+	if err != nil {
+		return a, err
+	}
+	if a.Status == AttemptInProgress {
+		return a, ErrBadReview
+	}
+	if err := a.review(questionID, points, feedback, staffID); err != nil {
+		return a, err
+	}
+	return a, s.Store.SaveResults(&a)
+}
@@ -0,0 +1,300 @@
+package exams
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// errAttemptOpen is returned by CreateAttempt when the student already has
+// an attempt of the exam in progress.
+var errAttemptOpen = errors.New("an attempt of this exam is already in progress")
+
+const examColumns = `id, course_code, title, blueprint, time_limit, max_attempts, reveal, opens_at, closes_at,
+	created_by, created_at`
+
+func scanExam(row rowScanner) (Exam, error) {
+	var e Exam
+	var blueprint []byte
+	var opensAt, closesAt sql.NullTime
+	err := row.Scan(&e.ID, &e.Course, &e.Title, &blueprint, &e.TimeLimit, &e.MaxAttempts, &e.Reveal, &opensAt, &closesAt,
+		&e.CreatedBy, &e.CreatedAt)
+	if err != nil {
+		return e, err
+	}
+	if opensAt.Valid {
+		e.OpensAt = &opensAt.Time
+	}
+	if closesAt.Valid {
+		e.ClosesAt = &closesAt.Time
+	}
+	return e, json.Unmarshal(blueprint, &e.Blueprint)
+}
+
+// nullTime stores an optional time in UTC.
+func nullTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+// CreateExam stores a new exam.
+func (s *Store) CreateExam(e *Exam) error {
+	blueprint, err := json.Marshal(e.Blueprint)
+	if err != nil {
+		return err
+	}
+	return s.db.QueryRow(
+		`INSERT INTO exams (id, course_code, title, blueprint, time_limit, max_attempts, reveal, opens_at, closes_at, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING created_at`,
+		e.ID, e.Course, e.Title, blueprint, e.TimeLimit, e.MaxAttempts, e.Reveal, nullTime(e.OpensAt), nullTime(e.ClosesAt),
+		e.CreatedBy,
+	).Scan(&e.CreatedAt)
+}
+
+// GetExam loads an exam.
+func (s *Store) GetExam(id string) (Exam, error) {
+	e, err := scanExam(s.db.QueryRow(`SELECT `+examColumns+` FROM exams WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return e, ErrExamNotFound
+	}
+	return e, err
+}
+
+// ListExams returns the exams of a course, or every exam with an empty
+// course, newest first.
+func (s *Store) ListExams(course string) ([]Exam, error) {
+	rows, err := s.db.Query(
+		`SELECT `+examColumns+` FROM exams WHERE ($1 = '' OR course_code = $1) ORDER BY created_at DESC`, course,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Exam{}
+	for rows.Next() {
+		e, err := scanExam(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+const attemptColumns = `id, COALESCE(exam_id, ''), student_id, title, questions, answers, status, started_at, deadline,
+	submitted_at, score, max_score, results, reveal, reveal_at`
+
+func scanAttempt(row rowScanner) (Attempt, error) {
+	var a Attempt
+	var questions, answers, results []byte
+	var submittedAt, revealAt sql.NullTime
+	err := row.Scan(&a.ID, &a.ExamID, &a.StudentID, &a.Title, &questions, &answers, &a.Status, &a.StartedAt, &a.Deadline,
+		&submittedAt, &a.Score, &a.MaxScore, &results, &a.Reveal, &revealAt)
+	if err != nil {
+		return a, err
+	}
+	if submittedAt.Valid {
+		a.SubmittedAt = &submittedAt.Time
+	}
+	if revealAt.Valid {
+		a.RevealAt = &revealAt.Time
+	}
+	if err := json.Unmarshal(questions, &a.Questions); err != nil {
+		return a, err
+	}
+	for i := range a.Questions {
+		if a.Questions[i].Points == 0 { // drawn before questions had points
+			a.Questions[i].Points = 1
+		}
+	}
+	if err := json.Unmarshal(answers, &a.Answers); err != nil {
+		return a, err
+	}
+	if results != nil {
+		if err := json.Unmarshal(results, &a.Results); err != nil {
+			return a, err
+		}
+	}
+	return a, nil
+}
+
+// CreateAttempt stores a new attempt. For an exam it returns
+// ErrNoAttemptsLeft if the student already used maxAttempts of them (0
+// for no limit), checked in the same statement, and errAttemptOpen if
+// one is in progress. The exam_attempts_open index makes a concurrent
+// start wait for this one and then fail, so the count cannot go stale.
+func (s *Store) CreateAttempt(a *Attempt, maxAttempts int) error {
+	questions, err := json.Marshal(a.Questions)
+	if err != nil {
+		return err
+	}
+	var examID interface{}
+	if a.ExamID != "" {
+		examID = a.ExamID
+	}
+	res, err := s.db.Exec(
+		`INSERT INTO exam_attempts (id, exam_id, student_id, title, questions, status, started_at, deadline, reveal, reveal_at)
+		 SELECT $1, $2::TEXT, $3::TEXT, $4, $5, $6, $7, $8, $9, $10
+		 WHERE $11 = 0 OR (SELECT COUNT(*) FROM exam_attempts WHERE exam_id = $2::TEXT AND student_id = $3::TEXT) < $11`,
+		a.ID, examID, a.StudentID, a.Title, questions, a.Status, a.StartedAt.UTC(), a.Deadline.UTC(), a.Reveal,
+		nullTime(a.RevealAt), maxAttempts,
+	)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "exam_attempts_open" {
+		return errAttemptOpen
+	} else if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNoAttemptsLeft
+	}
+	return nil
+}
+
+// DuplicateAttempts returns the attempts of an exam in progress that a
+// newer one of the same student, from before exam_attempts_open, stands
+// in for.
+func (s *Store) DuplicateAttempts() ([]Attempt, error) {
+	rows, err := s.db.Query(
+		`SELECT ` + attemptColumns + ` FROM exam_attempts a
+		 WHERE a.status = 'in_progress' AND a.exam_id IS NOT NULL AND EXISTS (
+			SELECT 1 FROM exam_attempts b WHERE b.exam_id = a.exam_id AND b.student_id = a.student_id
+			AND b.status = 'in_progress' AND (b.started_at, b.id) > (a.started_at, a.id))`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Attempt{}
+	for rows.Next() {
+		a, err := scanAttempt(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// IndexOpenAttempts builds exam_attempts_open: a student has at most one
+// attempt of an exam in progress, which also serializes the attempt
+// limit check in CreateAttempt.
+func (s *Store) IndexOpenAttempts() error {
+	_, err := s.db.Exec(
+		`CREATE UNIQUE INDEX IF NOT EXISTS exam_attempts_open ON exam_attempts (exam_id, student_id)
+		 WHERE status = 'in_progress'`,
+	)
+	return err
+}
+
+// GetAttempt loads an attempt.
+func (s *Store) GetAttempt(id string) (Attempt, error) {
+	a, err := scanAttempt(s.db.QueryRow(`SELECT `+attemptColumns+` FROM exam_attempts WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return a, ErrAttemptNotFound
+	}
+	return a, err
+}
+
+// Attempts returns attempts newest first, of one exam and/or one student;
+// empty arguments match any.
+func (s *Store) Attempts(examID, studentID string) ([]Attempt, error) {
+	rows, err := s.db.Query(
+		`SELECT `+attemptColumns+` FROM exam_attempts
+		 WHERE ($1 = '' OR exam_id = $1) AND ($2 = '' OR student_id = $2)
+		 ORDER BY started_at DESC`, examID, studentID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Attempt{}
+	for rows.Next() {
+		a, err := scanAttempt(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// OpenAttempt returns the student's attempt of an exam still in
+// progress, or ErrAttemptNotFound.
+func (s *Store) OpenAttempt(examID, studentID string) (Attempt, error) {
+	a, err := scanAttempt(s.db.QueryRow(
+		`SELECT `+attemptColumns+` FROM exam_attempts
+		 WHERE exam_id = $1 AND student_id = $2 AND status = 'in_progress'
+		 ORDER BY started_at DESC LIMIT 1`, examID, studentID,
+	))
+	if err == sql.ErrNoRows {
+		return a, ErrAttemptNotFound
+	}
+	return a, err
+}
+
+// SaveAnswers merges answers into an attempt in progress. It returns
+// ErrTimeUp if the attempt is over or its deadline was before cutoff.
+func (s *Store) SaveAnswers(id string, answers map[string]Response, cutoff time.Time) error {
+	raw, err := json.Marshal(answers)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec(
+		`UPDATE exam_attempts SET answers = answers || $2::JSONB
+		 WHERE id = $1 AND status = 'in_progress' AND deadline >= $3`, id, raw, cutoff.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrTimeUp
+	}
+	return nil
+}
+
+// FinishAttempt stores the grade of an attempt in progress and closes it
+// as submitted or expired. It returns ErrSubmitted if it was already
+// closed.
+func (s *Store) FinishAttempt(a *Attempt) error {
+	results, err := json.Marshal(a.Results)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec(
+		`UPDATE exam_attempts SET status = $2, submitted_at = $3, score = $4, max_score = $5, results = $6
+		 WHERE id = $1 AND status = 'in_progress'`,
+		a.ID, a.Status, a.SubmittedAt.UTC(), a.Score, a.MaxScore, results,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrSubmitted
+	}
+	return nil
+}
+
+// SaveResults stores the regraded results and score of a closed attempt.
+func (s *Store) SaveResults(a *Attempt) error {
+	results, err := json.Marshal(a.Results)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec(
+		`UPDATE exam_attempts SET score = $2, max_score = $3, results = $4 WHERE id = $1 AND status <> 'in_progress'`,
+		a.ID, a.Score, a.MaxScore, results,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrAttemptNotFound
+	}
+	return nil
+}
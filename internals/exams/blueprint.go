@@ -0,0 +1,72 @@
+package exams
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MaxExamQuestions caps the length of a generated exam.
+const MaxExamQuestions = 100
+
+var (
+	ErrInvalidBlueprint = errors.New("a blueprint needs 1 to 100 questions in sections with a known difficulty and Bloom level")
+	ErrNotEnough        = errors.New("the question bank does not have enough questions for this blueprint")
+)
+
+// Section asks for Count questions matching its filters; empty filters
+// match any question.
+type Section struct {
+	Topic      string `json:"topic"`
+	Difficulty string `json:"difficulty,omitempty"`
+	Bloom      string `json:"bloomLevel,omitempty"`
+	Tag        string `json:"tag,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+	Count      int    `json:"count"`
+}
+
+func (s Section) String() string {
+	parts := []string{}
+	for _, p := range []string{s.Difficulty, s.Bloom, s.Topic, s.Kind} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if s.Tag != "" {
+		parts = append(parts, "#"+s.Tag)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Blueprint describes an exam by how many questions to draw of each kind.
+// Questions keep the order of their sections unless Shuffle is set; a
+// question is never drawn twice.
+type Blueprint struct {
+	Sections []Section `json:"sections"`
+	Shuffle  bool      `json:"shuffle"`
+}
+
+// normalize checks a blueprint and files its filters the way questions
+// are.
+func (b *Blueprint) normalize() error {
+	total := 0
+	for i := range b.Sections {
+		s := &b.Sections[i]
+		s.Topic, s.Tag = Topic(s.Topic), Topic(s.Tag)
+		s.Difficulty, s.Bloom, s.Kind = strings.ToLower(s.Difficulty), strings.ToLower(s.Bloom), strings.ToLower(s.Kind)
+		if s.Count < 1 || (s.Difficulty != "" && s.Difficulty != Easy && s.Difficulty != Medium && s.Difficulty != Hard) ||
+			(s.Bloom != "" && !validBloom(s.Bloom)) {
+			return ErrInvalidBlueprint
+		}
+		total += s.Count
+	}
+	if total == 0 || total > MaxExamQuestions {
+		return ErrInvalidBlueprint
+	}
+	return nil
+}
+
+// notEnough reports which section the bank could not fill.
+func notEnough(s Section, found int) error {
+	return fmt.Errorf("%w: %d of %d %s questions", ErrNotEnough, found, s.Count, strings.TrimSpace(s.String()))
+}
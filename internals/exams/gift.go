@@ -0,0 +1,362 @@
+package exams
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// GIFT escapes its special characters with a backslash. Parsing swaps
+// escaped ones for private-use runes, so the markup can be split on the
+// plain ones, and swaps them back in the text it keeps. An escaped newline
+// stays one until then, so it cannot end a question or start a comment.
+var giftEscapes = strings.NewReplacer(
+	`\\`, "\uE000", `\~`, "\uE001", `\=`, "\uE002", `\#`, "\uE003",
+	`\{`, "\uE004", `\}`, "\uE005", `\:`, "\uE006", `\n`, "\uE007",
+)
+
+var giftUnescapes = strings.NewReplacer(
+	"\uE000", `\`, "\uE001", "~", "\uE002", "=", "\uE003", "#", "\uE004", "{", "\uE005", "}", "\uE006", ":",
+	"\uE007", "\n",
+)
+
+var giftSpecial = strings.NewReplacer(
+	`\`, `\\`, "~", `\~`, "=", `\=`, "#", `\#`, "{", `\{`, "}", `\}`, ":", `\:`, "\n", `\n`,
+)
+
+var (
+	giftMarkup = regexp.MustCompile(`^\[(html|moodle|plain|markdown)\]`)
+	giftWeight = regexp.MustCompile(`^%(-?[0-9.]+)%`)
+)
+
+// giftText unescapes a piece of GIFT, dropping its markup format and
+// turning HTML into plain text.
+func giftText(s string) string {
+	s = strings.TrimSpace(s)
+	markup := giftMarkup.FindString(s)
+	s = giftUnescapes.Replace(strings.TrimPrefix(s, markup))
+	if markup == "[html]" {
+		return plainText(s)
+	}
+	return strings.TrimSpace(s)
+}
+
+// giftBlocks splits GIFT into its questions, blank-line separated as
+// Moodle reads them, with the $CATEGORY each is under. Comment lines are
+// dropped.
+func giftBlocks(src string) (blocks, categories []string) {
+	category := ""
+	var cur []string
+	flush := func() {
+		if block := strings.TrimSpace(strings.Join(cur, "\n")); block != "" {
+			blocks = append(blocks, block)
+			categories = append(categories, category)
+		}
+		cur = nil
+	}
+	for _, line := range strings.Split(src, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "//"):
+			continue
+		case strings.HasPrefix(trimmed, "$CATEGORY:"):
+			flush()
+			category = giftText(strings.TrimPrefix(trimmed, "$CATEGORY:"))
+			continue
+		case trimmed == "":
+			flush()
+			continue
+		}
+		cur = append(cur, line)
+	}
+	flush()
+	return blocks, categories
+}
+
+// parseGIFT reads questions in Moodle's GIFT format: choice, true/false,
+// multiple answer (by weights), short answer, numeric, matching and
+// essay questions, filed under their $CATEGORY.
+func parseGIFT(data []byte) ([]imported, error) {
+	src := string(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	src = giftEscapes.Replace(strings.ReplaceAll(src, "\r\n", "\n"))
+	blocks, categories := giftBlocks(src)
+	if len(blocks) > MaxImportQuestions {
+		return nil, errTooManyQuestions
+	}
+	parsed := []imported{}
+	for i, block := range blocks {
+		p := imported{}
+		if strings.HasPrefix(block, "::") {
+			if end := strings.Index(block[2:], "::"); end >= 0 {
+				p.Name = giftText(block[2 : end+2])
+				block = block[end+4:]
+			}
+		}
+		p.Question, p.Err = giftQuestion(block)
+		if p.Err == nil {
+			p.Question.Topic = categoryTopic(categories[i])
+		}
+		if p.Name == "" {
+			p.Name = p.Question.Question
+		}
+		if p.Name == "" {
+			p.Name = giftText(strings.SplitN(block, "\n", 2)[0])
+		}
+		parsed = append(parsed, p)
+	}
+	return parsed, nil
+}
+
+// giftAnswer is one answer of a GIFT answer block.
+type giftAnswer struct {
+	Correct  bool    // marked =
+	Weight   float64 // percent, 100 when marked = without one
+	Text     string
+	Feedback string
+}
+
+// giftQuestion reads one question: its text around an answer block.
+func giftQuestion(block string) (Question, error) {
+	q := Question{}
+	open := strings.Index(block, "{")
+	shut := strings.LastIndex(block, "}")
+	if open < 0 || shut < open {
+		return q, fmt.Errorf("%w: no answer block in braces", ErrInvalidQuestion)
+	}
+	q.Question = giftText(block[:open])
+	if after := giftText(block[shut+1:]); after != "" {
+		q.Question += " _____ " + after
+	}
+	body := strings.TrimSpace(block[open+1 : shut])
+
+	if i := strings.Index(body, "####"); i >= 0 {
+		q.Explanation = giftText(body[i+4:])
+		body = strings.TrimSpace(body[:i])
+	}
+
+	switch upper := strings.ToUpper(strings.TrimSpace(strings.SplitN(body, "#", 2)[0])); {
+	case body == "":
+		q.Kind, q.ManualReview = KindShort, true
+		return q, nil
+	case upper == "T" || upper == "TRUE" || upper == "F" || upper == "FALSE":
+		q.Kind, q.Options = KindSingle, []string{"True", "False"}
+		if upper[0] == 'F' {
+			q.CorrectAnswer = 1
+		}
+		return q, nil
+	case strings.HasPrefix(body, "#"):
+		return q, giftNumeric(&q, body[1:])
+	}
+
+	answers := []giftAnswer{}
+	for _, part := range splitKeep(body, "=~") {
+		a := giftAnswer{Correct: part[0] == '='}
+		text := part[1:]
+		if m := giftWeight.FindStringSubmatch(text); m != nil {
+			a.Weight, _ = strconv.ParseFloat(m[1], 64)
+			text = text[len(m[0]):]
+		} else if a.Correct {
+			a.Weight = 100
+		}
+		if i := strings.Index(text, "#"); i >= 0 {
+			a.Feedback = giftText(text[i+1:])
+			text = text[:i]
+		}
+		a.Text = strings.TrimSpace(text)
+		answers = append(answers, a)
+	}
+	if len(answers) == 0 {
+		return q, fmt.Errorf("%w: the answer block has no answers", ErrInvalidQuestion)
+	}
+
+	wrong, matching := 0, 0
+	for _, a := range answers {
+		if !a.Correct {
+			wrong++
+		}
+		if strings.Contains(a.Text, "->") {
+			matching++
+		}
+	}
+	switch {
+	case matching > 0:
+		q.Kind = KindMatching
+		for _, a := range answers {
+			pair := strings.SplitN(a.Text, "->", 2)
+			if len(pair) != 2 || !a.Correct {
+				return q, fmt.Errorf("%w: every matching answer needs =option -> match", ErrInvalidQuestion)
+			}
+			q.Options = append(q.Options, giftText(pair[0]))
+			q.Matches = append(q.Matches, giftText(pair[1]))
+		}
+	case wrong == 0:
+		q.Kind = KindShort
+		for _, a := range answers {
+			if a.Weight >= 100 {
+				q.Patterns = append(q.Patterns, exactPattern(giftText(a.Text)))
+			}
+		}
+	default:
+		correct := []int{}
+		feedback := []string{}
+		for i, a := range answers {
+			q.Options = append(q.Options, giftText(a.Text))
+			if a.Weight > 0 {
+				correct = append(correct, i)
+				if a.Feedback != "" {
+					feedback = append(feedback, a.Feedback)
+				}
+			}
+		}
+		if len(correct) == 1 && answers[correct[0]].Weight >= 100 {
+			q.Kind, q.CorrectAnswer = KindSingle, correct[0]
+		} else {
+			q.Kind, q.CorrectAnswers = KindMultiple, correct
+		}
+		if q.Explanation == "" {
+			q.Explanation = strings.Join(feedback, " ")
+		}
+	}
+	return q, nil
+}
+
+// splitKeep splits s before each of the marker bytes, dropping anything
+// before the first.
+func splitKeep(s, markers string) []string {
+	parts := []string{}
+	start := -1
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(markers, s[i]) >= 0 {
+			if start >= 0 {
+				parts = append(parts, s[start:i])
+			}
+			start = i
+		}
+	}
+	if start >= 0 {
+		parts = append(parts, s[start:])
+	}
+	return parts
+}
+
+// giftNumeric reads a numeric answer block: n, n:tolerance or min..max,
+// or several =answers of which the first full-credit one counts.
+func giftNumeric(q *Question, body string) error {
+	q.Kind = KindNumeric
+	body = strings.TrimSpace(body)
+	if strings.HasPrefix(body, "=") {
+		for _, part := range splitKeep(body, "=~") {
+			if part[0] == '=' && !giftWeight.MatchString(part[1:]) {
+				body = part[1:]
+				break
+			}
+		}
+	}
+	if i := strings.Index(body, "#"); i >= 0 {
+		body = body[:i]
+	}
+	body = strings.TrimSpace(body)
+	bad := fmt.Errorf("%w: a numeric answer needs n, n:tolerance or min..max", ErrInvalidQuestion)
+	if lo, hi, ok := strings.Cut(body, ".."); ok {
+		from, err1 := strconv.ParseFloat(strings.TrimSpace(lo), 64)
+		to, err2 := strconv.ParseFloat(strings.TrimSpace(hi), 64)
+		if err1 != nil || err2 != nil || to < from {
+			return bad
+		}
+		answer := math.Round((from+to)/2*1e9) / 1e9
+		q.Answer, q.Tolerance = &answer, math.Round((to-from)/2*1e9)/1e9
+		return nil
+	}
+	n, tol, _ := strings.Cut(giftUnescapes.Replace(body), ":")
+	answer, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+	if err != nil {
+		return bad
+	}
+	q.Answer = &answer
+	if tol = strings.TrimSpace(tol); tol != "" {
+		if q.Tolerance, err = strconv.ParseFloat(tol, 64); err != nil {
+			return bad
+		}
+	}
+	return nil
+}
+
+// giftFloat writes a number the way GIFT reads it.
+func giftFloat(f float64) string {
+	return strconv.FormatFloat(math.Round(f*1e5)/1e5, 'f', -1, 64)
+}
+
+// writeGIFT writes questions in GIFT, filed under a $CATEGORY per topic.
+// GIFT has no form for ordering or code questions, numeric answers with
+// units, nor for short answers graded by anything but exact answers.
+func writeGIFT(questions []Question) ([]byte, []Skipped) {
+	var b strings.Builder
+	skipped := []Skipped{}
+	category := ""
+	for i := range questions {
+		q := &questions[i]
+		var answers []string
+		switch q.Kind {
+		case KindSingle:
+			for j, o := range q.Options {
+				mark := "~"
+				if j == q.CorrectAnswer {
+					mark = "="
+				}
+				answers = append(answers, mark+giftSpecial.Replace(o))
+			}
+		case KindMultiple:
+			right := 100 / float64(len(q.CorrectAnswers))
+			wrong := -100.0
+			if others := len(q.Options) - len(q.CorrectAnswers); others > 0 {
+				wrong = -100 / float64(others)
+			}
+			for j, o := range q.Options {
+				weight := wrong
+				if containsInt(q.CorrectAnswers, j) {
+					weight = right
+				}
+				answers = append(answers, "~%"+giftFloat(weight)+"%"+giftSpecial.Replace(o))
+			}
+		case KindNumeric:
+			if len(q.Units) > 0 {
+				skipped = append(skipped, Skipped{ID: q.ID, Error: "numeric answers with units cannot be written as gift"})
+				continue
+			}
+			answers = []string{"#" + giftFloat(*q.Answer) + ":" + giftFloat(q.Tolerance)}
+		case KindShort:
+			exact, ok := exactAnswers(q)
+			if !ok || (len(exact) == 0 && !q.ManualReview) {
+				skipped = append(skipped, Skipped{ID: q.ID, Error: "short answers graded by keywords or patterns cannot be written as gift"})
+				continue
+			}
+			for _, a := range exact {
+				answers = append(answers, "="+giftSpecial.Replace(a))
+			}
+		case KindMatching:
+			for j, o := range q.Options {
+				answers = append(answers, "="+giftSpecial.Replace(o)+" -> "+giftSpecial.Replace(q.Matches[j]))
+			}
+		default:
+			skipped = append(skipped, unsupported(q, FormatGIFT))
+			continue
+		}
+		if q.Explanation != "" {
+			answers = append(answers, "####"+giftSpecial.Replace(q.Explanation))
+		}
+
+		if q.Topic != category {
+			category = q.Topic
+			fmt.Fprintf(&b, "$CATEGORY: %s\n\n", giftSpecial.Replace(category))
+		}
+		fmt.Fprintf(&b, "::Q%d:: %s {\n", q.ID, giftSpecial.Replace(q.Question))
+		for _, a := range answers {
+			fmt.Fprintf(&b, "\t%s\n", a)
+		}
+		b.WriteString("}\n\n")
+	}
+	return []byte(b.String()), skipped
+}
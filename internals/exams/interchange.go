@@ -0,0 +1,306 @@
+package exams
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Formats the question bank imports from and exports to, as Moodle and
+// other LMSs write them.
+const (
+	FormatGIFT   = "gift"   // Moodle's GIFT text format
+	FormatMoodle = "moodle" // Moodle XML
+	FormatQTI    = "qti"    // IMS QTI 2.1, an item file or a content package zip
+)
+
+// Import statuses of a question.
+const (
+	ImportAdded     = "added"
+	ImportReady     = "ready" // would be added, in a dry run
+	ImportDuplicate = "duplicate"
+	ImportInvalid   = "invalid"
+)
+
+// MaxImportQuestions caps the questions of one import.
+const MaxImportQuestions = 1000
+
+// errTooManyQuestions stops a parser as soon as a file has more than
+// MaxImportQuestions questions.
+var errTooManyQuestions = fmt.Errorf("%w: more than %d questions", ErrBadImport, MaxImportQuestions)
+
+var (
+	ErrUnknownFormat = errors.New("format must be gift, moodle or qti")
+	ErrBadImport     = errors.New("the file could not be read")
+)
+
+// imported is a question read from a file, or why it could not be.
+type imported struct {
+	Name     string
+	Question Question
+	Err      error
+}
+
+// ImportItem reports what became of one question of an imported file.
+// Index counts questions from 1 in file order.
+type ImportItem struct {
+	Index       int       `json:"index"`
+	Name        string    `json:"name,omitempty"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	DuplicateOf int64     `json:"duplicateOf,omitempty"` // a bank question, or 0 for an earlier one of the file
+	Question    *Question `json:"question,omitempty"`
+}
+
+// ImportReport is the outcome of an import, or of a dry run previewing it.
+type ImportReport struct {
+	Format     string       `json:"format"`
+	DryRun     bool         `json:"dryRun"`
+	Added      int          `json:"added"`
+	Ready      int          `json:"ready"`
+	Duplicates int          `json:"duplicates"`
+	Invalid    int          `json:"invalid"`
+	Items      []ImportItem `json:"items"`
+}
+
+// ImportOptions tune an import. Topic files the questions a file gives no
+// category for; duplicates of bank questions are skipped unless
+// KeepDuplicates is set.
+type ImportOptions struct {
+	Topic          string
+	DryRun         bool
+	KeepDuplicates bool
+}
+
+// Skipped is a question an export left out, since its format cannot hold
+// it.
+type Skipped struct {
+	ID    int64  `json:"id"`
+	Error string `json:"error"`
+}
+
+// Export is a question bank written in a format.
+type Export struct {
+	Data        []byte
+	ContentType string
+	Ext         string
+	Skipped     []Skipped
+}
+
+// DetectFormat guesses the format of an uploaded file from its content,
+// then its name.
+func DetectFormat(name string, data []byte) string {
+	head := data[:min(len(data), 4096)]
+	switch {
+	case bytes.HasPrefix(data, []byte("PK")), bytes.Contains(head, []byte("<assessmentItem")):
+		return FormatQTI
+	case bytes.Contains(head, []byte("<quiz")):
+		return FormatMoodle
+	case strings.HasSuffix(strings.ToLower(name), ".zip"):
+		return FormatQTI
+	case strings.HasSuffix(strings.ToLower(name), ".xml"):
+		return FormatMoodle
+	}
+	return FormatGIFT
+}
+
+// questionKey is what two questions share when one duplicates the other:
+// topic, kind and text, ignoring case and spacing.
+func questionKey(q *Question) string {
+	return q.Topic + "|" + q.Kind + "|" + strings.ToLower(strings.Join(strings.Fields(q.Question), " "))
+}
+
+// Import reads questions in format into the bank, authored by staffID.
+// Every question is reported on its own: added (or ready, in a dry run),
+// a duplicate of a bank question or of an earlier one of the file, or
+// invalid with the reason. Only an unreadable file fails as a whole.
+func (s *Service) Import(staffID, format string, data []byte, opts ImportOptions) (ImportReport, error) {
+	report := ImportReport{Format: format, DryRun: opts.DryRun, Items: []ImportItem{}}
+	if s.Store == nil {
+		return report, ErrUnavailable
+	}
+	var parsed []imported
+	var err error
+	switch format {
+	case FormatGIFT:
+		parsed, err = parseGIFT(data)
+	case FormatMoodle:
+		parsed, err = parseMoodle(data)
+	case FormatQTI:
+		parsed, err = parseQTI(data)
+	default:
+		return report, ErrUnknownFormat
+	}
+	if err != nil {
+		return report, err
+	}
+
+	topics := []string{}
+	for i := range parsed {
+		q := &parsed[i].Question
+		if q.Topic == "" {
+			q.Topic = opts.Topic
+		}
+		if parsed[i].Err == nil && Topic(q.Topic) == "" {
+			parsed[i].Err = fmt.Errorf("%w: the file gives no category and no topic was given", ErrInvalidQuestion)
+		}
+		if parsed[i].Err == nil {
+			parsed[i].Err = q.normalize()
+		}
+		topics = append(topics, q.Topic)
+	}
+	existing, err := s.Store.Keys(topics)
+	if err != nil {
+		return report, err
+	}
+
+	seen := make(map[string]bool)
+	for i, p := range parsed {
+		item := ImportItem{Index: i + 1, Name: p.Name}
+		q := p.Question
+		q.CreatedBy = staffID
+		key := questionKey(&q)
+		switch {
+		case p.Err != nil:
+			item.Status, item.Error = ImportInvalid, p.Err.Error()
+			report.Invalid++
+		case existing[key] != 0 && !opts.KeepDuplicates:
+			item.Status, item.DuplicateOf = ImportDuplicate, existing[key]
+			report.Duplicates++
+		case seen[key]:
+			item.Status = ImportDuplicate
+			report.Duplicates++
+		case opts.DryRun:
+			item.Status = ImportReady
+			report.Ready++
+		default:
+			if err := s.Store.Create(&q); err != nil {
+				return report, err
+			}
+			item.Status = ImportAdded
+			report.Added++
+		}
+		if p.Err == nil {
+			seen[key] = true
+			item.Question = &q
+		}
+		report.Items = append(report.Items, item)
+	}
+	return report, nil
+}
+
+// Export writes the questions matching f in format. Questions the format
+// has no form for are left out and listed in Skipped.
+func (s *Service) Export(format string, f Filter) (Export, error) {
+	if s.Store == nil {
+		return Export{}, ErrUnavailable
+	}
+	if format != FormatGIFT && format != FormatMoodle && format != FormatQTI {
+		return Export{}, ErrUnknownFormat
+	}
+	questions, err := s.List(f)
+	if err != nil {
+		return Export{}, err
+	}
+	switch format {
+	case FormatGIFT:
+		data, skipped := writeGIFT(questions)
+		return Export{Data: data, ContentType: "text/plain; charset=utf-8", Ext: "gift", Skipped: skipped}, nil
+	case FormatMoodle:
+		data, skipped, err := writeMoodle(questions)
+		return Export{Data: data, ContentType: "application/xml", Ext: "xml", Skipped: skipped}, err
+	default:
+		data, skipped, err := writeQTI(questions)
+		return Export{Data: data, ContentType: "application/zip", Ext: "zip", Skipped: skipped}, err
+	}
+}
+
+// exactPattern is a short answer pattern accepting just answer.
+func exactPattern(answer string) string {
+	return "^" + regexp.QuoteMeta(strings.TrimSpace(answer)) + "$"
+}
+
+var quotedMeta = regexp.MustCompile(`\\(.)`)
+
+// exactAnswers returns the answers a short answer question's patterns
+// accept, if each is an exactPattern.
+func exactAnswers(q *Question) ([]string, bool) {
+	answers := []string{}
+	for _, p := range q.Patterns {
+		if !strings.HasPrefix(p, "^") || !strings.HasSuffix(p, "$") {
+			return nil, false
+		}
+		answer := quotedMeta.ReplaceAllString(p[1:len(p)-1], "$1")
+		if exactPattern(answer) != p {
+			return nil, false
+		}
+		answers = append(answers, answer)
+	}
+	return answers, len(q.Keywords) == 0
+}
+
+// wildcardPattern turns a Moodle short answer, where * matches anything,
+// into a pattern.
+func wildcardPattern(answer string) string {
+	parts := strings.Split(strings.TrimSpace(answer), "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	return "^" + strings.Join(parts, ".*") + "$"
+}
+
+var (
+	htmlBreak = regexp.MustCompile(`(?i)<\s*(br|/p|/div|/li|/h[1-6])\s*/?\s*>`)
+	htmlTag   = regexp.MustCompile(`<[^>]*>`)
+)
+
+// plainText turns HTML, as Moodle and QTI hold question text, into plain
+// text.
+func plainText(s string) string {
+	s = htmlBreak.ReplaceAllString(s, "\n")
+	s = html.UnescapeString(htmlTag.ReplaceAllString(s, ""))
+	lines := []string{}
+	for _, l := range strings.Split(s, "\n") {
+		if l = strings.Join(strings.Fields(l), " "); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// categoryTopic files questions of a category path like $course$/top/AI
+// under its last part.
+func categoryTopic(path string) string {
+	parts := strings.Split(strings.TrimSpace(path), "/")
+	return Topic(parts[len(parts)-1])
+}
+
+// metaTags are the tags exports carry difficulty and Bloom level in, for
+// formats without fields of their own for them.
+func metaTags(q *Question) []string {
+	return []string{"difficulty-" + q.Difficulty, "bloom-" + q.Bloom}
+}
+
+// applyMetaTags reads difficulty and Bloom level back out of the tags.
+func applyMetaTags(q *Question) {
+	tags := []string{}
+	for _, t := range q.Tags {
+		switch tag := Topic(t); {
+		case strings.HasPrefix(tag, "difficulty-"):
+			q.Difficulty = strings.TrimPrefix(tag, "difficulty-")
+		case strings.HasPrefix(tag, "bloom-"):
+			q.Bloom = strings.TrimPrefix(tag, "bloom-")
+		default:
+			tags = append(tags, t)
+		}
+	}
+	q.Tags = tags
+}
+
+// unsupported reports a question kind a format has no form for.
+func unsupported(q *Question, format string) Skipped {
+	return Skipped{ID: q.ID, Error: fmt.Sprintf("%s questions cannot be written as %s", q.Kind, format)}
+}
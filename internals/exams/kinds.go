@@ -0,0 +1,398 @@
+package exams
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"regexp"
+	"strings"
+)
+
+// Languages code questions can be written in, as the code runner runs them.
+var Languages = []string{"python", "c", "go"}
+
+const (
+	maxTestCases   = 20
+	maxPatterns    = 20
+	maxUnitLength  = 50
+	maxTextAnswer  = 2000
+	maxCodeAnswer  = 64 * 1024
+	maxStarterCode = 16 * 1024
+)
+
+// Spec is the answer key of the kinds beyond single choice, which keeps
+// its key in CorrectAnswer. Only the fields of a question's kind are kept.
+type Spec struct {
+	CorrectAnswers []int      `json:"correctAnswers,omitempty"` // multiple: every correct option
+	Answer         *float64   `json:"answer,omitempty"`         // numeric
+	Tolerance      float64    `json:"tolerance,omitempty"`      // numeric: how far off still counts
+	Units          []string   `json:"units,omitempty"`          // numeric: accepted spellings of the unit
+	Patterns       []string   `json:"patterns,omitempty"`       // short: regexps of full-credit answers
+	Keywords       []string   `json:"keywords,omitempty"`       // short: words earning partial credit
+	ManualReview   bool       `json:"manualReview,omitempty"`   // short: staff review every answer
+	Matches        []string   `json:"matches,omitempty"`        // matching: the match of each option
+	Language       string     `json:"language,omitempty"`       // code
+	StarterCode    string     `json:"starterCode,omitempty"`    // code: shown to students to start from
+	TestCases      []TestCase `json:"testCases,omitempty"`      // code
+}
+
+// TestCase is a run of a code question's program: Output is what it must
+// print given Input. Hidden test cases are only shown once revealed.
+type TestCase struct {
+	Input  string `json:"input"`
+	Output string `json:"output"`
+	Hidden bool   `json:"hidden,omitempty"`
+}
+
+// Runner runs a program in a language on stdin, returning what it
+// printed. An error means it did not compile or run to completion.
+type Runner func(language, code, stdin string) (string, error)
+
+// distinct reports whether every string of list is different.
+func distinct(list []string) bool {
+	seen := make(map[string]bool)
+	for _, s := range list {
+		if seen[strings.ToLower(s)] {
+			return false
+		}
+		seen[strings.ToLower(s)] = true
+	}
+	return true
+}
+
+// trimAll trims every string of list, dropping empty ones.
+func trimAll(list []string) []string {
+	out := []string{}
+	for _, s := range list {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// normalizeKey checks the answer key of a question's kind, dropping the
+// fields of other kinds.
+func (q *Question) normalizeKey() error {
+	spec := q.Spec
+	q.Spec = Spec{}
+	n := len(q.Options)
+	switch q.Kind {
+	case KindSingle:
+		if n < 2 || n > MaxOptions || q.CorrectAnswer < 0 || q.CorrectAnswer >= n {
+			return ErrInvalidQuestion
+		}
+		return nil
+
+	case KindMultiple:
+		if n < 2 || n > MaxOptions || len(spec.CorrectAnswers) == 0 {
+			return ErrInvalidQuestion
+		}
+		picked := make(map[int]bool)
+		for _, c := range spec.CorrectAnswers {
+			if c < 0 || c >= n || picked[c] {
+				return ErrInvalidQuestion
+			}
+			picked[c] = true
+		}
+		q.CorrectAnswers = spec.CorrectAnswers
+
+	case KindNumeric:
+		if n != 0 || spec.Answer == nil || math.IsNaN(*spec.Answer) || math.IsInf(*spec.Answer, 0) ||
+			spec.Tolerance < 0 || math.IsInf(spec.Tolerance, 0) {
+			return ErrInvalidQuestion
+		}
+		q.Answer, q.Tolerance, q.Units = spec.Answer, spec.Tolerance, trimAll(spec.Units)
+
+	case KindShort:
+		q.Patterns, q.Keywords, q.ManualReview = trimAll(spec.Patterns), trimAll(spec.Keywords), spec.ManualReview
+		if n != 0 || len(q.Patterns) > maxPatterns || len(q.Keywords) > maxPatterns ||
+			(len(q.Patterns) == 0 && len(q.Keywords) == 0 && !q.ManualReview) {
+			return ErrInvalidQuestion
+		}
+		for _, p := range q.Patterns {
+			if _, err := regexp.Compile("(?i)" + p); err != nil {
+				return fmt.Errorf("%w: bad pattern %q", ErrInvalidQuestion, p)
+			}
+		}
+
+	case KindMatching:
+		q.Matches = trimAll(spec.Matches)
+		if n < 2 || n > MaxOptions || len(q.Matches) != n || !distinct(q.Options) || !distinct(q.Matches) {
+			return ErrInvalidQuestion
+		}
+
+	case KindOrdering:
+		if n < 2 || n > MaxOptions || !distinct(q.Options) {
+			return ErrInvalidQuestion
+		}
+
+	case KindCode:
+		q.Language, q.StarterCode, q.TestCases = strings.ToLower(spec.Language), spec.StarterCode, spec.TestCases
+		known := false
+		for _, l := range Languages {
+			known = known || l == q.Language
+		}
+		if n != 0 || !known || len(q.TestCases) == 0 || len(q.TestCases) > maxTestCases ||
+			len(q.StarterCode) > maxStarterCode {
+			return ErrInvalidQuestion
+		}
+
+	default:
+		return ErrInvalidQuestion
+	}
+	q.CorrectAnswer = 0
+	return nil
+}
+
+// Response is a student's answer to one question, in the field of its
+// kind. Matches gives the match picked for each option in turn; Order
+// lists the options in the order given.
+type Response struct {
+	Choice  *int     `json:"choice,omitempty"`
+	Choices []int    `json:"choices,omitempty"`
+	Number  *float64 `json:"number,omitempty"`
+	Unit    string   `json:"unit,omitempty"`
+	Text    string   `json:"text,omitempty"`
+	Matches []string `json:"matches,omitempty"`
+	Order   []string `json:"order,omitempty"`
+	Code    string   `json:"code,omitempty"`
+}
+
+// check reports whether r is an answer to q.
+func (q *Question) check(r Response) error {
+	ok := true
+	switch q.Kind {
+	case KindSingle:
+		ok = r.Choice == nil || (*r.Choice >= 0 && *r.Choice < len(q.Options))
+	case KindMultiple:
+		picked := make(map[int]bool)
+		for _, c := range r.Choices {
+			ok = ok && c >= 0 && c < len(q.Options) && !picked[c]
+			picked[c] = true
+		}
+	case KindNumeric:
+		ok = (r.Number == nil || (!math.IsNaN(*r.Number) && !math.IsInf(*r.Number, 0))) && len(r.Unit) <= maxUnitLength
+	case KindShort:
+		ok = len(r.Text) <= maxTextAnswer
+	case KindMatching:
+		ok = r.Matches == nil || len(r.Matches) == len(q.Options)
+		for _, m := range r.Matches {
+			ok = ok && (m == "" || indexOf(q.Matches, m) >= 0)
+		}
+	case KindOrdering:
+		ok = r.Order == nil || (len(r.Order) == len(q.Options) && distinct(r.Order))
+		for _, o := range r.Order {
+			ok = ok && indexOf(q.Options, o) >= 0
+		}
+	case KindCode:
+		ok = len(r.Code) <= maxCodeAnswer
+	}
+	if !ok {
+		return ErrBadAnswer
+	}
+	return nil
+}
+
+func indexOf(list []string, s string) int {
+	for i, l := range list {
+		if strings.EqualFold(l, strings.TrimSpace(s)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Grade scores r out of the question's points, with partial credit for
+// the kinds that have it. Short answers the key does not settle are
+// flagged for staff review; run runs code questions' test cases.
+func (q *Question) Grade(r Response, run Runner) QuestionResult {
+	res := QuestionResult{QuestionID: q.ID, MaxPoints: q.Points}
+	credit := 0.0
+	switch q.Kind {
+	case KindSingle:
+		if r.Choice != nil && *r.Choice == q.CorrectAnswer {
+			credit = 1
+		}
+
+	case KindMultiple:
+		right, wrong := 0, 0
+		for _, c := range r.Choices {
+			if containsInt(q.CorrectAnswers, c) {
+				right++
+			} else {
+				wrong++
+			}
+		}
+		credit = float64(right) / float64(len(q.CorrectAnswers))
+		if others := len(q.Options) - len(q.CorrectAnswers); others > 0 {
+			credit -= float64(wrong) / float64(others)
+		}
+		res.Feedback = fmt.Sprintf("%d of %d correct options picked, %d wrong", right, len(q.CorrectAnswers), wrong)
+
+	case KindNumeric:
+		switch {
+		case r.Number == nil:
+		case math.Abs(*r.Number-*q.Answer) > q.Tolerance+1e-9:
+			res.Feedback = "Outside the accepted range"
+		case len(q.Units) > 0 && indexOf(q.Units, r.Unit) < 0:
+			res.Feedback = "Missing or wrong unit"
+		default:
+			credit = 1
+		}
+
+	case KindShort:
+		text := strings.Join(strings.Fields(r.Text), " ")
+		if text == "" {
+			break
+		}
+		for _, p := range q.Patterns {
+			if regexp.MustCompile("(?i)" + p).MatchString(text) {
+				credit = 1
+			}
+		}
+		if credit < 1 && len(q.Keywords) > 0 {
+			found := 0
+			for _, k := range q.Keywords {
+				if strings.Contains(strings.ToLower(text), strings.ToLower(k)) {
+					found++
+				}
+			}
+			credit = float64(found) / float64(len(q.Keywords))
+			res.Feedback = fmt.Sprintf("%d of %d keywords found", found, len(q.Keywords))
+		}
+		res.NeedsReview = q.ManualReview || credit < 1
+
+	case KindMatching:
+		right := 0
+		for i, m := range r.Matches {
+			if indexOf(q.Matches, m) == i {
+				right++
+			}
+		}
+		credit = float64(right) / float64(len(q.Options))
+		res.Feedback = fmt.Sprintf("%d of %d matched", right, len(q.Options))
+
+	case KindOrdering:
+		right := 0
+		for i, o := range r.Order {
+			if indexOf(q.Options, o) == i {
+				right++
+			}
+		}
+		credit = float64(right) / float64(len(q.Options))
+		res.Feedback = fmt.Sprintf("%d of %d in place", right, len(q.Options))
+
+	case KindCode:
+		if strings.TrimSpace(r.Code) == "" {
+			break
+		}
+		if run == nil {
+			res.NeedsReview, res.Feedback = true, "The code could not be run"
+			break
+		}
+		passed := 0
+		for _, tc := range q.TestCases {
+			if out, err := run(q.Language, r.Code, tc.Input); err == nil && sameOutput(out, tc.Output) {
+				passed++
+			}
+		}
+		credit = float64(passed) / float64(len(q.TestCases))
+		res.Feedback = fmt.Sprintf("%d of %d tests passed", passed, len(q.TestCases))
+	}
+	res.Points = math.Round(max(credit, 0)*q.Points*100) / 100
+	res.Correct = res.Points == res.MaxPoints
+	return res
+}
+
+func containsInt(list []int, n int) bool {
+	for _, l := range list {
+		if l == n {
+			return true
+		}
+	}
+	return false
+}
+
+// sameOutput compares program output ignoring trailing whitespace of each
+// line and around the whole.
+func sameOutput(got, want string) bool {
+	clean := func(s string) string {
+		lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+		for i, l := range lines {
+			lines[i] = strings.TrimRight(l, " \t")
+		}
+		return strings.TrimSpace(strings.Join(lines, "\n"))
+	}
+	return clean(got) == clean(want)
+}
+
+// shuffled returns list in an order fixed by the question, so a student
+// sees the same order on every load.
+func (q *Question) shuffled(list []string) []string {
+	out := make([]string, len(list))
+	perm := rand.New(rand.NewSource(q.ID)).Perm(len(list))
+	if len(list) > 1 && perm[0] == 0 {
+		perm[0], perm[1] = perm[1], perm[0]
+	}
+	for i, p := range perm {
+		out[i] = list[p]
+	}
+	return out
+}
+
+// QuestionView is a question as a student sees it: the answer key and
+// explanation only once they are revealed. Ordering options and matches
+// come shuffled.
+type QuestionView struct {
+	ID             int64      `json:"id"`
+	Kind           string     `json:"kind"`
+	Question       string     `json:"question"`
+	Options        []string   `json:"options"`
+	Matches        []string   `json:"matches,omitempty"`
+	Points         float64    `json:"points"`
+	Language       string     `json:"language,omitempty"`
+	StarterCode    string     `json:"starterCode,omitempty"`
+	TestCases      []TestCase `json:"testCases,omitempty"`
+	CorrectAnswer  *int       `json:"correctAnswer,omitempty"`
+	CorrectAnswers []int      `json:"correctAnswers,omitempty"`
+	Answer         *float64   `json:"answer,omitempty"`
+	Tolerance      float64    `json:"tolerance,omitempty"`
+	Units          []string   `json:"units,omitempty"`
+	Keywords       []string   `json:"keywords,omitempty"`
+	CorrectMatches []string   `json:"correctMatches,omitempty"`
+	CorrectOrder   []string   `json:"correctOrder,omitempty"`
+	Explanation    string     `json:"explanation,omitempty"`
+}
+
+// View returns q for students, with its answer key if reveal is set.
+func (q Question) View(reveal bool) QuestionView {
+	v := QuestionView{ID: q.ID, Kind: q.Kind, Question: q.Question, Options: q.Options, Points: q.Points,
+		Language: q.Language, StarterCode: q.StarterCode}
+	switch q.Kind {
+	case KindMatching:
+		v.Matches = q.shuffled(q.Matches)
+	case KindOrdering:
+		v.Options = q.shuffled(q.Options)
+	}
+	for _, tc := range q.TestCases {
+		if reveal || !tc.Hidden {
+			v.TestCases = append(v.TestCases, tc)
+		}
+	}
+	if !reveal {
+		return v
+	}
+	switch q.Kind {
+	case KindSingle:
+		c := q.CorrectAnswer
+		v.CorrectAnswer = &c
+	case KindMatching:
+		v.CorrectMatches = q.Matches
+	case KindOrdering:
+		v.CorrectOrder = q.Options
+	}
+	v.CorrectAnswers, v.Answer, v.Tolerance, v.Units, v.Keywords = q.CorrectAnswers, q.Answer, q.Tolerance, q.Units, q.Keywords
+	v.Explanation = q.Explanation
+	return v
+}
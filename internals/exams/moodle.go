@@ -0,0 +1,356 @@
+package exams
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// moodleQuiz is a Moodle XML question file. Categories are questions of
+// type category that file the questions after them.
+type moodleQuiz struct {
+	XMLName   xml.Name         `xml:"quiz"`
+	Questions []moodleQuestion `xml:"question"`
+}
+
+type moodleText struct {
+	Format string `xml:"format,attr,omitempty"`
+	Text   string `xml:"text"`
+}
+
+type moodleQuestion struct {
+	Type            string              `xml:"type,attr"`
+	Category        *moodleText         `xml:"category,omitempty"`
+	Name            *moodleText         `xml:"name,omitempty"`
+	QuestionText    *moodleText         `xml:"questiontext,omitempty"`
+	GeneralFeedback *moodleText         `xml:"generalfeedback,omitempty"`
+	DefaultGrade    string              `xml:"defaultgrade,omitempty"`
+	Single          string              `xml:"single,omitempty"`
+	ShuffleAnswers  string              `xml:"shuffleanswers,omitempty"`
+	Answers         []moodleAnswer      `xml:"answer"`
+	Subquestions    []moodleSubquestion `xml:"subquestion"`
+	Units           *moodleUnits        `xml:"units,omitempty"`
+	CodeRunnerType  string              `xml:"coderunnertype,omitempty"`
+	AnswerPreload   string              `xml:"answerpreload,omitempty"`
+	TestCases       *moodleTestCases    `xml:"testcases,omitempty"`
+	Tags            *moodleTags         `xml:"tags,omitempty"`
+}
+
+// The lists of a question, as pointers so questions without them leave
+// them out.
+type (
+	moodleUnits struct {
+		Units []moodleUnit `xml:"unit"`
+	}
+	moodleTestCases struct {
+		TestCases []moodleTestCase `xml:"testcase"`
+	}
+	moodleTags struct {
+		Tags []moodleText `xml:"tag"`
+	}
+)
+
+type moodleAnswer struct {
+	Fraction  string      `xml:"fraction,attr"`
+	Format    string      `xml:"format,attr,omitempty"`
+	Text      string      `xml:"text"`
+	Tolerance string      `xml:"tolerance,omitempty"`
+	Feedback  *moodleText `xml:"feedback,omitempty"`
+}
+
+type moodleSubquestion struct {
+	Format string     `xml:"format,attr,omitempty"`
+	Text   string     `xml:"text"`
+	Answer moodleText `xml:"answer"`
+}
+
+type moodleUnit struct {
+	Multiplier string `xml:"multiplier"`
+	Name       string `xml:"unit_name"`
+}
+
+// moodleTestCase is a test case of a CodeRunner question. Display is
+// SHOW, HIDE, HIDE_IF_FAIL or HIDE_IF_SUCCEED.
+type moodleTestCase struct {
+	UseAsExample string     `xml:"useasexample,attr,omitempty"`
+	TestCode     moodleText `xml:"testcode"`
+	Stdin        moodleText `xml:"stdin"`
+	Expected     moodleText `xml:"expected"`
+	Display      moodleText `xml:"display"`
+}
+
+// CodeRunner question types of the code runner's languages.
+var codeRunnerTypes = map[string]string{"python": "python3", "c": "c_program", "go": "go"}
+
+func (u *moodleUnits) list() []moodleUnit {
+	if u == nil {
+		return nil
+	}
+	return u.Units
+}
+
+func (t *moodleTestCases) list() []moodleTestCase {
+	if t == nil {
+		return nil
+	}
+	return t.TestCases
+}
+
+func (t *moodleText) plain() string {
+	if t == nil {
+		return ""
+	}
+	if t.Format == "html" || t.Format == "" || t.Format == "moodle_auto_format" {
+		return plainText(t.Text)
+	}
+	return strings.TrimSpace(t.Text)
+}
+
+func fraction(s string) float64 {
+	f, _ := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return f
+}
+
+// parseMoodle reads a Moodle XML file: multichoice, truefalse,
+// shortanswer, numerical, matching, essay, ordering and CodeRunner
+// questions, filed under their categories. Tags difficulty-… and bloom-…
+// set those fields.
+func parseMoodle(data []byte) ([]imported, error) {
+	var quiz moodleQuiz
+	if err := xml.NewDecoder(bytes.NewReader(data)).Decode(&quiz); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadImport, err)
+	}
+	parsed := []imported{}
+	topic := ""
+	for _, mq := range quiz.Questions {
+		if mq.Type == "category" {
+			if mq.Category != nil {
+				topic = categoryTopic(mq.Category.Text)
+			}
+			continue
+		}
+		p := imported{Name: mq.Name.plain()}
+		p.Question, p.Err = moodleQuestionOf(&mq)
+		p.Question.Topic = topic
+		if p.Name == "" {
+			p.Name = p.Question.Question
+		}
+		if parsed = append(parsed, p); len(parsed) > MaxImportQuestions {
+			return nil, errTooManyQuestions
+		}
+	}
+	return parsed, nil
+}
+
+func moodleQuestionOf(mq *moodleQuestion) (Question, error) {
+	q := Question{Question: mq.QuestionText.plain(), Explanation: mq.GeneralFeedback.plain()}
+	q.Points, _ = strconv.ParseFloat(strings.TrimSpace(mq.DefaultGrade), 64)
+	if mq.Tags != nil {
+		for _, t := range mq.Tags.Tags {
+			q.Tags = append(q.Tags, t.Text)
+		}
+	}
+	applyMetaTags(&q)
+	answerText := func(a moodleAnswer) string {
+		return (&moodleText{Format: a.Format, Text: a.Text}).plain()
+	}
+
+	switch mq.Type {
+	case "multichoice", "truefalse":
+		correct := []int{}
+		for i, a := range mq.Answers {
+			q.Options = append(q.Options, answerText(a))
+			if fraction(a.Fraction) > 0 {
+				correct = append(correct, i)
+			}
+		}
+		single := mq.Type == "truefalse" || mq.Single == "true" || mq.Single == "1"
+		if single && len(correct) == 1 {
+			q.Kind, q.CorrectAnswer = KindSingle, correct[0]
+		} else {
+			q.Kind, q.CorrectAnswers = KindMultiple, correct
+		}
+
+	case "shortanswer":
+		q.Kind = KindShort
+		for _, a := range mq.Answers {
+			if fraction(a.Fraction) >= 100 {
+				q.Patterns = append(q.Patterns, wildcardPattern(answerText(a)))
+			}
+		}
+
+	case "numerical":
+		q.Kind = KindNumeric
+		for _, a := range mq.Answers {
+			if fraction(a.Fraction) < 100 {
+				continue
+			}
+			answer, err := strconv.ParseFloat(strings.TrimSpace(a.Text), 64)
+			if err != nil {
+				return q, fmt.Errorf("%w: numeric answer %q", ErrInvalidQuestion, a.Text)
+			}
+			q.Answer, q.Tolerance = &answer, fraction(a.Tolerance)
+			break
+		}
+		for _, u := range mq.Units.list() {
+			if m := fraction(u.Multiplier); m == 1 || m == 0 {
+				q.Units = append(q.Units, u.Name)
+			}
+		}
+
+	case "matching":
+		q.Kind = KindMatching
+		for _, sq := range mq.Subquestions {
+			if text := (&moodleText{Format: sq.Format, Text: sq.Text}).plain(); text != "" {
+				q.Options = append(q.Options, text)
+				q.Matches = append(q.Matches, strings.TrimSpace(sq.Answer.Text)) // always plain text in Moodle
+			}
+		}
+
+	case "essay":
+		q.Kind, q.ManualReview = KindShort, true
+
+	case "ordering":
+		q.Kind = KindOrdering
+		for _, a := range mq.Answers {
+			q.Options = append(q.Options, answerText(a))
+		}
+
+	case "coderunner":
+		q.Kind, q.StarterCode = KindCode, mq.AnswerPreload
+		for lang, t := range codeRunnerTypes {
+			if strings.HasPrefix(strings.ToLower(mq.CodeRunnerType), t) {
+				q.Language = lang
+			}
+		}
+		if q.Language == "" {
+			return q, fmt.Errorf("%w: CodeRunner type %q is not one the code runner runs", ErrInvalidQuestion, mq.CodeRunnerType)
+		}
+		for _, tc := range mq.TestCases.list() {
+			if strings.TrimSpace(tc.TestCode.Text) != "" {
+				return q, fmt.Errorf("%w: CodeRunner test code is not supported, only stdin and expected output", ErrInvalidQuestion)
+			}
+			q.TestCases = append(q.TestCases, TestCase{
+				Input: tc.Stdin.Text, Output: tc.Expected.Text, Hidden: strings.TrimSpace(tc.Display.Text) == "HIDE",
+			})
+		}
+
+	default:
+		return q, fmt.Errorf("%w: unsupported question type %q", ErrInvalidQuestion, mq.Type)
+	}
+	return q, nil
+}
+
+// writeMoodle writes questions as Moodle XML, with a category per topic
+// and difficulty and Bloom level as tags. Short answers graded by
+// keywords or patterns have no form in it.
+func writeMoodle(questions []Question) ([]byte, []Skipped, error) {
+	quiz := moodleQuiz{}
+	skipped := []Skipped{}
+	category := ""
+	plain := func(s string) *moodleText { return &moodleText{Format: "plain_text", Text: s} }
+	for i := range questions {
+		q := &questions[i]
+		mq := moodleQuestion{
+			Name:            plain(fmt.Sprintf("Q%d", q.ID)),
+			QuestionText:    plain(q.Question),
+			GeneralFeedback: plain(q.Explanation),
+			DefaultGrade:    strconv.FormatFloat(q.Points, 'f', -1, 64),
+		}
+		mq.Tags = &moodleTags{}
+		for _, t := range append(append([]string{}, q.Tags...), metaTags(q)...) {
+			mq.Tags.Tags = append(mq.Tags.Tags, moodleText{Text: t})
+		}
+		answer := func(text string, frac float64) moodleAnswer {
+			return moodleAnswer{Fraction: giftFloat(frac), Format: "plain_text", Text: text}
+		}
+
+		switch q.Kind {
+		case KindSingle, KindMultiple:
+			mq.Type, mq.Single, mq.ShuffleAnswers = "multichoice", "true", "0"
+			right, wrong := 100.0, 0.0
+			if q.Kind == KindMultiple {
+				mq.Single = "false"
+				right, wrong = 100/float64(len(q.CorrectAnswers)), -100
+				if others := len(q.Options) - len(q.CorrectAnswers); others > 0 {
+					wrong = -100 / float64(others)
+				}
+			}
+			for j, o := range q.Options {
+				frac := wrong
+				if j == q.CorrectAnswer && q.Kind == KindSingle || containsInt(q.CorrectAnswers, j) {
+					frac = right
+				}
+				mq.Answers = append(mq.Answers, answer(o, frac))
+			}
+		case KindNumeric:
+			mq.Type = "numerical"
+			a := answer(strconv.FormatFloat(*q.Answer, 'f', -1, 64), 100)
+			a.Tolerance = strconv.FormatFloat(q.Tolerance, 'f', -1, 64)
+			mq.Answers = []moodleAnswer{a}
+			if len(q.Units) > 0 {
+				mq.Units = &moodleUnits{}
+			}
+			for _, u := range q.Units {
+				mq.Units.Units = append(mq.Units.Units, moodleUnit{Multiplier: "1", Name: u})
+			}
+		case KindShort:
+			exact, ok := exactAnswers(q)
+			switch {
+			case ok && len(exact) > 0:
+				mq.Type = "shortanswer"
+				for _, a := range exact {
+					mq.Answers = append(mq.Answers, answer(a, 100))
+				}
+			case ok && q.ManualReview:
+				mq.Type = "essay"
+			default:
+				skipped = append(skipped, Skipped{ID: q.ID, Error: "short answers graded by keywords or patterns cannot be written as moodle"})
+				continue
+			}
+		case KindMatching:
+			mq.Type, mq.ShuffleAnswers = "matching", "1"
+			for j, o := range q.Options {
+				mq.Subquestions = append(mq.Subquestions, moodleSubquestion{
+					Format: "plain_text", Text: o, Answer: moodleText{Text: q.Matches[j]},
+				})
+			}
+		case KindOrdering:
+			mq.Type = "ordering"
+			for _, o := range q.Options {
+				mq.Answers = append(mq.Answers, answer(o, 0))
+			}
+		case KindCode:
+			mq.Type, mq.CodeRunnerType, mq.AnswerPreload = "coderunner", codeRunnerTypes[q.Language], q.StarterCode
+			mq.TestCases = &moodleTestCases{}
+			for _, tc := range q.TestCases {
+				display, example := "SHOW", "1"
+				if tc.Hidden {
+					display, example = "HIDE", "0"
+				}
+				mq.TestCases.TestCases = append(mq.TestCases.TestCases, moodleTestCase{
+					UseAsExample: example, Stdin: moodleText{Text: tc.Input}, Expected: moodleText{Text: tc.Output},
+					Display: moodleText{Text: display},
+				})
+			}
+		default:
+			skipped = append(skipped, unsupported(q, FormatMoodle))
+			continue
+		}
+
+		if q.Topic != category {
+			category = q.Topic
+			quiz.Questions = append(quiz.Questions, moodleQuestion{
+				Type: "category", Category: &moodleText{Text: "$course$/top/" + category},
+			})
+		}
+		quiz.Questions = append(quiz.Questions, mq)
+	}
+	data, err := xml.MarshalIndent(quiz, "", "  ")
+	if err != nil {
+		return nil, skipped, err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), skipped, nil
+}
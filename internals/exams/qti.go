@@ -0,0 +1,641 @@
+package exams
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	qtiNamespace = "http://www.imsglobal.org/xsd/imsqti_v2p1"
+	qtiTemplates = "http://www.imsglobal.org/question/qti_v2p1/rptemplates/"
+
+	maxQTIFileSize = 4 << 20
+	maxQTIEntries  = 5000
+	maxQTIDepth    = 64
+)
+
+// qtiNode is any element of a QTI item. Its text and child elements are
+// kept in document order for the mixed text and markup of prompts and
+// choices; each piece of text is stored once, in the element holding it.
+type qtiNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr
+	Nodes   []*qtiNode
+	content []qtiContent
+}
+
+// qtiContent is a run of text or a child element.
+type qtiContent struct {
+	text string
+	node *qtiNode
+}
+
+// decodeQTI reads the root element of an item from its tokens, refusing
+// elements nested deeper than maxQTIDepth.
+func decodeQTI(data []byte) (*qtiNode, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	var root *qtiNode
+	stack := []*qtiNode{}
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			if root == nil {
+				return nil, fmt.Errorf("no root element")
+			}
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if len(stack) >= maxQTIDepth {
+				return nil, fmt.Errorf("elements are nested more than %d deep", maxQTIDepth)
+			}
+			n := &qtiNode{XMLName: t.Name, Attrs: append([]xml.Attr(nil), t.Attr...)}
+			if len(stack) == 0 {
+				root = n
+			} else {
+				parent := stack[len(stack)-1]
+				parent.Nodes = append(parent.Nodes, n)
+				parent.content = append(parent.content, qtiContent{node: n})
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return root, nil
+			}
+		case xml.CharData:
+			if len(stack) > 0 {
+				n := stack[len(stack)-1]
+				n.content = append(n.content, qtiContent{text: string(t)})
+			}
+		}
+	}
+}
+
+func (n *qtiNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// all returns the elements named local under n, depth first.
+func (n *qtiNode) all(local string) []*qtiNode {
+	found := []*qtiNode{}
+	for _, c := range n.Nodes {
+		if c.XMLName.Local == local {
+			found = append(found, c)
+		}
+		found = append(found, c.all(local)...)
+	}
+	return found
+}
+
+// first returns the first element named local under n, or nil.
+func (n *qtiNode) first(local string) *qtiNode {
+	if found := n.all(local); len(found) > 0 {
+		return found[0]
+	}
+	return nil
+}
+
+func (n *qtiNode) text() string {
+	if n == nil {
+		return ""
+	}
+	var b strings.Builder
+	n.render(&b, false)
+	return plainText(b.String())
+}
+
+// render writes the text under n the way plainText reads HTML: escaped,
+// with a break after each block element. In a body, interactions become a
+// blank and rubric blocks and feedback are left out.
+func (n *qtiNode) render(b *strings.Builder, body bool) {
+	for _, c := range n.content {
+		switch {
+		case c.node == nil:
+			b.WriteString(html.EscapeString(c.text))
+		case body && strings.HasSuffix(c.node.XMLName.Local, "Interaction"):
+			b.WriteString(" _____ ")
+		case body && (c.node.XMLName.Local == "rubricBlock" || c.node.XMLName.Local == "modalFeedback"):
+		default:
+			c.node.render(b, body)
+			if qtiBlock[c.node.XMLName.Local] {
+				b.WriteString("<br>")
+			}
+		}
+	}
+}
+
+// qtiBlock lists the XHTML elements that end a line of text.
+var qtiBlock = map[string]bool{
+	"br": true, "p": true, "div": true, "li": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// parseQTI reads QTI 2.1: one assessmentItem file, or a content package
+// zip of them. Items with choice, order, match, text entry or extended
+// text interactions are read; each other item is reported on its own.
+func parseQTI(data []byte) ([]imported, error) {
+	if !bytes.HasPrefix(data, []byte("PK")) {
+		if len(data) > maxQTIFileSize {
+			return nil, fmt.Errorf("%w: an item file is larger than %d MB", ErrBadImport, maxQTIFileSize>>20)
+		}
+		p, err := qtiItem(data)
+		if err != nil {
+			return nil, err
+		}
+		return []imported{p}, nil
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadImport, err)
+	}
+	if len(zr.File) > maxQTIEntries {
+		return nil, fmt.Errorf("%w: the package has more than %d files", ErrBadImport, maxQTIEntries)
+	}
+	files := []*zip.File{}
+	keywords := map[string][]string{}
+	for _, f := range zr.File {
+		if f.Name == "imsmanifest.xml" {
+			if keywords, err = qtiKeywords(f); err != nil {
+				return nil, err
+			}
+		} else if strings.HasSuffix(strings.ToLower(f.Name), ".xml") && path.Base(f.Name) != "imsmanifest.xml" {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	parsed := []imported{}
+	for _, f := range files {
+		raw, err := readQTIFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Contains(raw, []byte("<assessmentItem")) {
+			continue // tests, sections and other resources of the package
+		}
+		p, err := qtiItem(raw)
+		if err != nil {
+			p = imported{Err: err}
+		}
+		if dir, ok := strings.CutPrefix(path.Dir(f.Name), "items/"); ok && p.Err == nil {
+			p.Question.Topic = Topic(dir) // as writeQTI files them
+		}
+		if p.Err == nil {
+			p.Question.Tags = keywords[f.Name]
+			applyMetaTags(&p.Question)
+		}
+		if p.Name == "" {
+			p.Name = f.Name
+		}
+		if parsed = append(parsed, p); len(parsed) > MaxImportQuestions {
+			return nil, errTooManyQuestions
+		}
+	}
+	return parsed, nil
+}
+
+// readQTIFile reads one file of a package, up to maxQTIFileSize.
+func readQTIFile(f *zip.File) ([]byte, error) {
+	tooLarge := fmt.Errorf("%w: %s is larger than %d MB", ErrBadImport, f.Name, maxQTIFileSize>>20)
+	if f.UncompressedSize64 > maxQTIFileSize {
+		return nil, tooLarge
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadImport, err)
+	}
+	defer rc.Close()
+	// The size in the header is the sender's word; read one byte past the
+	// limit to catch a file that is larger.
+	raw, err := io.ReadAll(io.LimitReader(rc, maxQTIFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadImport, err)
+	}
+	if len(raw) > maxQTIFileSize {
+		return nil, tooLarge
+	}
+	return raw, nil
+}
+
+// qtiKeywords reads the LOM keywords of each resource in a package
+// manifest, by the href of the resource, as writeQTI files tags there.
+func qtiKeywords(f *zip.File) (map[string][]string, error) {
+	raw, err := readQTIFile(f)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := decodeQTI(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: imsmanifest.xml: %v", ErrBadImport, err)
+	}
+	keywords := map[string][]string{}
+	for _, res := range manifest.all("resource") {
+		for _, k := range res.all("keyword") {
+			for _, str := range k.all("string") {
+				if t := strings.TrimSpace(str.text()); t != "" {
+					keywords[res.attr("href")] = append(keywords[res.attr("href")], t)
+				}
+			}
+		}
+	}
+	return keywords, nil
+}
+
+// qtiItem reads one assessmentItem. A malformed file is an error; an item
+// it cannot map is reported in the result.
+func qtiItem(data []byte) (imported, error) {
+	item, err := decodeQTI(data)
+	if err == nil && item.XMLName.Local != "assessmentItem" {
+		err = fmt.Errorf("root element is %s", item.XMLName.Local)
+	}
+	if err != nil {
+		return imported{}, fmt.Errorf("%w: %v", ErrBadImport, err)
+	}
+	p := imported{Name: item.attr("title")}
+	p.Question, p.Err = qtiQuestion(item)
+	return p, nil
+}
+
+// qtiCorrect returns the correct response values of a response variable.
+func qtiCorrect(item *qtiNode, id string) (values []string, baseType string) {
+	for _, decl := range item.all("responseDeclaration") {
+		if decl.attr("identifier") != id {
+			continue
+		}
+		if correct := decl.first("correctResponse"); correct != nil {
+			for _, v := range correct.all("value") {
+				values = append(values, strings.TrimSpace(v.text()))
+			}
+		}
+		for _, entry := range decl.all("mapEntry") {
+			if fraction(entry.attr("mappedValue")) > 0 && !containsString(values, entry.attr("mapKey")) {
+				values = append(values, entry.attr("mapKey"))
+			}
+		}
+		return values, decl.attr("baseType")
+	}
+	return nil, ""
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func qtiQuestion(item *qtiNode) (Question, error) {
+	q := Question{}
+	body := item.first("itemBody")
+	if body == nil {
+		return q, fmt.Errorf("%w: the item has no itemBody", ErrInvalidQuestion)
+	}
+	var interaction *qtiNode
+	for _, kind := range []string{"choiceInteraction", "orderInteraction", "matchInteraction", "textEntryInteraction",
+		"extendedTextInteraction"} {
+		if interaction = body.first(kind); interaction != nil {
+			break
+		}
+	}
+	if interaction == nil {
+		return q, fmt.Errorf("%w: the item has no supported interaction", ErrInvalidQuestion)
+	}
+
+	var raw strings.Builder
+	body.render(&raw, true)
+	text := plainText(raw.String())
+	text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "_____"))
+	if prompt := interaction.first("prompt").text(); prompt != "" {
+		text = strings.TrimSpace(text + "\n" + prompt)
+	}
+	q.Question = text
+	feedback := []string{}
+	for _, f := range item.all("modalFeedback") {
+		feedback = append(feedback, f.text())
+	}
+	q.Explanation = strings.Join(feedback, " ")
+	for _, outcome := range item.all("outcomeDeclaration") {
+		if outcome.attr("identifier") == "MAXSCORE" {
+			q.Points = fraction(outcome.first("value").text())
+		}
+	}
+
+	correct, baseType := qtiCorrect(item, interaction.attr("responseIdentifier"))
+	choices := func(list []*qtiNode) (ids, texts []string) {
+		for _, c := range list {
+			ids = append(ids, c.attr("identifier"))
+			texts = append(texts, c.text())
+		}
+		return ids, texts
+	}
+
+	switch interaction.XMLName.Local {
+	case "choiceInteraction":
+		ids, texts := choices(interaction.all("simpleChoice"))
+		q.Options = texts
+		picked := []int{}
+		for i, id := range ids {
+			if containsString(correct, id) {
+				picked = append(picked, i)
+			}
+		}
+		if interaction.attr("maxChoices") == "1" && len(picked) == 1 {
+			q.Kind, q.CorrectAnswer = KindSingle, picked[0]
+		} else {
+			q.Kind, q.CorrectAnswers = KindMultiple, picked
+		}
+
+	case "orderInteraction":
+		ids, texts := choices(interaction.all("simpleChoice"))
+		q.Kind = KindOrdering
+		for _, id := range correct {
+			for i := range ids {
+				if ids[i] == id {
+					q.Options = append(q.Options, texts[i])
+				}
+			}
+		}
+		if len(q.Options) != len(ids) {
+			return q, fmt.Errorf("%w: the correct order does not list every choice", ErrInvalidQuestion)
+		}
+
+	case "matchInteraction":
+		sets := interaction.all("simpleMatchSet")
+		if len(sets) != 2 {
+			return q, fmt.Errorf("%w: a match interaction needs two match sets", ErrInvalidQuestion)
+		}
+		leftIDs, left := choices(sets[0].all("simpleAssociableChoice"))
+		rightIDs, right := choices(sets[1].all("simpleAssociableChoice"))
+		q.Kind = KindMatching
+		for i, id := range leftIDs {
+			for _, pair := range correct {
+				from, to, _ := strings.Cut(strings.Join(strings.Fields(pair), " "), " ")
+				if from != id {
+					continue
+				}
+				for j := range rightIDs {
+					if rightIDs[j] == to {
+						q.Options = append(q.Options, left[i])
+						q.Matches = append(q.Matches, right[j])
+					}
+				}
+			}
+		}
+		if len(q.Options) != len(leftIDs) {
+			return q, fmt.Errorf("%w: every left choice needs exactly one correct match", ErrInvalidQuestion)
+		}
+
+	case "textEntryInteraction", "extendedTextInteraction":
+		if baseType == "float" || baseType == "integer" {
+			q.Kind = KindNumeric
+			if len(correct) == 0 {
+				return q, fmt.Errorf("%w: the numeric item has no correct response", ErrInvalidQuestion)
+			}
+			answer, err := strconv.ParseFloat(correct[0], 64)
+			if err != nil {
+				return q, fmt.Errorf("%w: numeric answer %q", ErrInvalidQuestion, correct[0])
+			}
+			q.Answer = &answer
+			if equal := item.first("equal"); equal != nil {
+				tol := fraction(strings.Fields(equal.attr("tolerance") + " 0")[0])
+				if equal.attr("toleranceMode") == "relative" {
+					tol = answer * tol / 100
+				}
+				q.Tolerance = max(tol, -tol)
+			}
+			break
+		}
+		q.Kind = KindShort
+		for _, c := range correct {
+			q.Patterns = append(q.Patterns, exactPattern(c))
+		}
+		q.ManualReview = interaction.XMLName.Local == "extendedTextInteraction" || len(q.Patterns) == 0
+	}
+	return q, nil
+}
+
+// qtiWriter builds the XML of an item.
+type qtiWriter struct {
+	strings.Builder
+}
+
+func (w *qtiWriter) text(s string) {
+	xml.EscapeText(w, []byte(s))
+}
+
+// tag writes <name attrs>text</name>, attrs being name/value pairs.
+func (w *qtiWriter) tag(name, text string, attrs ...string) {
+	w.WriteString("<" + name)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		w.WriteString(" " + attrs[i] + `="`)
+		w.text(attrs[i+1])
+		w.WriteString(`"`)
+	}
+	w.WriteString(">")
+	w.text(text)
+	w.WriteString("</" + name + ">\n")
+}
+
+// qtiItemXML writes q as an assessmentItem, or returns ok false if QTI has
+// no form for it.
+func qtiItemXML(q *Question) (string, bool) {
+	var decl, interaction, processing qtiWriter
+	cardinality, baseType := "single", "identifier"
+	correct := []string{}
+	processing.WriteString(`<responseProcessing template="` + qtiTemplates + `match_correct"/>` + "\n")
+	letter := func(prefix string, i int) string { return prefix + strconv.Itoa(i+1) }
+
+	switch q.Kind {
+	case KindSingle, KindMultiple:
+		maxChoices := "1"
+		if q.Kind == KindMultiple {
+			cardinality, maxChoices = "multiple", "0"
+		}
+		interaction.WriteString(`<choiceInteraction responseIdentifier="RESPONSE" shuffle="false" maxChoices="` + maxChoices + `">` + "\n")
+		interaction.tag("prompt", q.Question)
+		for i, o := range q.Options {
+			interaction.tag("simpleChoice", o, "identifier", letter("C", i))
+			if (q.Kind == KindSingle && i == q.CorrectAnswer) || containsInt(q.CorrectAnswers, i) {
+				correct = append(correct, letter("C", i))
+			}
+		}
+		interaction.WriteString("</choiceInteraction>\n")
+
+	case KindOrdering:
+		cardinality = "ordered"
+		interaction.WriteString(`<orderInteraction responseIdentifier="RESPONSE" shuffle="true">` + "\n")
+		interaction.tag("prompt", q.Question)
+		for i, o := range q.Options {
+			interaction.tag("simpleChoice", o, "identifier", letter("C", i))
+			correct = append(correct, letter("C", i))
+		}
+		interaction.WriteString("</orderInteraction>\n")
+
+	case KindMatching:
+		cardinality, baseType = "multiple", "directedPair"
+		n := strconv.Itoa(len(q.Options))
+		interaction.WriteString(`<matchInteraction responseIdentifier="RESPONSE" shuffle="true" maxAssociations="` + n + `">` + "\n")
+		interaction.tag("prompt", q.Question)
+		for _, set := range [][]string{q.Options, q.Matches} {
+			prefix := "L"
+			if len(correct) > 0 {
+				prefix = "R"
+			}
+			interaction.WriteString("<simpleMatchSet>\n")
+			for i, s := range set {
+				interaction.tag("simpleAssociableChoice", s, "identifier", letter(prefix, i), "matchMax", "1")
+				if prefix == "L" {
+					correct = append(correct, letter("L", i)+" "+letter("R", i))
+				}
+			}
+			interaction.WriteString("</simpleMatchSet>\n")
+		}
+		interaction.WriteString("</matchInteraction>\n")
+
+	case KindNumeric:
+		if len(q.Units) > 0 {
+			return "", false
+		}
+		baseType = "float"
+		correct = []string{strconv.FormatFloat(*q.Answer, 'f', -1, 64)}
+		interaction.tag("p", q.Question)
+		interaction.WriteString(`<p><textEntryInteraction responseIdentifier="RESPONSE" expectedLength="15"/></p>` + "\n")
+		tol := strconv.FormatFloat(q.Tolerance, 'f', -1, 64)
+		processing.Reset()
+		processing.WriteString(`<responseProcessing><responseCondition><responseIf>` +
+			`<equal toleranceMode="absolute" tolerance="` + tol + " " + tol + `">` +
+			`<variable identifier="RESPONSE"/><correct identifier="RESPONSE"/></equal>` +
+			`<setOutcomeValue identifier="SCORE"><baseValue baseType="float">1</baseValue></setOutcomeValue>` +
+			`</responseIf></responseCondition></responseProcessing>` + "\n")
+
+	case KindShort:
+		exact, ok := exactAnswers(q)
+		if !ok {
+			return "", false
+		}
+		baseType, correct = "string", exact
+		interaction.tag("p", q.Question)
+		if len(exact) == 0 {
+			interaction.WriteString(`<extendedTextInteraction responseIdentifier="RESPONSE"/>` + "\n")
+			processing.Reset()
+		} else {
+			interaction.WriteString(`<p><textEntryInteraction responseIdentifier="RESPONSE" expectedLength="30"/></p>` + "\n")
+			processing.Reset()
+			processing.WriteString(`<responseProcessing template="` + qtiTemplates + `map_response"/>` + "\n")
+		}
+
+	default:
+		return "", false
+	}
+
+	decl.WriteString(`<responseDeclaration identifier="RESPONSE" cardinality="` + cardinality + `" baseType="` + baseType + `">` + "\n")
+	if len(correct) > 0 && q.Kind != KindShort {
+		decl.WriteString("<correctResponse>\n")
+		for _, c := range correct {
+			decl.tag("value", c)
+		}
+		decl.WriteString("</correctResponse>\n")
+	}
+	if q.Kind == KindShort && len(correct) > 0 {
+		decl.WriteString(`<mapping defaultValue="0">` + "\n")
+		for _, c := range correct {
+			decl.WriteString(`<mapEntry mapKey="`)
+			decl.text(c)
+			decl.WriteString(`" mappedValue="1"/>` + "\n")
+		}
+		decl.WriteString("</mapping>\n")
+	}
+	decl.WriteString("</responseDeclaration>\n")
+
+	var w qtiWriter
+	w.WriteString(xml.Header)
+	w.WriteString(`<assessmentItem xmlns="` + qtiNamespace + `" identifier="Q` + strconv.FormatInt(q.ID, 10) + `" title="`)
+	w.text(fmt.Sprintf("Q%d", q.ID))
+	w.WriteString(`" adaptive="false" timeDependent="false">` + "\n")
+	w.WriteString(decl.String())
+	w.WriteString(`<outcomeDeclaration identifier="SCORE" cardinality="single" baseType="float"/>` + "\n")
+	w.WriteString(`<outcomeDeclaration identifier="MAXSCORE" cardinality="single" baseType="float">` +
+		"<defaultValue><value>" + strconv.FormatFloat(q.Points, 'f', -1, 64) + "</value></defaultValue></outcomeDeclaration>\n")
+	if q.Explanation != "" {
+		w.WriteString(`<outcomeDeclaration identifier="FEEDBACK" cardinality="single" baseType="identifier"/>` + "\n")
+	}
+	w.WriteString("<itemBody>\n" + interaction.String() + "</itemBody>\n")
+	w.WriteString(processing.String())
+	if q.Explanation != "" {
+		w.tag("modalFeedback", q.Explanation, "outcomeIdentifier", "FEEDBACK", "identifier", "EXPLANATION", "showHide", "hide")
+	}
+	w.WriteString("</assessmentItem>\n")
+	return w.String(), true
+}
+
+// writeQTI writes questions as a QTI 2.1 content package: a zip of one
+// item file each with a manifest listing them, with their tags as LOM
+// keywords. Code questions, numeric answers with units, and short answers
+// graded by keywords or patterns, have no form in it.
+func writeQTI(questions []Question) ([]byte, []Skipped, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	skipped := []Skipped{}
+	var manifest qtiWriter
+	manifest.WriteString(xml.Header)
+	manifest.WriteString(`<manifest xmlns="http://www.imsglobal.org/xsd/imscp_v1p1" identifier="MANIFEST-question-bank">` + "\n" +
+		"<metadata><schema>QTIv2.1 Package</schema><schemaversion>1.0.0</schemaversion></metadata>\n" +
+		"<organizations/>\n<resources>\n")
+	for i := range questions {
+		q := &questions[i]
+		item, ok := qtiItemXML(q)
+		if !ok && q.Kind == KindNumeric {
+			skipped = append(skipped, Skipped{ID: q.ID, Error: "numeric answers with units cannot be written as qti"})
+			continue
+		} else if !ok {
+			skipped = append(skipped, unsupported(q, FormatQTI))
+			continue
+		}
+		name := fmt.Sprintf("items/%s/q%d.xml", q.Topic, q.ID)
+		f, err := zw.Create(name)
+		if err != nil {
+			return nil, skipped, err
+		}
+		if _, err := f.Write([]byte(item)); err != nil {
+			return nil, skipped, err
+		}
+		manifest.WriteString(fmt.Sprintf(`<resource identifier="Q%d" type="imsqti_item_xmlv2p1" href="%s">`+"\n", q.ID, name))
+		manifest.WriteString(`<metadata><lom xmlns="http://ltsc.ieee.org/xsd/LOM"><general>` + "\n")
+		for _, t := range append(append([]string{}, q.Tags...), metaTags(q)...) {
+			manifest.WriteString("<keyword>")
+			manifest.tag("string", t)
+			manifest.WriteString("</keyword>\n")
+		}
+		manifest.WriteString("</general></lom></metadata>\n")
+		manifest.WriteString(fmt.Sprintf(`<file href="%s"/>`+"\n</resource>\n", name))
+	}
+	manifest.WriteString("</resources>\n</manifest>\n")
+	f, err := zw.Create("imsmanifest.xml")
+	if err != nil {
+		return nil, skipped, err
+	}
+	if _, err := f.Write([]byte(manifest.String())); err != nil {
+		return nil, skipped, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, skipped, err
+	}
+	return buf.Bytes(), skipped, nil
+}
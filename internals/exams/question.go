@@ -0,0 +1,154 @@
+package exams
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Difficulty levels of a question.
+const (
+	Easy   = "easy"
+	Medium = "medium"
+	Hard   = "hard"
+)
+
+// Bloom's taxonomy levels, lowest first.
+var BloomLevels = []string{"remember", "understand", "apply", "analyze", "evaluate", "create"}
+
+// Question kinds.
+const (
+	KindSingle   = "single"   // one correct option
+	KindMultiple = "multiple" // several correct options, partial credit
+	KindNumeric  = "numeric"  // a number within a tolerance, with a unit
+	KindShort    = "short"    // short text graded by pattern or keywords
+	KindMatching = "matching" // pair each option with one of the matches
+	KindOrdering = "ordering" // put the options in order
+	KindCode     = "code"     // a program graded by its test cases
+)
+
+const (
+	// MaxOptions caps the options of a question.
+	MaxOptions = 10
+	// MaxTags caps the tags of a question.
+	MaxTags = 20
+	// MaxPoints caps what one question is worth.
+	MaxPoints = 100
+)
+
+var (
+	ErrNotFound        = errors.New("question not found")
+	ErrInvalidQuestion = errors.New("a question needs a topic, its text and an answer key fitting its kind")
+	ErrUnavailable     = errors.New("the question bank is unavailable")
+)
+
+var topicUnsafe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Topic turns a topic name into the key questions are filed under:
+// "Renewable Energy" becomes "renewable-energy".
+func Topic(name string) string {
+	return strings.Trim(topicUnsafe.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// Question is one question of the bank. The answer key (CorrectAnswer and
+// the Spec fields of its kind) and Explanation are for staff and grading;
+// CreatedBy and UpdatedBy are staff IDs.
+type Question struct {
+	ID            int64     `json:"id"`
+	Topic         string    `json:"topic"`
+	Tags          []string  `json:"tags"`
+	Difficulty    string    `json:"difficulty"`
+	Bloom         string    `json:"bloomLevel"`
+	Kind          string    `json:"kind"`
+	Question      string    `json:"question"`
+	Options       []string  `json:"options"`
+	CorrectAnswer int       `json:"correctAnswer"`
+	Points        float64   `json:"points"`
+	Spec                    // the answer key of the other kinds
+	Explanation   string    `json:"explanation"`
+	CreatedBy     string    `json:"createdBy"`
+	UpdatedBy     string    `json:"updatedBy,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+func validBloom(level string) bool {
+	for _, l := range BloomLevels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize trims and checks a question, defaulting to a medium, single
+// choice question worth one point at the understand level.
+func (q *Question) normalize() error {
+	q.Topic = Topic(q.Topic)
+	q.Question = strings.TrimSpace(q.Question)
+	q.Explanation = strings.TrimSpace(q.Explanation)
+	if q.Kind == "" {
+		q.Kind = KindSingle
+	}
+	if q.Difficulty == "" {
+		q.Difficulty = Medium
+	}
+	if q.Bloom == "" {
+		q.Bloom = "understand"
+	}
+	if q.Points == 0 {
+		q.Points = 1
+	}
+	q.Difficulty, q.Bloom = strings.ToLower(q.Difficulty), strings.ToLower(q.Bloom)
+	if q.Topic == "" || q.Question == "" || !validBloom(q.Bloom) || q.Points < 0 || q.Points > MaxPoints ||
+		(q.Difficulty != Easy && q.Difficulty != Medium && q.Difficulty != Hard) {
+		return ErrInvalidQuestion
+	}
+
+	for i, o := range q.Options {
+		if q.Options[i] = strings.TrimSpace(o); q.Options[i] == "" {
+			return ErrInvalidQuestion
+		}
+	}
+	if q.Options == nil {
+		q.Options = []string{}
+	}
+	if err := q.normalizeKey(); err != nil {
+		return err
+	}
+
+	tags := []string{}
+	seen := make(map[string]bool)
+	for _, t := range q.Tags {
+		if t = Topic(t); t != "" && !seen[t] {
+			seen[t] = true
+			tags = append(tags, t)
+		}
+	}
+	if len(tags) > MaxTags {
+		return ErrInvalidQuestion
+	}
+	q.Tags = tags
+	return nil
+}
+
+// Filter narrows a listing of the bank. Empty fields match everything;
+// Search matches the question text.
+type Filter struct {
+	Topic      string
+	Tag        string
+	Difficulty string
+	Bloom      string
+	Kind       string
+	CreatedBy  string
+	Search     string
+	Limit      int
+	Offset     int
+}
+
+// TopicCount is a topic of the bank with how many questions it has.
+type TopicCount struct {
+	Topic     string `json:"topic"`
+	Questions int    `json:"questions"`
+}
@@ -0,0 +1,186 @@
+package exams
+
+// seedQuestions start an empty bank with the questions the exam page
+// shipped with.
+var seedQuestions = []Question{
+	{
+		Topic:         "ai",
+		Tags:          []string{"terminology"},
+		Difficulty:    Easy,
+		Bloom:         "remember",
+		Kind:          KindSingle,
+		Question:      "What does AI stand for?",
+		Options:       []string{"Artificial Intelligence", "Automated Inference", "Algorithmic Integration", "Advanced Interface"},
+		CorrectAnswer: 0,
+		Explanation:   "AI stands for Artificial Intelligence, which refers to the simulation of human intelligence in machines.",
+		CreatedBy:     "system",
+	},
+	{
+		Topic:         "ai",
+		Tags:          []string{"applications", "nlp"},
+		Difficulty:    Easy,
+		Bloom:         "understand",
+		Kind:          KindSingle,
+		Question:      "Which of these is a common application of AI?",
+		Options:       []string{"Natural Language Processing", "Solar Panel Efficiency", "Hydropower Generation", "Wind Turbine Design"},
+		CorrectAnswer: 0,
+		Explanation:   "Natural Language Processing (NLP) is a common AI application that enables machines to understand and interpret human language.",
+		CreatedBy:     "system",
+	},
+	{
+		Topic:         "ai",
+		Tags:          []string{"machine-learning"},
+		Difficulty:    Medium,
+		Bloom:         "understand",
+		Kind:          KindSingle,
+		Question:      "What is machine learning?",
+		Options:       []string{"A subset of AI focused on algorithms that learn from data", "The process of building physical robots", "A type of renewable energy technology", "A data storage methodology"},
+		CorrectAnswer: 0,
+		Explanation:   "Machine learning is a subset of AI that focuses on developing algorithms that can learn from and make predictions based on data.",
+		CreatedBy:     "system",
+	},
+	{
+		Topic:         "ai",
+		Tags:          []string{"neural-networks"},
+		Difficulty:    Medium,
+		Bloom:         "remember",
+		Kind:          KindSingle,
+		Question:      "Which AI technique is inspired by the human brain?",
+		Options:       []string{"Neural Networks", "Decision Trees", "Support Vector Machines", "Random Forests"},
+		CorrectAnswer: 0,
+		Explanation:   "Neural networks are computing systems inspired by the biological neural networks in human brains.",
+		CreatedBy:     "system",
+	},
+	{
+		Topic:         "ai",
+		Tags:          []string{"history", "evaluation"},
+		Difficulty:    Medium,
+		Bloom:         "understand",
+		Kind:          KindSingle,
+		Question:      "What is the Turing Test used for?",
+		Options:       []string{"To evaluate a machine's ability to exhibit intelligent behavior", "To measure computing processing speed", "To assess data storage capacity", "To evaluate network security"},
+		CorrectAnswer: 0,
+		Explanation:   "The Turing Test evaluates a machine's ability to exhibit intelligent behavior equivalent to, or indistinguishable from, that of a human.",
+		CreatedBy:     "system",
+	},
+	{
+		Topic:         "vlsc",
+		Tags:          []string{"terminology"},
+		Difficulty:    Easy,
+		Bloom:         "remember",
+		Kind:          KindSingle,
+		Question:      "What does VLSC stand for?",
+		Options:       []string{"Very Large Scale Integration", "Variable Length Source Code", "Voltage Level System Control", "Visual Logic Simulation Circuit"},
+		CorrectAnswer: 0,
+		Explanation:   "VLSC stands for Very Large Scale Integration, which refers to the process of creating integrated circuits by combining thousands of transistors into a single chip.",
+		CreatedBy:     "system",
+	},
+	{
+		Topic:         "vlsc",
+		Tags:          []string{"integrated-circuits"},
+		Difficulty:    Medium,
+		Bloom:         "understand",
+		Kind:          KindSingle,
+		Question:      "What is the primary goal of VLSC technology?",
+		Options:       []string{"To increase transistor density on integrated circuits", "To reduce software development time", "To improve renewable energy efficiency", "To enhance AI algorithms"},
+		CorrectAnswer: 0,
+		Explanation:   "The primary goal of VLSC technology is to increase transistor density on integrated circuits, enabling more powerful and efficient electronic devices.",
+		CreatedBy:     "system",
+	},
+	{
+		Topic:         "vlsc",
+		Tags:          []string{"industry"},
+		Difficulty:    Easy,
+		Bloom:         "understand",
+		Kind:          KindSingle,
+		Question:      "Which industry benefits most directly from VLSC advancements?",
+		Options:       []string{"Semiconductor industry", "Agriculture industry", "Textile industry", "Healthcare industry"},
+		CorrectAnswer: 0,
+		Explanation:   "The semiconductor industry benefits most directly from VLSC advancements as it deals with the design and manufacturing of integrated circuits.",
+		CreatedBy:     "system",
+	},
+	{
+		Topic:         "vlsc",
+		Tags:          []string{"power", "design"},
+		Difficulty:    Hard,
+		Bloom:         "analyze",
+		Kind:          KindSingle,
+		Question:      "What is a common challenge in VLSC design?",
+		Options:       []string{"Power dissipation and heat management", "Data storage limitations", "Software compatibility issues", "Network bandwidth constraints"},
+		CorrectAnswer: 0,
+		Explanation:   "Power dissipation and heat management are significant challenges in VLSC design due to the high density of transistors generating substantial heat.",
+		CreatedBy:     "system",
+	},
+	{
+		Topic:         "vlsc",
+		Tags:          []string{"cmos"},
+		Difficulty:    Medium,
+		Bloom:         "remember",
+		Kind:          KindSingle,
+		Question:      "Which technology is closely related to VLSC?",
+		Options:       []string{"CMOS technology", "Solar panel technology", "Wind turbine technology", "Hydroelectric technology"},
+		CorrectAnswer: 0,
+		Explanation:   "CMOS (Complementary Metal-Oxide-Semiconductor) technology is closely related to VLSC as it's a common technology used for constructing integrated circuits.",
+		CreatedBy:     "system",
+	},
+	{
+		Topic:         "renewable",
+		Tags:          []string{"energy-sources"},
+		Difficulty:    Easy,
+		Bloom:         "remember",
+		Kind:          KindSingle,
+		Question:      "Which of these is a renewable energy source?",
+		Options:       []string{"Solar power", "Natural gas", "Coal", "Petroleum"},
+		CorrectAnswer: 0,
+		Explanation:   "Solar power is a renewable energy source as it harnesses energy from the sun, which is virtually inexhaustible.",
+		CreatedBy:     "system",
+	},
+	{
+		Topic:         "renewable",
+		Tags:          []string{"environment"},
+		Difficulty:    Medium,
+		Bloom:         "understand",
+		Kind:          KindSingle,
+		Question:      "What is the main advantage of renewable energy?",
+		Options:       []string{"Reduced environmental impact", "Lower initial setup cost", "Consistent energy output regardless of weather", "Simpler technology"},
+		CorrectAnswer: 0,
+		Explanation:   "The main advantage of renewable energy is its reduced environmental impact compared to fossil fuels.",
+		CreatedBy:     "system",
+	},
+	{
+		Topic:         "renewable",
+		Tags:          []string{"solar"},
+		Difficulty:    Easy,
+		Bloom:         "remember",
+		Kind:          KindSingle,
+		Question:      "Which renewable energy source uses photovoltaic cells?",
+		Options:       []string{"Solar power", "Wind power", "Hydropower", "Geothermal energy"},
+		CorrectAnswer: 0,
+		Explanation:   "Solar power uses photovoltaic cells to convert sunlight directly into electricity.",
+		CreatedBy:     "system",
+	},
+	{
+		Topic:         "renewable",
+		Tags:          []string{"storage", "grid"},
+		Difficulty:    Hard,
+		Bloom:         "analyze",
+		Kind:          KindSingle,
+		Question:      "What is a common challenge for renewable energy?",
+		Options:       []string{"Intermittency and storage issues", "High fuel costs", "Limited availability of resources", "Complex regulatory requirements"},
+		CorrectAnswer: 0,
+		Explanation:   "Intermittency and storage issues are common challenges for renewable energy, as sources like solar and wind are not always available.",
+		CreatedBy:     "system",
+	},
+	{
+		Topic:         "renewable",
+		Tags:          []string{"ai", "grid"},
+		Difficulty:    Medium,
+		Bloom:         "apply",
+		Kind:          KindSingle,
+		Question:      "How can AI help renewable energy systems?",
+		Options:       []string{"Optimizing energy production and distribution", "Reducing manufacturing costs of solar panels", "Increasing wind speed for turbines", "Creating more efficient hydroelectric dams"},
+		CorrectAnswer: 0,
+		Explanation:   "AI can help optimize energy production and distribution in renewable energy systems by predicting demand and managing grid operations.",
+		CreatedBy:     "system",
+	},
+}
@@ -0,0 +1,129 @@
+package exams
+
+import (
+	"database/sql"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Service runs the question bank, draws exams from it and runs students'
+// attempts at them.
+type Service struct {
+	Store  *Store
+	Runner Runner // runs code questions' test cases
+
+	timersMu sync.Mutex
+	timers   map[string]*time.Timer // attempt ID -> its deadline
+
+	gradingMu sync.Mutex
+	grading   map[string]bool // attempts whose code answers are running
+}
+
+// NewService returns a Service storing questions in db. Without a database
+// every call returns ErrUnavailable.
+func NewService(db *sql.DB) *Service {
+	if db == nil {
+		return &Service{}
+	}
+	return &Service{Store: NewStore(db)}
+}
+
+// Seed starts an empty bank with the questions the exam page used to
+// hard-code.
+func (s *Service) Seed() error {
+	if s.Store == nil {
+		return ErrUnavailable
+	}
+	return s.Store.Seed(seedQuestions)
+}
+
+// Create adds a question to the bank, authored by staffID.
+func (s *Service) Create(staffID string, q Question) (Question, error) {
+	if s.Store == nil {
+		return q, ErrUnavailable
+	}
+	if err := q.normalize(); err != nil {
+		return q, err
+	}
+	q.CreatedBy, q.UpdatedBy = staffID, ""
+	return q, s.Store.Create(&q)
+}
+
+// Get returns a question of the bank.
+func (s *Service) Get(id int64) (Question, error) {
+	if s.Store == nil {
+		return Question{}, ErrUnavailable
+	}
+	return s.Store.Get(id)
+}
+
+// Update replaces question id, recording staffID as its last editor.
+func (s *Service) Update(staffID string, id int64, q Question) (Question, error) {
+	if s.Store == nil {
+		return q, ErrUnavailable
+	}
+	if err := q.normalize(); err != nil {
+		return q, err
+	}
+	q.ID, q.UpdatedBy = id, staffID
+	return q, s.Store.Update(&q)
+}
+
+// Delete removes question id from the bank.
+func (s *Service) Delete(id int64) error {
+	if s.Store == nil {
+		return ErrUnavailable
+	}
+	return s.Store.Delete(id)
+}
+
+// List returns the questions matching f.
+func (s *Service) List(f Filter) ([]Question, error) {
+	if s.Store == nil {
+		return nil, ErrUnavailable
+	}
+	f.Topic, f.Tag = Topic(f.Topic), Topic(f.Tag)
+	return s.Store.List(f)
+}
+
+// Topics returns the topics of the bank with their question counts.
+func (s *Service) Topics() ([]TopicCount, error) {
+	if s.Store == nil {
+		return nil, ErrUnavailable
+	}
+	return s.Store.Topics()
+}
+
+// Generate draws a fresh set of questions for b, in random order within
+// each section. It returns ErrNotEnough, naming the section, if the bank
+// cannot fill one.
+func (s *Service) Generate(b Blueprint) ([]Question, error) {
+	if s.Store == nil {
+		return nil, ErrUnavailable
+	}
+	if err := b.normalize(); err != nil {
+		return nil, err
+	}
+	questions := []Question{}
+	drawn := []int64{}
+	for _, sec := range b.Sections {
+		picked, err := s.Store.Draw(sec, drawn)
+		if err != nil {
+			return nil, err
+		}
+		if len(picked) < sec.Count {
+			return nil, notEnough(sec, len(picked))
+		}
+		for _, q := range picked {
+			drawn = append(drawn, q.ID)
+		}
+		questions = append(questions, picked...)
+	}
+	if b.Shuffle {
+		rand.Shuffle(len(questions), func(i, j int) {
+			questions[i], questions[j] = questions[j], questions[i]
+		})
+	}
+	return questions, nil
+}
@@ -0,0 +1,235 @@
+package exams
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Store persists the question bank in Postgres.
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore returns a Store backed by db.
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+const questionColumns = `id, topic, tags, difficulty, bloom_level, kind, question, options, correct_answer, points,
+	spec, explanation, created_by, COALESCE(updated_by, ''), created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanQuestion(row rowScanner) (Question, error) {
+	var q Question
+	var spec []byte
+	err := row.Scan(&q.ID, &q.Topic, pq.Array(&q.Tags), &q.Difficulty, &q.Bloom, &q.Kind, &q.Question,
+		pq.Array(&q.Options), &q.CorrectAnswer, &q.Points, &spec, &q.Explanation, &q.CreatedBy, &q.UpdatedBy,
+		&q.CreatedAt, &q.UpdatedAt)
+	if err != nil {
+		return q, err
+	}
+	if q.Tags == nil {
+		q.Tags = []string{}
+	}
+	if q.Options == nil {
+		q.Options = []string{}
+	}
+	return q, json.Unmarshal(spec, &q.Spec)
+}
+
+func (s *Store) queryQuestions(query string, args ...interface{}) ([]Question, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Question{}
+	for rows.Next() {
+		q, err := scanQuestion(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, q)
+	}
+	return list, rows.Err()
+}
+
+// Create stores a new question.
+func (s *Store) Create(q *Question) error {
+	spec, err := json.Marshal(q.Spec)
+	if err != nil {
+		return err
+	}
+	return s.db.QueryRow(
+		`INSERT INTO exam_questions (topic, tags, difficulty, bloom_level, kind, question, options, correct_answer,
+			points, spec, explanation, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id, created_at, updated_at`,
+		q.Topic, pq.Array(q.Tags), q.Difficulty, q.Bloom, q.Kind, q.Question, pq.Array(q.Options), q.CorrectAnswer,
+		q.Points, spec, q.Explanation, q.CreatedBy,
+	).Scan(&q.ID, &q.CreatedAt, &q.UpdatedAt)
+}
+
+// Get loads a question.
+func (s *Store) Get(id int64) (Question, error) {
+	q, err := scanQuestion(s.db.QueryRow(`SELECT `+questionColumns+` FROM exam_questions WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return q, ErrNotFound
+	}
+	return q, err
+}
+
+// Update replaces a question's content, keeping its author.
+func (s *Store) Update(q *Question) error {
+	spec, err := json.Marshal(q.Spec)
+	if err != nil {
+		return err
+	}
+	err = s.db.QueryRow(
+		`UPDATE exam_questions SET topic = $2, tags = $3, difficulty = $4, bloom_level = $5, kind = $6, question = $7,
+			options = $8, correct_answer = $9, points = $10, spec = $11, explanation = $12, updated_by = $13,
+			updated_at = NOW()
+		 WHERE id = $1 RETURNING created_by, created_at, updated_at`,
+		q.ID, q.Topic, pq.Array(q.Tags), q.Difficulty, q.Bloom, q.Kind, q.Question, pq.Array(q.Options),
+		q.CorrectAnswer, q.Points, spec, q.Explanation, q.UpdatedBy,
+	).Scan(&q.CreatedBy, &q.CreatedAt, &q.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Delete removes a question from the bank.
+func (s *Store) Delete(id int64) error {
+	res, err := s.db.Exec(`DELETE FROM exam_questions WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// where builds the conditions of a filter, numbering its arguments after
+// args.
+func where(f Filter, args []interface{}) (string, []interface{}) {
+	conds := []string{"TRUE"}
+	add := func(cond string, v interface{}) {
+		args = append(args, v)
+		conds = append(conds, strings.ReplaceAll(cond, "?", "$"+strconv.Itoa(len(args))))
+	}
+	if f.Topic != "" {
+		add("topic = ?", f.Topic)
+	}
+	if f.Tag != "" {
+		add("? = ANY(tags)", f.Tag)
+	}
+	if f.Difficulty != "" {
+		add("difficulty = ?", f.Difficulty)
+	}
+	if f.Bloom != "" {
+		add("bloom_level = ?", f.Bloom)
+	}
+	if f.Kind != "" {
+		add("kind = ?", f.Kind)
+	}
+	if f.CreatedBy != "" {
+		add("created_by = ?", f.CreatedBy)
+	}
+	if f.Search != "" {
+		add("question ILIKE '%' || ? || '%'", f.Search)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// List returns the questions matching f by topic, then newest first.
+func (s *Store) List(f Filter) ([]Question, error) {
+	cond, args := where(f, nil)
+	query := `SELECT ` + questionColumns + ` FROM exam_questions WHERE ` + cond + ` ORDER BY topic, id DESC`
+	if f.Limit > 0 {
+		args = append(args, f.Limit, f.Offset)
+		query += ` LIMIT $` + strconv.Itoa(len(args)-1) + ` OFFSET $` + strconv.Itoa(len(args))
+	}
+	return s.queryQuestions(query, args...)
+}
+
+// Topics returns every topic of the bank with its question count.
+func (s *Store) Topics() ([]TopicCount, error) {
+	rows, err := s.db.Query(`SELECT topic, COUNT(*) FROM exam_questions GROUP BY topic ORDER BY topic`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	topics := []TopicCount{}
+	for rows.Next() {
+		var t TopicCount
+		if err := rows.Scan(&t.Topic, &t.Questions); err != nil {
+			return nil, err
+		}
+		topics = append(topics, t)
+	}
+	return topics, rows.Err()
+}
+
+// Draw picks up to sec.Count random questions matching sec, leaving out
+// the questions in exclude.
+func (s *Store) Draw(sec Section, exclude []int64) ([]Question, error) {
+	cond, args := where(Filter{Topic: sec.Topic, Tag: sec.Tag, Difficulty: sec.Difficulty, Bloom: sec.Bloom, Kind: sec.Kind},
+		[]interface{}{pq.Array(exclude), sec.Count})
+	return s.queryQuestions(
+		`SELECT `+questionColumns+` FROM exam_questions
+		 WHERE NOT (id = ANY($1)) AND `+cond+` ORDER BY random() LIMIT $2`, args...,
+	)
+}
+
+// Keys returns the duplicate keys of the questions of topics, with their
+// IDs.
+func (s *Store) Keys(topics []string) (map[string]int64, error) {
+	rows, err := s.db.Query(`SELECT id, topic, kind, question FROM exam_questions WHERE topic = ANY($1)`,
+		pq.Array(topics))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make(map[string]int64)
+	for rows.Next() {
+		var q Question
+		if err := rows.Scan(&q.ID, &q.Topic, &q.Kind, &q.Question); err != nil {
+			return nil, err
+		}
+		keys[questionKey(&q)] = q.ID
+	}
+	return keys, rows.Err()
+}
+
+// Seed fills an empty bank with questions.
+func (s *Store) Seed(questions []Question) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var empty bool
+	if err := tx.QueryRow(`SELECT NOT EXISTS (SELECT 1 FROM exam_questions)`).Scan(&empty); err != nil || !empty {
+		return err
+	}
+	for _, q := range questions {
+		if _, err := tx.Exec(
+			`INSERT INTO exam_questions (topic, tags, difficulty, bloom_level, kind, question, options, correct_answer,
+				explanation, created_by)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+			q.Topic, pq.Array(q.Tags), q.Difficulty, q.Bloom, q.Kind, q.Question, pq.Array(q.Options), q.CorrectAnswer,
+			q.Explanation, q.CreatedBy,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"CampusMoon/internals/storage"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ===== Meeting Attendance =====
+
+// AttendanceRecord is one participant's presence in a room on one date.
+type AttendanceRecord struct {
+	Date           string    `json:"date"`
+	UserID         string    `json:"user_id"`
+	UserName       string    `json:"user_name"`
+	IsStaff        bool      `json:"is_staff"`
+	FirstJoined    time.Time `json:"first_joined"`
+	LastLeft       time.Time `json:"last_left"`
+	Joins          int       `json:"joins"`
+	PresentSeconds int64     `json:"present_seconds"`
+	SessionSeconds int64     `json:"session_seconds"`
+	Present        bool      `json:"present"`
+}
+
+type attendanceInterval struct {
+	start, end time.Time
+}
+
+// attendanceHeartbeat is how often open attendance records are marked as
+// still present, which bounds what a crash can add to them.
+const attendanceHeartbeat = time.Minute
+
+// InitAttendance closes the records a crash or restart left open at the
+// last time they were seen, then keeps the open ones' seen_at current.
+func InitAttendance() {
+	if storage.DB == nil {
+		return
+	}
+	_, err := storage.DB.Exec(
+		`UPDATE meeting_attendance SET left_at = COALESCE(seen_at, joined_at) WHERE left_at IS NULL`)
+	if err != nil {
+		log.Println("Error closing stale attendance:", err)
+	}
+	go func() {
+		for range time.Tick(attendanceHeartbeat) {
+			if _, err := storage.DB.Exec(`UPDATE meeting_attendance SET seen_at = NOW() WHERE left_at IS NULL`); err != nil {
+				log.Println("Error updating attendance:", err)
+			}
+		}
+	}()
+}
+
+// startAttendance records that a verified participant joined the main room.
+// Moves between the main room and breakouts keep the same record open. It
+// runs outside the room lock, so it may come after the client has left.
+func startAttendance(c *Client, roomID string) {
+	c.attendanceMu.Lock()
+	defer c.attendanceMu.Unlock()
+	if !c.Verified || c.attendanceID != 0 || c.attendanceDone || storage.DB == nil {
+		return
+	}
+	err := storage.DB.QueryRow(
+		`INSERT INTO meeting_attendance (room_id, user_id, user_name, is_staff) VALUES ($1, $2, $3, $4) RETURNING id`,
+		roomID, c.UserID, c.UserName, c.IsStaff,
+	).Scan(&c.attendanceID)
+	if err != nil {
+		log.Println("Error recording attendance:", err)
+	}
+}
+
+// endAttendance closes the participant's open attendance record.
+func endAttendance(c *Client) {
+	c.attendanceMu.Lock()
+	defer c.attendanceMu.Unlock()
+	c.attendanceDone = true
+	if c.attendanceID == 0 || storage.DB == nil {
+		return
+	}
+	_, err := storage.DB.Exec(`UPDATE meeting_attendance SET left_at = NOW() WHERE id = $1`, c.attendanceID)
+	if err != nil {
+		log.Println("Error closing attendance:", err)
+	}
+	c.attendanceID = 0
+}
+
+// requireStaff checks the staff_id query parameter (or X-Staff-ID header)
+// against user_ids and writes a 403 if it is not a staff ID.
+func requireStaff(w http.ResponseWriter, r *http.Request) (string, bool) {
+	staffID := r.URL.Query().Get("staff_id")
+	if staffID == "" {
+		staffID = r.Header.Get("X-Staff-ID")
+	}
+	if !isStaffID(storage.DB, staffID) {
+		http.Error(w, "Staff access required", http.StatusForbidden)
+		return "", false
+	}
+	return staffID, true
+}
+
+// thresholdParam reads a numeric threshold from the query string, falling
+// back to an environment variable and then to def.
+func thresholdParam(r *http.Request, name, env string, def float64) float64 {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		v = os.Getenv(env)
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 {
+		return f
+	}
+	return def
+}
+
+// MeetingAttendanceHandler reports attendance for a meeting room, optionally
+// limited to one date. A participant counts as present when their merged
+// time in the room meets both min_minutes and min_percent of the session,
+// where the session runs from the first join to the last leave that day.
+//
+// GET /meeting/attendance?room=CS101&date=2025-01-31&staff_id=STF-0001&format=csv
+func MeetingAttendanceHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireStaff(w, r); !ok {
+		return
+	}
+
+	roomID := r.URL.Query().Get("room")
+	if roomID == "" {
+		http.Error(w, "Missing room param", http.StatusBadRequest)
+		return
+	}
+	date := r.URL.Query().Get("date")
+	if date != "" {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			http.Error(w, "Invalid date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+	minMinutes := thresholdParam(r, "min_minutes", "ATTENDANCE_MIN_MINUTES", 0)
+	minPercent := thresholdParam(r, "min_percent", "ATTENDANCE_MIN_PERCENT", 50)
+
+	rows, err := storage.DB.Query(
+		`SELECT user_id, user_name, is_staff, joined_at, COALESCE(left_at, NOW()), TO_CHAR(joined_at, 'YYYY-MM-DD')
+		 FROM meeting_attendance
+		 WHERE room_id = $1 AND ($2 = '' OR TO_CHAR(joined_at, 'YYYY-MM-DD') = $2)
+		 ORDER BY joined_at`,
+		roomID, date,
+	)
+	if err != nil {
+		log.Println("Attendance query error:", err)
+		http.Error(w, "Database query failed", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type key struct{ date, userID string }
+	records := make(map[key]*AttendanceRecord)
+	intervals := make(map[key][]attendanceInterval)
+	sessionStart := make(map[string]time.Time)
+	sessionEnd := make(map[string]time.Time)
+
+	for rows.Next() {
+		var rec AttendanceRecord
+		var userName *string
+		var joined, left time.Time
+		if err := rows.Scan(&rec.UserID, &userName, &rec.IsStaff, &joined, &left, &rec.Date); err != nil {
+			log.Println("Row scan error:", err)
+			continue
+		}
+		k := key{rec.Date, rec.UserID}
+		existing, ok := records[k]
+		if !ok {
+			if userName != nil {
+				rec.UserName = *userName
+			}
+			rec.FirstJoined = joined
+			existing = &rec
+			records[k] = existing
+		}
+		existing.Joins++
+		if left.After(existing.LastLeft) {
+			existing.LastLeft = left
+		}
+		intervals[k] = append(intervals[k], attendanceInterval{joined, left})
+
+		if s, ok := sessionStart[rec.Date]; !ok || joined.Before(s) {
+			sessionStart[rec.Date] = joined
+		}
+		if left.After(sessionEnd[rec.Date]) {
+			sessionEnd[rec.Date] = left
+		}
+	}
+
+	report := make([]AttendanceRecord, 0, len(records))
+	for k, rec := range records {
+		rec.PresentSeconds = int64(mergedDuration(intervals[k]).Seconds())
+		rec.SessionSeconds = int64(sessionEnd[k.date].Sub(sessionStart[k.date]).Seconds())
+		minutesOK := float64(rec.PresentSeconds) >= minMinutes*60
+		percentOK := rec.SessionSeconds == 0 ||
+			float64(rec.PresentSeconds)*100 >= minPercent*float64(rec.SessionSeconds)
+		rec.Present = minutesOK && percentOK
+		report = append(report, *rec)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Date != report[j].Date {
+			return report[i].Date < report[j].Date
+		}
+		return report[i].UserID < report[j].UserID
+	})
+
+	if r.URL.Query().Get("format") == "csv" {
+		writeAttendanceCSV(w, roomID, report)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// mergedDuration sums the intervals after merging overlaps, so a
+// participant connected from two tabs is not counted twice.
+func mergedDuration(intervals []attendanceInterval) time.Duration {
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i].start.Before(intervals[j].start) })
+	var total time.Duration
+	var cur attendanceInterval
+	for i, iv := range intervals {
+		if i == 0 {
+			cur = iv
+			continue
+		}
+		if !iv.start.After(cur.end) {
+			if iv.end.After(cur.end) {
+				cur.end = iv.end
+			}
+			continue
+		}
+		total += cur.end.Sub(cur.start)
+		cur = iv
+	}
+	if len(intervals) > 0 {
+		total += cur.end.Sub(cur.start)
+	}
+	return total
+}
+
+func writeAttendanceCSV(w http.ResponseWriter, roomID string, report []AttendanceRecord) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "attendance-"+roomID+".csv"))
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"date", "user_id", "user_name", "is_staff", "first_joined", "last_left", "joins", "present_minutes", "session_minutes", "present"})
+	for _, rec := range report {
+		cw.Write([]string{
+			rec.Date,
+			rec.UserID,
+			rec.UserName,
+			strconv.FormatBool(rec.IsStaff),
+			rec.FirstJoined.Format(time.RFC3339),
+			rec.LastLeft.Format(time.RFC3339),
+			strconv.Itoa(rec.Joins),
+			strconv.FormatFloat(float64(rec.PresentSeconds)/60, 'f', 1, 64),
+			strconv.FormatFloat(float64(rec.SessionSeconds)/60, 'f', 1, 64),
+			strconv.FormatBool(rec.Present),
+		})
+	}
+	cw.Flush()
+}
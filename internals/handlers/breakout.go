@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// ===== Breakout Rooms =====
+
+const maxBreakoutRooms = 50
+
+// moveToRoom switches c from one room to another. The client is told first
+// so it can tear down its peer connections before the new handshake starts.
+// No room lock may be held by the caller. A client that disconnects during
+// the move is not admitted, since its read loop has already left.
+func moveToRoom(c *Client, from, to *MeetingRoom) {
+	c.Send(map[string]interface{}{"type": "room-changed", "room": to.ID, "main": to.Parent == nil})
+	from.leaveRoom(c, true)
+	to.mu.Lock()
+	defer to.mu.Unlock()
+	if !c.setMeetingRoom(to) {
+		return
+	}
+	to.admitLocked(c)
+}
+
+// releaseHost clears the host role of a client that disconnected while
+// visiting a breakout room.
+func (room *MeetingRoom) releaseHost(c *Client) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if room.HostID != c.ID {
+		return
+	}
+	room.HostID = ""
+	for id, p := range room.Participants {
+		if p.IsStaff {
+			room.HostID = id
+			break
+		}
+	}
+	room.broadcastLocked(room.stateLocked(), "")
+}
+
+// breakoutLocked returns the breakout room with the given ID. room.mu must be held.
+func (room *MeetingRoom) breakoutLocked(id string) *MeetingRoom {
+	for _, b := range room.Breakouts {
+		if b.ID == id {
+			return b
+		}
+	}
+	return nil
+}
+
+// breakoutStateLocked lists the breakout rooms, their members and the
+// pending assignments. room.mu must be held; breakout locks are taken here.
+func (room *MeetingRoom) breakoutStateLocked() map[string]interface{} {
+	rooms := []map[string]interface{}{}
+	for _, b := range room.Breakouts {
+		b.mu.Lock()
+		members := []string{}
+		for id := range b.Participants {
+			members = append(members, id)
+		}
+		b.mu.Unlock()
+		rooms = append(rooms, map[string]interface{}{"id": b.ID, "participants": members})
+	}
+	state := map[string]interface{}{
+		"type":        "breakouts-update",
+		"open":        room.BreakoutsOpen,
+		"rooms":       rooms,
+		"assignments": room.BreakoutAssigned,
+	}
+	if !room.BreakoutEndsAt.IsZero() {
+		state["endsAt"] = room.BreakoutEndsAt.Format(time.RFC3339)
+	}
+	return state
+}
+
+// sendBreakoutState sends the breakout overview to the main room's host.
+func (room *MeetingRoom) sendBreakoutState() {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	state := room.breakoutStateLocked()
+	if host := room.hostClientLocked(); host != nil {
+		host.Send(state)
+	}
+}
+
+// hostClientLocked finds the host, who may be visiting a breakout room.
+// room.mu must be held.
+func (room *MeetingRoom) hostClientLocked() *Client {
+	if host, ok := room.Participants[room.HostID]; ok {
+		return host
+	}
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+	return Clients[room.HostID]
+}
+
+// broadcastAll sends msg to the main room and every breakout room.
+func (room *MeetingRoom) broadcastAll(msg interface{}) {
+	room.mu.Lock()
+	breakouts := append([]*MeetingRoom(nil), room.Breakouts...)
+	room.broadcastLocked(msg, "")
+	room.mu.Unlock()
+	for _, b := range breakouts {
+		b.broadcast(msg, "")
+	}
+}
+
+// allParticipants lists everyone in the main room and its breakout rooms.
+func (room *MeetingRoom) allParticipants() []*Client {
+	room.mu.Lock()
+	rooms := append([]*MeetingRoom{room}, room.Breakouts...)
+	clients := []*Client{}
+	for _, c := range room.Participants {
+		clients = append(clients, c)
+	}
+	room.mu.Unlock()
+	for _, b := range rooms[1:] {
+		b.mu.Lock()
+		for _, c := range b.Participants {
+			clients = append(clients, c)
+		}
+		b.mu.Unlock()
+	}
+	return clients
+}
+
+// openBreakouts moves every assigned participant into their breakout room
+// and starts the optional countdown.
+func (room *MeetingRoom) openBreakouts(seconds int) {
+	type move struct {
+		c  *Client
+		to *MeetingRoom
+	}
+	var moves []move
+
+	room.mu.Lock()
+	room.BreakoutsOpen = true
+	for clientID, breakoutID := range room.BreakoutAssigned {
+		c, ok := room.Participants[clientID]
+		b := room.breakoutLocked(breakoutID)
+		if ok && b != nil {
+			moves = append(moves, move{c, b})
+		}
+	}
+	if room.breakoutTimer != nil {
+		room.breakoutTimer.Stop()
+		room.breakoutTimer = nil
+	}
+	room.breakoutRound++
+	room.BreakoutEndsAt = time.Time{}
+	if seconds > 0 {
+		round := room.breakoutRound
+		room.BreakoutEndsAt = time.Now().Add(time.Duration(seconds) * time.Second)
+		room.breakoutTimer = time.AfterFunc(time.Duration(seconds)*time.Second, func() {
+			room.closeBreakouts(round)
+		})
+	}
+	room.mu.Unlock()
+
+	for _, m := range moves {
+		moveToRoom(m.c, room, m.to)
+	}
+	if seconds > 0 {
+		room.broadcastAll(map[string]interface{}{
+			"type":   "breakout-countdown",
+			"endsAt": room.BreakoutEndsAt.Format(time.RFC3339),
+		})
+	}
+}
+
+// closeBreakouts recalls everyone to the main room and removes the
+// breakout rooms. A countdown passes the round it was started in, so a
+// stale timer leaves newer breakouts alone; the host passes 0.
+func (room *MeetingRoom) closeBreakouts(round int) {
+	room.mu.Lock()
+	if round != 0 && round != room.breakoutRound {
+		room.mu.Unlock()
+		return
+	}
+	room.breakoutRound++
+	closing := room.breakoutRound
+	breakouts := room.Breakouts
+	room.BreakoutsOpen = false
+	room.BreakoutEndsAt = time.Time{}
+	if room.breakoutTimer != nil {
+		room.breakoutTimer.Stop()
+		room.breakoutTimer = nil
+	}
+	room.mu.Unlock()
+
+	for _, b := range breakouts {
+		b.mu.Lock()
+		members := make([]*Client, 0, len(b.Participants))
+		for _, c := range b.Participants {
+			members = append(members, c)
+		}
+		b.mu.Unlock()
+		for _, c := range members {
+			moveToRoom(c, b, room)
+		}
+	}
+
+	room.mu.Lock()
+	if room.breakoutRound == closing {
+		room.Breakouts = nil
+		room.BreakoutAssigned = make(map[string]string)
+	}
+	room.mu.Unlock()
+	room.sendBreakoutState()
+}
+
+// handleBreakouts handles the host's breakout room commands. It returns
+// false if msgType is not a breakout message.
+func handleBreakouts(current *MeetingRoom, caller *Client, msgType string, msg map[string]interface{}) bool {
+	switch msgType {
+	case "create-breakouts", "assign-breakout", "assign-breakouts-random", "open-breakouts",
+		"broadcast-breakouts", "join-breakout", "return-to-main", "close-breakouts":
+	default:
+		return false
+	}
+
+	// Breakouts are managed from the main room, even while the host is
+	// visiting one of them.
+	room := current.mainRoom()
+
+	room.mu.Lock()
+	if caller.ID != room.HostID {
+		room.mu.Unlock()
+		caller.Send(moderationError("Only the host can do that"))
+		return true
+	}
+
+	switch msgType {
+	case "create-breakouts":
+		count := 0
+		if n, ok := msg["count"].(float64); ok {
+			count = int(n)
+		}
+		if room.BreakoutsOpen {
+			room.mu.Unlock()
+			caller.Send(moderationError("Close the open breakout rooms first"))
+			return true
+		}
+		if count < 1 || count > maxBreakoutRooms {
+			room.mu.Unlock()
+			caller.Send(moderationError(fmt.Sprintf("Breakout count must be between 1 and %d", maxBreakoutRooms)))
+			return true
+		}
+		room.Breakouts = make([]*MeetingRoom, count)
+		for i := range room.Breakouts {
+			room.Breakouts[i] = newMeetingRoom(fmt.Sprintf("%s/breakout-%d", room.ID, i+1), room)
+		}
+		room.BreakoutAssigned = make(map[string]string)
+		room.breakoutRound++
+		room.mu.Unlock()
+
+	case "assign-breakout":
+		target, _ := msg["target"].(string)
+		breakoutID, _ := msg["breakout"].(string)
+		_, ok := room.Participants[target]
+		if !ok || target == room.HostID || room.breakoutLocked(breakoutID) == nil {
+			room.mu.Unlock()
+			caller.Send(moderationError("Unknown participant or breakout room"))
+			return true
+		}
+		room.BreakoutAssigned[target] = breakoutID
+		b := room.breakoutLocked(breakoutID)
+		c := room.Participants[target]
+		open := room.BreakoutsOpen
+		room.mu.Unlock()
+		// Late assignments while breakouts are open take effect at once.
+		if open {
+			moveToRoom(c, room, b)
+		}
+
+	case "assign-breakouts-random":
+		if len(room.Breakouts) == 0 {
+			room.mu.Unlock()
+			caller.Send(moderationError("Create breakout rooms first"))
+			return true
+		}
+		ids := []string{}
+		for id := range room.Participants {
+			if id != room.HostID {
+				ids = append(ids, id)
+			}
+		}
+		rand.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+		room.BreakoutAssigned = make(map[string]string)
+		for i, id := range ids {
+			room.BreakoutAssigned[id] = room.Breakouts[i%len(room.Breakouts)].ID
+		}
+		room.mu.Unlock()
+
+	case "open-breakouts":
+		if len(room.Breakouts) == 0 {
+			room.mu.Unlock()
+			caller.Send(moderationError("Create breakout rooms first"))
+			return true
+		}
+		room.mu.Unlock()
+		seconds := 0
+		if s, ok := msg["seconds"].(float64); ok && s > 0 {
+			seconds = int(s)
+		}
+		room.openBreakouts(seconds)
+
+	case "broadcast-breakouts":
+		text, _ := msg["message"].(string)
+		room.mu.Unlock()
+		if text == "" {
+			return true
+		}
+		room.broadcastAll(map[string]interface{}{
+			"type":      "breakout-broadcast",
+			"message":   text,
+			"timestamp": time.Now().Format(time.RFC3339),
+		})
+
+	case "join-breakout":
+		breakoutID, _ := msg["breakout"].(string)
+		b := room.breakoutLocked(breakoutID)
+		room.mu.Unlock()
+		if b == nil || b == current {
+			caller.Send(moderationError("Unknown breakout room"))
+			return true
+		}
+		moveToRoom(caller, current, b)
+
+	case "return-to-main":
+		room.mu.Unlock()
+		if current != room {
+			moveToRoom(caller, current, room)
+		}
+
+	case "close-breakouts":
+		room.mu.Unlock()
+		room.closeBreakouts(0)
+		return true
+	}
+
+	room.sendBreakoutState()
+	return true
+}
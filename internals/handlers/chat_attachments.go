@@ -0,0 +1,449 @@
+package handlers
+
+import (
+	"CampusMoon/internals/messaging"
+	"CampusMoon/internals/storage"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+)
+
+// ===== Chat Attachments =====
+
+const (
+	thumbnailMaxSide  = 320
+	maxImagePixels    = 40 << 20 // refuse to decode larger images
+	maxCodePreviewLen = 2000
+)
+
+var (
+	chatAttachmentMaxBytes = envInt64("CHAT_ATTACHMENT_MAX_BYTES", 20<<20)
+
+	chatBucketOnce sync.Once
+	chatBucketErr  error
+)
+
+// codeLanguages maps source file extensions to highlight.js language names.
+var codeLanguages = map[string]string{
+	".c": "c", ".h": "c", ".cpp": "cpp", ".cc": "cpp", ".hpp": "cpp", ".cs": "csharp",
+	".css": "css", ".go": "go", ".html": "xml", ".java": "java", ".js": "javascript",
+	".json": "json", ".kt": "kotlin", ".md": "markdown", ".php": "php", ".py": "python",
+	".rb": "ruby", ".rs": "rust", ".sh": "bash", ".sql": "sql", ".swift": "swift",
+	".ts": "typescript", ".v": "verilog", ".vhd": "vhdl", ".xml": "xml", ".yaml": "yaml",
+	".yml": "yaml", ".csv": "plaintext", ".txt": "plaintext", ".log": "plaintext",
+}
+
+// inlineTypes are served for display in the page; everything else is sent
+// as a download.
+var inlineTypes = map[string]bool{
+	"image/png": true, "image/jpeg": true, "image/gif": true, "image/webp": true,
+	"application/pdf": true,
+}
+
+// chatScope is the chat a request acts in, a discussion channel, a direct
+// conversation or a meeting room, with the caller's identity there.
+type chatScope struct {
+	Channel string // messaging channel
+	Prefix  string // object storage prefix of its files
+	Actor   messaging.Actor
+	client  *Client // the caller's connection, for meeting rooms
+}
+
+func envInt64(name string, def int64) int64 {
+	if n, err := strconv.ParseInt(os.Getenv(name), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return def
+}
+
+// discussionMediaPrefix is where a channel's files live in object storage.
+func discussionMediaPrefix(channel *DiscussionChannel) string {
+	return fmt.Sprintf("discussion/%d", channel.ID)
+}
+
+// meetingMediaPrefix is where a meeting room's chat files live.
+func meetingMediaPrefix(roomID string) string {
+	return "meeting/" + url.PathEscape(roomID)
+}
+
+// chatMediaBucket returns the bucket for chat files, MINIO_AUDIO_BUCKET or
+// the video bucket as before, creating it on first use.
+func chatMediaBucket() (string, error) {
+	if storage.MinioClient == nil {
+		return "", errors.New("object storage is not configured")
+	}
+	bucket := os.Getenv("MINIO_AUDIO_BUCKET")
+	if bucket == "" {
+		bucket = storage.VideoBucketName
+	}
+	chatBucketOnce.Do(func() {
+		ctx := context.Background()
+		exists, err := storage.MinioClient.BucketExists(ctx, bucket)
+		if err == nil && !exists {
+			err = storage.MinioClient.MakeBucket(ctx, bucket, minio.MakeBucketOptions{})
+		}
+		if err != nil {
+			log.Println("chat media bucket:", err)
+		}
+		chatBucketErr = err
+	})
+	return bucket, chatBucketErr
+}
+
+// meetingClientFor finds userID's verified connection in roomID, which may
+// be a breakout room.
+func meetingClientFor(roomID, userID string) *Client {
+	// Room locks are taken before clientsMu elsewhere, so collect the
+	// candidates first and check their rooms after releasing it.
+	var candidates []*Client
+	clientsMu.RLock()
+	for _, c := range Clients {
+		if c.Verified && c.UserID == userID {
+			candidates = append(candidates, c)
+		}
+	}
+	clientsMu.RUnlock()
+	for _, c := range candidates {
+		if room := c.meetingRoom(); room != nil && room.ID == roomID && room.isParticipant(c.ID) {
+			return c
+		}
+	}
+	return nil
+}
+
+// attendedMeeting reports whether userID has joined the main room of roomID.
+func attendedMeeting(roomID, userID string) bool {
+	mainID := strings.SplitN(roomID, "/breakout-", 2)[0]
+	var attended bool
+	err := storage.DB.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM meeting_attendance WHERE room_id = $1 AND user_id = $2)`, mainID, userID,
+	).Scan(&attended)
+	if err != nil {
+		log.Println("attendance lookup:", err)
+	}
+	return attended
+}
+
+// chatScopeFromRequest resolves the channel (discussion), conversation
+// (direct messages) or room (meeting) query parameter and checks that
+// user_id belongs to it, writing an error on failure. Posting to a meeting needs a live connection in the room;
+// reading also allows anyone who attended it.
+func chatScopeFromRequest(w http.ResponseWriter, r *http.Request, posting bool) (*chatScope, bool) {
+	if r.URL.Query().Get("channel") != "" {
+		channel, identity, ok := discussionChannelFromRequest(w, r)
+		if !ok {
+			return nil, false
+		}
+		return &chatScope{
+			Channel: channel.hubChannel(),
+			Prefix:  discussionMediaPrefix(channel),
+			Actor:   messaging.Actor{ID: identity.UserID, Name: identity.DisplayName, IsStaff: identity.IsStaff},
+		}, true
+	}
+	if r.URL.Query().Get("conversation") != "" {
+		conv, actor, ok := directConversationFromRequest(w, r)
+		if !ok {
+			return nil, false
+		}
+		return &chatScope{Channel: conv.hubChannel(), Prefix: directMediaPrefix(conv), Actor: actor}, true
+	}
+
+	roomID := r.URL.Query().Get("room")
+	userID := r.URL.Query().Get("user_id")
+	if roomID == "" {
+		http.Error(w, "Missing channel, conversation or room param", http.StatusBadRequest)
+		return nil, false
+	}
+	if storage.DB == nil || !isRegisteredID(storage.DB, userID) {
+		http.Error(w, "You are not a member of this meeting", http.StatusForbidden)
+		return nil, false
+	}
+	scope := &chatScope{
+		Channel: messaging.MeetingChannel(roomID),
+		Prefix:  meetingMediaPrefix(roomID),
+		Actor:   messaging.Actor{ID: userID, Name: fmt.Sprintf("User %s", shortID(userID)), IsStaff: isStaffID(storage.DB, userID)},
+		client:  meetingClientFor(roomID, userID),
+	}
+	if scope.client != nil {
+		scope.Actor.Name = scope.client.UserName
+	}
+	if scope.client == nil && (posting || !(scope.Actor.IsStaff || attendedMeeting(roomID, userID))) {
+		http.Error(w, "You are not a member of this meeting", http.StatusForbidden)
+		return nil, false
+	}
+	return scope, true
+}
+
+// classifyAttachment checks an upload against the allowed file types and
+// returns its content type. Images, PDFs and UTF-8 text or source files are
+// accepted; the type comes from the content, not the client.
+func classifyAttachment(name string, data []byte) (string, error) {
+	detected := http.DetectContentType(data)
+	switch {
+	case detected == "image/png", detected == "image/jpeg", detected == "image/gif", detected == "image/webp":
+		return detected, nil
+	case detected == "application/pdf":
+		return detected, nil
+	}
+	if _, ok := codeLanguages[strings.ToLower(filepath.Ext(name))]; ok && utf8.Valid(data) {
+		// Served as plain text so HTML and scripts are never rendered.
+		return "text/plain; charset=utf-8", nil
+	}
+	return "", fmt.Errorf("file type %s is not allowed; share images, PDFs or text and code files", detected)
+}
+
+// makeThumbnail scales an image to fit thumbnailMaxSide and encodes it as
+// JPEG over a white background. ok is false for formats the standard
+// library cannot decode, such as WebP.
+func makeThumbnail(data []byte) (thumb []byte, width, height int, ok bool) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width*cfg.Height > maxImagePixels {
+		return nil, cfg.Width, cfg.Height, false
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, cfg.Width, cfg.Height, false
+	}
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > thumbnailMaxSide || h > thumbnailMaxSide {
+		if w >= h {
+			w, h = thumbnailMaxSide, max(1, h*thumbnailMaxSide/b.Dx())
+		} else {
+			w, h = max(1, w*thumbnailMaxSide/b.Dy()), thumbnailMaxSide
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, bl, a := src.At(b.Min.X+x*b.Dx()/w, b.Min.Y+y*b.Dy()/h).RGBA()
+			// premultiplied colour over white
+			dst.Set(x, y, color.RGBA64{uint16(r + 0xffff - a), uint16(g + 0xffff - a), uint16(bl + 0xffff - a), 0xffff})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, cfg.Width, cfg.Height, false
+	}
+	return buf.Bytes(), cfg.Width, cfg.Height, true
+}
+
+// putChatObject stores data under the scope's prefix and returns the object
+// name and the member-only URL it is served from.
+func putChatObject(scope *chatScope, kind, ext, contentType, fileName string, data []byte) (string, string, error) {
+	bucket, err := chatMediaBucket()
+	if err != nil {
+		return "", "", err
+	}
+	object := fmt.Sprintf("%s/%s-%s%s", scope.Prefix, kind, uuid.New().String(), ext)
+	opts := minio.PutObjectOptions{ContentType: contentType}
+	if !inlineTypes[contentType] {
+		opts.ContentDisposition = mime.FormatMediaType("attachment", map[string]string{"filename": fileName})
+	}
+	_, err = storage.MinioClient.PutObject(context.Background(), bucket, object, bytes.NewReader(data), int64(len(data)), opts)
+	return object, "/chat/media?object=" + url.QueryEscape(object), err
+}
+
+// removeChatObjects deletes stored chat files whose message was never
+// posted.
+func removeChatObjects(objects ...string) {
+	bucket, err := chatMediaBucket()
+	if err != nil {
+		return
+	}
+	for _, object := range objects {
+		if object == "" {
+			continue
+		}
+		if err := storage.MinioClient.RemoveObject(context.Background(), bucket, object, minio.RemoveObjectOptions{}); err != nil {
+			log.Println("remove chat object:", err)
+		}
+	}
+}
+
+// ChatAttachmentHandler uploads a file and posts it as a message, with an
+// optional caption. Images get a thumbnail and code files the language and
+// line count needed for syntax highlighting.
+//
+// POST /chat/attachments?channel=<id>&user_id=<id>   (discussion)
+// POST /chat/attachments?room=CS101&user_id=<id>     (meeting chat)
+// multipart form: file, message (caption), reply_to_id (discussions only)
+func ChatAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	scope, ok := chatScopeFromRequest(w, r, true)
+	if !ok {
+		return
+	}
+	if scope.client != nil {
+		if room := scope.client.meetingRoom(); room != nil && room.chatDisabledFor(scope.client) {
+			http.Error(w, "Chat is disabled by the host", http.StatusForbidden)
+			return
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, chatAttachmentMaxBytes+1<<20)
+	if err := r.ParseMultipartForm(8 << 20); err != nil {
+		http.Error(w, fmt.Sprintf("Upload too large or malformed; files are limited to %d MB", chatAttachmentMaxBytes>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "file is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, chatAttachmentMaxBytes+1))
+	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusBadRequest)
+		return
+	}
+	if len(data) == 0 || int64(len(data)) > chatAttachmentMaxBytes {
+		http.Error(w, fmt.Sprintf("Files must be between 1 byte and %d MB", chatAttachmentMaxBytes>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	name := filepath.Base(header.Filename)
+	contentType, err := classifyAttachment(name, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	att := messaging.Attachment{Name: name, ContentType: contentType, Size: int64(len(data))}
+	att.Object, att.URL, err = putChatObject(scope, "file", strings.ToLower(filepath.Ext(name)), contentType, name, data)
+	if err != nil {
+		log.Println("upload attachment:", err)
+		http.Error(w, "Failed to store file", http.StatusInternalServerError)
+		return
+	}
+
+	thumbObject := ""
+	switch {
+	case strings.HasPrefix(contentType, "image/"):
+		thumb, width, height, ok := makeThumbnail(data)
+		att.Width, att.Height = width, height
+		if ok {
+			if thumbObject, att.ThumbnailURL, err = putChatObject(scope, "thumb", ".jpg", "image/jpeg", "", thumb); err != nil {
+				log.Println("upload thumbnail:", err)
+				thumbObject, att.ThumbnailURL = "", ""
+			}
+		}
+	case strings.HasPrefix(contentType, "text/"):
+		att.Language = codeLanguages[strings.ToLower(filepath.Ext(name))]
+		att.Lines = bytes.Count(data, []byte("\n")) + 1
+		if bytes.HasSuffix(data, []byte("\n")) {
+			att.Lines--
+		}
+		preview := string(data)
+		if len(preview) > maxCodePreviewLen {
+			preview = strings.ToValidUTF8(preview[:maxCodePreviewLen], "")
+		}
+		att.Preview = preview
+	}
+
+	m := messaging.Message{
+		Channel:     scope.Channel,
+		SenderID:    scope.Actor.ID,
+		SenderName:  scope.Actor.Name,
+		Kind:        messaging.KindFile,
+		Body:        r.FormValue("message"),
+		Attachments: []messaging.Attachment{att},
+	}
+	if v := r.FormValue("reply_to_id"); v != "" && scope.client == nil {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			removeChatObjects(att.Object, thumbObject)
+			http.Error(w, "Invalid reply_to_id", http.StatusBadRequest)
+			return
+		}
+		m.ReplyToID = &id
+	}
+	m, err = Messages.Post(m)
+	if err != nil {
+		removeChatObjects(att.Object, thumbObject)
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, messaging.ErrBadReply), errors.Is(err, messaging.ErrMessageTooLong):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, messaging.ErrMuted), errors.Is(err, messaging.ErrLinksBlocked):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, messaging.ErrSlowMode):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	default:
+		log.Println("post attachment:", err)
+		http.Error(w, "Failed to post message", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newDiscussionEvent(m))
+}
+
+// ChatMediaHandler streams a chat file to members of its channel or room,
+// with range support for seeking:
+// /chat/media?channel=<id>|room=<id>&user_id=<id>&object=<object name>.
+func ChatMediaHandler(w http.ResponseWriter, r *http.Request) {
+	scope, ok := chatScopeFromRequest(w, r, false)
+	if !ok {
+		return
+	}
+	object := r.URL.Query().Get("object")
+	if !strings.HasPrefix(object, scope.Prefix+"/") || strings.Contains(object, "..") {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	bucket, err := chatMediaBucket()
+	if err != nil {
+		http.Error(w, "File storage is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	obj, err := storage.MinioClient.GetObject(r.Context(), bucket, object, minio.GetObjectOptions{})
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	defer obj.Close()
+	info, err := obj.Stat()
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	if cd := info.Metadata.Get("Content-Disposition"); cd != "" {
+		w.Header().Set("Content-Disposition", cd)
+	}
+	http.ServeContent(w, r, filepath.Base(object), info.LastModified, obj)
+}
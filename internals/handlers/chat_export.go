@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"CampusMoon/internals/messaging"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ===== Chat Export =====
+
+var exportFileUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// chatExport is one chat's messages over a date range.
+type chatExport struct {
+	Channel  string              `json:"channel"`
+	From     time.Time           `json:"from"`
+	To       time.Time           `json:"to"`
+	Messages []messaging.Message `json:"messages"`
+
+	mediaQuery string // appended to media links so staff can open them
+}
+
+// exportRangeFromRequest reads from and to (YYYY-MM-DD or RFC 3339). A
+// date-only to includes that whole day. Missing bounds mean the start of
+// the chat and now.
+func exportRangeFromRequest(r *http.Request) (time.Time, time.Time, error) {
+	from, to := time.Unix(0, 0), time.Now().Add(time.Minute)
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			t, err = time.ParseInLocation("2006-01-02", v, time.Local)
+		}
+		if err != nil {
+			return from, to, fmt.Errorf("invalid from, expected YYYY-MM-DD or RFC 3339")
+		}
+		from = t
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			t, err = time.ParseInLocation("2006-01-02", v, time.Local)
+			t = t.AddDate(0, 0, 1)
+		}
+		if err != nil {
+			return from, to, fmt.Errorf("invalid to, expected YYYY-MM-DD or RFC 3339")
+		}
+		to = t
+	}
+	if !to.After(from) {
+		return from, to, fmt.Errorf("to must be after from")
+	}
+	return from, to, nil
+}
+
+// exportFileName names a download after the chat and range.
+func exportFileName(prefix string, r *http.Request, ext string) string {
+	name := r.URL.Query().Get("channel")
+	if name == "" {
+		name = r.URL.Query().Get("room")
+	}
+	name = prefix + "-" + exportFileUnsafe.ReplaceAllString(name, "_")
+	for _, p := range []string{"from", "to"} {
+		if v := r.URL.Query().Get(p); v != "" {
+			name += "-" + exportFileUnsafe.ReplaceAllString(v, "_")
+		}
+	}
+	return name + "." + ext
+}
+
+// voiceNoteSeconds formats a voice note's length as m:ss.
+func voiceNoteSeconds(ms int64) string {
+	s := (ms + 500) / 1000
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// reactionSummary lists a message's reactions as "👍 3, 🎉 1".
+func reactionSummary(m messaging.Message) string {
+	emoji := make([]string, 0, len(m.Reactions))
+	for e := range m.Reactions {
+		emoji = append(emoji, e)
+	}
+	sort.Strings(emoji)
+	parts := make([]string, 0, len(emoji))
+	for _, e := range emoji {
+		parts = append(parts, fmt.Sprintf("%s %d", e, len(m.Reactions[e])))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// MediaURL links to an attachment with the staff member's access.
+func (e *chatExport) MediaURL(a messaging.Attachment) string {
+	if strings.HasPrefix(a.URL, "/chat/media?") || strings.HasPrefix(a.URL, "/discussion/media?") {
+		return a.URL + "&" + e.mediaQuery
+	}
+	return a.URL
+}
+
+// ChatExportHandler exports a discussion channel or meeting chat for a date
+// range as json (default), csv, txt or html, for staff. Voice notes are
+// exported as links with their transcripts.
+//
+// GET /chat/export?staff_id=STF-0001&channel=3|room=CS101&from=2025-01-01&to=2025-01-31&format=html
+func ChatExportHandler(w http.ResponseWriter, r *http.Request) {
+	staffID, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+	channel, ok := moderationChannelFromRequest(w, r, false)
+	if !ok {
+		return
+	}
+	from, to, err := exportRangeFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	messages, err := Messages.Store.Range(channel, from, to)
+	if err != nil {
+		log.Println("chat export:", err)
+		http.Error(w, "Database query failed", http.StatusInternalServerError)
+		return
+	}
+
+	media := url.Values{"user_id": {staffID}}
+	if v := r.URL.Query().Get("channel"); v != "" {
+		media.Set("channel", v)
+	} else {
+		media.Set("room", r.URL.Query().Get("room"))
+	}
+	export := &chatExport{Channel: channel, From: from, To: to, Messages: messages, mediaQuery: media.Encode()}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFileName("chat", r, "json")))
+		writeJSON(w, export)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFileName("chat", r, "csv")))
+		writeChatCSV(w, export)
+	case "txt":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFileName("chat", r, "txt")))
+		writeChatText(w, export)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFileName("chat", r, "html")))
+		if err := chatExportTemplate.Execute(w, export); err != nil {
+			log.Println("chat export html:", err)
+		}
+	default:
+		http.Error(w, "format must be json, csv, txt or html", http.StatusBadRequest)
+	}
+}
+
+func writeChatCSV(w http.ResponseWriter, e *chatExport) {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "created_at", "sender_id", "sender_name", "kind", "reply_to_id", "body", "attachments", "voice_note_seconds", "transcript", "reactions", "edited"})
+	for _, m := range e.Messages {
+		var replyTo string
+		if m.ReplyToID != nil {
+			replyTo = strconv.FormatInt(*m.ReplyToID, 10)
+		}
+		var links, transcripts []string
+		var seconds string
+		for _, a := range m.Attachments {
+			links = append(links, e.MediaURL(a))
+			if a.Transcript != "" {
+				transcripts = append(transcripts, a.Transcript)
+			}
+			if a.DurationMs > 0 {
+				seconds = strconv.FormatFloat(float64(a.DurationMs)/1000, 'f', 1, 64)
+			}
+		}
+		cw.Write([]string{
+			strconv.FormatInt(m.ID, 10),
+			m.CreatedAt.Format(time.RFC3339),
+			m.SenderID,
+			m.SenderName,
+			m.Kind,
+			replyTo,
+			m.Body,
+			strings.Join(links, " "),
+			seconds,
+			strings.Join(transcripts, " "),
+			reactionSummary(m),
+			strconv.FormatBool(m.Edited),
+		})
+	}
+	cw.Flush()
+}
+
+func writeChatText(w http.ResponseWriter, e *chatExport) {
+	fmt.Fprintf(w, "%s\n%s to %s\n\n", e.Channel, e.From.Format("2006-01-02 15:04"), e.To.Format("2006-01-02 15:04"))
+	for _, m := range e.Messages {
+		indent, replyTo := "", ""
+		if m.ReplyToID != nil {
+			indent, replyTo = "    ", fmt.Sprintf(" (reply to #%d)", *m.ReplyToID)
+		}
+		fmt.Fprintf(w, "%s[%s] #%d%s %s: %s\n", indent, m.CreatedAt.Format("2006-01-02 15:04"), m.ID, replyTo, m.SenderName, m.Body)
+		for _, a := range m.Attachments {
+			switch {
+			case m.Kind == messaging.KindAudio:
+				fmt.Fprintf(w, "%s    [voice note %s] %s\n", indent, voiceNoteSeconds(a.DurationMs), e.MediaURL(a))
+				if a.Transcript != "" {
+					fmt.Fprintf(w, "%s    transcript: %s\n", indent, a.Transcript)
+				}
+			default:
+				fmt.Fprintf(w, "%s    [file %s] %s\n", indent, a.Name, e.MediaURL(a))
+			}
+		}
+		if s := reactionSummary(m); s != "" {
+			fmt.Fprintf(w, "%s    reactions: %s\n", indent, s)
+		}
+	}
+}
+
+var chatExportTemplate = template.Must(template.New("chat-export").Funcs(template.FuncMap{
+	"reactions": reactionSummary,
+	"seconds":   voiceNoteSeconds,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Channel}} transcript</title>
+<style>
+body{font-family:system-ui,sans-serif;max-width:860px;margin:24px auto;color:#1b2733}
+.msg{padding:8px 12px;border-bottom:1px solid #e6ecf2}
+.reply{margin-left:32px;border-left:3px solid #c9d6e4}
+.meta{color:#6b7c8f;font-size:12px}
+.transcript{font-style:italic;color:#44546a}
+</style>
+</head>
+<body>
+<h1>{{.Channel}}</h1>
+<p class="meta">{{.From.Format "2006-01-02 15:04"}} to {{.To.Format "2006-01-02 15:04"}} &middot; {{len .Messages}} messages</p>
+{{range .Messages}}
+<div class="msg{{if .ReplyToID}} reply{{end}}" id="m{{.ID}}">
+  <div class="meta">#{{.ID}} &middot; {{.CreatedAt.Format "2006-01-02 15:04"}}{{if .ReplyToID}} &middot; reply to <a href="#m{{.ReplyToID}}">#{{.ReplyToID}}</a>{{end}}{{if .Edited}} &middot; edited{{end}}</div>
+  <strong>{{.SenderName}}</strong> {{.Body}}
+  {{$kind := .Kind}}{{range .Attachments}}
+  <div>{{if eq $kind "audio"}}&#127908; <a href="{{$.MediaURL .}}">Voice note ({{seconds .DurationMs}})</a>{{if .Transcript}}<div class="transcript">{{.Transcript}}</div>{{end}}{{else}}&#128206; <a href="{{$.MediaURL .}}">{{.Name}}</a>{{end}}</div>
+  {{end}}
+  {{with reactions .}}<div class="meta">{{.}}</div>{{end}}
+</div>
+{{end}}
+</body>
+</html>
+`))
+
+// ChatParticipationHandler reports per-member participation in a discussion
+// channel or meeting chat over a date range, as json (default) or csv, for
+// staff. Members who never posted are listed with zero counts.
+//
+// GET /chat/participation?staff_id=STF-0001&channel=3|room=CS101&from=2025-01-01&to=2025-01-31&format=csv
+func ChatParticipationHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireStaff(w, r); !ok {
+		return
+	}
+	channel, ok := moderationChannelFromRequest(w, r, false)
+	if !ok {
+		return
+	}
+	from, to, err := exportRangeFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stats, err := Messages.Store.Participation(channel, from, to)
+	if err != nil {
+		log.Println("chat participation:", err)
+		http.Error(w, "Database query failed", http.StatusInternalServerError)
+		return
+	}
+	seen := map[string]bool{}
+	for _, p := range stats {
+		seen[p.UserID] = true
+	}
+	for _, id := range (chatDirectory{}).Members(channel) {
+		if !seen[id] {
+			seen[id] = true
+			stats = append(stats, messaging.Participation{UserID: id})
+		}
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		writeJSON(w, stats)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFileName("participation", r, "csv")))
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"user_id", "name", "messages", "voice_notes", "files", "replies", "reactions_received", "first_at", "last_at"})
+		for _, p := range stats {
+			var first, last string
+			if p.FirstAt != nil {
+				first, last = p.FirstAt.Format(time.RFC3339), p.LastAt.Format(time.RFC3339)
+			}
+			cw.Write([]string{
+				p.UserID, p.Name,
+				strconv.Itoa(p.Messages), strconv.Itoa(p.VoiceNotes), strconv.Itoa(p.Files),
+				strconv.Itoa(p.Replies), strconv.Itoa(p.ReactionsReceived),
+				first, last,
+			})
+		}
+		cw.Flush()
+	default:
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"CampusMoon/internals/messaging"
+	"CampusMoon/internals/storage"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ===== Mentions & Unread Counts =====
+
+// chatDirectory resolves mentions and members for messaging: discussion
+// channels from course enrollments, direct conversations from their members,
+// meeting rooms from who is connected or has attended.
+type chatDirectory struct{}
+
+// discussionCourse returns the course of a discussion messaging channel.
+func discussionCourse(channel string) (string, bool) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(channel, messaging.DiscussionChannel("")), 10, 64)
+	if err != nil || storage.DB == nil {
+		return "", false
+	}
+	c, err := loadDiscussionChannel(id)
+	if err != nil {
+		return "", false
+	}
+	return c.CourseCode, true
+}
+
+// directConversationID returns the conversation of a direct messaging channel.
+func directConversationID(channel string) (int64, bool) {
+	prefix := messaging.DirectChannel("")
+	if !strings.HasPrefix(channel, prefix) {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(channel, prefix), 10, 64)
+	return id, err == nil && storage.DB != nil
+}
+
+// meetingRoomID returns the room of a meeting messaging channel.
+func meetingRoomID(channel string) (string, bool) {
+	prefix := messaging.MeetingChannel("")
+	if !strings.HasPrefix(channel, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(channel, prefix), true
+}
+
+// connectedInRoom lists the verified connections in roomID that match keep.
+func connectedInRoom(roomID string, keep func(c *Client) bool) []string {
+	var candidates []*Client
+	clientsMu.RLock()
+	for _, c := range Clients {
+		if c.Verified && keep(c) {
+			candidates = append(candidates, c)
+		}
+	}
+	clientsMu.RUnlock()
+
+	ids := []string{}
+	for _, c := range candidates {
+		if room := c.meetingRoom(); room != nil && room.ID == roomID && room.isParticipant(c.ID) {
+			ids = append(ids, c.UserID)
+		}
+	}
+	return ids
+}
+
+func queryUserIDs(query string, args ...interface{}) []string {
+	ids := []string{}
+	if storage.DB == nil {
+		return ids
+	}
+	rows, err := storage.DB.Query(query, args...)
+	if err != nil {
+		log.Println("user lookup:", err)
+		return ids
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// ResolveMention implements messaging.Directory. Handles match a user ID or
+// a display name with spaces written as underscores or left out. In a
+// course, @staff is the staff enrolled in it, not every staff member.
+func (chatDirectory) ResolveMention(channel, handle string) []string {
+	if course, ok := discussionCourse(channel); ok {
+		if handle == messaging.MentionStaff {
+			return queryUserIDs(`SELECT user_id FROM course_enrollments WHERE course_code = $1 AND role = 'staff'`, course)
+		}
+		return queryUserIDs(
+			`SELECT user_id FROM course_enrollments WHERE course_code = $1 AND (LOWER(user_id) = $2
+				OR LOWER(REPLACE(display_name, ' ', '_')) = $2 OR LOWER(REPLACE(display_name, ' ', '')) = $2)`,
+			course, handle,
+		)
+	}
+	if id, ok := directConversationID(channel); ok {
+		return queryUserIDs(`SELECT user_id FROM dm_members WHERE conversation_id = $1 AND LOWER(user_id) = $2`, id, handle)
+	}
+	if roomID, ok := meetingRoomID(channel); ok {
+		if handle == messaging.MentionStaff {
+			return connectedInRoom(roomID, func(c *Client) bool { return c.IsStaff })
+		}
+		ids := connectedInRoom(roomID, func(c *Client) bool { return strings.ToLower(c.UserID) == handle })
+		if len(ids) == 0 {
+			mainID := strings.SplitN(roomID, "/breakout-", 2)[0]
+			ids = queryUserIDs(`SELECT DISTINCT user_id FROM meeting_attendance WHERE room_id = $1 AND LOWER(user_id) = $2`, mainID, handle)
+		}
+		return ids
+	}
+	return nil
+}
+
+// Members implements messaging.Directory.
+func (chatDirectory) Members(channel string) []string {
+	if course, ok := discussionCourse(channel); ok {
+		return queryUserIDs(`SELECT user_id FROM course_enrollments WHERE course_code = $1`, course)
+	}
+	if id, ok := directConversationID(channel); ok {
+		return queryUserIDs(`SELECT user_id FROM dm_members WHERE conversation_id = $1`, id)
+	}
+	if roomID, ok := meetingRoomID(channel); ok {
+		mainID := strings.SplitN(roomID, "/breakout-", 2)[0]
+		ids := queryUserIDs(`SELECT DISTINCT user_id FROM meeting_attendance WHERE room_id = $1`, mainID)
+		return append(ids, connectedInRoom(roomID, func(c *Client) bool { return true })...)
+	}
+	return nil
+}
+
+// emailMention notifies a mentioned user by email when they opted in with
+// an address on one of their enrollments and SMTP is configured.
+func emailMention(m messaging.Message, userID string) {
+	if SMTPConfig.Host == "" || storage.DB == nil {
+		return
+	}
+	var email string
+	err := storage.DB.QueryRow(
+		`SELECT email FROM course_enrollments
+		 WHERE user_id = $1 AND notify_mentions AND COALESCE(email, '') <> '' LIMIT 1`, userID,
+	).Scan(&email)
+	if err == sql.ErrNoRows {
+		return
+	} else if err != nil {
+		log.Println("mention email lookup:", err)
+		return
+	}
+	body := fmt.Sprintf("<p><b>%s</b> mentioned you:</p><blockquote>%s</blockquote>",
+		html.EscapeString(m.SenderName), html.EscapeString(m.Body))
+	if err := sendEmail(email, "You were mentioned by "+m.SenderName, body); err != nil {
+		log.Println("mention email:", err)
+	}
+}
+
+// userChatChannels lists the chats whose unread counts a user sees: the
+// channels of their courses, their direct conversations and any chat they
+// have read or been mentioned in.
+func userChatChannels(userID string) ([]string, error) {
+	channels, err := Messages.Store.CursorChannels(userID)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, c := range channels {
+		seen[c] = true
+	}
+	rows, err := storage.DB.Query(
+		`SELECT 'discussion', c.id FROM discussion_channels c
+		 JOIN course_enrollments e ON e.course_code = c.course_code
+		 WHERE e.user_id = $1
+		 UNION ALL
+		 SELECT 'dm', conversation_id FROM dm_members WHERE user_id = $1
+		 ORDER BY 1, 2`, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind string
+		var id int64
+		if err := rows.Scan(&kind, &id); err != nil {
+			return nil, err
+		}
+		c := messaging.DiscussionChannel(strconv.FormatInt(id, 10))
+		if kind == "dm" {
+			c = messaging.DirectChannel(strconv.FormatInt(id, 10))
+		}
+		if !seen[c] {
+			seen[c] = true
+			channels = append(channels, c)
+		}
+	}
+	return channels, rows.Err()
+}
+
+// ChatUnreadHandler returns unread and mention counts for one chat, or for
+// every chat the user belongs to when neither channel nor room is given.
+//
+// GET /chat/unread?user_id=STU-0001[&channel=3|&conversation=5|&room=CS101]
+func ChatUnreadHandler(w http.ResponseWriter, r *http.Request) {
+	var userID string
+	var channels []string
+	if q := r.URL.Query(); q.Get("channel") != "" || q.Get("conversation") != "" || q.Get("room") != "" {
+		scope, ok := chatScopeFromRequest(w, r, false)
+		if !ok {
+			return
+		}
+		userID, channels = scope.Actor.ID, []string{scope.Channel}
+	} else {
+		userID = r.URL.Query().Get("user_id")
+		if storage.DB == nil || !isRegisteredID(storage.DB, userID) {
+			http.Error(w, "A registered user_id is required", http.StatusForbidden)
+			return
+		}
+		var err error
+		if channels, err = userChatChannels(userID); err != nil {
+			log.Println("unread channels:", err)
+			http.Error(w, "Database query failed", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	counts, err := Messages.Unread(userID, channels)
+	if err != nil {
+		log.Println("unread counts:", err)
+		http.Error(w, "Database query failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, counts)
+}
+
+// ChatReadHandler moves the caller's read cursor (POST) or lists who has
+// read a message (GET).
+//
+// POST /chat/read?channel=3&user_id=STU-0001 {"last_read_id":120}
+// GET  /chat/read?channel=3&user_id=STU-0001&message_id=120
+func ChatReadHandler(w http.ResponseWriter, r *http.Request) {
+	scope, ok := chatScopeFromRequest(w, r, false)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			LastReadID int64 `json:"last_read_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.LastReadID <= 0 {
+			http.Error(w, "last_read_id is required", http.StatusBadRequest)
+			return
+		}
+		count, err := Messages.MarkRead(scope.Channel, scope.Actor.ID, req.LastReadID)
+		if err == messaging.ErrNotFound {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println("mark read:", err)
+			http.Error(w, "Database update failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, count)
+
+	case http.MethodGet:
+		messageID, err := strconv.ParseInt(r.URL.Query().Get("message_id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid message_id param", http.StatusBadRequest)
+			return
+		}
+		receipts, err := Messages.Store.ReadReceipts(scope.Channel, messageID)
+		if err != nil {
+			log.Println("read receipts:", err)
+			http.Error(w, "Database query failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, receipts)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"CampusMoon/internals/messaging"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ===== Chat Moderation =====
+
+// moderationChannelFromRequest reads the chat a staff request targets:
+// channel=<discussion channel id> or room=<meeting room>. With optional
+// set, neither may be given, meaning every chat.
+func moderationChannelFromRequest(w http.ResponseWriter, r *http.Request, optional bool) (string, bool) {
+	if v := r.URL.Query().Get("channel"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid channel param", http.StatusBadRequest)
+			return "", false
+		}
+		channel, err := loadDiscussionChannel(id)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Channel not found", http.StatusNotFound)
+			return "", false
+		} else if err != nil {
+			log.Println("load channel:", err)
+			http.Error(w, "Database query failed", http.StatusInternalServerError)
+			return "", false
+		}
+		return channel.hubChannel(), true
+	}
+	if room := r.URL.Query().Get("room"); room != "" {
+		return messaging.MeetingChannel(room), true
+	}
+	if optional {
+		return "", true
+	}
+	http.Error(w, "Missing channel or room param", http.StatusBadRequest)
+	return "", false
+}
+
+// writeModerationError maps messaging errors to HTTP statuses.
+func writeModerationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, messaging.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, messaging.ErrForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, messaging.ErrAlreadyReported), errors.Is(err, messaging.ErrReportClosed), errors.Is(err, messaging.ErrDeleted):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Println("moderation:", err)
+		http.Error(w, "Moderation request failed", http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// ModerationSettingsHandler reads or replaces a chat's moderation settings.
+//
+// GET /chat/moderation/settings?channel=3&staff_id=STF-0001
+// PUT /chat/moderation/settings?room=CS101&staff_id=STF-0001 {"block_links":true,"slow_mode_seconds":30,"languages":["en","ta"]}
+func ModerationSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	staffID, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+	channel, ok := moderationChannelFromRequest(w, r, false)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req messaging.ChannelSettings
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Channel = channel
+		if err := Messages.Moderation.SetSettings(req, staffID); err != nil {
+			writeModerationError(w, err)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	settings, err := Messages.Moderation.Settings(channel)
+	if err != nil {
+		writeModerationError(w, err)
+		return
+	}
+	writeJSON(w, settings)
+}
+
+// ModerationWordsHandler lists, adds or removes filtered words. Lists are
+// per language and apply to the chats whose settings select them.
+//
+// GET    /chat/moderation/words?staff_id=STF-0001
+// POST   /chat/moderation/words?staff_id=STF-0001 {"language":"en","words":["..."]}
+// DELETE /chat/moderation/words?staff_id=STF-0001 {"language":"en","words":["..."]}
+func ModerationWordsHandler(w http.ResponseWriter, r *http.Request) {
+	staffID, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodPost || r.Method == http.MethodDelete {
+		var req struct {
+			Language string   `json:"language"`
+			Words    []string `json:"words"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(req.Language) == "" || strings.TrimSpace(strings.Join(req.Words, "")) == "" {
+			http.Error(w, "language and words are required", http.StatusBadRequest)
+			return
+		}
+		var err error
+		if r.Method == http.MethodPost {
+			err = Messages.Moderation.AddWords(req.Language, req.Words, staffID)
+		} else {
+			err = Messages.Moderation.RemoveWords(req.Language, req.Words, staffID)
+		}
+		if err != nil {
+			writeModerationError(w, err)
+			return
+		}
+	} else if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	lists, err := Messages.Moderation.Words()
+	if err != nil {
+		writeModerationError(w, err)
+		return
+	}
+	writeJSON(w, lists)
+}
+
+// ModerationMutesHandler lists, adds or lifts mutes in a chat. minutes of 0
+// mutes until lifted.
+//
+// GET    /chat/moderation/mutes?channel=3&staff_id=STF-0001
+// POST   /chat/moderation/mutes?channel=3&staff_id=STF-0001 {"user_id":"STU-0001","minutes":10,"reason":"spam"}
+// DELETE /chat/moderation/mutes?channel=3&staff_id=STF-0001&target=STU-0001
+func ModerationMutesHandler(w http.ResponseWriter, r *http.Request) {
+	staffID, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+	channel, ok := moderationChannelFromRequest(w, r, false)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		mutes, err := Messages.Moderation.Mutes(channel)
+		if err != nil {
+			writeModerationError(w, err)
+			return
+		}
+		writeJSON(w, mutes)
+
+	case http.MethodPost:
+		var req struct {
+			UserID  string `json:"user_id"`
+			Minutes int    `json:"minutes"`
+			Reason  string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" || req.Minutes < 0 {
+			http.Error(w, "user_id and a non-negative minutes are required", http.StatusBadRequest)
+			return
+		}
+		mute, err := Messages.Moderation.Mute(channel, req.UserID, time.Duration(req.Minutes)*time.Minute, strings.TrimSpace(req.Reason), staffID)
+		if err != nil {
+			writeModerationError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, mute)
+
+	case http.MethodDelete:
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "Missing target param", http.StatusBadRequest)
+			return
+		}
+		if err := Messages.Moderation.Unmute(channel, target, staffID); err != nil {
+			writeModerationError(w, err)
+			return
+		}
+		writeJSON(w, map[string]string{"status": "unmuted"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ChatReportsHandler lets a member report a message (POST) and staff read
+// the review queue (GET, pending by default; status=all for everything).
+//
+// POST /chat/reports?channel=3&user_id=STU-0001 {"message_id":42,"reason":"abusive"}
+// GET  /chat/reports?staff_id=STF-0001&status=pending[&channel=3|&room=CS101]
+func ChatReportsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		scope, ok := chatScopeFromRequest(w, r, false)
+		if !ok {
+			return
+		}
+		var req struct {
+			MessageID int64  `json:"message_id"`
+			Reason    string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MessageID == 0 {
+			http.Error(w, "message_id is required", http.StatusBadRequest)
+			return
+		}
+		report, err := Messages.Report(scope.Channel, req.MessageID, scope.Actor, req.Reason)
+		if err != nil {
+			writeModerationError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, report)
+
+	case http.MethodGet:
+		if _, ok := requireStaff(w, r); !ok {
+			return
+		}
+		channel, ok := moderationChannelFromRequest(w, r, true)
+		if !ok {
+			return
+		}
+		status := r.URL.Query().Get("status")
+		if status == "" {
+			status = messaging.ReportPending
+		} else if status == "all" {
+			status = ""
+		}
+		reports, err := Messages.Moderation.Reports(status, channel)
+		if err != nil {
+			writeModerationError(w, err)
+			return
+		}
+		// Reviewers see the reported content even if it was deleted.
+		for i := range reports {
+			if m, err := Messages.Store.Original(reports[i].MessageID); err == nil {
+				reports[i].Message = &m
+			}
+		}
+		writeJSON(w, reports)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ChatReportReviewHandler closes a report: dismiss, delete the message, or
+// delete it and mute its author for minutes (0 = until lifted).
+//
+// POST /chat/reports/review?staff_id=STF-0001 {"report_id":7,"action":"mute","minutes":30,"note":"second offence"}
+func ChatReportReviewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	staffID, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		ReportID int64  `json:"report_id"`
+		Action   string `json:"action"`
+		Minutes  int    `json:"minutes"`
+		Note     string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ReportID == 0 || req.Minutes < 0 {
+		http.Error(w, "report_id and action are required", http.StatusBadRequest)
+		return
+	}
+	switch req.Action {
+	case messaging.ReviewDismiss, messaging.ReviewDelete, messaging.ReviewMute:
+	default:
+		http.Error(w, "action must be dismiss, delete or mute", http.StatusBadRequest)
+		return
+	}
+
+	actor := messaging.Actor{ID: staffID, IsStaff: true}
+	report, err := Messages.ReviewReport(req.ReportID, actor, req.Action, time.Duration(req.Minutes)*time.Minute, strings.TrimSpace(req.Note))
+	if err != nil {
+		writeModerationError(w, err)
+		return
+	}
+	writeJSON(w, report)
+}
+
+// ModerationAuditHandler returns the latest moderator actions, newest first.
+//
+// GET /chat/moderation/audit?staff_id=STF-0001[&channel=3|&room=CS101]&limit=100
+func ModerationAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireStaff(w, r); !ok {
+		return
+	}
+	channel, ok := moderationChannelFromRequest(w, r, true)
+	if !ok {
+		return
+	}
+	limit := 100
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 1000 {
+		limit = n
+	}
+	entries, err := Messages.Moderation.AuditLog(channel, limit)
+	if err != nil {
+		writeModerationError(w, err)
+		return
+	}
+	writeJSON(w, entries)
+}
@@ -1,10 +1,16 @@
 package handlers
 
 import (
+    "bytes"
+    "context"
     "encoding/json"
     "net/http"
     "os"
     "os/exec"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
 	"fmt"
 
 )
@@ -27,69 +33,135 @@ func RunHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    output, err := runCode(req.Language, req.Code, "")
+    if err != nil {
+        respondWithOutput(w, output, err.Error())
+        return
+    }
+
+    respondWithOutput(w, output, "")
+}
+
+const (
+    // runTimeout stops programs that run too long, such as an endless loop.
+    runTimeout = 10 * time.Second
+    // runWaitDelay is how long a stopped program's children may keep its
+    // output open before the run gives up on them.
+    runWaitDelay = time.Second
+    // maxRunOutput caps what a program may print; it is stopped past it.
+    maxRunOutput = 64 << 10
+)
+
+// cappedOutput keeps up to maxRunOutput bytes of a program's output and
+// stops the program when it prints more.
+type cappedOutput struct {
+    mu     sync.Mutex
+    buf    bytes.Buffer
+    full   bool
+    cancel context.CancelFunc
+}
+
+func (o *cappedOutput) Write(p []byte) (int, error) {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    if room := maxRunOutput - o.buf.Len(); len(p) > room {
+        o.buf.Write(p[:room])
+        if !o.full {
+            o.full = true
+            o.cancel()
+        }
+        return len(p), nil
+    }
+    return o.buf.Write(p)
+}
+
+// runCode compiles if needed and runs code in its own working dir, feeding
+// it stdin, and returns what it printed (the compiler's output if it did
+// not compile). Go is built first rather than run with go run, so the
+// timeout stops the program itself. Exam code questions grade their test
+// cases with it.
+func runCode(language, code, stdin string) (string, error) {
     var (
         sourcePath string
         outputPath string
         cmd        *exec.Cmd
     )
 
-    // Create a working dir if it doesn't exist
+    // Create a working dir if it doesn't exist, with a dir of its own per
+    // run so runs at the same time don't overwrite each other
     _ = os.MkdirAll("./code", 0755)
+    dir, err := os.MkdirTemp("./code", "run-")
+    if err != nil {
+        return "", fmt.Errorf("failed to create a working dir: %w", err)
+    }
+    defer os.RemoveAll(dir)
+
+    ctx, cancel := context.WithTimeout(context.Background(), runTimeout)
+    defer cancel()
 
-    switch req.Language {
+    switch language {
     case "python":
-        sourcePath = "./code/code.py"
-        err := os.WriteFile(sourcePath, []byte(req.Code), 0644)
+        sourcePath = filepath.Join(dir, "code.py")
+        err := os.WriteFile(sourcePath, []byte(code), 0644)
         if err != nil {
-            respondWithError(w, "Failed to write Python code: "+err.Error())
-            return
+            return "", fmt.Errorf("failed to write Python code: %w", err)
         }
-        cmd = exec.Command("python3", sourcePath)
+        cmd = exec.CommandContext(ctx, "python3", sourcePath)
 
     case "c":
-        sourcePath = "./code/code.c"
-        outputPath = "./code/code.out"
+        sourcePath = filepath.Join(dir, "code.c")
+        outputPath = filepath.Join(dir, "code.out")
 
-        err := os.WriteFile(sourcePath, []byte(req.Code), 0644)
+        err := os.WriteFile(sourcePath, []byte(code), 0644)
         if err != nil {
-            respondWithError(w, "Failed to write C code: "+err.Error())
-            return
+            return "", fmt.Errorf("failed to write C code: %w", err)
         }
 
         // Compile the C code
-        compile := exec.Command("gcc", sourcePath, "-o", outputPath)
+        compile := exec.CommandContext(ctx, "gcc", sourcePath, "-o", outputPath)
         compileOutput, err := compile.CombinedOutput()
         if err != nil {
-            respondWithOutput(w, string(compileOutput), err.Error())
-            return
+            return string(compileOutput), err
         }
 
         // Run the compiled program
-        cmd = exec.Command(outputPath)
+        cmd = exec.CommandContext(ctx, outputPath)
 
     case "go":
-        sourcePath = "./code/code.go"
-        err := os.WriteFile(sourcePath, []byte(req.Code), 0644)
+        sourcePath = filepath.Join(dir, "code.go")
+        err := os.WriteFile(sourcePath, []byte(code), 0644)
+        if err != nil {
+            return "", fmt.Errorf("failed to write Go code: %w", err)
+        }
+
+        // Build the Go program, then run it
+        outputPath = filepath.Join(dir, "code.out")
+        build := exec.CommandContext(ctx, "go", "build", "-o", "code.out", "code.go")
+        build.Dir = dir
+        buildOutput, err := build.CombinedOutput()
         if err != nil {
-            respondWithError(w, "Failed to write Go code: "+err.Error())
-            return
+            return string(buildOutput), err
         }
 
-        cmd = exec.Command("go", "run", sourcePath)
+        cmd = exec.CommandContext(ctx, outputPath)
 
     default:
-        respondWithError(w, "Unsupported language: "+req.Language)
-        return
+        return "", fmt.Errorf("unsupported language: %s", language)
     }
 
     // Run the command and capture output
-    output, err := cmd.CombinedOutput()
-    if err != nil {
-        respondWithOutput(w, string(output), err.Error())
-        return
+    output := &cappedOutput{cancel: cancel}
+    cmd.Stdin = strings.NewReader(stdin)
+    cmd.Stdout, cmd.Stderr = output, output
+    cmd.WaitDelay = runWaitDelay
+    err = cmd.Run()
+    switch {
+    case output.full:
+        return output.buf.String(), fmt.Errorf("stopped after printing more than %d bytes", maxRunOutput)
+    case ctx.Err() != nil:
+        return output.buf.String(), fmt.Errorf("timed out after %s", runTimeout)
     }
-
-    respondWithOutput(w, string(output), "")
+    return output.buf.String(), err
 }
 
 // Utility functions
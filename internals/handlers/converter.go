@@ -78,6 +78,20 @@ func ConvertToTextHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// ---------------- Run Whisper ----------------
+	text, err := transcribeFile(localFilePath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Transcription failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Return JSON
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"text": text})
+}
+
+// transcribeFile runs Whisper on a local audio file and returns the text.
+// The transcript is written next to the input file.
+func transcribeFile(localFilePath string) (string, error) {
 	inputDir := filepath.Dir(localFilePath)
 	baseName := strings.TrimSuffix(filepath.Base(localFilePath), filepath.Ext(localFilePath))
 	txtFile := filepath.Join(inputDir, baseName+".txt")
@@ -93,19 +107,14 @@ func ConvertToTextHandler(w http.ResponseWriter, r *http.Request) {
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		log.Printf("Whisper error: %v, Output: %s", err, string(output))
-		http.Error(w, fmt.Sprintf("Transcription failed: %v, Output: %s", err, string(output)), http.StatusInternalServerError)
-		return
+		return "", fmt.Errorf("%v, Output: %s", err, string(output))
 	}
 
 	// ---------------- Read Transcription ----------------
 	text, err := os.ReadFile(txtFile)
 	if err != nil {
 		log.Printf("Error reading text file: %v", err)
-		http.Error(w, "Error reading transcribed text", http.StatusInternalServerError)
-		return
+		return "", fmt.Errorf("reading transcribed text: %w", err)
 	}
-
-	// Return JSON
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"text": string(text)})
+	return strings.TrimSpace(string(text)), nil
 }
@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"CampusMoon/internals/messaging"
+	"CampusMoon/internals/storage"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// ===== Direct Messages =====
+
+// dmMaxMembers caps group conversations, creator included.
+var dmMaxMembers = int(envInt64("DM_MAX_MEMBERS", 8))
+
+// DirectConversation is a one-to-one or small-group private chat.
+type DirectConversation struct {
+	ID        int64                  `json:"id"`
+	Title     string                 `json:"title"`
+	Members   []string               `json:"members"`
+	CreatedBy string                 `json:"created_by"`
+	CreatedAt time.Time              `json:"created_at"`
+	Unread    *messaging.UnreadCount `json:"unread,omitempty"`
+}
+
+// hubChannel names the messaging channel a conversation publishes to.
+func (c *DirectConversation) hubChannel() string {
+	return messaging.DirectChannel(strconv.FormatInt(c.ID, 10))
+}
+
+func (c *DirectConversation) hasMember(userID string) bool {
+	for _, m := range c.Members {
+		if m == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// directMediaPrefix is where a conversation's files live in object storage.
+func directMediaPrefix(c *DirectConversation) string {
+	return fmt.Sprintf("dm/%d", c.ID)
+}
+
+// dmPairKey identifies the one-to-one conversation between a and b, so
+// opening it twice returns the same conversation.
+func dmPairKey(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + "|" + b
+}
+
+// directActor is userID as shown in direct messages: their display name
+// from any course enrollment, or a generic one.
+func directActor(userID string) messaging.Actor {
+	actor := messaging.Actor{ID: userID, IsStaff: isStaffID(storage.DB, userID)}
+	var name sql.NullString
+	err := storage.DB.QueryRow(
+		`SELECT display_name FROM course_enrollments
+		 WHERE user_id = $1 AND COALESCE(display_name, '') <> '' LIMIT 1`, userID,
+	).Scan(&name)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("display name lookup:", err)
+	}
+	actor.Name = name.String
+	if actor.Name == "" {
+		if actor.IsStaff {
+			actor.Name = "Staff " + shortID(userID)
+		} else {
+			actor.Name = "Student " + shortID(userID)
+		}
+	}
+	return actor
+}
+
+func loadDirectConversation(id int64) (*DirectConversation, error) {
+	c := &DirectConversation{}
+	err := storage.DB.QueryRow(
+		`SELECT c.id, c.title, c.created_by, c.created_at,
+			ARRAY(SELECT user_id FROM dm_members WHERE conversation_id = c.id ORDER BY joined_at, user_id)
+		 FROM dm_conversations c WHERE c.id = $1`, id,
+	).Scan(&c.ID, &c.Title, &c.CreatedBy, &c.CreatedAt, pq.Array(&c.Members))
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// openDirectConversation starts a conversation between createdBy and
+// members. A conversation between two people is reused if it exists.
+func openDirectConversation(createdBy string, members []string, title string) (*DirectConversation, error) {
+	seen := map[string]bool{createdBy: true}
+	all := []string{createdBy}
+	for _, m := range members {
+		if m = strings.TrimSpace(m); m != "" && !seen[m] {
+			seen[m] = true
+			all = append(all, m)
+		}
+	}
+
+	tx, err := storage.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var pairKey interface{}
+	if len(all) == 2 {
+		pairKey = dmPairKey(all[0], all[1])
+		title = ""
+	}
+	var id int64
+	err = tx.QueryRow(
+		`INSERT INTO dm_conversations (pair_key, title, created_by) VALUES ($1, $2, $3)
+		 ON CONFLICT (pair_key) DO UPDATE SET pair_key = EXCLUDED.pair_key
+		 RETURNING id`,
+		pairKey, title, createdBy,
+	).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO dm_members (conversation_id, user_id)
+		 SELECT $1, unnest($2::text[]) ON CONFLICT DO NOTHING`,
+		id, pq.Array(all),
+	); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return loadDirectConversation(id)
+}
+
+// directConversationFromRequest loads the conversation in the conversation
+// query parameter and checks that user_id is one of its members, writing an
+// error on failure.
+func directConversationFromRequest(w http.ResponseWriter, r *http.Request) (*DirectConversation, messaging.Actor, bool) {
+	if storage.DB == nil {
+		http.Error(w, "Direct messages are unavailable", http.StatusServiceUnavailable)
+		return nil, messaging.Actor{}, false
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("conversation"), 10, 64)
+	if err != nil {
+		http.Error(w, "Missing or invalid conversation param", http.StatusBadRequest)
+		return nil, messaging.Actor{}, false
+	}
+	conv, err := loadDirectConversation(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return nil, messaging.Actor{}, false
+	} else if err != nil {
+		log.Println("load conversation:", err)
+		http.Error(w, "Database query failed", http.StatusInternalServerError)
+		return nil, messaging.Actor{}, false
+	}
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" || !conv.hasMember(userID) {
+		http.Error(w, "You are not a member of this conversation", http.StatusForbidden)
+		return nil, messaging.Actor{}, false
+	}
+	return conv, directActor(userID), true
+}
+
+// DirectConversationsHandler lists the caller's conversations with their
+// unread counts (GET) or opens one with other registered users (POST).
+// Opening a one-to-one conversation that exists returns it.
+//
+// GET  /dm/conversations?user_id=STU-0001
+// POST /dm/conversations?user_id=STU-0001 {"members":["STF-0001"],"title":""}
+func DirectConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if storage.DB == nil || !isRegisteredID(storage.DB, userID) {
+		http.Error(w, "A registered user_id is required", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := storage.DB.Query(
+			`SELECT conversation_id FROM dm_members WHERE user_id = $1 ORDER BY conversation_id DESC`, userID,
+		)
+		if err != nil {
+			log.Println("list conversations:", err)
+			http.Error(w, "Database query failed", http.StatusInternalServerError)
+			return
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		rows.Close()
+
+		conversations := []*DirectConversation{}
+		for _, id := range ids {
+			conv, err := loadDirectConversation(id)
+			if err != nil {
+				log.Println("load conversation:", err)
+				continue
+			}
+			if counts, err := Messages.Unread(userID, []string{conv.hubChannel()}); err == nil {
+				conv.Unread = &counts[0]
+			}
+			conversations = append(conversations, conv)
+		}
+		writeJSON(w, conversations)
+
+	case http.MethodPost:
+		var req struct {
+			Members []string `json:"members"`
+			Title   string   `json:"title"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Members) == 0 {
+			http.Error(w, "members is required", http.StatusBadRequest)
+			return
+		}
+		others := map[string]bool{}
+		for _, m := range req.Members {
+			if m = strings.TrimSpace(m); m == "" || m == userID || others[m] {
+				continue
+			}
+			if !isRegisteredID(storage.DB, m) {
+				http.Error(w, fmt.Sprintf("%s is not a registered user", m), http.StatusBadRequest)
+				return
+			}
+			others[m] = true
+		}
+		if len(others) == 0 {
+			http.Error(w, "A conversation needs another member", http.StatusBadRequest)
+			return
+		}
+		if len(others)+1 > dmMaxMembers {
+			http.Error(w, fmt.Sprintf("Conversations are limited to %d people", dmMaxMembers), http.StatusBadRequest)
+			return
+		}
+		conv, err := openDirectConversation(userID, req.Members, strings.TrimSpace(req.Title))
+		if err != nil {
+			log.Println("open conversation:", err)
+			http.Error(w, "Database insert failed", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, conv)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// DirectHistoryHandler serves a page of a conversation to its members,
+// paged like /chat/history with before, after, date and limit:
+// /dm/history?conversation=<id>&user_id=<id>.
+func DirectHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	conv, _, ok := directConversationFromRequest(w, r)
+	if !ok {
+		return
+	}
+	q, err := pageQueryFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := Messages.Page(conv.hubChannel(), q)
+	if err != nil {
+		log.Println("dm history query:", err)
+		http.Error(w, "Failed to fetch chat history", http.StatusInternalServerError)
+		return
+	}
+	setPageHeaders(w, page)
+
+	history := make([]discussionEvent, 0, len(page.Messages))
+	for _, m := range page.Messages {
+		history = append(history, newDiscussionEvent(m))
+	}
+	writeJSON(w, history)
+}
+
+// HandleDirectWS is the socket of one conversation, speaking the same frames
+// as /ws_discussion except voice notes:
+// /ws_dm?conversation=<id>&user_id=<id>[&last_seen_id=<message id>].
+func HandleDirectWS(w http.ResponseWriter, r *http.Request) {
+	conv, actor, ok := directConversationFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	ws, err := discussionUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("ws upgrade:", err)
+		return
+	}
+	ws.SetReadLimit(64 << 10)
+
+	hubChannel := conv.hubChannel()
+	client := &discussionClient{id: uuid.New().String(), userID: actor.ID, username: actor.Name, conn: ws}
+	// Live messages wait until the catch-up has been sent.
+	lastSeen, _ := strconv.ParseInt(r.URL.Query().Get("last_seen_id"), 10, 64)
+	if lastSeen > 0 {
+		client.catchUp.hold()
+	}
+	Messages.Hub.Subscribe(hubChannel, client)
+	Messages.Hub.Subscribe(messaging.UserChannel(actor.ID), client)
+	if lastSeen > 0 {
+		sendDiscussionCatchUp(client, hubChannel, lastSeen)
+	}
+	defer func() {
+		Messages.Hub.Unsubscribe(hubChannel, client)
+		Messages.Hub.Unsubscribe(messaging.UserChannel(actor.ID), client)
+		ws.Close()
+	}()
+
+	log.Printf("%s connected to conversation %d", actor.Name, conv.ID)
+	serveChatSocket(client, hubChannel, actor, nil)
+}
@@ -1,22 +1,18 @@
 package handlers
 
 import (
-	"bytes"
-	"context"
-	"database/sql"
+	"CampusMoon/internals/messaging"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
-	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/google/uuid"
-	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/gorilla/websocket"
 )
 
 var (
@@ -25,30 +21,53 @@ var (
 		CheckOrigin: func(r *http.Request) bool { return true },
 	}
 
-	// connected clients + lock
-	connectedClients = make(map[*websocket.Conn]string)
-	clientsLock      sync.Mutex
+	errVoiceNotesUnsupported = errors.New("voice notes are not supported here")
+)
+
+// discussionClient is a discussion socket subscribed to a channel.
+type discussionClient struct {
+	id       string
+	userID   string
+	username string
+	conn     *websocket.Conn
+	writeMu  sync.Mutex
+	catchUp  catchUpGate
+}
 
-	// broadcast queue
-	broadcastChan = make(chan ChatMessage, 64)
+func (c *discussionClient) SubscriberID() string {
+	return c.id
+}
 
-	// global DB reference (set by InitDiscussion)
-	globalDiscussionDB *sql.DB
-)
+// Deliver sends e to the socket. New messages are skipped for the user who
+// posted them, since the page shows its own messages as soon as they are
+// sent; edits, deletes, reactions and pins go to everyone.
+func (c *discussionClient) Deliver(e messaging.Event) error {
+	if e.Type == messaging.EventMessage {
+		if e.Message.SenderID == c.userID {
+			return nil
+		}
+		return c.catchUp.deliver(e.Message, func(m messaging.Message) error {
+			return c.send(newDiscussionEvent(m))
+		})
+	}
+	return c.send(discussionUpdate{Type: e.Type, Event: e.Type, ActorID: e.ActorID, Message: newDiscussionEvent(e.Message), Unread: e.Unread})
+}
 
-// ChatMessage supports text and audio messages
-type ChatMessage struct {
-	Type      string    `json:"type"`       // "text" or "audio"
-	Sender    string    `json:"sender"`
-	Content   string    `json:"content"`    // text or public audio URL
-	Timestamp time.Time `json:"timestamp"`
+func (c *discussionClient) send(v interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteJSON(v)
 }
 
-// InitDiscussion initializes the discussion subsystem with DB and starts broadcaster.
-// Call this once from main (pass your *sql.DB).
-func InitDiscussion(db *sql.DB) {
-	globalDiscussionDB = db
-	go broadcaster()
+// incomingDiscussionMessage is a text frame sent by discussion.html.
+// Type is text (the default), audio_meta, edit, delete, react, pin, unpin,
+// report (Content is the reason) or read (ID is the last message seen).
+type incomingDiscussionMessage struct {
+	Type      string `json:"type"`
+	Content   string `json:"content"`
+	ReplyToID *int64 `json:"reply_to_id"`
+	ID        int64  `json:"id"`
+	Emoji     string `json:"emoji"`
 }
 
 // ServeDiscussionPage serves the HTML UI (templates/discussion.html)
@@ -57,83 +76,98 @@ func ServeDiscussionPage(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, filepath.Join("templates", "discussion.html"))
 }
 
-// HandleDiscussionWS upgrades to websocket and processes incoming messages.
-// text frames: JSON ChatMessage {type:"text", sender:"...", content:"..."} (sender will be overwritten)
-// binary frames: raw audio bytes (server will auto-detect format and upload to MinIO)
+// HandleDiscussionWS upgrades to websocket and processes incoming messages
+// for one channel: /ws_discussion?channel=<id>&user_id=<student or staff id>.
+// A reconnecting page adds last_seen_id=<message id> to receive what it missed.
+// text frames: JSON {type:"text", content:"..."} (sender is set by the server)
+// binary frames: a voice note of at most VOICE_NOTE_MAX_BYTES; larger frames
+// close the socket.
 func HandleDiscussionWS(w http.ResponseWriter, r *http.Request) {
+	channel, identity, ok := discussionChannelFromRequest(w, r)
+	if !ok {
+		return
+	}
+
 	ws, err := discussionUpgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("ws upgrade:", err)
 		return
 	}
+
+	ws.SetReadLimit(voiceNoteMaxBytes)
+
+	username := identity.DisplayName
+	hubChannel := channel.hubChannel()
+	client := &discussionClient{id: uuid.New().String(), userID: identity.UserID, username: username, conn: ws}
+	// Live messages are held until the missed ones have been sent.
+	lastSeen, _ := strconv.ParseInt(r.URL.Query().Get("last_seen_id"), 10, 64)
+	if lastSeen > 0 {
+		client.catchUp.hold()
+	}
+	Messages.Hub.Subscribe(hubChannel, client)
+	Messages.Hub.Subscribe(messaging.UserChannel(identity.UserID), client)
+	if lastSeen > 0 {
+		sendDiscussionCatchUp(client, hubChannel, lastSeen)
+	}
+
 	// ensure socket closed and client removed on return
 	defer func() {
-		clientsLock.Lock()
-		delete(connectedClients, ws)
-		clientsLock.Unlock()
+		Messages.Hub.Unsubscribe(hubChannel, client)
+		Messages.Hub.Unsubscribe(messaging.UserChannel(identity.UserID), client)
 		ws.Close()
 	}()
 
-	// assign a temporary username
-	clientsLock.Lock()
-	username := fmt.Sprintf("User%d", len(connectedClients)+1)
-	connectedClients[ws] = username
-	clientsLock.Unlock()
+	log.Printf("%s connected to discussion channel %d", username, channel.ID)
 
-	log.Printf("%s connected to discussion", username)
+	actor := messaging.Actor{ID: identity.UserID, Name: username, IsStaff: identity.IsStaff}
+	serveChatSocket(client, hubChannel, actor, func(audio []byte, replyTo *int64) error {
+		return postVoiceNote(channel, identity, audio, replyTo)
+	})
+}
 
+// serveChatSocket reads frames from a chat socket until it closes. JSON
+// frames go to handleDiscussionFrame; binary frames are voice notes passed
+// to voice, or refused when voice is nil.
+func serveChatSocket(client *discussionClient, hubChannel string, actor messaging.Actor, voice func(audio []byte, replyTo *int64) error) {
+	var audioReplyTo *int64
 	for {
-		msgType, payload, err := ws.ReadMessage()
+		msgType, payload, err := client.conn.ReadMessage()
 		if err != nil {
 			// normal disconnect
 			if websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
-				log.Printf("%s disconnected", username)
+				log.Printf("%s disconnected", client.username)
 			} else {
-				log.Printf("ws read error for %s: %v", username, err)
+				log.Printf("ws read error for %s: %v", client.username, err)
 			}
 			return
 		}
 
 		switch msgType {
 		case websocket.TextMessage:
-			var incoming ChatMessage
+			var incoming incomingDiscussionMessage
 			if err := json.Unmarshal(payload, &incoming); err != nil {
 				log.Println("invalid text message json:", err)
 				continue
 			}
-			// enforce server-set sender & timestamp
-			incoming.Sender = username
-			incoming.Timestamp = time.Now()
-			if incoming.Type == "" {
-				incoming.Type = "text"
+			if incoming.Type == "audio_meta" {
+				// the audio bytes follow in the next binary frame
+				audioReplyTo = incoming.ReplyToID
+				continue
 			}
-			// persist and broadcast
-			if err := saveMessageToDB(incoming); err != nil {
-				log.Println("save message:", err)
+			if err := handleDiscussionFrame(hubChannel, actor, incoming); err != nil {
+				client.send(map[string]string{"type": "error", "error": err.Error()})
 			}
-			broadcastChan <- incoming
 
 		case websocket.BinaryMessage:
-			// treat as audio bytes
-			audioURL, contentType, err := uploadAudioBytesToMinIO(payload)
-			if err != nil {
-				log.Println("upload audio:", err)
-				continue
-			}
-
-			chat := ChatMessage{
-				Type:      "audio",
-				Sender:    username,
-				Content:   audioURL,
-				Timestamp: time.Now(),
+			// a recorded voice note
+			err := errVoiceNotesUnsupported
+			if voice != nil {
+				err = voice(payload, audioReplyTo)
 			}
-			// persist and broadcast
-			if err := saveMessageToDB(chat); err != nil {
-				log.Println("save audio message:", err)
+			audioReplyTo = nil
+			if err != nil {
+				client.send(map[string]string{"type": "error", "error": err.Error()})
 			}
-			// include content type in log (optional)
-			log.Printf("uploaded audio (content-type=%s) => %s", contentType, audioURL)
-			broadcastChan <- chat
 
 		default:
 			// ignore other frame types
@@ -141,140 +175,121 @@ func HandleDiscussionWS(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// broadcaster sends messages from channel to all connected clients.
-func broadcaster() {
-	for msg := range broadcastChan {
-		clientsLock.Lock()
-		for client, username := range connectedClients {
-			if username == msg.Sender {
-				continue // don’t send back to sender
-			}
-			if err := client.WriteJSON(msg); err != nil {
-				log.Println("ws write error, removing client:", err)
-				client.Close()
-				delete(connectedClients, client)
-			}
-		}
-		clientsLock.Unlock()
-	}
-}
-// saveMessageToDB persists a ChatMessage into chat_history table.
-// If DB not configured, it's a no-op (useful for dev).
-func saveMessageToDB(m ChatMessage) error {
-	if globalDiscussionDB == nil {
-		return nil
+// handleDiscussionFrame applies one JSON frame from a discussion socket.
+func handleDiscussionFrame(channel string, actor messaging.Actor, in incomingDiscussionMessage) error {
+	var err error
+	switch in.Type {
+	case "", messaging.KindText:
+		_, err = Messages.Post(messaging.Message{
+			Channel:    channel,
+			SenderID:   actor.ID,
+			SenderName: actor.Name,
+			Kind:       messaging.KindText,
+			Body:       in.Content,
+			ReplyToID:  in.ReplyToID,
+		})
+	case "edit":
+		_, err = Messages.Edit(channel, in.ID, actor, in.Content)
+	case "delete":
+		_, err = Messages.Delete(channel, in.ID, actor)
+	case "react":
+		_, err = Messages.React(channel, in.ID, actor, in.Emoji)
+	case "pin", "unpin":
+		_, err = Messages.Pin(channel, in.ID, actor, in.Type == "pin")
+	case "report":
+		_, err = Messages.Report(channel, in.ID, actor, in.Content)
+	case "read":
+		_, err = Messages.MarkRead(channel, actor.ID, in.ID)
+	default:
+		return fmt.Errorf("unknown message type %q", in.Type)
 	}
-	// Ensure table columns match these names: sender, type, content, timestamp
-	_, err := globalDiscussionDB.Exec(
-		`INSERT INTO chat_history (sender, type, content, timestamp) VALUES ($1, $2, $3, $4)`,
-		m.Sender, m.Type, m.Content, m.Timestamp,
-	)
 	return err
 }
 
-// ChatHistoryHandler serves stored history as JSON. Uses global DB.
+// ChatHistoryHandler_discussion serves a page of a channel's history as
+// JSON to its members: /discussion/history?channel=<id>&user_id=<id>, paged
+// like /chat/history with before, after, date and limit. Add
+// thread=<message id> for the replies to one message, or pinned=1 for the
+// pinned messages.
 func ChatHistoryHandler_discussion(w http.ResponseWriter, r *http.Request) {
-	if globalDiscussionDB == nil {
-		// return empty list rather than error in dev
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode([]ChatMessage{})
+	channel, _, ok := discussionChannelFromRequest(w, r)
+	if !ok {
 		return
 	}
 
-	rows, err := globalDiscussionDB.Query(
-		`SELECT sender, type, content, timestamp FROM chat_history ORDER BY timestamp ASC`,
-	)
+	var messages []messaging.Message
+	var err error
+	switch {
+	case r.URL.Query().Get("thread") != "":
+		var parentID int64
+		parentID, err = strconv.ParseInt(r.URL.Query().Get("thread"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid thread param", http.StatusBadRequest)
+			return
+		}
+		var parent messaging.Message
+		parent, err = Messages.Store.Get(parentID)
+		if err == nil && parent.Channel != channel.hubChannel() {
+			err = messaging.ErrNotFound
+		}
+		if err == nil {
+			messages, err = Messages.Store.Thread(parentID)
+		}
+	case r.URL.Query().Get("pinned") != "":
+		messages, err = Messages.Store.Pinned(channel.hubChannel())
+	default:
+		var q messaging.PageQuery
+		if q, err = pageQueryFromRequest(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var page messaging.Page
+		page, err = Messages.Page(channel.hubChannel(), q)
+		messages = page.Messages
+		setPageHeaders(w, page)
+	}
+	if err == messaging.ErrNotFound {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Println("chat history query:", err)
 		http.Error(w, "Failed to fetch chat history", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
 
-	history := make([]ChatMessage, 0)
-	for rows.Next() {
-		var m ChatMessage
-		if err := rows.Scan(&m.Sender, &m.Type, &m.Content, &m.Timestamp); err != nil {
-			log.Println("scan history row:", err)
-			continue
-		}
-		history = append(history, m)
+	history := make([]discussionEvent, 0, len(messages))
+	for _, m := range messages {
+		history = append(history, newDiscussionEvent(m))
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(history)
 }
 
-// uploadAudioBytesToMinIO uploads raw bytes to MinIO bucket and returns a public URL and content-type.
-// It attempts to detect the audio format by magic bytes ("OggS", EBML for webm, "RIFF" for wav).
-func uploadAudioBytesToMinIO(data []byte) (publicURL string, contentType string, err error) {
-	endpoint := os.Getenv("MINIO_ENDPOINT")
-	accessKey := os.Getenv("MINIO_ACCESS_KEY")
-	secretKey := os.Getenv("MINIO_SECRET_KEY")
-
-	if endpoint == "" || accessKey == "" || secretKey == "" {
-		return "", "", fmt.Errorf("minio credentials not set in environment")
-	}
-
-	minioClient, err := minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
-		Secure: false, // set true if using TLS
-	})
-	if err != nil {
-		return "", "", fmt.Errorf("minio new: %w", err)
-	}
-
-	bucket := os.Getenv("MINIO_AUDIO_BUCKET")
-	if bucket == "" {
-		bucket = "videos"
-	}
-
-	// auto-detect format
-	ext := ".bin"
-	ct := "application/octet-stream"
-	if len(data) >= 4 && bytes.HasPrefix(data, []byte("OggS")) {
-		ext = ".ogg"
-		ct = "audio/ogg"
-	} else if len(data) >= 4 && bytes.HasPrefix(data, []byte{0x1A, 0x45, 0xDF, 0xA3}) {
-		// EBML (webm / mkv family)
-		ext = ".webm"
-		ct = "audio/webm"
-	} else if len(data) >= 4 && bytes.HasPrefix(data, []byte("RIFF")) {
-		ext = ".wav"
-		ct = "audio/wav"
-	} else {
-		// try to sniff "ftyp" for some mp4/aac containers
-		if len(data) > 8 && bytes.Contains(data[:12], []byte("ftyp")) {
-			ext = ".m4a"
-			ct = "audio/mp4"
-		}
+// DiscussionEditsHandler returns the edit history of a message:
+// /discussion/edits?channel=<id>&user_id=<id>&id=<message id>. The history
+// of a deleted message holds its deleted text, so only staff see it.
+func DiscussionEditsHandler(w http.ResponseWriter, r *http.Request) {
+	channel, identity, ok := discussionChannelFromRequest(w, r)
+	if !ok {
+		return
 	}
-
-	objectName := fmt.Sprintf("audio-%s%s", uuid.New().String(), ext)
-	ctx := context.Background()
-
-	// create bucket if not exists
-	exists, err := minioClient.BucketExists(ctx, bucket)
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
 	if err != nil {
-		return "", "", fmt.Errorf("bucket exists check: %w", err)
+		http.Error(w, "Invalid id param", http.StatusBadRequest)
+		return
 	}
-	if !exists {
-		if err := minioClient.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
-			// ignore "already exists" style race conditions
-			return "", "", fmt.Errorf("make bucket: %w", err)
-		}
+	m, err := Messages.Store.Get(id)
+	if err != nil || m.Channel != channel.hubChannel() || (m.Deleted && !identity.IsStaff) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
 	}
-
-	// Put object
-	reader := bytes.NewReader(data)
-	_, err = minioClient.PutObject(ctx, bucket, objectName, reader, int64(len(data)), minio.PutObjectOptions{
-		ContentType: ct,
-	})
+	edits, err := Messages.Store.Edits(id)
 	if err != nil {
-		return "", "", fmt.Errorf("put object: %w", err)
+		log.Println("edit history query:", err)
+		http.Error(w, "Failed to fetch edit history", http.StatusInternalServerError)
+		return
 	}
-
-	// Construct public URL. If MinIO is behind gateway or uses a different host for public access,
-	// change accordingly; here we assume MINIO_ENDPOINT is host:port.
-	publicURL = fmt.Sprintf("http://%s/%s/%s", endpoint, bucket, objectName)
-	return publicURL, ct, nil
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(edits)
 }
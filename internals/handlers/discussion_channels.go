@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"CampusMoon/internals/messaging"
+	"CampusMoon/internals/storage"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DiscussionChannel is a course- or topic-scoped discussion room.
+type DiscussionChannel struct {
+	ID         int64     `json:"id"`
+	CourseCode string    `json:"course"`
+	Slug       string    `json:"slug"`
+	Title      string    `json:"title"`
+	Kind       string    `json:"kind"` // general, assignment, lecture or topic
+	Ref        string    `json:"ref,omitempty"`
+	CreatedBy  string    `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// discussionIdentity is who a user is inside one course.
+type discussionIdentity struct {
+	UserID      string
+	DisplayName string
+	IsStaff     bool
+}
+
+var slugInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+
+func slugify(s string) string {
+	return strings.Trim(slugInvalid.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+// hubChannel names the messaging channel a discussion channel publishes to.
+func (c *DiscussionChannel) hubChannel() string {
+	return messaging.DiscussionChannel(strconv.FormatInt(c.ID, 10))
+}
+
+// resolveDiscussionIdentity checks that userID may take part in course
+// discussions: staff always may, students must be enrolled. Display names
+// come from the enrollment, never from the client.
+func resolveDiscussionIdentity(userID, courseCode string) (*discussionIdentity, bool) {
+	if storage.DB == nil || userID == "" {
+		return nil, false
+	}
+	id := &discussionIdentity{UserID: userID, IsStaff: isStaffID(storage.DB, userID)}
+
+	var name sql.NullString
+	var role string
+	err := storage.DB.QueryRow(
+		`SELECT display_name, role FROM course_enrollments WHERE course_code = $1 AND user_id = $2`,
+		courseCode, userID,
+	).Scan(&name, &role)
+	switch {
+	case err == sql.ErrNoRows:
+		if !id.IsStaff {
+			return nil, false
+		}
+	case err != nil:
+		log.Println("enrollment lookup:", err)
+		return nil, false
+	}
+
+	id.DisplayName = name.String
+	if id.DisplayName == "" {
+		if id.IsStaff {
+			id.DisplayName = "Staff " + shortID(userID)
+		} else {
+			id.DisplayName = "Student " + shortID(userID)
+		}
+	}
+	return id, true
+}
+
+func loadDiscussionChannel(id int64) (*DiscussionChannel, error) {
+	var c DiscussionChannel
+	var ref sql.NullString
+	err := storage.DB.QueryRow(
+		`SELECT id, course_code, slug, title, kind, ref, created_by, created_at
+		 FROM discussion_channels WHERE id = $1`, id,
+	).Scan(&c.ID, &c.CourseCode, &c.Slug, &c.Title, &c.Kind, &ref, &c.CreatedBy, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	c.Ref = ref.String
+	return &c, nil
+}
+
+// discussionChannelFromRequest loads the channel in the channel query
+// parameter and the caller's identity in it, writing an error on failure.
+func discussionChannelFromRequest(w http.ResponseWriter, r *http.Request) (*DiscussionChannel, *discussionIdentity, bool) {
+	if storage.DB == nil {
+		http.Error(w, "Discussions are unavailable", http.StatusServiceUnavailable)
+		return nil, nil, false
+	}
+	channelID, err := strconv.ParseInt(r.URL.Query().Get("channel"), 10, 64)
+	if err != nil {
+		http.Error(w, "Missing or invalid channel param", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	channel, err := loadDiscussionChannel(channelID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return nil, nil, false
+	} else if err != nil {
+		log.Println("load channel:", err)
+		http.Error(w, "Database query failed", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	identity, ok := resolveDiscussionIdentity(r.URL.Query().Get("user_id"), channel.CourseCode)
+	if !ok {
+		http.Error(w, "You are not a member of this course", http.StatusForbidden)
+		return nil, nil, false
+	}
+	return channel, identity, true
+}
+
+// ensureGeneralChannel creates the course's general channel if missing.
+func ensureGeneralChannel(courseCode, createdBy string) error {
+	_, err := storage.DB.Exec(
+		`INSERT INTO discussion_channels (course_code, slug, title, kind, created_by)
+		 VALUES ($1, 'general', 'General', 'general', $2)
+		 ON CONFLICT (course_code, slug) DO NOTHING`,
+		courseCode, createdBy,
+	)
+	return err
+}
+
+// DiscussionChannelsHandler lists a course's channels (GET, for enrolled
+// users and staff) or creates one (POST, staff only).
+//
+// GET  /discussion/channels?course=CS101&user_id=STU-0001
+// POST /discussion/channels?staff_id=STF-0001 {"course":"CS101","kind":"lecture","ref":"7","title":"Lecture 7"}
+func DiscussionChannelsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listDiscussionChannels(w, r)
+	case http.MethodPost:
+		createDiscussionChannel(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func listDiscussionChannels(w http.ResponseWriter, r *http.Request) {
+	course := r.URL.Query().Get("course")
+	if course == "" {
+		http.Error(w, "Missing course param", http.StatusBadRequest)
+		return
+	}
+	if _, ok := resolveDiscussionIdentity(r.URL.Query().Get("user_id"), course); !ok {
+		http.Error(w, "You are not a member of this course", http.StatusForbidden)
+		return
+	}
+
+	rows, err := storage.DB.Query(
+		`SELECT id, course_code, slug, title, kind, ref, created_by, created_at
+		 FROM discussion_channels WHERE course_code = $1
+		 ORDER BY kind = 'general' DESC, created_at`, course,
+	)
+	if err != nil {
+		log.Println("list channels:", err)
+		http.Error(w, "Database query failed", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	channels := make([]DiscussionChannel, 0)
+	for rows.Next() {
+		var c DiscussionChannel
+		var ref sql.NullString
+		if err := rows.Scan(&c.ID, &c.CourseCode, &c.Slug, &c.Title, &c.Kind, &ref, &c.CreatedBy, &c.CreatedAt); err != nil {
+			log.Println("Row scan error:", err)
+			continue
+		}
+		c.Ref = ref.String
+		channels = append(channels, c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(channels)
+}
+
+func createDiscussionChannel(w http.ResponseWriter, r *http.Request) {
+	staffID, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Course string `json:"course"`
+		Kind   string `json:"kind"`
+		Ref    string `json:"ref"`
+		Title  string `json:"title"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Course = strings.TrimSpace(req.Course)
+	req.Title = strings.TrimSpace(req.Title)
+	if req.Course == "" {
+		http.Error(w, "course is required", http.StatusBadRequest)
+		return
+	}
+
+	var slug string
+	switch req.Kind {
+	case "general":
+		slug = "general"
+		if req.Title == "" {
+			req.Title = "General"
+		}
+	case "assignment", "lecture":
+		if req.Ref == "" {
+			http.Error(w, "ref is required for "+req.Kind+" channels", http.StatusBadRequest)
+			return
+		}
+		slug = req.Kind + "-" + slugify(req.Ref)
+		if req.Title == "" {
+			req.Title = fmt.Sprintf("%s %s", strings.ToUpper(req.Kind[:1])+req.Kind[1:], req.Ref)
+		}
+	case "topic", "":
+		req.Kind = "topic"
+		slug = slugify(req.Title)
+	default:
+		http.Error(w, "kind must be general, assignment, lecture or topic", http.StatusBadRequest)
+		return
+	}
+	if slug == "" || req.Title == "" {
+		http.Error(w, "title is required", http.StatusBadRequest)
+		return
+	}
+
+	c := DiscussionChannel{CourseCode: req.Course, Slug: slug, Title: req.Title, Kind: req.Kind, Ref: req.Ref, CreatedBy: staffID}
+	err := storage.DB.QueryRow(
+		`INSERT INTO discussion_channels (course_code, slug, title, kind, ref, created_by)
+		 VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6)
+		 ON CONFLICT (course_code, slug) DO NOTHING
+		 RETURNING id, created_at`,
+		c.CourseCode, c.Slug, c.Title, c.Kind, c.Ref, c.CreatedBy,
+	).Scan(&c.ID, &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "A channel with that name already exists", http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Println("create channel:", err)
+		http.Error(w, "Database insert failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+// DiscussionEnrollHandler enrolls a user in a course (staff only), which
+// grants access to the course's channels and sets their display name.
+// notify_mentions opts the user into mention emails sent to email.
+//
+// POST /discussion/enrollments?staff_id=STF-0001 {"course":"CS101","user_id":"STU-0001","display_name":"Asha K","role":"student","email":"asha@example.edu","notify_mentions":true}
+func DiscussionEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	staffID, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Course         string `json:"course"`
+		UserID         string `json:"user_id"`
+		DisplayName    string `json:"display_name"`
+		Role           string `json:"role"`
+		Email          string `json:"email"`
+		NotifyMentions bool   `json:"notify_mentions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Course == "" || !isRegisteredID(storage.DB, req.UserID) {
+		http.Error(w, "course and a registered user_id are required", http.StatusBadRequest)
+		return
+	}
+	if req.Role != "staff" {
+		req.Role = "student"
+	}
+
+	_, err := storage.DB.Exec(
+		`INSERT INTO course_enrollments (course_code, user_id, display_name, role, email, notify_mentions)
+		 VALUES ($1, $2, NULLIF($3, ''), $4, NULLIF($5, ''), $6)
+		 ON CONFLICT (course_code, user_id) DO UPDATE SET display_name = EXCLUDED.display_name, role = EXCLUDED.role,
+		 email = EXCLUDED.email, notify_mentions = EXCLUDED.notify_mentions`,
+		req.Course, req.UserID, strings.TrimSpace(req.DisplayName), req.Role, strings.TrimSpace(req.Email), req.NotifyMentions,
+	)
+	if err == nil {
+		err = ensureGeneralChannel(req.Course, staffID)
+	}
+	if err != nil {
+		log.Println("enroll:", err)
+		http.Error(w, "Database insert failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "enrolled"})
+}
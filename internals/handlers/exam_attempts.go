@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"CampusMoon/internals/exams"
+	"CampusMoon/internals/storage"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ===== Exam Attempts =====
+
+// examUser reads a registered user_id and whether it is a staff ID.
+func examUser(w http.ResponseWriter, r *http.Request) (string, bool, bool) {
+	userID := r.URL.Query().Get("user_id")
+	if storage.DB == nil || !isRegisteredID(storage.DB, userID) {
+		http.Error(w, "A registered user_id is required", http.StatusForbidden)
+		return "", false, false
+	}
+	return userID, isStaffID(storage.DB, userID), true
+}
+
+// ExamsHandler lists the exams of a course, or sets up a new one for
+// staff. An exam draws each attempt's questions from its blueprint; reveal
+// is submit (default), close or never.
+//
+// GET  /exam/exams?user_id=STU-0001&course=CS101
+// POST /exam/exams?staff_id=STF-0001 {"course":"CS101","title":"Midterm","blueprint":{"sections":[{"topic":"ai","count":10}]},"timeLimit":1800,"maxAttempts":1,"reveal":"close","closesAt":"2025-03-01T18:00:00Z"}
+func ExamsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		staffID, ok := requireStaff(w, r)
+		if !ok {
+			return
+		}
+		var e exams.Exam
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		e, err := Exams.CreateExam(staffID, e)
+		if err != nil {
+			writeExamError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, e)
+		return
+	}
+
+	userID, isStaff, ok := examUser(w, r)
+	if !ok {
+		return
+	}
+	course := r.URL.Query().Get("course")
+	if course != "" && !isStaff {
+		if _, enrolled := resolveDiscussionIdentity(userID, course); !enrolled {
+			http.Error(w, "You are not enrolled in this course", http.StatusForbidden)
+			return
+		}
+	}
+	list, err := Exams.ListExams(course)
+	if err != nil {
+		writeExamError(w, err)
+		return
+	}
+	writeJSON(w, list)
+}
+
+// ExamAttemptStartHandler starts an attempt, or resumes the one in
+// progress: at an exam by examId, or at a practice exam of a topic or
+// blueprint. The server keeps the answers; the attempt comes back without
+// them.
+//
+// POST /exam/attempts/start?user_id=STU-0001 {"examId":"exam-..."}
+// POST /exam/attempts/start?user_id=STU-0001 {"topic":"ai","count":5}
+func ExamAttemptStartHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := examUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		ExamRequest
+		ExamID string `json:"examId"`
+		Title  string `json:"title"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	var a exams.Attempt
+	var err error
+	if req.ExamID != "" {
+		var e exams.Exam
+		if e, err = Exams.GetExam(req.ExamID); err != nil {
+			writeExamError(w, err)
+			return
+		}
+		if e.Course != "" {
+			if _, enrolled := resolveDiscussionIdentity(userID, e.Course); !enrolled {
+				http.Error(w, "You are not enrolled in this course", http.StatusForbidden)
+				return
+			}
+		}
+		a, err = Exams.StartAttempt(e.ID, userID)
+	} else {
+		blueprint := req.Blueprint
+		if blueprint == nil {
+			if blueprint, err = topicBlueprint(req.Topic, req.Count); err != nil {
+				writeExamError(w, err)
+				return
+			}
+		}
+		title := req.Title
+		if title == "" {
+			title = "Practice exam"
+		}
+		a, err = Exams.StartPractice(userID, title, *blueprint)
+	}
+	if err != nil {
+		writeExamError(w, err)
+		return
+	}
+	writeJSON(w, a.View(time.Now()))
+}
+
+// ExamAttemptHandler returns an attempt: its student sees it without the
+// answers until they are revealed, staff see all of it.
+//
+// GET /exam/attempts/attempt-...?user_id=STU-0001
+func ExamAttemptHandler(w http.ResponseWriter, r *http.Request) {
+	userID, isStaff, ok := examUser(w, r)
+	if !ok {
+		return
+	}
+	a, err := Exams.Attempt(mux.Vars(r)["id"])
+	if err != nil {
+		writeExamError(w, err)
+		return
+	}
+	switch {
+	case isStaff:
+		writeJSON(w, a)
+	case a.StudentID == userID:
+		writeJSON(w, a.View(time.Now()))
+	default:
+		writeExamError(w, exams.ErrForbidden)
+	}
+}
+
+// ExamAnswersHandler autosaves answers to an attempt in progress, keyed by
+// question ID. Answers are refused once its time is up.
+//
+// PUT /exam/attempts/attempt-.../answers?user_id=STU-0001 {"answers":{"12":{"choice":2},"13":{"choices":[0,2]},"14":{"number":9.81,"unit":"m/s^2"},"15":{"text":"…"},"16":{"order":["…","…"]},"17":{"code":"…"}}}
+func ExamAnswersHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := examUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Answers map[string]exams.Response `json:"answers"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	a, err := Exams.SaveAnswers(mux.Vars(r)["id"], userID, req.Answers)
+	if err != nil {
+		writeExamError(w, err)
+		return
+	}
+	writeJSON(w, a.View(time.Now()))
+}
+
+// ExamSubmitHandler saves any last answers and grades an attempt. The
+// score, and the answers with explanations if the exam reveals them now,
+// come back with it.
+//
+// POST /exam/attempts/attempt-.../submit?user_id=STU-0001 {"answers":{"12":{"choice":2}}}
+func ExamSubmitHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := examUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Answers map[string]exams.Response `json:"answers"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	}
+	a, err := Exams.Submit(mux.Vars(r)["id"], userID, req.Answers)
+	if err != nil {
+		writeExamError(w, err)
+		return
+	}
+	writeJSON(w, a.View(time.Now()))
+}
+
+// ExamReviewHandler lets staff set the points of one question of a graded
+// attempt by hand, such as a short answer or code flagged for review. The
+// attempt's score follows.
+//
+// PUT /exam/attempts/attempt-.../review?staff_id=STF-0001 {"questionId":15,"points":0.5,"feedback":"Half right"}
+func ExamReviewHandler(w http.ResponseWriter, r *http.Request) {
+	staffID, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		QuestionID int64   `json:"questionId"`
+		Points     float64 `json:"points"`
+		Feedback   string  `json:"feedback"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	a, err := Exams.Review(mux.Vars(r)["id"], staffID, req.QuestionID, req.Points, req.Feedback)
+	if err != nil {
+		writeExamError(w, err)
+		return
+	}
+	writeJSON(w, a)
+}
+
+// ExamAttemptsHandler lists attempts with their scores, newest first.
+// Students get their own, optionally of one exam; staff can ask for an
+// exam's attempts, a student's, or both, and review=pending keeps those
+// with grades waiting for review.
+//
+// GET /exam/attempts?user_id=STU-0001
+// GET /exam/attempts?user_id=STF-0001&exam_id=exam-...&student_id=STU-0001
+// GET /exam/attempts?user_id=STF-0001&exam_id=exam-...&review=pending
+func ExamAttemptsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, isStaff, ok := examUser(w, r)
+	if !ok {
+		return
+	}
+	examID, studentID := r.URL.Query().Get("exam_id"), userID
+	if isStaff {
+		studentID = r.URL.Query().Get("student_id")
+		if examID == "" && studentID == "" {
+			http.Error(w, "Missing exam_id or student_id param", http.StatusBadRequest)
+			return
+		}
+	}
+	list, err := Exams.Attempts(examID, studentID)
+	if err != nil {
+		writeExamError(w, err)
+		return
+	}
+	if isStaff {
+		if r.URL.Query().Get("review") == "pending" {
+			pending := []exams.Attempt{}
+			for _, a := range list {
+				if a.NeedsReview() {
+					pending = append(pending, a)
+				}
+			}
+			list = pending
+		}
+		writeJSON(w, list)
+		return
+	}
+	now := time.Now()
+	views := make([]exams.AttemptView, len(list))
+	for i := range list {
+		views[i] = list[i].View(now)
+	}
+	writeJSON(w, views)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"CampusMoon/internals/exams"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// ===== Exam Question Bank =====
+
+// ExamTopicsHandler lists the topics of the question bank with how many
+// questions each has.
+//
+// GET /exam/topics
+func ExamTopicsHandler(w http.ResponseWriter, r *http.Request) {
+	topics, err := Exams.Topics()
+	if err != nil {
+		writeExamError(w, err)
+		return
+	}
+	writeJSON(w, topics)
+}
+
+// ExamQuestionsHandler lists the question bank, with answers, or adds a
+// question to it: single or multiple choice, numeric, short answer,
+// matching, ordering or code. Staff only. Listings filter by topic, tag,
+// difficulty, bloom, kind, author and q (text search), and page with limit
+// and offset.
+//
+// GET  /exam/questions?staff_id=STF-0001&topic=ai&difficulty=hard&limit=50
+// POST /exam/questions?staff_id=STF-0001 {"topic":"ai","tags":["search"],"difficulty":"hard","bloomLevel":"apply","question":"…","options":["…","…"],"correctAnswer":1,"explanation":"…"}
+// POST /exam/questions?staff_id=STF-0001 {"topic":"physics","kind":"numeric","question":"…","answer":9.81,"tolerance":0.05,"units":["m/s^2","m/s²"],"points":2}
+// POST /exam/questions?staff_id=STF-0001 {"topic":"cs","kind":"code","question":"…","language":"python","testCases":[{"input":"2 3","output":"5"},{"input":"-1 1","output":"0","hidden":true}]}
+func ExamQuestionsHandler(w http.ResponseWriter, r *http.Request) {
+	staffID, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+	if r.Method == http.MethodPost {
+		var q exams.Question
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		q, err := Exams.Create(staffID, q)
+		if err != nil {
+			writeExamError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, q)
+		return
+	}
+
+	query := r.URL.Query()
+	f := exams.Filter{
+		Topic:      query.Get("topic"),
+		Tag:        query.Get("tag"),
+		Difficulty: query.Get("difficulty"),
+		Bloom:      query.Get("bloom"),
+		Kind:       query.Get("kind"),
+		CreatedBy:  query.Get("author"),
+		Search:     query.Get("q"),
+	}
+	f.Limit, _ = strconv.Atoi(query.Get("limit"))
+	f.Offset, _ = strconv.Atoi(query.Get("offset"))
+	list, err := Exams.List(f)
+	if err != nil {
+		writeExamError(w, err)
+		return
+	}
+	writeJSON(w, list)
+}
+
+// ExamQuestionHandler reads, replaces or deletes one question of the bank.
+// Staff only; edits record who made them.
+//
+// GET    /exam/questions/12?staff_id=STF-0001
+// PUT    /exam/questions/12?staff_id=STF-0001 {"topic":"ai","question":"…","options":["…","…"],"correctAnswer":0}
+// DELETE /exam/questions/12?staff_id=STF-0001
+func ExamQuestionHandler(w http.ResponseWriter, r *http.Request) {
+	staffID, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid question id", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		var q exams.Question
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		q, err := Exams.Update(staffID, id, q)
+		if err != nil {
+			writeExamError(w, err)
+			return
+		}
+		writeJSON(w, q)
+	case http.MethodDelete:
+		if err := Exams.Delete(id); err != nil {
+			writeExamError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		q, err := Exams.Get(id)
+		if err != nil {
+			writeExamError(w, err)
+			return
+		}
+		writeJSON(w, q)
+	}
+}
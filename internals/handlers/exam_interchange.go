@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"CampusMoon/internals/exams"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ===== Exam Question Import / Export =====
+
+// examImportMaxBytes caps an uploaded question file.
+const examImportMaxBytes = 20 << 20
+
+// ExamImportHandler imports a GIFT, Moodle XML or QTI 2.1 file into the
+// question bank, uploaded as the "file" form field or as the raw body.
+// Staff only. The format is detected unless given; topic files questions
+// the file gives no category for. dry_run=1 previews the import without
+// adding anything, and duplicates of bank questions are skipped unless
+// duplicates=keep. Each question is reported: added, ready, duplicate or
+// invalid with the reason.
+//
+// POST /exam/questions/import?staff_id=STF-0001&format=gift&topic=ai&dry_run=1  (multipart "file")
+// POST /exam/questions/import?staff_id=STF-0001&format=qti  (body: a QTI package zip)
+func ExamImportHandler(w http.ResponseWriter, r *http.Request) {
+	staffID, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, examImportMaxBytes+1<<20)
+	var data []byte
+	var err error
+	name := ""
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, header, ferr := r.FormFile("file")
+		if ferr != nil {
+			http.Error(w, "Missing file", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		name = header.Filename
+		data, err = io.ReadAll(io.LimitReader(file, examImportMaxBytes+1))
+	} else {
+		data, err = io.ReadAll(io.LimitReader(r.Body, examImportMaxBytes+1))
+	}
+	if err != nil || len(data) > examImportMaxBytes {
+		http.Error(w, "The file is too large or could not be read", http.StatusBadRequest)
+		return
+	}
+	if len(data) == 0 {
+		http.Error(w, "The file is empty", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	format := strings.ToLower(query.Get("format"))
+	if format == "" {
+		format = exams.DetectFormat(name, data)
+	}
+	dryRun, _ := strconv.ParseBool(query.Get("dry_run"))
+	report, err := Exams.Import(staffID, format, data, exams.ImportOptions{
+		Topic:          query.Get("topic"),
+		DryRun:         dryRun,
+		KeepDuplicates: query.Get("duplicates") == "keep",
+	})
+	if err != nil {
+		writeExamError(w, err)
+		return
+	}
+	if report.Added > 0 {
+		w.WriteHeader(http.StatusCreated)
+	}
+	writeJSON(w, report)
+}
+
+// ExamExportHandler exports the question bank as GIFT, Moodle XML or a
+// QTI 2.1 package, filtered as the question listing is. Staff only.
+// Questions the format cannot hold are left out, their IDs listed in the
+// X-Skipped-Questions header.
+//
+// GET /exam/questions/export?staff_id=STF-0001&format=moodle&topic=ai
+func ExamExportHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireStaff(w, r); !ok {
+		return
+	}
+	query := r.URL.Query()
+	format := strings.ToLower(query.Get("format"))
+	if format == "" {
+		format = exams.FormatMoodle
+	}
+	export, err := Exams.Export(format, exams.Filter{
+		Topic:      query.Get("topic"),
+		Tag:        query.Get("tag"),
+		Difficulty: query.Get("difficulty"),
+		Bloom:      query.Get("bloom"),
+		Kind:       query.Get("kind"),
+		CreatedBy:  query.Get("author"),
+		Search:     query.Get("q"),
+	})
+	if err != nil {
+		writeExamError(w, err)
+		return
+	}
+
+	name := "question-bank"
+	if topic := exams.Topic(query.Get("topic")); topic != "" {
+		name += "-" + topic
+	}
+	if len(export.Skipped) > 0 {
+		ids := make([]string, len(export.Skipped))
+		for i, s := range export.Skipped {
+			ids[i] = strconv.FormatInt(s.ID, 10)
+		}
+		w.Header().Set("X-Skipped-Questions", strings.Join(ids, ","))
+	}
+	w.Header().Set("Content-Type", export.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+export.Ext))
+	w.Write(export.Data)
+}
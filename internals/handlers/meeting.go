@@ -0,0 +1,420 @@
+package handlers
+
+import (
+	"CampusMoon/internals/messaging"
+	"log"
+	"sync"
+	"time"
+)
+
+// ===== Meeting Rooms =====
+
+// MeetingRoom holds the peers of one /ws meeting and the host-controlled
+// state that moderation actions change. Participants have been admitted and
+// take part in signaling; Waiting holds peers parked in the waiting room.
+type MeetingRoom struct {
+	ID           string
+	HostID       string // client ID of the current host
+	Locked       bool
+	WaitingRoom  bool
+	ChatDisabled bool
+	SpotlightID  string
+	Hands        []string // raise-hand queue of client IDs, oldest first
+	FloorID      string   // client ID holding a timed speaking turn
+	FloorEndsAt  time.Time
+
+	Participants map[string]*Client
+	Waiting      map[string]*Client
+	Removed      map[string]bool // user IDs removed by the host
+
+	// Breakout rooms belong to a main room and are not registered in
+	// meetingRooms, so they can only be entered by being moved there.
+	Parent           *MeetingRoom
+	Breakouts        []*MeetingRoom
+	BreakoutAssigned map[string]string // client ID -> breakout room ID
+	BreakoutsOpen    bool
+	BreakoutEndsAt   time.Time
+
+	reactions     map[string][]time.Time
+	floorTimer    *time.Timer
+	floorTurn     int
+	breakoutTimer *time.Timer
+	breakoutRound int // bumped whenever breakouts are created, opened or closed
+
+	mu sync.Mutex
+}
+
+// roomMessageHandler handles one family of in-room messages. It returns
+// false if msgType does not belong to it.
+type roomMessageHandler func(room *MeetingRoom, caller *Client, msgType string, msg map[string]interface{}) bool
+
+// roomMessageHandlers are tried in order for message types that are not
+// plain signaling or chat.
+var roomMessageHandlers = []roomMessageHandler{
+	handleModeration,
+	handleSpeakingTurns,
+	handleBreakouts,
+	handleMeetingPolls,
+}
+
+// dispatchRoomMessage passes msg to the first handler that accepts it.
+func dispatchRoomMessage(room *MeetingRoom, caller *Client, msgType string, msg map[string]interface{}) bool {
+	for _, h := range roomMessageHandlers {
+		if h(room, caller, msgType, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	meetingRooms   = make(map[string]*MeetingRoom)
+	meetingRoomsMu sync.Mutex
+)
+
+// getMeetingRoom returns the room with the given ID, creating it if needed.
+func getMeetingRoom(id string) *MeetingRoom {
+	meetingRoomsMu.Lock()
+	defer meetingRoomsMu.Unlock()
+	room, ok := meetingRooms[id]
+	if !ok {
+		room = newMeetingRoom(id, nil)
+		meetingRooms[id] = room
+	}
+	return room
+}
+
+func newMeetingRoom(id string, parent *MeetingRoom) *MeetingRoom {
+	return &MeetingRoom{
+		ID:               id,
+		Parent:           parent,
+		Participants:     make(map[string]*Client),
+		Waiting:          make(map[string]*Client),
+		Removed:          make(map[string]bool),
+		BreakoutAssigned: make(map[string]string),
+		reactions:        make(map[string][]time.Time),
+	}
+}
+
+// mainRoom returns the room a breakout belongs to, or room itself.
+func (room *MeetingRoom) mainRoom() *MeetingRoom {
+	if room.Parent != nil {
+		return room.Parent
+	}
+	return room
+}
+
+// join places c in the room, the waiting room, or rejects it. It returns
+// false when the connection should be closed.
+func (room *MeetingRoom) join(c *Client) bool {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if c.UserID != "" && room.Removed[c.UserID] {
+		c.Send(map[string]interface{}{"type": "join-denied", "reason": "You were removed from this meeting"})
+		return false
+	}
+	if room.Locked && !c.IsStaff {
+		c.Send(map[string]interface{}{"type": "join-denied", "reason": "The meeting is locked"})
+		return false
+	}
+	if room.WaitingRoom && !c.IsStaff {
+		room.Waiting[c.ID] = c
+		c.Send(map[string]interface{}{"type": "waiting-room", "id": c.ID})
+		room.notifyWaitingLocked()
+		return true
+	}
+	room.admitLocked(c)
+	return true
+}
+
+// admitLocked adds c to the participants and runs the peer handshake.
+// room.mu must be held.
+func (room *MeetingRoom) admitLocked(c *Client) {
+	delete(room.Waiting, c.ID)
+
+	existingPeers := []string{}
+	for peerID := range room.Participants {
+		existingPeers = append(existingPeers, peerID)
+	}
+	room.Participants[c.ID] = c
+
+	if room.HostID == "" && c.IsStaff {
+		room.HostID = c.ID
+	}
+	if room.Parent == nil {
+		go startAttendance(c, room.ID) // no database work under room.mu
+	}
+	// A reconnecting page gets the chat it missed first; live messages are
+	// held until then, and the database is read outside room.mu.
+	if lastSeen := c.lastSeenChatID; lastSeen > 0 {
+		c.lastSeenChatID = 0
+		c.catchUp.hold()
+		Messages.Hub.Subscribe(messaging.MeetingChannel(room.ID), c)
+		go sendChatCatchUp(c, room.ID, lastSeen)
+	} else {
+		Messages.Hub.Subscribe(messaging.MeetingChannel(room.ID), c)
+	}
+
+	// id tells the page its own client ID, which host and spotlight name,
+	// and isStaff whether its staff claim was verified.
+	c.Send(map[string]interface{}{"type": "existing-peers", "id": c.ID, "isStaff": c.IsStaff, "peers": existingPeers})
+	c.Send(room.stateLocked())
+	c.Send(room.handQueueLocked())
+
+	for peerID, p := range room.Participants {
+		if peerID != c.ID {
+			p.Send(map[string]interface{}{"type": "new-peer", "id": c.ID})
+		}
+	}
+}
+
+// leave removes c from the room and hands the host role on if needed.
+func (room *MeetingRoom) leave(c *Client) {
+	room.leaveRoom(c, false)
+	if room.Parent != nil {
+		room.Parent.releaseHost(c)
+	}
+}
+
+// leaveRoom removes c from the room. When moving is set, c is only
+// switching between the main room and a breakout, so it keeps the host role.
+func (room *MeetingRoom) leaveRoom(c *Client, moving bool) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if _, waiting := room.Waiting[c.ID]; waiting {
+		delete(room.Waiting, c.ID)
+		room.notifyWaitingLocked()
+		return
+	}
+	if _, ok := room.Participants[c.ID]; !ok {
+		return
+	}
+	delete(room.Participants, c.ID)
+	Messages.Hub.Unsubscribe(messaging.MeetingChannel(room.ID), c)
+
+	if room.SpotlightID == c.ID {
+		room.SpotlightID = ""
+	}
+	if room.FloorID == c.ID {
+		room.endFloorLocked()
+	}
+	if room.lowerHandLocked(c.ID) {
+		room.broadcastLocked(room.handQueueLocked(), "")
+	}
+	delete(room.reactions, c.ID)
+	if room.HostID == c.ID && !moving {
+		room.HostID = ""
+		for id, p := range room.Participants {
+			if p.IsStaff {
+				room.HostID = id
+				break
+			}
+		}
+	}
+
+	for _, p := range room.Participants {
+		p.Send(map[string]interface{}{"type": "peer-disconnected", "id": c.ID})
+	}
+	room.broadcastLocked(room.stateLocked(), "")
+
+	if room.Parent == nil && len(room.Breakouts) == 0 &&
+		len(room.Participants) == 0 && len(room.Waiting) == 0 {
+		meetingRoomsMu.Lock()
+		delete(meetingRooms, room.ID)
+		meetingRoomsMu.Unlock()
+	}
+}
+
+func (room *MeetingRoom) isParticipant(id string) bool {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	_, ok := room.Participants[id]
+	return ok
+}
+
+func (room *MeetingRoom) participant(id string) *Client {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	return room.Participants[id]
+}
+
+func (room *MeetingRoom) chatDisabledFor(c *Client) bool {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	return room.ChatDisabled && c.ID != room.HostID
+}
+
+// broadcast sends msg to every participant except exceptID.
+func (room *MeetingRoom) broadcast(msg interface{}, exceptID string) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	room.broadcastLocked(msg, exceptID)
+}
+
+func (room *MeetingRoom) broadcastLocked(msg interface{}, exceptID string) {
+	for id, p := range room.Participants {
+		if id == exceptID {
+			continue
+		}
+		if err := p.Send(msg); err != nil {
+			log.Println("Error sending to participant:", err)
+		}
+	}
+}
+
+// stateLocked describes the moderation state shown to every participant.
+func (room *MeetingRoom) stateLocked() map[string]interface{} {
+	muted := []string{}
+	for id, p := range room.Participants {
+		if p.Muted {
+			muted = append(muted, id)
+		}
+	}
+	return map[string]interface{}{
+		"type":         "room-state",
+		"room":         room.ID,
+		"host":         room.HostID,
+		"locked":       room.Locked,
+		"waitingRoom":  room.WaitingRoom,
+		"chatDisabled": room.ChatDisabled,
+		"spotlight":    room.SpotlightID,
+		"muted":        muted,
+	}
+}
+
+// notifyWaitingLocked tells the host who is in the waiting room.
+func (room *MeetingRoom) notifyWaitingLocked() {
+	host, ok := room.Participants[room.HostID]
+	if !ok {
+		return
+	}
+	waiting := []map[string]string{}
+	for id, c := range room.Waiting {
+		waiting = append(waiting, map[string]string{"id": id, "name": c.UserName})
+	}
+	host.Send(map[string]interface{}{"type": "waiting-room-update", "waiting": waiting})
+}
+
+// ===== Moderation =====
+
+func moderationError(reason string) map[string]interface{} {
+	return map[string]interface{}{"type": "moderation-error", "error": reason}
+}
+
+// handleModeration applies a host-only action sent by caller. It returns
+// false if msgType is not a moderation message.
+func handleModeration(room *MeetingRoom, caller *Client, msgType string, msg map[string]interface{}) bool {
+	switch msgType {
+	case "mute-participant", "unmute-participant", "mute-all", "remove-participant",
+		"lock-room", "unlock-room", "enable-waiting-room", "disable-waiting-room",
+		"admit-participant", "deny-participant", "disable-chat", "enable-chat",
+		"spotlight", "transfer-host":
+	default:
+		return false
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if caller.ID != room.HostID {
+		caller.Send(moderationError("Only the host can do that"))
+		return true
+	}
+
+	target, _ := msg["target"].(string)
+
+	switch msgType {
+	case "mute-participant", "unmute-participant":
+		p, ok := room.Participants[target]
+		if !ok {
+			caller.Send(moderationError("Unknown participant"))
+			return true
+		}
+		if msgType == "mute-participant" {
+			p.Muted = true
+			p.Send(map[string]interface{}{"type": "muted-by-host"})
+		} else {
+			// Unmuting someone's microphone needs their consent.
+			p.Muted = false
+			p.Send(map[string]interface{}{"type": "unmute-request"})
+		}
+
+	case "mute-all":
+		for id, p := range room.Participants {
+			if id != room.HostID {
+				p.Muted = true
+				p.Send(map[string]interface{}{"type": "muted-by-host"})
+			}
+		}
+
+	case "remove-participant":
+		c, ok := room.Participants[target]
+		if !ok {
+			c, ok = room.Waiting[target]
+		}
+		if !ok || target == caller.ID {
+			caller.Send(moderationError("Unknown participant"))
+			return true
+		}
+		if c.UserID != "" {
+			room.Removed[c.UserID] = true
+		}
+		c.Send(map[string]interface{}{"type": "removed"})
+		// Closing the socket ends its read loop, which calls leave.
+		c.Conn.Close()
+
+	case "lock-room", "unlock-room":
+		room.Locked = msgType == "lock-room"
+
+	case "enable-waiting-room", "disable-waiting-room":
+		room.WaitingRoom = msgType == "enable-waiting-room"
+		if !room.WaitingRoom {
+			for _, c := range room.Waiting {
+				room.admitLocked(c)
+			}
+		}
+
+	case "admit-participant":
+		c, ok := room.Waiting[target]
+		if !ok {
+			caller.Send(moderationError("Not in the waiting room"))
+			return true
+		}
+		room.admitLocked(c)
+		room.notifyWaitingLocked()
+
+	case "deny-participant":
+		c, ok := room.Waiting[target]
+		if !ok {
+			caller.Send(moderationError("Not in the waiting room"))
+			return true
+		}
+		delete(room.Waiting, target)
+		c.Send(map[string]interface{}{"type": "join-denied", "reason": "The host denied your request"})
+		c.Conn.Close()
+		room.notifyWaitingLocked()
+
+	case "disable-chat", "enable-chat":
+		room.ChatDisabled = msgType == "disable-chat"
+
+	case "spotlight":
+		if _, ok := room.Participants[target]; target != "" && !ok {
+			caller.Send(moderationError("Unknown participant"))
+			return true
+		}
+		room.SpotlightID = target
+
+	case "transfer-host":
+		if _, ok := room.Participants[target]; !ok || target == caller.ID {
+			caller.Send(moderationError("Unknown participant"))
+			return true
+		}
+		room.HostID = target
+		room.notifyWaitingLocked()
+	}
+
+	room.broadcastLocked(room.stateLocked(), "")
+	return true
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"time"
+)
+
+// ===== Raise Hand, Reactions & Speaking Turns =====
+
+const (
+	reactionLimit       = 5                // reactions allowed per window
+	reactionWindow      = 10 * time.Second // rate-limit window per participant
+	defaultFloorSeconds = 60
+	maxFloorSeconds     = 600
+)
+
+var allowedReactions = map[string]bool{
+	"👍": true, "👏": true, "❤️": true, "😂": true, "😮": true, "🎉": true, "🤔": true, "👎": true,
+}
+
+// handQueueLocked returns the ordered raise-hand queue. room.mu must be held.
+func (room *MeetingRoom) handQueueLocked() map[string]interface{} {
+	queue := []map[string]string{}
+	for _, id := range room.Hands {
+		if p, ok := room.Participants[id]; ok {
+			queue = append(queue, map[string]string{"id": id, "name": p.UserName})
+		}
+	}
+	return map[string]interface{}{"type": "hand-queue", "queue": queue}
+}
+
+// lowerHandLocked removes id from the raise-hand queue and reports whether
+// it was there. room.mu must be held.
+func (room *MeetingRoom) lowerHandLocked(id string) bool {
+	for i, h := range room.Hands {
+		if h == id {
+			room.Hands = append(room.Hands[:i], room.Hands[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// allowReactionLocked applies the per-participant reaction rate limit.
+// room.mu must be held.
+func (room *MeetingRoom) allowReactionLocked(id string) bool {
+	now := time.Now()
+	recent := room.reactions[id][:0]
+	for _, t := range room.reactions[id] {
+		if now.Sub(t) < reactionWindow {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= reactionLimit {
+		room.reactions[id] = recent
+		return false
+	}
+	room.reactions[id] = append(recent, now)
+	return true
+}
+
+// giveFloorLocked unmutes target for a timed speaking turn, ending any turn
+// already in progress. room.mu must be held.
+func (room *MeetingRoom) giveFloorLocked(target *Client, seconds int) {
+	room.endFloorLocked()
+
+	target.Muted = false
+	room.lowerHandLocked(target.ID)
+	room.FloorID = target.ID
+	room.FloorEndsAt = time.Now().Add(time.Duration(seconds) * time.Second)
+	room.floorTurn++
+
+	turn := room.floorTurn
+	room.floorTimer = time.AfterFunc(time.Duration(seconds)*time.Second, func() {
+		room.mu.Lock()
+		defer room.mu.Unlock()
+		if room.floorTurn != turn {
+			return
+		}
+		room.endFloorLocked()
+		room.broadcastLocked(room.floorStateLocked(), "")
+		room.broadcastLocked(room.stateLocked(), "")
+	})
+
+	target.Send(map[string]interface{}{"type": "floor-granted", "seconds": seconds})
+}
+
+// endFloorLocked mutes the current speaker again. room.mu must be held.
+func (room *MeetingRoom) endFloorLocked() {
+	if room.floorTimer != nil {
+		room.floorTimer.Stop()
+		room.floorTimer = nil
+	}
+	if room.FloorID == "" {
+		return
+	}
+	if p, ok := room.Participants[room.FloorID]; ok && room.FloorID != room.HostID {
+		p.Muted = true
+		p.Send(map[string]interface{}{"type": "floor-ended"})
+	}
+	room.FloorID = ""
+	room.FloorEndsAt = time.Time{}
+}
+
+func (room *MeetingRoom) floorStateLocked() map[string]interface{} {
+	state := map[string]interface{}{"type": "floor-update", "speaker": room.FloorID}
+	if room.FloorID != "" {
+		state["endsAt"] = room.FloorEndsAt.Format(time.RFC3339)
+	}
+	return state
+}
+
+// handleSpeakingTurns handles raise-hand, reaction and floor messages. It
+// returns false if msgType is not one of them.
+func handleSpeakingTurns(room *MeetingRoom, caller *Client, msgType string, msg map[string]interface{}) bool {
+	switch msgType {
+	case "raise-hand", "lower-hand", "dismiss-all-hands", "reaction", "give-floor", "end-floor":
+	default:
+		return false
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	isHost := caller.ID == room.HostID
+	target, _ := msg["target"].(string)
+
+	switch msgType {
+	case "raise-hand":
+		for _, id := range room.Hands {
+			if id == caller.ID {
+				return true
+			}
+		}
+		room.Hands = append(room.Hands, caller.ID)
+		room.broadcastLocked(room.handQueueLocked(), "")
+
+	case "lower-hand":
+		// Anyone may lower their own hand; only the host may lower others.
+		if target == "" {
+			target = caller.ID
+		}
+		if target != caller.ID && !isHost {
+			caller.Send(moderationError("Only the host can do that"))
+			return true
+		}
+		if room.lowerHandLocked(target) {
+			if p, ok := room.Participants[target]; ok && target != caller.ID {
+				p.Send(map[string]interface{}{"type": "hand-lowered"})
+			}
+			room.broadcastLocked(room.handQueueLocked(), "")
+		}
+
+	case "dismiss-all-hands":
+		if !isHost {
+			caller.Send(moderationError("Only the host can do that"))
+			return true
+		}
+		room.Hands = nil
+		room.broadcastLocked(room.handQueueLocked(), "")
+
+	case "reaction":
+		emoji, _ := msg["emoji"].(string)
+		if !allowedReactions[emoji] {
+			return true
+		}
+		if !room.allowReactionLocked(caller.ID) {
+			caller.Send(map[string]interface{}{"type": "rate-limited", "retryAfter": int(reactionWindow.Seconds())})
+			return true
+		}
+		room.broadcastLocked(map[string]interface{}{"type": "reaction", "from": caller.ID, "emoji": emoji}, "")
+
+	case "give-floor":
+		if !isHost {
+			caller.Send(moderationError("Only the host can do that"))
+			return true
+		}
+		p, ok := room.Participants[target]
+		if !ok {
+			caller.Send(moderationError("Unknown participant"))
+			return true
+		}
+		seconds := defaultFloorSeconds
+		if s, ok := msg["seconds"].(float64); ok && s > 0 {
+			// Clamp before converting: int() of a huge float is undefined.
+			seconds = int(min(max(s, 1), maxFloorSeconds))
+		}
+		room.giveFloorLocked(p, seconds)
+		room.broadcastLocked(room.handQueueLocked(), "")
+		room.broadcastLocked(room.floorStateLocked(), "")
+		room.broadcastLocked(room.stateLocked(), "")
+
+	case "end-floor":
+		if !isHost {
+			caller.Send(moderationError("Only the host can do that"))
+			return true
+		}
+		room.endFloorLocked()
+		room.broadcastLocked(room.floorStateLocked(), "")
+		room.broadcastLocked(room.stateLocked(), "")
+	}
+	return true
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"CampusMoon/internals/polls"
+	"encoding/json"
+	"errors"
+	"log"
+)
+
+// ===== In-Meeting Polls & Sign Language =====
+
+const maxSignTranscriptLen = 2000
+
+// meetingPollActor is caller as seen by the poll service. Only verified
+// users have an ID to vote with, so a participant keeps one vote across
+// reconnects and extra tabs.
+func meetingPollActor(c *Client) polls.Actor {
+	actor := polls.Actor{Name: c.UserName, IsStaff: c.IsStaff}
+	if c.Verified {
+		actor.ID = c.UserID
+	}
+	return actor
+}
+
+// seesPollAnswers reports whether c gets the staff view of the room's polls:
+// verified staff and the meeting's host do.
+func seesPollAnswers(room *MeetingRoom, c *Client) bool {
+	if c.IsStaff {
+		return true
+	}
+	main := room.mainRoom()
+	main.mu.Lock()
+	defer main.mu.Unlock()
+	return c.ID == main.HostID
+}
+
+// publishMeetingPoll sends e to everyone in room and its breakout rooms,
+// each in the view they may see.
+func publishMeetingPoll(room *MeetingRoom, e polls.Event) {
+	msg, staffMsg := meetingPollEvent(e, false), meetingPollEvent(e, true)
+	if msg == nil {
+		return
+	}
+	for _, c := range room.allParticipants() {
+		m := msg
+		if seesPollAnswers(room, c) {
+			m = staffMsg
+		}
+		if err := c.Send(m); err != nil {
+			log.Println("Error sending to participant:", err)
+		}
+	}
+}
+
+// meetingPollEvent is the room message for a poll or quiz change, or nil if
+// the room is not told about it. The correct answer is only sent to
+// students once it can no longer be answered.
+func meetingPollEvent(e polls.Event, isStaff bool) map[string]interface{} {
+	if e.Quiz.ID != "" {
+		if e.Type == polls.EventQuizAnswer {
+			return nil
+		}
+		q := quizView(e.Quiz, isStaff)
+		return map[string]interface{}{"type": e.Type, "quiz": q, "stats": e.Stats, "leaderboard": e.Leaderboard}
+	}
+	p := pollView(e.Poll, isStaff)
+	switch e.Type {
+	case polls.EventVote:
+		return map[string]interface{}{"type": e.Type, "pollId": p.ID, "results": p.Results, "totalVotes": p.Total, "summary": p.Summary, "resultsHidden": p.ResultsHidden}
+	case polls.EventClosed:
+		return map[string]interface{}{"type": e.Type, "pollId": p.ID, "finalResults": p.Results, "totalVotes": p.Total, "summary": p.Summary, "resultsHidden": p.ResultsHidden}
+	}
+	return map[string]interface{}{"type": e.Type, "poll": p}
+}
+
+// sendMeetingPolls sends the room's open polls to a participant who just
+// joined.
+func sendMeetingPolls(room *MeetingRoom, c *Client) {
+	if !room.isParticipant(c.ID) {
+		return
+	}
+	scope := polls.MeetingScope(room.mainRoom().ID)
+	open, err := Polls.List(scope, polls.StatusOpen)
+	if err != nil {
+		if !errors.Is(err, polls.ErrUnavailable) {
+			log.Println("meeting polls:", err)
+		}
+		return
+	}
+	isStaff := seesPollAnswers(room, c)
+	for i := range open {
+		open[i] = pollView(open[i], isStaff)
+	}
+	votes := map[string]polls.Answer{}
+	if c.Verified {
+		if votes, err = Polls.VotesBy(scope, c.UserID); err != nil {
+			log.Println("meeting poll votes:", err)
+		}
+	}
+	c.Send(map[string]interface{}{"type": "polls", "polls": open, "myVotes": votes})
+}
+
+// decodeRoomField decodes msg[key] into v, reporting whether it could.
+func decodeRoomField(msg map[string]interface{}, key string, v interface{}) bool {
+	raw, ok := msg[key]
+	if !ok {
+		return false
+	}
+	data, err := json.Marshal(raw)
+	return err == nil && json.Unmarshal(data, v) == nil
+}
+
+// pollAnswer reads the answer of a vote-poll message. Single-choice votes
+// may send just optionIndex, as older clients do.
+func pollAnswer(msg map[string]interface{}) (polls.Answer, bool) {
+	var answer polls.Answer
+	if _, ok := msg["answer"]; ok {
+		return answer, decodeRoomField(msg, "answer", &answer)
+	}
+	idx, ok := msg["optionIndex"].(float64)
+	if !ok || idx != float64(int(idx)) {
+		return answer, false
+	}
+	answer.Choices = []int{int(idx)}
+	return answer, true
+}
+
+// handleMeetingPolls handles create-poll, vote-poll, end-poll and
+// sign-language-update. It returns false for any other message type.
+// Polls belong to the main room, so breakouts share them.
+func handleMeetingPolls(room *MeetingRoom, caller *Client, msgType string, msg map[string]interface{}) bool {
+	scope := polls.MeetingScope(room.mainRoom().ID)
+	pollID, _ := msg["pollId"].(string)
+
+	var err error
+	switch msgType {
+	case "create-poll":
+		var poll polls.Poll
+		if !decodeRoomField(msg, "poll", &poll) {
+			err = polls.ErrInvalidPoll
+			break
+		}
+		_, err = Polls.Create(scope, meetingPollActor(caller), poll)
+
+	case "vote-poll":
+		answer, ok := pollAnswer(msg)
+		if !ok {
+			err = polls.ErrBadAnswer
+			break
+		}
+		_, err = Polls.Vote(scope, pollID, meetingPollActor(caller), answer)
+
+	case "end-poll":
+		_, err = Polls.Close(scope, pollID, meetingPollActor(caller))
+
+	case "sign-language-update":
+		room.mu.Lock()
+		defer room.mu.Unlock()
+		if !caller.IsStaff && caller.ID != room.HostID {
+			caller.Send(moderationError("Only staff or the host can share sign language"))
+			return true
+		}
+		transcript, _ := msg["transcript"].(string)
+		if transcript == "" || len(transcript) > maxSignTranscriptLen {
+			return true
+		}
+		gloss, _ := msg["gloss"].(string)
+		room.broadcastLocked(map[string]interface{}{
+			"type":       "sign-language-update",
+			"from":       caller.ID,
+			"transcript": transcript,
+			"gloss":      gloss,
+		}, caller.ID)
+
+	default:
+		return false
+	}
+	if err != nil {
+		caller.Send(moderationError(pollErrorText(err)))
+	}
+	return true
+}
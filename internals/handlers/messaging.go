@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"CampusMoon/internals/messaging"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Messages is the shared messaging service behind meeting chat and
+// discussion channels. InitMessaging replaces it with a database-backed one.
+var Messages = messaging.NewService(nil)
+
+// InitMessaging sets up the messaging service. Call this once from main.
+func InitMessaging(db *sql.DB) {
+	Messages = messaging.NewService(db)
+	Messages.Moderation.IsStaff = func(userID string) bool { return isStaffID(db, userID) }
+	Messages.Directory = chatDirectory{}
+	Messages.OnMention = func(m messaging.Message, userID string) { go emailMention(m, userID) }
+}
+
+// ===== Meeting chat adapter =====
+
+// SubscriberID implements messaging.Subscriber.
+func (c *Client) SubscriberID() string {
+	return c.ID
+}
+
+// Deliver implements messaging.Subscriber. New messages use the
+// chat-message format meet.html understands; changes are sent as typed
+// events carrying the updated message.
+func (c *Client) Deliver(e messaging.Event) error {
+	switch e.Type {
+	case messaging.EventMessage:
+		return c.catchUp.deliver(e.Message, func(m messaging.Message) error {
+			return c.Send(meetingChatEvent(m))
+		})
+	case messaging.EventUnread:
+		return c.Send(map[string]interface{}{"type": e.Type, "unread": e.Unread})
+	case messaging.EventMention:
+		return c.Send(map[string]interface{}{"type": e.Type, "message": meetingChatEvent(e.Message)})
+	case messaging.EventQueue:
+		return c.Send(map[string]interface{}{"type": e.Type, "data": e.Data})
+	}
+	return c.Send(map[string]interface{}{"type": e.Type, "message": e.Message, "actor_id": e.ActorID})
+}
+
+func meetingChatEvent(m messaging.Message) map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "chat-message",
+		"id":          m.ID,
+		"sender":      m.SenderName,
+		"message":     m.Body,
+		"timestamp":   m.CreatedAt.Format(time.RFC3339),
+		"user_id":     m.SenderID,
+		"attachments": m.Attachments,
+	}
+}
+
+// ===== Discussion adapter =====
+
+// discussionEvent is a message in the format discussion.html reads: the
+// unified fields plus the older type/sender/content/timestamp names.
+type discussionEvent struct {
+	messaging.Message
+	Type      string    `json:"type"`
+	Sender    string    `json:"sender"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// discussionUpdate is a typed event about an existing message, a read
+// receipt, a mention or an unread count.
+type discussionUpdate struct {
+	Type    string                 `json:"type"`
+	Event   string                 `json:"event"`
+	ActorID string                 `json:"actor_id"`
+	Message discussionEvent        `json:"message"`
+	Unread  *messaging.UnreadCount `json:"unread,omitempty"`
+}
+
+func newDiscussionEvent(m messaging.Message) discussionEvent {
+	content := m.Body
+	if m.Kind == messaging.KindAudio && len(m.Attachments) > 0 {
+		content = m.Attachments[0].URL
+	}
+	return discussionEvent{
+		Message:   m,
+		Type:      m.Kind,
+		Sender:    m.SenderName,
+		Content:   content,
+		Timestamp: m.CreatedAt,
+	}
+}
+
+// ===== History paging =====
+
+// pageQueryFromRequest reads the before, after, date and limit parameters.
+// date is YYYY-MM-DD or RFC 3339 and jumps to the first message from then.
+func pageQueryFromRequest(r *http.Request) (messaging.PageQuery, error) {
+	var q messaging.PageQuery
+	params := r.URL.Query()
+	for name, dst := range map[string]*int64{"before": &q.Before, "after": &q.After} {
+		if v := params.Get(name); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || id <= 0 {
+				return q, fmt.Errorf("invalid %s param", name)
+			}
+			*dst = id
+		}
+	}
+	if v := params.Get("date"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			t, err = time.ParseInLocation("2006-01-02", v, time.Local)
+		}
+		if err != nil {
+			return q, fmt.Errorf("invalid date, expected YYYY-MM-DD or RFC 3339")
+		}
+		q.Since = t
+	}
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return q, fmt.Errorf("invalid limit param")
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
+
+// setPageHeaders exposes a page's cursors so history responses can stay
+// plain JSON arrays.
+func setPageHeaders(w http.ResponseWriter, p messaging.Page) {
+	w.Header().Set("Access-Control-Expose-Headers", "X-Page-Before, X-Page-After, X-Has-Older, X-Has-Newer")
+	if len(p.Messages) > 0 {
+		w.Header().Set("X-Page-Before", strconv.FormatInt(p.Before, 10))
+		w.Header().Set("X-Page-After", strconv.FormatInt(p.After, 10))
+	}
+	w.Header().Set("X-Has-Older", strconv.FormatBool(p.HasOlder))
+	w.Header().Set("X-Has-Newer", strconv.FormatBool(p.HasNewer))
+}
+
+// catchUpGate holds a subscriber's new messages while its catch-up is
+// being sent, so they arrive after the older messages they follow; pages
+// drop anything older than the newest message they have seen.
+type catchUpGate struct {
+	mu      sync.Mutex
+	holding bool
+	held    []messaging.Message
+}
+
+// hold starts holding new messages. Call it before subscribing.
+func (g *catchUpGate) hold() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.holding = true
+}
+
+// deliver sends m, or holds it until release.
+func (g *catchUpGate) deliver(m messaging.Message, send func(messaging.Message) error) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.holding {
+		g.held = append(g.held, m)
+		return nil
+	}
+	return send(m)
+}
+
+// release sends the held messages newer than lastID, which the catch-up
+// already covered, and stops holding.
+func (g *catchUpGate) release(lastID int64, send func(messaging.Message) error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, m := range g.held {
+		if m.ID > lastID {
+			send(m)
+		}
+	}
+	g.held, g.holding = nil, false
+}
+
+// sendChatCatchUp sends a reconnecting participant the room chat they
+// missed since lastSeenID, then a chat-catch-up marker, then the live
+// messages held meanwhile.
+func sendChatCatchUp(c *Client, roomID string, lastSeenID int64) {
+	send := func(m messaging.Message) error { return c.Send(meetingChatEvent(m)) }
+	page, err := Messages.CatchUp(messaging.MeetingChannel(roomID), lastSeenID)
+	if err != nil {
+		log.Println("chat catch-up:", err)
+		c.catchUp.release(lastSeenID, send)
+		return
+	}
+	for _, m := range page.Messages {
+		send(m)
+	}
+	c.Send(map[string]interface{}{"type": "chat-catch-up", "count": len(page.Messages), "lastId": page.After, "hasMore": page.HasNewer})
+	c.catchUp.release(max(page.After, lastSeenID), send)
+}
+
+// sendDiscussionCatchUp is the discussion equivalent of sendChatCatchUp.
+func sendDiscussionCatchUp(c *discussionClient, channel string, lastSeenID int64) {
+	send := func(m messaging.Message) error { return c.send(newDiscussionEvent(m)) }
+	page, err := Messages.CatchUp(channel, lastSeenID)
+	if err != nil {
+		log.Println("discussion catch-up:", err)
+		c.catchUp.release(lastSeenID, send)
+		return
+	}
+	for _, m := range page.Messages {
+		send(m)
+	}
+	c.send(map[string]interface{}{"type": "catch_up", "count": len(page.Messages), "last_id": page.After, "has_more": page.HasNewer})
+	c.catchUp.release(max(page.After, lastSeenID), send)
+}
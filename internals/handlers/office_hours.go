@@ -0,0 +1,501 @@
+package handlers
+
+import (
+	"CampusMoon/internals/messaging"
+	"CampusMoon/internals/storage"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ===== Office Hours =====
+
+// Office-hours modes: a called student gets a direct conversation or a
+// private meeting room with the staff member.
+const (
+	officeHoursChat    = "chat"
+	officeHoursMeeting = "meeting"
+)
+
+// Queue entry statuses.
+const (
+	queueWaiting   = "waiting"
+	queueCalled    = "called"
+	queueDone      = "done"
+	queueLeft      = "left"
+	queueCancelled = "cancelled"
+)
+
+// OfficeHours is a staff member's open (or closed) office-hours session.
+type OfficeHours struct {
+	ID       int64      `json:"id"`
+	StaffID  string     `json:"staff_id"`
+	Course   string     `json:"course"`
+	Title    string     `json:"title"`
+	Mode     string     `json:"mode"`
+	OpenedAt time.Time  `json:"opened_at"`
+	ClosedAt *time.Time `json:"closed_at,omitempty"`
+	Waiting  int        `json:"waiting"`
+}
+
+// QueueEntry is one student's place in an office-hours queue. Position
+// counts from 1 while waiting.
+type QueueEntry struct {
+	ID             int64      `json:"id"`
+	SessionID      int64      `json:"session_id"`
+	StudentID      string     `json:"student_id"`
+	StudentName    string     `json:"student_name"`
+	Topic          string     `json:"topic"`
+	Status         string     `json:"status"`
+	Position       int        `json:"position,omitempty"`
+	ConversationID *int64     `json:"conversation_id,omitempty"`
+	RoomID         string     `json:"room_id,omitempty"`
+	JoinedAt       time.Time  `json:"joined_at"`
+	CalledAt       *time.Time `json:"called_at,omitempty"`
+}
+
+func loadOfficeHours(id int64) (*OfficeHours, error) {
+	var s OfficeHours
+	var closedAt sql.NullTime
+	err := storage.DB.QueryRow(
+		`SELECT id, staff_id, course_code, title, mode, opened_at, closed_at,
+			(SELECT COUNT(*) FROM office_hours_queue WHERE session_id = $1 AND status = 'waiting')
+		 FROM office_hours WHERE id = $1`, id,
+	).Scan(&s.ID, &s.StaffID, &s.Course, &s.Title, &s.Mode, &s.OpenedAt, &closedAt, &s.Waiting)
+	if err != nil {
+		return nil, err
+	}
+	if closedAt.Valid {
+		s.ClosedAt = &closedAt.Time
+	}
+	return &s, nil
+}
+
+// officeHoursFromRequest loads the session in the id query parameter,
+// writing an error on failure.
+func officeHoursFromRequest(w http.ResponseWriter, r *http.Request) (*OfficeHours, bool) {
+	if storage.DB == nil {
+		http.Error(w, "Office hours are unavailable", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Missing or invalid id param", http.StatusBadRequest)
+		return nil, false
+	}
+	session, err := loadOfficeHours(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Office hours not found", http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		log.Println("load office hours:", err)
+		http.Error(w, "Database query failed", http.StatusInternalServerError)
+		return nil, false
+	}
+	return session, true
+}
+
+// activeQueue lists a session's waiting and called entries, called first,
+// then waiting in the order they joined.
+func activeQueue(session *OfficeHours) ([]QueueEntry, error) {
+	rows, err := storage.DB.Query(
+		`SELECT q.id, q.session_id, q.student_id, COALESCE(e.display_name, ''), q.topic, q.status,
+			q.conversation_id, COALESCE(q.room_id, ''), q.joined_at, q.called_at
+		 FROM office_hours_queue q
+		 LEFT JOIN course_enrollments e ON e.course_code = $2 AND e.user_id = q.student_id
+		 WHERE q.session_id = $1 AND q.status IN ('waiting', 'called')
+		 ORDER BY q.status = 'waiting', q.joined_at, q.id`,
+		session.ID, session.Course,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []QueueEntry{}
+	position := 0
+	for rows.Next() {
+		var q QueueEntry
+		var conversationID sql.NullInt64
+		var calledAt sql.NullTime
+		if err := rows.Scan(&q.ID, &q.SessionID, &q.StudentID, &q.StudentName, &q.Topic, &q.Status,
+			&conversationID, &q.RoomID, &q.JoinedAt, &calledAt); err != nil {
+			return nil, err
+		}
+		if q.StudentName == "" {
+			q.StudentName = "Student " + shortID(q.StudentID)
+		}
+		if conversationID.Valid {
+			q.ConversationID = &conversationID.Int64
+		}
+		if calledAt.Valid {
+			q.CalledAt = &calledAt.Time
+		}
+		if q.Status == queueWaiting {
+			position++
+			q.Position = position
+		}
+		entries = append(entries, q)
+	}
+	return entries, rows.Err()
+}
+
+// publishQueue sends the session's queue to its staff member and each
+// queued student their own entry, over their personal channels. Entries in
+// extra (students who just left the queue) are sent to their students too.
+func publishQueue(session *OfficeHours, extra ...QueueEntry) {
+	entries, err := activeQueue(session)
+	if err != nil {
+		log.Println("office hours queue:", err)
+		return
+	}
+	Messages.Hub.PublishTo(messaging.UserChannel(session.StaffID), messaging.Event{
+		Type: messaging.EventQueue,
+		Data: map[string]interface{}{"session": session, "queue": entries},
+	})
+	for _, q := range append(entries, extra...) {
+		Messages.Hub.PublishTo(messaging.UserChannel(q.StudentID), messaging.Event{
+			Type: messaging.EventQueue,
+			Data: map[string]interface{}{"session": session, "entry": q},
+		})
+	}
+}
+
+// OfficeHoursHandler lists a course's open office hours (GET), opens a
+// session (POST, staff) or closes one (DELETE, its staff member), which
+// cancels everyone still waiting.
+//
+// GET    /office-hours?course=CS101&user_id=STU-0001
+// POST   /office-hours?staff_id=STF-0001 {"course":"CS101","title":"Week 3 help","mode":"chat|meeting"}
+// DELETE /office-hours?id=4&staff_id=STF-0001
+func OfficeHoursHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		course := r.URL.Query().Get("course")
+		if _, ok := resolveDiscussionIdentity(r.URL.Query().Get("user_id"), course); !ok {
+			http.Error(w, "You are not a member of this course", http.StatusForbidden)
+			return
+		}
+		rows, err := storage.DB.Query(
+			`SELECT id FROM office_hours WHERE course_code = $1 AND closed_at IS NULL ORDER BY opened_at`, course,
+		)
+		if err != nil {
+			log.Println("list office hours:", err)
+			http.Error(w, "Database query failed", http.StatusInternalServerError)
+			return
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		rows.Close()
+		sessions := []*OfficeHours{}
+		for _, id := range ids {
+			if s, err := loadOfficeHours(id); err == nil {
+				sessions = append(sessions, s)
+			}
+		}
+		writeJSON(w, sessions)
+
+	case http.MethodPost:
+		staffID, ok := requireStaff(w, r)
+		if !ok {
+			return
+		}
+		var req struct {
+			Course string `json:"course"`
+			Title  string `json:"title"`
+			Mode   string `json:"mode"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Course) == "" {
+			http.Error(w, "course is required", http.StatusBadRequest)
+			return
+		}
+		if req.Mode == "" {
+			req.Mode = officeHoursChat
+		}
+		if req.Mode != officeHoursChat && req.Mode != officeHoursMeeting {
+			http.Error(w, "mode must be chat or meeting", http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(req.Title) == "" {
+			req.Title = "Office hours"
+		}
+		var id int64
+		err := storage.DB.QueryRow(
+			`INSERT INTO office_hours (staff_id, course_code, title, mode) VALUES ($1, $2, $3, $4) RETURNING id`,
+			staffID, strings.TrimSpace(req.Course), strings.TrimSpace(req.Title), req.Mode,
+		).Scan(&id)
+		if err != nil {
+			log.Println("open office hours:", err)
+			http.Error(w, "Database insert failed", http.StatusInternalServerError)
+			return
+		}
+		session, err := loadOfficeHours(id)
+		if err != nil {
+			log.Println("load office hours:", err)
+			http.Error(w, "Database query failed", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, session)
+
+	case http.MethodDelete:
+		staffID, ok := requireStaff(w, r)
+		if !ok {
+			return
+		}
+		session, ok := officeHoursFromRequest(w, r)
+		if !ok {
+			return
+		}
+		if session.StaffID != staffID {
+			http.Error(w, "Only the staff member who opened these office hours can close them", http.StatusForbidden)
+			return
+		}
+		cancelled, _ := activeQueue(session)
+		_, err := storage.DB.Exec(
+			`WITH closed AS (UPDATE office_hours SET closed_at = NOW() WHERE id = $1 AND closed_at IS NULL)
+			 UPDATE office_hours_queue
+			 SET status = CASE WHEN status = 'called' THEN 'done' ELSE 'cancelled' END, finished_at = NOW()
+			 WHERE session_id = $1 AND status IN ('waiting', 'called')`, session.ID,
+		)
+		if err != nil {
+			log.Println("close office hours:", err)
+			http.Error(w, "Database update failed", http.StatusInternalServerError)
+			return
+		}
+		session, _ = loadOfficeHours(session.ID)
+		for i := range cancelled {
+			if cancelled[i].Status == queueWaiting {
+				cancelled[i].Status = queueCancelled
+			} else {
+				cancelled[i].Status = queueDone
+			}
+			cancelled[i].Position = 0
+		}
+		publishQueue(session, cancelled...)
+		writeJSON(w, session)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// OfficeHoursQueueHandler shows the queue (GET: the whole queue for its
+// staff member, a student's own entry otherwise), joins it (POST) or leaves
+// it (DELETE). Queue changes are also pushed over the user's sockets as
+// queue-updated events.
+//
+// GET    /office-hours/queue?id=4&user_id=STU-0001
+// POST   /office-hours/queue?id=4&user_id=STU-0001 {"topic":"Question about lab 2"}
+// DELETE /office-hours/queue?id=4&user_id=STU-0001
+func OfficeHoursQueueHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := officeHoursFromRequest(w, r)
+	if !ok {
+		return
+	}
+	userID := r.URL.Query().Get("user_id")
+	identity, ok := resolveDiscussionIdentity(userID, session.Course)
+	if !ok {
+		http.Error(w, "You are not a member of this course", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		entries, err := activeQueue(session)
+		if err != nil {
+			log.Println("office hours queue:", err)
+			http.Error(w, "Database query failed", http.StatusInternalServerError)
+			return
+		}
+		if userID == session.StaffID {
+			writeJSON(w, map[string]interface{}{"session": session, "queue": entries})
+			return
+		}
+		for _, q := range entries {
+			if q.StudentID == userID {
+				writeJSON(w, map[string]interface{}{"session": session, "entry": q})
+				return
+			}
+		}
+		writeJSON(w, map[string]interface{}{"session": session, "entry": nil})
+
+	case http.MethodPost:
+		if session.ClosedAt != nil {
+			http.Error(w, "These office hours are closed", http.StatusConflict)
+			return
+		}
+		if userID == session.StaffID {
+			http.Error(w, "You cannot queue for your own office hours", http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			Topic string `json:"topic"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		res, err := storage.DB.Exec(
+			`INSERT INTO office_hours_queue (session_id, student_id, topic) VALUES ($1, $2, $3)
+			 ON CONFLICT (session_id, student_id) WHERE status IN ('waiting', 'called') DO NOTHING`,
+			session.ID, identity.UserID, strings.TrimSpace(req.Topic),
+		)
+		if err != nil {
+			log.Println("join office hours:", err)
+			http.Error(w, "Database insert failed", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "You are already in this queue", http.StatusConflict)
+			return
+		}
+		session, _ = loadOfficeHours(session.ID)
+		publishQueue(session)
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, map[string]string{"status": queueWaiting})
+
+	case http.MethodDelete:
+		res, err := storage.DB.Exec(
+			`UPDATE office_hours_queue SET status = 'left', finished_at = NOW()
+			 WHERE session_id = $1 AND student_id = $2 AND status = 'waiting'`, session.ID, userID,
+		)
+		if err != nil {
+			log.Println("leave office hours:", err)
+			http.Error(w, "Database update failed", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "You are not waiting in this queue", http.StatusNotFound)
+			return
+		}
+		session, _ = loadOfficeHours(session.ID)
+		publishQueue(session, QueueEntry{SessionID: session.ID, StudentID: userID, Status: queueLeft})
+		writeJSON(w, map[string]string{"status": queueLeft})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// OfficeHoursNextHandler finishes the students the staff member was helping
+// and calls the next one in line into a direct conversation or a private
+// meeting room, depending on the session's mode. The called student is told
+// over their sockets.
+//
+// POST /office-hours/next?id=4&staff_id=STF-0001
+func OfficeHoursNextHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	staffID, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+	session, ok := officeHoursFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if session.StaffID != staffID {
+		http.Error(w, "Only the staff member who opened these office hours can call students", http.StatusForbidden)
+		return
+	}
+	if session.ClosedAt != nil {
+		http.Error(w, "These office hours are closed", http.StatusConflict)
+		return
+	}
+
+	finished, called, err := callNextStudent(session)
+	if err == sql.ErrNoRows {
+		session, _ = loadOfficeHours(session.ID)
+		publishQueue(session, finished...)
+		http.Error(w, "The queue is empty", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("office hours next:", err)
+		http.Error(w, "Database update failed", http.StatusInternalServerError)
+		return
+	}
+	session, _ = loadOfficeHours(session.ID)
+	publishQueue(session, finished...)
+	writeJSON(w, called)
+}
+
+// callNextStudent marks the session's called entries done and calls the
+// first waiting one, returning sql.ErrNoRows when nobody is waiting.
+func callNextStudent(session *OfficeHours) ([]QueueEntry, *QueueEntry, error) {
+	tx, err := storage.DB.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var finished []QueueEntry
+	rows, err := tx.Query(
+		`UPDATE office_hours_queue SET status = 'done', finished_at = NOW()
+		 WHERE session_id = $1 AND status = 'called' RETURNING id, student_id`, session.ID,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		q := QueueEntry{SessionID: session.ID, Status: queueDone}
+		if err := rows.Scan(&q.ID, &q.StudentID); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		finished = append(finished, q)
+	}
+	rows.Close()
+
+	var next QueueEntry
+	err = tx.QueryRow(
+		`SELECT id, student_id, topic, joined_at FROM office_hours_queue
+		 WHERE session_id = $1 AND status = 'waiting'
+		 ORDER BY joined_at, id LIMIT 1 FOR UPDATE SKIP LOCKED`, session.ID,
+	).Scan(&next.ID, &next.StudentID, &next.Topic, &next.JoinedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			if cerr := tx.Commit(); cerr != nil {
+				return nil, nil, cerr
+			}
+		}
+		return finished, nil, err
+	}
+
+	next.SessionID, next.Status = session.ID, queueCalled
+	var conversationID interface{}
+	if session.Mode == officeHoursMeeting {
+		next.RoomID = "office-hours-" + uuid.New().String()
+	} else {
+		conv, err := openDirectConversation(session.StaffID, []string{next.StudentID}, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		next.ConversationID = &conv.ID
+		conversationID = conv.ID
+	}
+	now := time.Now()
+	next.CalledAt = &now
+	_, err = tx.Exec(
+		`UPDATE office_hours_queue SET status = 'called', called_at = $2, conversation_id = $3, room_id = NULLIF($4, '')
+		 WHERE id = $1`,
+		next.ID, now, conversationID, next.RoomID,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return finished, &next, nil
+}
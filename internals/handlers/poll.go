@@ -1,172 +1,357 @@
 package handlers
 
 import (
+	"CampusMoon/internals/polls"
+	"CampusMoon/internals/storage"
+	"database/sql"
+	"errors"
 	"log"
 	"net/http"
 	"sync"
-	"math/rand"
 
 	"github.com/gorilla/websocket"
 )
 
+// Polls is the shared poll service behind /ws_poll, meeting rooms and the
+// poll API. InitPolls replaces it with a database-backed one.
+var Polls = polls.NewService(nil)
+
+// InitPolls sets up the poll service. Call this once from main.
+func InitPolls(db *sql.DB) {
+	Polls = polls.NewService(db)
+	Polls.Publish = publishPollEvent
+}
+
+// Message is a /ws_poll frame. Clients send poll-created (staff), poll-vote,
+// poll-ended (staff) and participant-updated; the server answers with the
+// updated poll state. Quizzes take quiz-create, quiz-next, quiz-end-question
+// and quiz-finish from staff and quiz-answer from participants.
 type Message struct {
-	Type           string      `json:"type"`
-	Question       string      `json:"question,omitempty"`
-	Options        []string    `json:"options,omitempty"`
-	CorrectAnswer  int         `json:"correctAnswer,omitempty"`
-	Choice         int         `json:"choice,omitempty"`
-	Correct        bool        `json:"correct,omitempty"`
-	PollId         string      `json:"pollId,omitempty"`
-	OptionIndex    int         `json:"optionIndex,omitempty"`
-	Sender         string      `json:"sender,omitempty"`
-	ParticipantId  string      `json:"participantId,omitempty"`
-	ParticipantName string     `json:"participantName,omitempty"`
-	Poll           *PollData   `json:"poll,omitempty"`
-	FinalResults   map[int]int `json:"finalResults,omitempty"`
-}
-
-type PollData struct {
-	Id            string          `json:"id"`
-	Question      string          `json:"question"`
-	Options       []string        `json:"options"`
-	CorrectAnswer int             `json:"correctAnswer"`
-	Results       map[int]int     `json:"results"`
-	TotalVotes    int             `json:"totalVotes"`
-	Attendance    map[string]bool `json:"attendance"`
+	Type            string                  `json:"type"`
+	PollId          string                  `json:"pollId,omitempty"`
+	OptionIndex     *int                    `json:"optionIndex,omitempty"`
+	Answer          *polls.Answer           `json:"answer,omitempty"`
+	Correct         bool                    `json:"correct,omitempty"`
+	ParticipantId   string                  `json:"participantId,omitempty"`
+	ParticipantName string                  `json:"participantName,omitempty"`
+	IsStaff         bool                    `json:"isStaff,omitempty"`
+	Poll            *polls.Poll             `json:"poll,omitempty"`
+	Polls           []polls.Poll            `json:"polls,omitempty"`
+	MyVotes         map[string]polls.Answer `json:"myVotes,omitempty"`
+	Results         []int                   `json:"results,omitempty"`
+	FinalResults    []int                   `json:"finalResults,omitempty"`
+	Summary         *polls.Summary          `json:"summary,omitempty"`
+	ResultsHidden   bool                    `json:"resultsHidden,omitempty"`
+	TotalVotes      int                     `json:"totalVotes,omitempty"`
+	QuizId          string                  `json:"quizId,omitempty"`
+	QuestionIndex   *int                    `json:"questionIndex,omitempty"`
+	Quiz            *polls.Quiz             `json:"quiz,omitempty"`
+	Quizzes         []polls.Quiz            `json:"quizzes,omitempty"`
+	Stats           *polls.QuestionStats    `json:"stats,omitempty"`
+	Leaderboard     []polls.Standing        `json:"leaderboard,omitempty"`
+	Error           string                  `json:"error,omitempty"`
 }
 
+// Client_poll is one /ws_poll connection, following the polls of one scope.
 type Client_poll struct {
-	conn *websocket.Conn
-	name string
+	conn    *websocket.Conn
+	scope   string
+	userID  string
+	name    string
+	isStaff bool
+	writeMu sync.Mutex
+}
+
+// Send writes a JSON message to the client, one writer at a time.
+func (c *Client_poll) Send(v interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteJSON(v)
 }
 
 var (
-	clientsPoll    = make(map[*Client_poll]bool)
-	broadcastPoll  = make(chan Message)
-	mutexPoll      = &sync.Mutex{}
-	activePoll     *PollData
-	attendancePoll = make(map[string]string) // participantId -> participantName
+	clientsPoll = make(map[string]map[*Client_poll]bool) // scope -> clients
+	mutexPoll   = &sync.Mutex{}
 )
 
 var upgraderPoll = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
+// pollScopeFromRequest resolves the course or room query parameter and
+// checks that user_id may take part in its polls, writing an error on
+// failure. Course polls need an enrollment unless the user is staff.
+func pollScopeFromRequest(w http.ResponseWriter, r *http.Request) (string, polls.Actor, bool) {
+	userID := r.URL.Query().Get("user_id")
+	if course := r.URL.Query().Get("course"); course != "" {
+		identity, ok := resolveDiscussionIdentity(userID, course)
+		if !ok {
+			http.Error(w, "You are not enrolled in this course", http.StatusForbidden)
+			return "", polls.Actor{}, false
+		}
+		return polls.CourseScope(course), polls.Actor{ID: userID, Name: identity.DisplayName, IsStaff: identity.IsStaff}, true
+	}
+	roomID := r.URL.Query().Get("room")
+	if roomID == "" {
+		http.Error(w, "Missing course or room param", http.StatusBadRequest)
+		return "", polls.Actor{}, false
+	}
+	if storage.DB == nil || !isRegisteredID(storage.DB, userID) {
+		http.Error(w, "A registered user_id is required", http.StatusForbidden)
+		return "", polls.Actor{}, false
+	}
+	actor := polls.Actor{ID: userID, Name: "User " + shortID(userID), IsStaff: isStaffID(storage.DB, userID)}
+	return polls.MeetingScope(roomID), actor, true
+}
+
+// pollView hides the correct answers from students, and the results from
+// whoever may not see them yet.
+func pollView(p polls.Poll, isStaff bool) polls.Poll {
+	if !isStaff {
+		p.CorrectAnswer = nil
+		p.CorrectAnswers = nil
+		p.CorrectNumber = nil
+	}
+	p.Redact(isStaff)
+	return p
+}
+
+// quizView hides the questions still to come from students, and the answer
+// to the running one. Everyone gets the current question on its own.
+func quizView(q polls.Quiz, isStaff bool) polls.Quiz {
+	if q.Current >= 0 && q.Current < len(q.Questions) {
+		current := q.Questions[q.Current]
+		if !isStaff && q.Status == polls.QuizRunning {
+			current.HideAnswer()
+		}
+		q.CurrentQuestion = &current
+	}
+	if !isStaff {
+		q.Questions = nil
+	}
+	return q
+}
+
+// pollErrorText is the message shown to a client for a failed poll action.
+func pollErrorText(err error) string {
+	switch {
+	case errors.Is(err, polls.ErrNotFound), errors.Is(err, polls.ErrForbidden), errors.Is(err, polls.ErrInvalidPoll),
+		errors.Is(err, polls.ErrClosed), errors.Is(err, polls.ErrBadOption), errors.Is(err, polls.ErrBadAnswer), errors.Is(err, polls.ErrAlreadyVoted),
+		errors.Is(err, polls.ErrNotVoter), errors.Is(err, polls.ErrUnavailable),
+		errors.Is(err, polls.ErrInvalidQuiz), errors.Is(err, polls.ErrQuizState), errors.Is(err, polls.ErrTimeUp):
+		return err.Error()
+	}
+	log.Println("poll:", err)
+	return "Poll request failed"
+}
+
+// publishPollEvent fans a poll or quiz change out to the /ws_poll clients of
+// its scope and, for meeting polls, to the meeting room and its breakouts.
+func publishPollEvent(e polls.Event) {
+	for _, c := range pollScopeClients(e.Scope()) {
+		msg := pollEventMessage(e, c.isStaff)
+		if err := c.Send(msg); err != nil {
+			log.Printf("❌ error: %v", err)
+			c.conn.Close()
+		}
+		// Correct answers mark attendance, as the poll page always has.
+		if correct, _ := e.Poll.Grade(e.Answer); e.Type == polls.EventVote && correct && (c.isStaff || c.userID == e.Voter.ID) {
+			c.Send(Message{
+				Type:            "attendance-marked",
+				PollId:          e.Poll.ID,
+				ParticipantId:   e.Voter.ID,
+				ParticipantName: e.Voter.Name,
+				Correct:         true,
+			})
+		}
+	}
+
+	if roomID, ok := polls.ScopeRoom(e.Scope()); ok {
+		meetingRoomsMu.Lock()
+		room := meetingRooms[roomID]
+		meetingRoomsMu.Unlock()
+		if room != nil {
+			publishMeetingPoll(room, e)
+		}
+	}
+}
+
+// pollEventMessage is the /ws_poll frame for e.
+func pollEventMessage(e polls.Event, isStaff bool) Message {
+	if e.Quiz.ID != "" {
+		if e.Type == polls.EventQuizAnswer {
+			return Message{Type: e.Type, QuizId: e.Quiz.ID, Stats: e.Stats}
+		}
+		q := quizView(e.Quiz, isStaff)
+		return Message{Type: e.Type, QuizId: q.ID, Quiz: &q, Stats: e.Stats, Leaderboard: e.Leaderboard}
+	}
+	p := pollView(e.Poll, isStaff)
+	switch e.Type {
+	case polls.EventVote:
+		return Message{Type: e.Type, PollId: p.ID, Results: p.Results, TotalVotes: p.Total, Summary: p.Summary, ResultsHidden: p.ResultsHidden}
+	case polls.EventClosed:
+		return Message{Type: e.Type, PollId: p.ID, FinalResults: p.Results, TotalVotes: p.Total, Summary: p.Summary, ResultsHidden: p.ResultsHidden}
+	}
+	return Message{Type: e.Type, PollId: p.ID, Poll: &p}
+}
+
+// HandleConnectionsPoll follows the polls of a course or meeting room.
+//
+// ws://localhost:8080/ws_poll?course=CS101&user_id=STU-0001
+// ws://localhost:8080/ws_poll?room=CS101-live&user_id=STF-0001
 func HandleConnectionsPoll(w http.ResponseWriter, r *http.Request) {
+	scope, actor, ok := pollScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
 	ws, err := upgraderPoll.Upgrade(w, r, nil)
 	if err != nil {
-		log.Fatal(err)
+		log.Println("WebSocket upgrade error:", err)
+		return
 	}
 	defer ws.Close()
 
-	// Generate a unique ID for the participant
-	participantId := "user-" + randString(8)
-	client_poll := &Client_poll{conn: ws, name: "Participant " + randString(4)}
-	
+	client_poll := &Client_poll{conn: ws, scope: scope, userID: actor.ID, name: actor.Name, isStaff: actor.IsStaff}
+
 	mutexPoll.Lock()
-	clientsPoll[client_poll] = true
-	attendancePoll[participantId] = client_poll.name
+	if clientsPoll[scope] == nil {
+		clientsPoll[scope] = make(map[*Client_poll]bool)
+	}
+	clientsPoll[scope][client_poll] = true
 	mutexPoll.Unlock()
 
-	// Send participant their ID
-	ws.WriteJSON(Message{
-		Type:          "participant-id",
-		ParticipantId: participantId,
-	})
+	defer func() {
+		mutexPoll.Lock()
+		delete(clientsPoll[scope], client_poll)
+		if len(clientsPoll[scope]) == 0 {
+			delete(clientsPoll, scope)
+		}
+		mutexPoll.Unlock()
+		broadcastPollScope(scope, Message{Type: "participant-left", ParticipantId: actor.ID})
+	}()
 
-	// Notify others about new participant
-	broadcastPoll <- Message{
-		Type:           "participant-joined",
-		ParticipantId:  participantId,
-		ParticipantName: client_poll.name,
+	client_poll.Send(Message{Type: "participant-id", ParticipantId: actor.ID, ParticipantName: actor.Name, IsStaff: actor.IsStaff})
+	for _, c := range pollScopeClients(scope) {
+		mutexPoll.Lock()
+		name := c.name
+		mutexPoll.Unlock()
+		client_poll.Send(Message{Type: "participant-joined", ParticipantId: c.userID, ParticipantName: name})
+	}
+	if open, err := Polls.List(scope, polls.StatusOpen); err != nil {
+		client_poll.Send(Message{Type: "poll-error", Error: pollErrorText(err)})
+	} else {
+		for i := range open {
+			open[i] = pollView(open[i], actor.IsStaff)
+		}
+		// Votes are sent back so a reconnecting page shows what was chosen.
+		votes, err := Polls.VotesBy(scope, actor.ID)
+		if err != nil {
+			log.Println("poll votes:", err)
+		}
+		client_poll.Send(Message{Type: "polls", Polls: open, MyVotes: votes})
 	}
+	if active, err := Polls.ListQuizzes(scope, true); err == nil {
+		for i := range active {
+			active[i] = quizView(active[i], actor.IsStaff)
+		}
+		client_poll.Send(Message{Type: "quizzes", Quizzes: active})
+	}
+	broadcastPollScope(scope, Message{Type: "participant-joined", ParticipantId: actor.ID, ParticipantName: actor.Name})
 
 	for {
 		var msg Message
-		err := ws.ReadJSON(&msg)
-		if err != nil {
-			log.Printf("❌ error: %v", err)
-			mutexPoll.Lock()
-			delete(clientsPoll, client_poll)
-			delete(attendancePoll, participantId)
-			mutexPoll.Unlock()
+		if err := ws.ReadJSON(&msg); err != nil {
 			break
 		}
 
-		// Set the sender ID for the message
-		msg.Sender = participantId
-		
+		var err error
 		switch msg.Type {
 		case "participant-updated":
+			if msg.ParticipantName == "" {
+				continue
+			}
 			mutexPoll.Lock()
 			client_poll.name = msg.ParticipantName
-			attendancePoll[participantId] = msg.ParticipantName
 			mutexPoll.Unlock()
-			
+			broadcastPollScope(scope, Message{Type: msg.Type, ParticipantId: actor.ID, ParticipantName: msg.ParticipantName})
+
 		case "poll-created":
-			mutexPoll.Lock()
-			// Store the active poll
-			activePoll = msg.Poll
-			// Initialize attendance tracking
-			activePoll.Attendance = make(map[string]bool)
-			mutexPoll.Unlock()
-			
+			if msg.Poll == nil {
+				continue
+			}
+			_, err = Polls.Create(scope, actor, *msg.Poll)
+
 		case "poll-vote":
 			mutexPoll.Lock()
-			if activePoll != nil && activePoll.Id == msg.PollId {
-				// Update poll results
-				activePoll.Results[msg.OptionIndex]++
-				activePoll.TotalVotes++
-				
-				// Check if the answer is correct
-				isCorrect := msg.OptionIndex == activePoll.CorrectAnswer
-				
-				// Mark attendance if answer is correct
-				if isCorrect {
-					activePoll.Attendance[msg.Sender] = true
-					
-					// Send attendance confirmation to the participant
-					msg.Correct = true
-					msg.Type = "attendance-marked"
-					
-					// Send to all clients_poll
-					for client_poll := range clientsPoll {
-						err := client_poll.conn.WriteJSON(msg)
-						if err != nil {
-							log.Printf("❌ error: %v", err)
-							client_poll.conn.Close()
-							delete(clientsPoll, client_poll)
-						}
-					}
-					mutexPoll.Unlock()
-					continue // Skip the normal broadcast
-				}
-			}
+			voter := polls.Actor{ID: actor.ID, Name: client_poll.name}
 			mutexPoll.Unlock()
-			
+			// Single-choice votes may send just optionIndex.
+			if msg.Answer == nil && msg.OptionIndex != nil {
+				msg.Answer = &polls.Answer{Choices: []int{*msg.OptionIndex}}
+			}
+			if msg.Answer == nil {
+				err = polls.ErrBadAnswer
+				break
+			}
+			_, err = Polls.Vote(scope, msg.PollId, voter, *msg.Answer)
+
 		case "poll-ended":
+			_, err = Polls.Close(scope, msg.PollId, actor)
+
+		case "quiz-create":
+			if msg.Quiz == nil {
+				continue
+			}
+			_, err = Polls.CreateQuiz(scope, actor, *msg.Quiz)
+
+		case "quiz-next":
+			_, err = Polls.NextQuestion(scope, msg.QuizId, actor)
+
+		case "quiz-end-question":
+			_, err = Polls.EndQuestion(scope, msg.QuizId, actor)
+
+		case "quiz-finish":
+			_, err = Polls.FinishQuiz(scope, msg.QuizId, actor)
+
+		case "quiz-answer":
+			if msg.Answer == nil || msg.QuestionIndex == nil {
+				err = polls.ErrBadAnswer
+				break
+			}
 			mutexPoll.Lock()
-			activePoll = nil
+			voter := polls.Actor{ID: actor.ID, Name: client_poll.name}
 			mutexPoll.Unlock()
+			// Whether it was right is only shown once the question ends.
+			if _, err = Polls.Answer(scope, msg.QuizId, voter, *msg.QuestionIndex, *msg.Answer); err == nil {
+				client_poll.Send(Message{Type: "quiz-answer-received", QuizId: msg.QuizId, QuestionIndex: msg.QuestionIndex})
+			}
+
+		default:
+			continue
 		}
+		if err != nil {
+			client_poll.Send(Message{Type: "poll-error", PollId: msg.PollId, QuizId: msg.QuizId, Error: pollErrorText(err)})
+		}
+	}
+}
 
-		broadcastPoll <- msg
+// pollScopeClients returns the /ws_poll clients of scope.
+func pollScopeClients(scope string) []*Client_poll {
+	mutexPoll.Lock()
+	defer mutexPoll.Unlock()
+	clients := make([]*Client_poll, 0, len(clientsPoll[scope]))
+	for c := range clientsPoll[scope] {
+		clients = append(clients, c)
 	}
+	return clients
 }
 
-func HandleMessagesPoll() {
-	for {
-		msg := <-broadcastPoll
-		mutexPoll.Lock()
-		for client_poll := range clientsPoll {
-			err := client_poll.conn.WriteJSON(msg)
-			if err != nil {
-				log.Printf("❌ error: %v", err)
-				client_poll.conn.Close()
-				delete(clientsPoll, client_poll)
-			}
+// broadcastPollScope sends msg to every /ws_poll client of scope.
+func broadcastPollScope(scope string, msg Message) {
+	for _, c := range pollScopeClients(scope) {
+		if err := c.Send(msg); err != nil {
+			c.conn.Close()
 		}
-		mutexPoll.Unlock()
 	}
 }
 
@@ -174,12 +359,6 @@ func ServePoll(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "templates/poll.html")
 }
 
-// Helper function to generate random string
-func randString(n int) string {
-	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
-	}
-	return string(b)
-}
\ No newline at end of file
+func ServeQuiz(w http.ResponseWriter, r *http.Request) {
+	http.ServeFile(w, r, "templates/quiz.html")
+}
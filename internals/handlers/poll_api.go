@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"CampusMoon/internals/polls"
+	"CampusMoon/internals/storage"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// writePollError maps poll errors to HTTP statuses.
+func writePollError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, polls.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, polls.ErrForbidden), errors.Is(err, polls.ErrNotVoter):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, polls.ErrInvalidPoll), errors.Is(err, polls.ErrBadOption), errors.Is(err, polls.ErrBadAnswer),
+		errors.Is(err, polls.ErrInvalidQuiz):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, polls.ErrClosed), errors.Is(err, polls.ErrAlreadyVoted), errors.Is(err, polls.ErrQuizState),
+		errors.Is(err, polls.ErrTimeUp):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, polls.ErrUnavailable):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		log.Println("poll:", err)
+		http.Error(w, "Poll request failed", http.StatusInternalServerError)
+	}
+}
+
+// PollListHandler lists the polls of a course or meeting room, newest
+// first. status filters to open or closed polls.
+//
+// GET /polls/list?course=CS101&user_id=STU-0001&status=open
+func PollListHandler(w http.ResponseWriter, r *http.Request) {
+	scope, actor, ok := pollScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	list, err := Polls.List(scope, r.URL.Query().Get("status"))
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+	for i := range list {
+		list[i] = pollView(list[i], actor.IsStaff)
+	}
+	writeJSON(w, list)
+}
+
+// PollCreateHandler opens a poll in a course or meeting room. Staff only.
+//
+// POST /polls/create?room=CS101-live&user_id=STF-0001 {"question":"2+2?","options":["3","4"],"correctAnswer":1}
+// POST /polls/create?course=CS101&user_id=STF-0001 {"kind":"numeric","question":"g in m/s²?","correctNumber":9.81,"tolerance":0.05}
+// POST /polls/create?course=CS101&user_id=STF-0001 {"kind":"text","question":"How is the workload?","anonymous":true,"hideResults":true,"minResponses":5}
+func PollCreateHandler(w http.ResponseWriter, r *http.Request) {
+	scope, actor, ok := pollScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	var req polls.Poll
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	p, err := Polls.Create(scope, actor, req)
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, p)
+}
+
+// PollCloseHandler closes a poll and keeps a snapshot of its final results.
+//
+// POST /polls/close?id=poll-...&staff_id=STF-0001
+func PollCloseHandler(w http.ResponseWriter, r *http.Request) {
+	staffID, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+	p, err := Polls.Get(r.URL.Query().Get("id"))
+	if err == nil {
+		p, err = Polls.Close(p.Scope, p.ID, polls.Actor{ID: staffID, IsStaff: true})
+	}
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+	writeJSON(w, p)
+}
+
+// PollSnapshotsHandler lists the result snapshots of a poll, or takes a
+// new one. Staff only.
+//
+// GET  /polls/snapshots?id=poll-...&staff_id=STF-0001
+// POST /polls/snapshots?id=poll-...&staff_id=STF-0001
+func PollSnapshotsHandler(w http.ResponseWriter, r *http.Request) {
+	staffID, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if r.Method == http.MethodPost {
+		snap, err := Polls.Snapshot(id, polls.Actor{ID: staffID, IsStaff: true})
+		if err != nil {
+			writePollError(w, err)
+			return
+		}
+		if p, err := Polls.Get(id); err == nil {
+			p.RedactSnapshot(&snap)
+		}
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, snap)
+		return
+	}
+	p, err := Polls.Get(id)
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+	snaps, err := Polls.Snapshots(id)
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+	for i := range snaps {
+		p.RedactSnapshot(&snaps[i])
+	}
+	writeJSON(w, snaps)
+}
+
+// QuizListHandler lists the quizzes of a course or meeting room, newest
+// first. active=1 leaves out finished quizzes. Students only see the
+// current question of each.
+//
+// GET /quizzes/list?course=CS101&user_id=STU-0001&active=1
+func QuizListHandler(w http.ResponseWriter, r *http.Request) {
+	scope, actor, ok := pollScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	list, err := Polls.ListQuizzes(scope, r.URL.Query().Get("active") == "1")
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+	for i := range list {
+		list[i] = quizView(list[i], actor.IsStaff)
+	}
+	writeJSON(w, list)
+}
+
+// QuizCreateHandler puts a quiz in the lobby of a course or meeting room.
+// Staff only. Questions are single-choice, multi-select or numeric, each
+// with its correct answer; timeLimit (seconds) and points are optional.
+//
+// POST /quizzes/create?course=CS101&user_id=STF-0001 {"title":"Week 3","questions":[{"question":"2+2?","options":["3","4"],"correctAnswer":1,"timeLimit":15}]}
+func QuizCreateHandler(w http.ResponseWriter, r *http.Request) {
+	scope, actor, ok := pollScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	var req polls.Quiz
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	q, err := Polls.CreateQuiz(scope, actor, req)
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, q)
+}
+
+// QuizControlHandler moves a quiz on: next starts the next question (or
+// finishes after the last), end-question ends the running question early
+// and finish ends the quiz. Staff only.
+//
+// POST /quizzes/next?id=quiz-...&staff_id=STF-0001
+// POST /quizzes/end-question?id=quiz-...&staff_id=STF-0001
+// POST /quizzes/finish?id=quiz-...&staff_id=STF-0001
+func QuizControlHandler(w http.ResponseWriter, r *http.Request) {
+	staffID, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+	q, err := Polls.GetQuiz(r.URL.Query().Get("id"))
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+	actor := polls.Actor{ID: staffID, IsStaff: true}
+	switch mux.Vars(r)["action"] {
+	case "next":
+		q, err = Polls.NextQuestion(q.Scope, q.ID, actor)
+	case "end-question":
+		q, err = Polls.EndQuestion(q.Scope, q.ID, actor)
+	case "finish":
+		q, err = Polls.FinishQuiz(q.Scope, q.ID, actor)
+	default:
+		http.Error(w, "Unknown quiz action", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+	writeJSON(w, q)
+}
+
+// QuizLeaderboardHandler returns the standings of a quiz up to its last
+// finished question.
+//
+// GET /quizzes/leaderboard?id=quiz-...&course=CS101&user_id=STU-0001
+func QuizLeaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	scope, _, ok := pollScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	q, err := Polls.GetQuiz(r.URL.Query().Get("id"))
+	if err == nil && q.Scope != scope {
+		err = polls.ErrNotFound
+	}
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+	board, err := Polls.Leaderboard(q.ID)
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+	writeJSON(w, board)
+}
+
+// QuizResultsHandler returns saved quiz results. Students get their own:
+// every quiz they took, or one quiz with their answers. Staff can ask for
+// a whole quiz by id or one student's history by student_id.
+//
+// GET /quizzes/results?user_id=STU-0001
+// GET /quizzes/results?user_id=STU-0001&id=quiz-...
+// GET /quizzes/results?user_id=STF-0001&id=quiz-...
+// GET /quizzes/results?user_id=STF-0001&student_id=STU-0001
+func QuizResultsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if storage.DB == nil || !isRegisteredID(storage.DB, userID) {
+		http.Error(w, "A registered user_id is required", http.StatusForbidden)
+		return
+	}
+	quizID := r.URL.Query().Get("id")
+	participantID := userID
+	if isStaffID(storage.DB, userID) {
+		participantID = r.URL.Query().Get("student_id")
+		if quizID == "" && participantID == "" {
+			http.Error(w, "Missing id or student_id param", http.StatusBadRequest)
+			return
+		}
+	}
+	results, err := Polls.Results(quizID, participantID)
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+	writeJSON(w, results)
+}
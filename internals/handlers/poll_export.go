@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"CampusMoon/internals/polls"
+	"CampusMoon/internals/storage"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// ===== Poll & Quiz Export =====
+
+// writeExport sends v as json (default), one of sheets as csv (picked by
+// the sheet param, the first by default) or every sheet as xlsx.
+func writeExport(w http.ResponseWriter, r *http.Request, name string, v interface{}, sheets []exportSheet) {
+	name = exportFileUnsafe.ReplaceAllString(name, "_")
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".json"))
+		writeJSON(w, v)
+	case "csv":
+		sheet := sheets[0]
+		if want := r.URL.Query().Get("sheet"); want != "" {
+			found := false
+			for _, s := range sheets {
+				if strings.EqualFold(exportFileUnsafe.ReplaceAllString(s.Name, "_"), exportFileUnsafe.ReplaceAllString(want, "_")) {
+					sheet, found = s, true
+				}
+			}
+			if !found {
+				names := make([]string, len(sheets))
+				for i, s := range sheets {
+					names[i] = s.Name
+				}
+				http.Error(w, "sheet must be one of: "+strings.Join(names, ", "), http.StatusBadRequest)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q",
+			name+"-"+exportFileUnsafe.ReplaceAllString(strings.ToLower(sheet.Name), "_")+".csv"))
+		writeSheetCSV(w, sheet)
+	case "xlsx":
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".xlsx"))
+		if err := writeXLSX(w, sheets); err != nil {
+			log.Println("poll export xlsx:", err)
+		}
+	default:
+		http.Error(w, "format must be json, csv or xlsx", http.StatusBadRequest)
+	}
+}
+
+// courseRoster maps the students of a course to their names.
+func courseRoster(course string) (map[string]string, error) {
+	rows, err := storage.DB.Query(
+		`SELECT user_id, COALESCE(display_name, '') FROM course_enrollments WHERE course_code = $1 AND role = 'student'`, course,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	roster := make(map[string]string)
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		if name == "" {
+			name = "Student " + shortID(id)
+		}
+		roster[id] = name
+	}
+	return roster, rows.Err()
+}
+
+func medianSeconds(t *polls.TimeStats) *float64 {
+	if t == nil {
+		return nil
+	}
+	return &t.Median
+}
+
+func itemCells(item *polls.ItemStats) []interface{} {
+	if item == nil {
+		return []interface{}{nil, nil, nil, nil}
+	}
+	return []interface{}{item.Responses, item.Correct, item.Difficulty, item.Discrimination}
+}
+
+// pollSheets lays out polls and their votes. Anonymous ballots have no
+// voter or timing.
+func pollSheets(reports []polls.PollReport) []exportSheet {
+	summary := exportSheet{Name: "Polls", Header: []string{"poll_id", "created_at", "closed_at", "kind", "question",
+		"status", "anonymous", "votes", "graded_responses", "correct", "difficulty", "discrimination", "median_seconds"}}
+	votes := exportSheet{Name: "Responses", Header: []string{"poll_id", "question", "voter_id", "answer", "correct",
+		"voted_at", "changed_at", "seconds"}}
+	for _, pr := range reports {
+		p := pr.Poll
+		row := []interface{}{p.ID, p.CreatedAt, p.ClosedAt, p.Kind, p.Question, p.Status, p.Anonymous, len(pr.Votes)}
+		row = append(row, itemCells(pr.Item)...)
+		summary.Rows = append(summary.Rows, append(row, medianSeconds(pr.ResponseTime)))
+		for _, v := range pr.Votes {
+			var correct interface{}
+			if ok, graded := p.Grade(v.Answer); graded {
+				correct = ok
+			}
+			row := []interface{}{p.ID, p.Question, v.VoterID, p.AnswerText(v.Answer), correct, nil, nil, nil}
+			if !p.Anonymous {
+				row[5], row[6], row[7] = v.VotedAt, v.ChangedAt, math.Round(v.VotedAt.Sub(p.CreatedAt).Seconds()*1000)/1000
+			}
+			votes.Rows = append(votes.Rows, row)
+		}
+	}
+	return []exportSheet{summary, votes}
+}
+
+// quizSheets lays out quizzes with their questions, answers and final
+// standings.
+func quizSheets(reports []polls.QuizReport) []exportSheet {
+	summary := exportSheet{Name: "Quizzes", Header: []string{"quiz_id", "title", "created_at", "finished_at", "status",
+		"questions", "participants", "answers", "median_seconds"}}
+	items := exportSheet{Name: "Quiz questions", Header: []string{"quiz_id", "question_number", "question", "kind",
+		"responses", "correct", "difficulty", "discrimination", "median_seconds"}}
+	answers := exportSheet{Name: "Quiz answers", Header: []string{"quiz_id", "question_number", "participant_id", "name",
+		"answer", "correct", "seconds", "points", "streak", "answered_at"}}
+	standings := exportSheet{Name: "Leaderboard", Header: []string{"quiz_id", "rank", "participant_id", "name", "score",
+		"correct", "answered", "best_streak"}}
+	for _, qr := range reports {
+		q := qr.Quiz
+		summary.Rows = append(summary.Rows, []interface{}{q.ID, q.Title, q.CreatedAt, q.FinishedAt, q.Status,
+			len(q.Questions), len(qr.Leaderboard), len(qr.Answers), medianSeconds(qr.ResponseTime)})
+		for i, item := range qr.Items {
+			row := append([]interface{}{q.ID, i + 1, item.Question, item.Kind}, itemCells(item)...)
+			items.Rows = append(items.Rows, append(row, medianSeconds(item.ResponseTime)))
+		}
+		for _, a := range qr.Answers {
+			var text string
+			if a.QuestionIndex >= 0 && a.QuestionIndex < len(q.Questions) {
+				p := polls.Poll{Kind: q.Questions[a.QuestionIndex].Kind, Options: q.Questions[a.QuestionIndex].Options}
+				text = p.AnswerText(a.Answer)
+			}
+			answers.Rows = append(answers.Rows, []interface{}{q.ID, a.QuestionIndex + 1, a.ParticipantID, a.Name, text,
+				a.Correct, float64(a.ElapsedMs) / 1000, a.Points, a.Streak, a.AnsweredAt})
+		}
+		for _, st := range qr.Leaderboard {
+			standings.Rows = append(standings.Rows, []interface{}{q.ID, st.Rank, st.ParticipantID, st.Name, st.Score,
+				st.Correct, st.Answered, st.BestStreak})
+		}
+	}
+	return []exportSheet{summary, items, answers, standings}
+}
+
+// timeSheet lays out response time distributions, one per row, with a
+// column per bucket.
+func timeSheet(rep polls.Report) exportSheet {
+	type named struct {
+		set, id, question string
+		t                 *polls.TimeStats
+	}
+	all := []named{{"all", rep.Scope, "", rep.ResponseTime}}
+	for _, pr := range rep.Polls {
+		all = append(all, named{"poll", pr.Poll.ID, pr.Poll.Question, pr.ResponseTime})
+	}
+	for _, qr := range rep.Quizzes {
+		all = append(all, named{"quiz", qr.Quiz.ID, qr.Quiz.Title, qr.ResponseTime})
+		for _, item := range qr.Items {
+			all = append(all, named{"quiz question", item.ID, item.Question, item.ResponseTime})
+		}
+	}
+	sheet := exportSheet{Name: "Response times", Header: []string{"set", "id", "question", "count", "mean", "median",
+		"p25", "p75", "p90", "min", "max"}}
+	if rep.ResponseTime != nil {
+		for _, b := range rep.ResponseTime.Buckets {
+			sheet.Header = append(sheet.Header, b.Label)
+		}
+	}
+	for _, n := range all {
+		if n.t == nil {
+			continue
+		}
+		row := []interface{}{n.set, n.id, n.question, n.t.Count, n.t.Mean, n.t.Median, n.t.P25, n.t.P75, n.t.P90, n.t.Min, n.t.Max}
+		for _, b := range n.t.Buckets {
+			row = append(row, b.Count)
+		}
+		sheet.Rows = append(sheet.Rows, row)
+	}
+	return sheet
+}
+
+func studentSheet(students []polls.StudentHistory) exportSheet {
+	sheet := exportSheet{Name: "Students", Header: []string{"participant_id", "name", "polls_offered", "polls_answered",
+		"participation", "graded_answered", "graded_correct", "quizzes_offered", "quizzes_taken", "quiz_points"}}
+	for _, h := range students {
+		sheet.Rows = append(sheet.Rows, []interface{}{h.ParticipantID, h.Name, h.PollsOffered, h.PollsAnswered,
+			h.Participation, h.GradedAnswered, h.GradedCorrect, h.QuizzesOffered, h.QuizzesTaken, h.QuizPoints})
+	}
+	return sheet
+}
+
+func historySheet(h polls.StudentHistory) exportSheet {
+	sheet := exportSheet{Name: "History", Header: []string{"kind", "id", "title", "at", "answered", "anonymous",
+		"answer", "correct", "seconds", "score", "rank", "questions_right"}}
+	for _, e := range h.Entries {
+		var correct, score, rank, right interface{}
+		if e.Correct != nil {
+			correct = *e.Correct
+		}
+		if e.Score != nil {
+			score, rank, right = *e.Score, *e.Rank, *e.QuestionsRight
+		}
+		sheet.Rows = append(sheet.Rows, []interface{}{e.Kind, e.ID, e.Title, e.At, e.Answered, e.Anonymous,
+			e.AnswerText, correct, e.ResponseSeconds, score, rank, right})
+	}
+	return sheet
+}
+
+// PollExportHandler exports one poll of a course or meeting room with
+// every vote, as json (default), csv or xlsx. Course staff only. Votes in
+// anonymous polls come without voters. The csv format takes
+// sheet=polls|responses.
+//
+// GET /polls/export?id=poll-...&course=CS101&user_id=STF-0001&format=xlsx
+func PollExportHandler(w http.ResponseWriter, r *http.Request) {
+	scope, actor, ok := pollScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if !actor.IsStaff {
+		writePollError(w, polls.ErrForbidden)
+		return
+	}
+	report, err := Polls.PollReport(r.URL.Query().Get("id"))
+	if err == nil && report.Poll.Scope != scope {
+		err = polls.ErrNotFound
+	}
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+	writeExport(w, r, report.Poll.ID, report, pollSheets([]polls.PollReport{report}))
+}
+
+// PollReportHandler exports every poll and quiz of a course or meeting
+// room with item analysis (difficulty and discrimination of each graded
+// question), response time distributions and each student's
+// participation, as json (default), csv or xlsx. Course staff only. The
+// csv format takes a sheet: polls, responses, quizzes, quiz_questions,
+// quiz_answers, leaderboard, response_times or students.
+//
+// GET /polls/report?course=CS101&user_id=STF-0001&format=xlsx
+// GET /polls/report?room=CS101-live&user_id=STF-0001&format=csv&sheet=students
+func PollReportHandler(w http.ResponseWriter, r *http.Request) {
+	scope, actor, ok := pollScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if !actor.IsStaff {
+		writePollError(w, polls.ErrForbidden)
+		return
+	}
+	var roster map[string]string
+	if course := r.URL.Query().Get("course"); course != "" {
+		var err error
+		if roster, err = courseRoster(course); err != nil {
+			log.Println("poll report roster:", err)
+			http.Error(w, "Database query failed", http.StatusInternalServerError)
+			return
+		}
+	}
+	report, err := Polls.Report(scope, roster)
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+	sheets := append(pollSheets(report.Polls), quizSheets(report.Quizzes)...)
+	sheets = append(sheets, timeSheet(report), studentSheet(report.Students))
+	writeExport(w, r, "poll-report-"+scope, report, sheets)
+}
+
+// PollHistoryHandler returns a student's participation in the polls and
+// quizzes of a course or meeting room: their own, or for staff the
+// student_id's. Answers to anonymous polls are never included. Formats
+// are as for /polls/report.
+//
+// GET /polls/history?course=CS101&user_id=STU-0001
+// GET /polls/history?course=CS101&user_id=STF-0001&student_id=STU-0001&format=csv
+func PollHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	scope, actor, ok := pollScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	studentID, name := actor.ID, actor.Name
+	if id := r.URL.Query().Get("student_id"); id != "" && id != actor.ID {
+		if !actor.IsStaff {
+			writePollError(w, polls.ErrForbidden)
+			return
+		}
+		studentID, name = id, "Student "+shortID(id)
+		if course := r.URL.Query().Get("course"); course != "" {
+			roster, err := courseRoster(course)
+			if err != nil {
+				log.Println("poll history roster:", err)
+				http.Error(w, "Database query failed", http.StatusInternalServerError)
+				return
+			}
+			if roster[id] == "" {
+				http.Error(w, "Not a student of this course", http.StatusNotFound)
+				return
+			}
+			name = roster[id]
+		}
+	}
+	report, err := Polls.Report(scope, map[string]string{studentID: name})
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+	history := report.Students[0]
+	writeExport(w, r, "poll-history-"+studentID, history, []exportSheet{historySheet(history)})
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"CampusMoon/internals/messaging"
+	"CampusMoon/internals/storage"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+)
+
+// ===== Discussion Voice Notes =====
+
+// Voice notes arrive as one binary frame on the discussion socket. They are
+// transcoded to mono Opus with ffmpeg, stored in MinIO, served back through
+// /chat/media to channel members, and transcribed in the background.
+const (
+	voiceNoteBitrate    = "32k"
+	voiceNotePeakCount  = 64
+	voiceNoteSampleRate = 8000 // PCM rate used to measure duration and peaks
+	voiceNoteTimeout    = 2 * time.Minute
+	voiceNoteMaxJobs    = 2 // concurrent transcriptions
+)
+
+var (
+	voiceNoteMaxBytes   = envInt64("VOICE_NOTE_MAX_BYTES", 10<<20)
+	voiceNoteMaxSeconds = envInt64("VOICE_NOTE_MAX_SECONDS", 300)
+
+	errVoiceNoteEmpty    = errors.New("voice note is empty")
+	errVoiceNoteTooLong  = fmt.Errorf("voice notes are limited to %d seconds", voiceNoteMaxSeconds)
+	errVoiceNoteNotAudio = errors.New("voice note is not a supported audio file")
+
+	voiceNoteJobs = make(chan struct{}, voiceNoteMaxJobs)
+)
+
+// voiceNote is a transcoded recording in a temporary directory.
+type voiceNote struct {
+	dir      string
+	path     string
+	size     int64
+	duration time.Duration
+	peaks    []float64
+}
+
+// processVoiceNote transcodes data to Opus and measures it. The caller owns
+// the returned note's directory.
+func processVoiceNote(data []byte) (*voiceNote, error) {
+	if len(data) == 0 {
+		return nil, errVoiceNoteEmpty
+	}
+	dir, err := os.MkdirTemp("", "voice-note-")
+	if err != nil {
+		return nil, err
+	}
+	note := &voiceNote{dir: dir, path: filepath.Join(dir, "note.ogg")}
+	input := filepath.Join(dir, "input")
+	if err := os.WriteFile(input, data, 0o600); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), voiceNoteTimeout)
+	defer cancel()
+
+	// ffmpeg decides whether this is audio; anything without an audio stream
+	// fails here. Encoding stops one second past the limit so an over-long
+	// recording is detected without transcoding all of it.
+	limit := strconv.FormatInt(voiceNoteMaxSeconds+1, 10)
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-v", "error", "-y", "-i", input,
+		"-map", "0:a:0", "-vn", "-ac", "1", "-c:a", "libopus", "-b:a", voiceNoteBitrate,
+		"-t", limit, "-f", "ogg", note.path)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		log.Printf("ffmpeg transcode: %v, Output: %s", err, string(output))
+		return nil, errVoiceNoteNotAudio
+	}
+
+	pcm, err := exec.CommandContext(ctx, "ffmpeg", "-v", "error", "-i", note.path,
+		"-ac", "1", "-ar", strconv.Itoa(voiceNoteSampleRate), "-f", "s16le", "-").Output()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("decode voice note: %w", err)
+	}
+	samples := len(pcm) / 2
+	note.duration = time.Duration(samples) * time.Second / voiceNoteSampleRate
+	if samples == 0 {
+		os.RemoveAll(dir)
+		return nil, errVoiceNoteEmpty
+	}
+	if note.duration > time.Duration(voiceNoteMaxSeconds)*time.Second {
+		os.RemoveAll(dir)
+		return nil, errVoiceNoteTooLong
+	}
+	note.peaks = waveformPeaks(pcm, voiceNotePeakCount)
+
+	info, err := os.Stat(note.path)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	note.size = info.Size()
+	return note, nil
+}
+
+// waveformPeaks splits signed 16-bit PCM into n buckets and returns each
+// bucket's peak amplitude scaled to 0-1.
+func waveformPeaks(pcm []byte, n int) []float64 {
+	samples := len(pcm) / 2
+	if samples < n {
+		n = samples
+	}
+	peaks := make([]float64, n)
+	for i := 0; i < samples; i++ {
+		v := math.Abs(float64(int16(binary.LittleEndian.Uint16(pcm[2*i:]))) / 32768)
+		b := i * n / samples
+		if v > peaks[b] {
+			peaks[b] = v
+		}
+	}
+	for i, p := range peaks {
+		peaks[i] = math.Round(p*100) / 100
+	}
+	return peaks
+}
+
+// uploadVoiceNote stores the note under the channel's prefix and returns
+// its object name.
+func uploadVoiceNote(channel *DiscussionChannel, note *voiceNote) (string, error) {
+	bucket, err := chatMediaBucket()
+	if err != nil {
+		return "", err
+	}
+	object := fmt.Sprintf("%s/voice-%s.ogg", discussionMediaPrefix(channel), uuid.New().String())
+	_, err = storage.MinioClient.FPutObject(context.Background(), bucket, object, note.path, minio.PutObjectOptions{
+		ContentType: "audio/ogg; codecs=opus",
+	})
+	return object, err
+}
+
+// postVoiceNote processes, stores and posts a recording, then starts its
+// transcription.
+func postVoiceNote(channel *DiscussionChannel, identity *discussionIdentity, data []byte, replyTo *int64) error {
+	note, err := processVoiceNote(data)
+	if err != nil {
+		return err
+	}
+	object, err := uploadVoiceNote(channel, note)
+	if err != nil {
+		os.RemoveAll(note.dir)
+		log.Println("upload voice note:", err)
+		return errors.New("voice note could not be stored")
+	}
+
+	m, err := Messages.Post(messaging.Message{
+		Channel:    channel.hubChannel(),
+		SenderID:   identity.UserID,
+		SenderName: identity.DisplayName,
+		Kind:       messaging.KindAudio,
+		ReplyToID:  replyTo,
+		Attachments: []messaging.Attachment{{
+			URL:              "/chat/media?object=" + url.QueryEscape(object),
+			Object:           object,
+			Name:             "voice-note.ogg",
+			ContentType:      "audio/ogg; codecs=opus",
+			Size:             note.size,
+			DurationMs:       note.duration.Milliseconds(),
+			Peaks:            note.peaks,
+			TranscriptStatus: messaging.TranscriptPending,
+		}},
+	})
+	if err != nil {
+		os.RemoveAll(note.dir)
+		removeChatObjects(object)
+		return err
+	}
+	go transcribeVoiceNote(m, note)
+	return nil
+}
+
+// transcribeVoiceNote runs the note through Whisper and attaches the
+// transcript to the message, which notifies the channel.
+func transcribeVoiceNote(m messaging.Message, note *voiceNote) {
+	defer os.RemoveAll(note.dir)
+	voiceNoteJobs <- struct{}{}
+	defer func() { <-voiceNoteJobs }()
+
+	text, err := transcribeFile(note.path)
+
+	current, loadErr := Messages.Store.Get(m.ID)
+	if loadErr != nil {
+		log.Println("reload voice note:", loadErr)
+		return
+	}
+	attachments := current.Attachments
+	for i := range attachments {
+		if attachments[i].Object != m.Attachments[0].Object {
+			continue
+		}
+		if err != nil {
+			attachments[i].TranscriptStatus = messaging.TranscriptFailed
+		} else {
+			attachments[i].Transcript = text
+			attachments[i].TranscriptStatus = messaging.TranscriptDone
+		}
+	}
+	if _, err := Messages.SetAttachments(m.Channel, m.ID, attachments); err != nil && err != messaging.ErrDeleted {
+		log.Println("save voice note transcript:", err)
+	}
+}
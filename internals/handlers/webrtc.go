@@ -1,15 +1,17 @@
 package handlers
 
 import (
+	"CampusMoon/internals/messaging"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"time"
-	"encoding/json" 
+	"sync"
 
-	"github.com/gorilla/websocket"
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 )
 
 // ===== Client Struct =====
@@ -19,9 +21,65 @@ type Client struct {
 	UserID   string
 	IsStaff  bool
 	UserName string
+	RoomID   string
+	Muted    bool
+	Verified bool // UserID is a registered student or staff ID
+
+	attendanceID   int64
+	attendanceDone bool // the client left; no record may be opened
+	attendanceMu   sync.Mutex
+	lastSeenChatID int64 // set on reconnect; cleared once caught up
+	catchUp        catchUpGate
+
+	writeMu sync.Mutex
+	roomMu  sync.Mutex
+	room    *MeetingRoom
+	closed  bool // the socket is gone; no room may take the client in
+}
+
+// Send writes a JSON message to the client. gorilla/websocket allows only one
+// concurrent writer per connection, so every write goes through here.
+func (c *Client) Send(v interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.Conn.WriteJSON(v)
+}
+
+// meetingRoom returns the room the client currently belongs to, which
+// changes when it is moved into or out of a breakout room.
+func (c *Client) meetingRoom() *MeetingRoom {
+	c.roomMu.Lock()
+	defer c.roomMu.Unlock()
+	return c.room
+}
+
+// setMeetingRoom moves the client into room. It returns false once the
+// client has disconnected.
+func (c *Client) setMeetingRoom(room *MeetingRoom) bool {
+	c.roomMu.Lock()
+	defer c.roomMu.Unlock()
+	if c.closed {
+		return false
+	}
+	c.room = room
+	if room != nil {
+		c.RoomID = room.ID
+	}
+	return true
+}
+
+// closeMeeting marks the client disconnected and returns the room it was
+// in, so a breakout move racing the disconnect cannot place it elsewhere.
+func (c *Client) closeMeeting() *MeetingRoom {
+	c.roomMu.Lock()
+	defer c.roomMu.Unlock()
+	c.closed = true
+	return c.room
 }
 
 var Clients = make(map[string]*Client)
+var clientsMu sync.RWMutex
+
 var Upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
@@ -36,29 +94,28 @@ func Handlewebrtc(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 
 	// Generate a unique ID
 	id := uuid.New().String()
-	client := &Client{ID: id, Conn: conn}
+	client := &Client{ID: id, Conn: conn, RoomID: r.URL.Query().Get("room")}
+	if client.RoomID == "" {
+		client.RoomID = "default"
+	}
+
+	clientsMu.Lock()
 	Clients[id] = client
+	clientsMu.Unlock()
 
 	defer func() {
+		clientsMu.Lock()
 		delete(Clients, id)
-		conn.Close()
-	}()
-
-	// Send existing peers
-	existingPeers := []string{}
-	for peerID := range Clients {
-		if peerID != id {
-			existingPeers = append(existingPeers, peerID)
+		clientsMu.Unlock()
+		if room := client.closeMeeting(); room != nil {
+			room.leave(client)
 		}
-	}
-	conn.WriteJSON(map[string]interface{}{"type": "existing-peers", "peers": existingPeers})
-
-	// Notify others about new peer
-	for peerID, c := range Clients {
-		if peerID != id {
-			c.Conn.WriteJSON(map[string]interface{}{"type": "new-peer", "id": id})
+		endAttendance(client)
+		if client.Verified {
+			Messages.Hub.Unsubscribe(messaging.UserChannel(client.UserID), client)
 		}
-	}
+		conn.Close()
+	}()
 
 	// Listen for messages
 	for {
@@ -74,51 +131,125 @@ func Handlewebrtc(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 			continue
 		}
 
-		switch msgType {
-		case "user-info":
+		// The peer joins its room once it has identified itself; until then
+		// (and while parked in the waiting room) only user-info is accepted.
+		room := client.meetingRoom()
+		if msgType == "user-info" {
+			if room != nil {
+				continue
+			}
 			if userID, ok := msg["userId"].(string); ok {
 				client.UserID = userID
 			}
-			if isStaff, ok := msg["isStaff"].(bool); ok {
-				client.IsStaff = isStaff
+			if roomID, ok := msg["room"].(string); ok && roomID != "" {
+				client.RoomID = roomID
+			}
+			// A reconnecting page reports the last chat message it saw.
+			if lastSeen, ok := msg["lastSeenId"].(float64); ok && lastSeen > 0 {
+				client.lastSeenChatID = int64(lastSeen)
+			}
+			// isStaff is only a claim; the role comes from user_ids.
+			if isStaff, ok := msg["isStaff"].(bool); ok && isStaff {
+				client.IsStaff = isStaffID(db, client.UserID)
+			}
+			client.Verified = client.IsStaff || isRegisteredID(db, client.UserID)
+			client.UserName = fmt.Sprintf("User %s", shortID(client.UserID))
+			// Verified users also get their mentions and unread counts.
+			if client.Verified {
+				Messages.Hub.Subscribe(messaging.UserChannel(client.UserID), client)
 			}
-			client.UserName = fmt.Sprintf("User %s", client.UserID[:6])
 
+			room = getMeetingRoom(client.RoomID)
+			client.setMeetingRoom(room)
+			if !room.join(client) {
+				break
+			}
+			sendMeetingPolls(room, client)
+			continue
+		}
+
+		if room == nil || !room.isParticipant(client.ID) {
+			continue
+		}
+
+		switch msgType {
 		case "offer", "answer", "ice-candidate":
 			toID, ok := msg["to"].(string)
 			if ok {
-				if c, found := Clients[toID]; found {
+				if c := room.participant(toID); c != nil {
 					msg["from"] = id
-					c.Conn.WriteJSON(msg)
+					c.Send(msg)
 				}
 			}
 
 		case "chat-message":
-			HandleChatMessage(db, client, msg)
+			if room.chatDisabledFor(client) {
+				client.Send(moderationError("Chat is disabled by the host"))
+				continue
+			}
+			HandleChatMessage(client, room, msg)
+
+		case "chat-read":
+			if lastRead, ok := msg["messageId"].(float64); ok && lastRead > 0 && client.Verified {
+				if _, err := Messages.MarkRead(messaging.MeetingChannel(room.ID), client.UserID, int64(lastRead)); err != nil && !errors.Is(err, messaging.ErrNotFound) {
+					log.Println("Error marking chat read:", err)
+				}
+			}
 
 		default:
-			log.Printf("Unknown message type: %s", msgType)
+			if !dispatchRoomMessage(room, client, msgType, msg) {
+				log.Printf("Unknown message type: %s", msgType)
+			}
 		}
 	}
+}
 
-	// Notify others about disconnect
-	for _, c := range Clients {
-		c.Conn.WriteJSON(map[string]interface{}{"type": "peer-disconnected", "id": id})
+// isStaffID reports whether id is a registered staff ID in user_ids.
+func isStaffID(db *sql.DB, id string) bool {
+	if db == nil || id == "" {
+		return false
+	}
+	var exists bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM user_ids WHERE staff_id = $1)`, id).Scan(&exists)
+	if err != nil {
+		log.Println("Error checking staff id:", err)
+		return false
 	}
+	return exists
+}
+
+// isRegisteredID reports whether id is a student or staff ID in user_ids.
+func isRegisteredID(db *sql.DB, id string) bool {
+	if db == nil || id == "" {
+		return false
+	}
+	var exists bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM user_ids WHERE student_id = $1 OR staff_id = $1)`, id).Scan(&exists)
+	if err != nil {
+		log.Println("Error checking user id:", err)
+		return false
+	}
+	return exists
+}
+
+// shortID returns the first six characters of id, used for display names.
+func shortID(id string) string {
+	if len(id) > 6 {
+		return id[:6]
+	}
+	return id
 }
 
 // ===== Chat Message Handling =====
-func HandleChatMessage(db *sql.DB, sender *Client, msg map[string]interface{}) {
+
+// HandleChatMessage posts a chat message of sender to room, the room the
+// sender's read loop found it in; sender.RoomID changes with breakout moves.
+func HandleChatMessage(sender *Client, room *MeetingRoom, msg map[string]interface{}) {
 	message, ok := msg["message"].(string)
 	if !ok || message == "" {
 		return
 	}
 
-	timestamp, ok := msg["timestamp"].(string)
-	if !ok {
-		timestamp = time.Now().Format(time.RFC3339)
-	}
-
 	senderID := sender.UserID
 	if senderID == "" {
 		senderID = sender.ID
@@ -126,32 +257,30 @@ func HandleChatMessage(db *sql.DB, sender *Client, msg map[string]interface{}) {
 
 	senderName := sender.UserName
 	if senderName == "" {
-		senderName = fmt.Sprintf("User %s", senderID[:6])
+		senderName = fmt.Sprintf("User %s", shortID(senderID))
 	}
 
-	_, err := db.Exec(`INSERT INTO chat_messages (sender_id, sender_name, message, timestamp) 
-		VALUES ($1, $2, $3, $4)`, senderID, senderName, message, timestamp)
-	if err != nil {
+	_, err := Messages.Post(messaging.Message{
+		Channel:    messaging.MeetingChannel(room.ID),
+		SenderID:   senderID,
+		SenderName: senderName,
+		Kind:       messaging.KindText,
+		Body:       message,
+	})
+	if errors.Is(err, messaging.ErrMuted) || errors.Is(err, messaging.ErrSlowMode) || errors.Is(err, messaging.ErrLinksBlocked) {
+		sender.Send(moderationError(err.Error()))
+	} else if err != nil {
 		log.Println("Error saving chat message:", err)
-	}
-
-	chatMsg := map[string]interface{}{
-		"type":      "chat-message",
-		"sender":    senderName,
-		"message":   message,
-		"timestamp": timestamp,
-		"user_id":   senderID,
-	}
-
-	for _, client := range Clients {
-		if err := client.Conn.WriteJSON(chatMsg); err != nil {
-			log.Println("Error sending chat message:", err)
-		}
+		sender.Send(moderationError("Message could not be sent"))
 	}
 }
 
 // ===== Chat History =====
-func ChatHistory(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+
+// ChatHistory returns a page of a room's chat, oldest first:
+// /chat/history?room=CS101&before=<id>|after=<id>|date=2025-01-31&limit=50.
+// Cursors for the neighbouring pages are in the X-Page-* headers.
+func ChatHistory(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	if r.Method == http.MethodOptions {
 		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
@@ -165,47 +294,28 @@ func ChatHistory(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	rows, err := db.Query(`SELECT sender_id, sender_name, message, timestamp 
-		FROM chat_messages 
-		WHERE room_id = 'default' 
-		ORDER BY timestamp DESC 
-		LIMIT 50`)
-	if err != nil {
-		http.Error(w, "Failed to query chat messages: "+err.Error(), http.StatusInternalServerError)
-		return
+	roomID := r.URL.Query().Get("room")
+	if roomID == "" {
+		roomID = "default"
 	}
-	defer rows.Close()
 
-	type Message struct {
-		SenderID   string `json:"user_id"`
-		SenderName string `json:"sender"`
-		Message    string `json:"message"`
-		Timestamp  string `json:"timestamp"`
+	q, err := pageQueryFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-
-	var messages []Message
-	for rows.Next() {
-		var senderID, senderName, message, timestamp string
-		err := rows.Scan(&senderID, &senderName, &message, &timestamp)
-		if err != nil {
-			log.Println("Row scan error:", err)
-			continue
-		}
-		messages = append(messages, Message{
-			SenderID:   senderID,
-			SenderName: senderName,
-			Message:    message,
-			Timestamp:  timestamp,
-		})
+	page, err := Messages.Page(messaging.MeetingChannel(roomID), q)
+	if err != nil {
+		http.Error(w, "Failed to query chat messages: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	// Reverse order
-	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
-		messages[i], messages[j] = messages[j], messages[i]
+	history := make([]map[string]interface{}, 0, len(page.Messages))
+	for _, m := range page.Messages {
+		history = append(history, meetingChatEvent(m))
 	}
 
+	setPageHeaders(w, page)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(messages)
+	json.NewEncoder(w).Encode(history)
 }
-
-
@@ -0,0 +1,68 @@
+package messaging
+
+import "time"
+
+// Participation sums up what one sender contributed to a channel.
+// ReactionsReceived leaves out reactions to one's own messages.
+type Participation struct {
+	UserID            string     `json:"user_id"`
+	Name              string     `json:"name"`
+	Messages          int        `json:"messages"`
+	VoiceNotes        int        `json:"voice_notes"`
+	Files             int        `json:"files"`
+	Replies           int        `json:"replies"`
+	ReactionsReceived int        `json:"reactions_received"`
+	FirstAt           *time.Time `json:"first_at,omitempty"`
+	LastAt            *time.Time `json:"last_at,omitempty"`
+}
+
+// Range returns the messages of channel created in [from, to), replies
+// included, oldest first with reactions loaded. Deleted messages are left
+// out.
+func (s *Store) Range(channel string, from, to time.Time) ([]Message, error) {
+	return s.query(
+		`m.channel = $1 AND m.created_at >= $2 AND m.created_at < $3 AND m.deleted_at IS NULL`,
+		[]interface{}{channel, from, to}, 0,
+	)
+}
+
+// Participation counts each sender's messages in channel created in
+// [from, to), most active first. Deleted and system messages do not count.
+func (s *Store) Participation(channel string, from, to time.Time) ([]Participation, error) {
+	stats := []Participation{}
+	if s.db == nil {
+		return stats, nil
+	}
+	rows, err := s.db.Query(
+		`SELECT m.sender_id, MAX(m.sender_name), COUNT(*),
+			COUNT(*) FILTER (WHERE m.kind = 'audio'),
+			COUNT(*) FILTER (WHERE m.kind = 'file'),
+			COUNT(*) FILTER (WHERE m.reply_to_id IS NOT NULL),
+			COALESCE(SUM(rc.n), 0)::int,
+			MIN(m.created_at), MAX(m.created_at)
+		 FROM messages m
+		 LEFT JOIN LATERAL (
+			SELECT COUNT(*) AS n FROM message_reactions r WHERE r.message_id = m.id AND r.user_id <> m.sender_id
+		 ) rc ON TRUE
+		 WHERE m.channel = $1 AND m.created_at >= $2 AND m.created_at < $3
+			AND m.deleted_at IS NULL AND m.kind <> 'system'
+		 GROUP BY m.sender_id
+		 ORDER BY COUNT(*) DESC, m.sender_id`,
+		channel, from, to,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p Participation
+		var first, last time.Time
+		if err := rows.Scan(&p.UserID, &p.Name, &p.Messages, &p.VoiceNotes, &p.Files, &p.Replies,
+			&p.ReactionsReceived, &first, &last); err != nil {
+			return nil, err
+		}
+		p.FirstAt, p.LastAt = &first, &last
+		stats = append(stats, p)
+	}
+	return stats, rows.Err()
+}
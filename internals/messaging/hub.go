@@ -0,0 +1,100 @@
+package messaging
+
+import (
+	"log"
+	"sync"
+)
+
+// Event types delivered to subscribers.
+const (
+	EventMessage  = "message"
+	EventEdited   = "message-edited"
+	EventDeleted  = "message-deleted"
+	EventReaction = "reaction-updated"
+	EventPinned   = "pin-updated"
+	EventAttached = "attachments-updated"
+	EventRead     = "message-read"   // ActorID read the channel up to Message.ID
+	EventMention  = "mention"        // sent to the mentioned user's channel
+	EventUnread   = "unread-updated" // sent to a user's channel with Unread set
+	EventQueue    = "queue-updated"  // office-hours queue change, with Data set
+)
+
+// Event is a change to a channel: a new message or an update to one. Events
+// that are not about a message carry their payload in Data.
+type Event struct {
+	Type    string       `json:"event"`
+	Message Message      `json:"message"`
+	ActorID string       `json:"actor_id,omitempty"`
+	Unread  *UnreadCount `json:"unread,omitempty"`
+	Data    interface{}  `json:"data,omitempty"`
+}
+
+// Subscriber receives the events of the channels it joined. Each transport
+// adapter implements Deliver to put an event in its own wire format.
+type Subscriber interface {
+	SubscriberID() string
+	Deliver(e Event) error
+}
+
+// Hub tracks which subscribers are connected to which channel.
+type Hub struct {
+	mu       sync.RWMutex
+	channels map[string]map[string]Subscriber
+}
+
+// NewHub returns an empty Hub.
+func NewHub() *Hub {
+	return &Hub{channels: make(map[string]map[string]Subscriber)}
+}
+
+// Subscribe adds s to channel.
+func (h *Hub) Subscribe(channel string, s Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	subs, ok := h.channels[channel]
+	if !ok {
+		subs = make(map[string]Subscriber)
+		h.channels[channel] = subs
+	}
+	subs[s.SubscriberID()] = s
+}
+
+// HasSubscribers reports whether anyone is subscribed to channel.
+func (h *Hub) HasSubscribers(channel string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.channels[channel]) > 0
+}
+
+// Unsubscribe removes s from channel.
+func (h *Hub) Unsubscribe(channel string, s Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	subs := h.channels[channel]
+	delete(subs, s.SubscriberID())
+	if len(subs) == 0 {
+		delete(h.channels, channel)
+	}
+}
+
+// Publish delivers e to every subscriber of its message's channel.
+func (h *Hub) Publish(e Event) {
+	h.PublishTo(e.Message.Channel, e)
+}
+
+// PublishTo delivers e to every subscriber of channel, such as a user's
+// personal channel.
+func (h *Hub) PublishTo(channel string, e Event) {
+	h.mu.RLock()
+	subs := make([]Subscriber, 0, len(h.channels[channel]))
+	for _, s := range h.channels[channel] {
+		subs = append(subs, s)
+	}
+	h.mu.RUnlock()
+
+	for _, s := range subs {
+		if err := s.Deliver(e); err != nil {
+			log.Println("deliver message:", err)
+		}
+	}
+}
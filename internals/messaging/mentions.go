@@ -0,0 +1,267 @@
+package messaging
+
+import (
+	"database/sql"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// mentionPattern finds @handles not preceded by a word character, so email
+// addresses are not read as mentions.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@])@([\p{L}\p{N}_.\-]+)`)
+
+// MentionStaff is the handle that mentions every staff member of a channel.
+const MentionStaff = "staff"
+
+// Directory answers membership questions that depend on where a channel
+// lives (a course's discussion or a meeting room).
+type Directory interface {
+	// ResolveMention returns the user IDs a handle refers to in channel.
+	ResolveMention(channel, handle string) []string
+	// Members returns the user IDs who can read channel.
+	Members(channel string) []string
+}
+
+// UnreadCount is a user's unread state in one channel.
+type UnreadCount struct {
+	Channel    string `json:"channel"`
+	LastReadID int64  `json:"last_read_id"`
+	Unread     int    `json:"unread"`
+	Mentions   int    `json:"mentions"`
+}
+
+// ReadReceipt is how far one member has read a channel.
+type ReadReceipt struct {
+	UserID     string    `json:"user_id"`
+	LastReadID int64     `json:"last_read_id"`
+	ReadAt     time.Time `json:"read_at"`
+}
+
+// parseMentions returns the distinct handles in body, lower-cased.
+func parseMentions(body string) []string {
+	seen := map[string]bool{}
+	handles := []string{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		h := strings.ToLower(strings.TrimRight(match[1], ".-"))
+		if h != "" && !seen[h] {
+			seen[h] = true
+			handles = append(handles, h)
+		}
+	}
+	return handles
+}
+
+// resolveMentions fills m.Mentions from the handles in its body. Senders
+// are never mentioned by their own message.
+func (s *Service) resolveMentions(m *Message) {
+	m.Mentions = []string{}
+	if s.Directory == nil {
+		return
+	}
+	seen := map[string]bool{m.SenderID: true}
+	for _, handle := range parseMentions(m.Body) {
+		for _, id := range s.Directory.ResolveMention(m.Channel, handle) {
+			if !seen[id] {
+				seen[id] = true
+				m.Mentions = append(m.Mentions, id)
+			}
+		}
+	}
+}
+
+// notifyNew tells mentioned users about m and pushes fresh unread counts to
+// every connected member of its channel.
+func (s *Service) notifyNew(m Message) {
+	for _, id := range m.Mentions {
+		s.Hub.PublishTo(UserChannel(id), Event{Type: EventMention, Message: m, ActorID: m.SenderID})
+		if s.OnMention != nil {
+			s.OnMention(m, id)
+		}
+	}
+	if s.Directory == nil {
+		return
+	}
+	// Only members connected somewhere are sent their count, all counted
+	// in one query.
+	seen := map[string]bool{m.SenderID: true}
+	connected := []string{}
+	for _, id := range s.Directory.Members(m.Channel) {
+		if !seen[id] {
+			seen[id] = true
+			if s.Hub.HasSubscribers(UserChannel(id)) {
+				connected = append(connected, id)
+			}
+		}
+	}
+	if len(connected) == 0 {
+		return
+	}
+	counts, err := s.Store.MembersUnread(m.Channel, connected)
+	if err != nil {
+		log.Println("unread count:", err)
+		return
+	}
+	for i := range counts {
+		s.Hub.PublishTo(UserChannel(connected[i]), Event{
+			Type:    EventUnread,
+			Message: Message{Channel: m.Channel},
+			Unread:  &counts[i],
+		})
+	}
+}
+
+// MarkRead moves userID's read cursor in channel forward to messageID and
+// publishes a read receipt. Cursors never move backwards.
+func (s *Service) MarkRead(channel, userID string, messageID int64) (UnreadCount, error) {
+	if _, err := s.load(channel, messageID); err != nil {
+		return UnreadCount{}, err
+	}
+	if err := s.Store.MarkRead(channel, userID, messageID); err != nil {
+		return UnreadCount{}, err
+	}
+	counts, err := s.Store.Unread(userID, []string{channel})
+	if err != nil {
+		return UnreadCount{}, err
+	}
+	s.Hub.Publish(Event{Type: EventRead, Message: Message{ID: counts[0].LastReadID, Channel: channel}, ActorID: userID})
+	s.Hub.PublishTo(UserChannel(userID), Event{Type: EventUnread, Message: Message{Channel: channel}, Unread: &counts[0]})
+	return counts[0], nil
+}
+
+// Unread returns userID's unread counts for each of channels.
+func (s *Service) Unread(userID string, channels []string) ([]UnreadCount, error) {
+	return s.Store.Unread(userID, channels)
+}
+
+// ===== Store =====
+
+// MarkRead stores a read cursor, keeping the larger of the old and new IDs.
+func (s *Store) MarkRead(channel, userID string, messageID int64) error {
+	_, err := s.db.Exec(
+		`INSERT INTO channel_read_cursors (channel, user_id, last_read_id, updated_at)
+		 VALUES ($1, $2, $3, NOW())
+		 ON CONFLICT (channel, user_id) DO UPDATE
+		 SET last_read_id = GREATEST(channel_read_cursors.last_read_id, EXCLUDED.last_read_id), updated_at = NOW()`,
+		channel, userID, messageID,
+	)
+	return err
+}
+
+// Unread counts the messages after userID's cursor in each channel, leaving
+// out their own and deleted ones, and how many of those mention them.
+func (s *Store) Unread(userID string, channels []string) ([]UnreadCount, error) {
+	counts := make([]UnreadCount, 0, len(channels))
+	for _, channel := range channels {
+		c := UnreadCount{Channel: channel}
+		if s.db != nil {
+			err := s.db.QueryRow(
+				`WITH cursor AS (
+					SELECT COALESCE((SELECT last_read_id FROM channel_read_cursors WHERE channel = $1 AND user_id = $2), 0) AS id
+				)
+				SELECT (SELECT id FROM cursor),
+					COUNT(*) FILTER (WHERE m.sender_id <> $2),
+					COUNT(*) FILTER (WHERE EXISTS (
+						SELECT 1 FROM message_mentions mm WHERE mm.message_id = m.id AND mm.user_id = $2))
+				FROM messages m
+				WHERE m.channel = $1 AND m.deleted_at IS NULL AND m.id > (SELECT id FROM cursor)`,
+				channel, userID,
+			).Scan(&c.LastReadID, &c.Unread, &c.Mentions)
+			if err != nil {
+				return nil, err
+			}
+		}
+		counts = append(counts, c)
+	}
+	return counts, nil
+}
+
+// MembersUnread is Unread for one channel and several users, in the order
+// of userIDs.
+func (s *Store) MembersUnread(channel string, userIDs []string) ([]UnreadCount, error) {
+	counts := make([]UnreadCount, len(userIDs))
+	index := make(map[string]int, len(userIDs))
+	for i, id := range userIDs {
+		counts[i] = UnreadCount{Channel: channel}
+		index[id] = i
+	}
+	if s.db == nil {
+		return counts, nil
+	}
+	rows, err := s.db.Query(
+		`SELECT u.id, COALESCE(c.last_read_id, 0),
+			(SELECT COUNT(*) FROM messages m
+			 WHERE m.channel = $1 AND m.deleted_at IS NULL AND m.id > COALESCE(c.last_read_id, 0) AND m.sender_id <> u.id),
+			(SELECT COUNT(*) FROM messages m JOIN message_mentions mm ON mm.message_id = m.id AND mm.user_id = u.id
+			 WHERE m.channel = $1 AND m.deleted_at IS NULL AND m.id > COALESCE(c.last_read_id, 0))
+		 FROM unnest($2::text[]) AS u(id)
+		 LEFT JOIN channel_read_cursors c ON c.channel = $1 AND c.user_id = u.id`,
+		channel, pq.Array(userIDs),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var c UnreadCount
+		if err := rows.Scan(&id, &c.LastReadID, &c.Unread, &c.Mentions); err != nil {
+			return nil, err
+		}
+		if i, ok := index[id]; ok {
+			c.Channel = channel
+			counts[i] = c
+		}
+	}
+	return counts, rows.Err()
+}
+
+// CursorChannels lists the channels where userID has a read cursor or has
+// been mentioned.
+func (s *Store) CursorChannels(userID string) ([]string, error) {
+	rows, err := s.db.Query(
+		`SELECT channel FROM channel_read_cursors WHERE user_id = $1
+		 UNION
+		 SELECT m.channel FROM message_mentions mm JOIN messages m ON m.id = mm.message_id WHERE mm.user_id = $1`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	channels := []string{}
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			return nil, err
+		}
+		channels = append(channels, c)
+	}
+	return channels, rows.Err()
+}
+
+// ReadReceipts lists the members who have read channel up to messageID.
+func (s *Store) ReadReceipts(channel string, messageID int64) ([]ReadReceipt, error) {
+	rows, err := s.db.Query(
+		`SELECT user_id, last_read_id, updated_at FROM channel_read_cursors
+		 WHERE channel = $1 AND last_read_id >= $2 ORDER BY updated_at`, channel, messageID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	receipts := []ReadReceipt{}
+	for rows.Next() {
+		var r ReadReceipt
+		var readAt sql.NullTime
+		if err := rows.Scan(&r.UserID, &r.LastReadID, &readAt); err != nil {
+			return nil, err
+		}
+		r.ReadAt = readAt.Time
+		receipts = append(receipts, r)
+	}
+	return receipts, rows.Err()
+}
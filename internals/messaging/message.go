@@ -0,0 +1,158 @@
+package messaging
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Message kinds.
+const (
+	KindText   = "text"
+	KindAudio  = "audio"
+	KindFile   = "file"
+	KindSystem = "system"
+)
+
+// MaxBodyLength caps the size of a message body in bytes.
+const MaxBodyLength = 4000
+
+var (
+	ErrEmptyMessage   = errors.New("message is empty")
+	ErrMessageTooLong = errors.New("message is too long")
+	ErrNoChannel      = errors.New("message has no channel")
+	ErrNotFound       = errors.New("message not found")
+	ErrForbidden      = errors.New("not allowed")
+	ErrDeleted        = errors.New("message was deleted")
+	ErrBadReply       = errors.New("reply target is not in this channel")
+	ErrBadReaction    = errors.New("unsupported reaction")
+)
+
+// Message is the single message model shared by meeting chat and
+// discussion channels.
+type Message struct {
+	ID          int64               `json:"id"`
+	Channel     string              `json:"channel"`
+	SenderID    string              `json:"sender_id"`
+	SenderName  string              `json:"sender_name"`
+	Kind        string              `json:"kind"`
+	Body        string              `json:"body"`
+	Attachments []Attachment        `json:"attachments"`
+	ReplyToID   *int64              `json:"reply_to_id,omitempty"`
+	ReplyCount  int                 `json:"reply_count"`
+	Reactions   map[string][]string `json:"reactions"` // emoji -> user IDs
+	Edited      bool                `json:"edited"`
+	Deleted     bool                `json:"deleted"`
+	DeletedBy   string              `json:"deleted_by,omitempty"`
+	Mentions    []string            `json:"mentions"` // mentioned user IDs
+	Pinned      bool                `json:"pinned"`
+	PinnedBy    string              `json:"pinned_by,omitempty"`
+	CreatedAt   time.Time           `json:"created_at"`
+	UpdatedAt   *time.Time          `json:"updated_at,omitempty"`
+}
+
+// Attachment is a file stored in object storage and linked to a message.
+// Voice notes also carry their duration, waveform peaks (0-1) and, once
+// transcription finishes, a transcript. Images carry their size and a
+// thumbnail; code files a highlight.js language, line count and preview.
+type Attachment struct {
+	URL              string    `json:"url"`
+	Object           string    `json:"object,omitempty"`
+	Name             string    `json:"name,omitempty"`
+	ContentType      string    `json:"content_type,omitempty"`
+	Size             int64     `json:"size,omitempty"`
+	DurationMs       int64     `json:"duration_ms,omitempty"`
+	Peaks            []float64 `json:"peaks,omitempty"`
+	Transcript       string    `json:"transcript,omitempty"`
+	TranscriptStatus string    `json:"transcript_status,omitempty"` // pending, done or failed
+	Width            int       `json:"width,omitempty"`
+	Height           int       `json:"height,omitempty"`
+	ThumbnailURL     string    `json:"thumbnail_url,omitempty"`
+	Language         string    `json:"language,omitempty"`
+	Lines            int       `json:"lines,omitempty"`
+	Preview          string    `json:"preview,omitempty"`
+}
+
+// Transcript states.
+const (
+	TranscriptPending = "pending"
+	TranscriptDone    = "done"
+	TranscriptFailed  = "failed"
+)
+
+// Edit is a previous version of an edited message.
+type Edit struct {
+	MessageID int64     `json:"message_id"`
+	Body      string    `json:"body"`
+	EditedBy  string    `json:"edited_by"`
+	EditedAt  time.Time `json:"edited_at"`
+}
+
+// Actor is the user performing an action on a message. Staff may delete
+// any message and pin messages.
+type Actor struct {
+	ID      string
+	Name    string
+	IsStaff bool
+}
+
+// AllowedReactions lists the emoji that may be used as reactions.
+var AllowedReactions = map[string]bool{
+	"👍": true, "👎": true, "❤️": true, "😂": true, "😮": true, "🎉": true, "🤔": true, "✅": true,
+}
+
+// MeetingChannel names the chat channel of a meeting room.
+func MeetingChannel(roomID string) string {
+	return "meeting:" + roomID
+}
+
+// UserChannel names a user's personal channel, which carries their
+// mentions and unread counts wherever they are connected.
+func UserChannel(userID string) string {
+	return "user:" + userID
+}
+
+// DirectChannel names a direct message conversation.
+func DirectChannel(conversationID string) string {
+	return "dm:" + conversationID
+}
+
+// DiscussionChannel names a discussion channel.
+func DiscussionChannel(name string) string {
+	return "discussion:" + name
+}
+
+// validate normalises m and checks it can be stored.
+func (m *Message) validate() error {
+	if m.Channel == "" {
+		return ErrNoChannel
+	}
+	if m.Kind == "" {
+		m.Kind = KindText
+	}
+	if m.Attachments == nil {
+		m.Attachments = []Attachment{}
+	}
+	if m.Reactions == nil {
+		m.Reactions = map[string][]string{}
+	}
+	if m.Mentions == nil {
+		m.Mentions = []string{}
+	}
+	m.Body = strings.TrimSpace(m.Body)
+	if m.Body == "" && len(m.Attachments) == 0 {
+		return ErrEmptyMessage
+	}
+	if len(m.Body) > MaxBodyLength {
+		return ErrMessageTooLong
+	}
+	return nil
+}
+
+// redact hides the content of a deleted message.
+func (m *Message) redact() {
+	if m.Deleted {
+		m.Body = ""
+		m.Attachments = []Attachment{}
+	}
+}
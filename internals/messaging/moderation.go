@@ -0,0 +1,580 @@
+package messaging
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrMuted           = errors.New("you are muted in this channel")
+	ErrSlowMode        = errors.New("slow mode is on")
+	ErrLinksBlocked    = errors.New("links are not allowed in this channel")
+	ErrAlreadyReported = errors.New("you already reported this message")
+	ErrReportClosed    = errors.New("report was already reviewed")
+)
+
+// Report states and review actions.
+const (
+	ReportPending   = "pending"
+	ReportDismissed = "dismissed"
+	ReportActioned  = "actioned"
+
+	ReviewDismiss = "dismiss"
+	ReviewDelete  = "delete"
+	ReviewMute    = "mute"
+)
+
+var linkPattern = regexp.MustCompile(`(?i)(https?://|www\.)\S+|\b[a-z0-9-]+\.(com|net|org|io|ly|gg|xyz|me|co|info|app|link)\b`)
+
+// ChannelSettings are the moderation options of one channel. Languages
+// picks the word lists applied to it; empty applies every list.
+type ChannelSettings struct {
+	Channel         string     `json:"channel"`
+	BlockLinks      bool       `json:"block_links"`
+	SlowModeSeconds int        `json:"slow_mode_seconds"`
+	Languages       []string   `json:"languages"`
+	UpdatedBy       string     `json:"updated_by,omitempty"`
+	UpdatedAt       *time.Time `json:"updated_at,omitempty"`
+}
+
+// Mute stops a user posting in a channel, until Until or indefinitely.
+type Mute struct {
+	Channel   string     `json:"channel"`
+	UserID    string     `json:"user_id"`
+	Until     *time.Time `json:"until,omitempty"`
+	Reason    string     `json:"reason"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Report is a member's complaint about a message, reviewed by staff.
+type Report struct {
+	ID         int64      `json:"id"`
+	MessageID  int64      `json:"message_id"`
+	Channel    string     `json:"channel"`
+	ReporterID string     `json:"reporter_id"`
+	Reason     string     `json:"reason"`
+	Status     string     `json:"status"`
+	ReviewedBy string     `json:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+	Resolution string     `json:"resolution,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Message    *Message   `json:"message,omitempty"`
+}
+
+// AuditEntry records one moderator action.
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	Channel    string    `json:"channel"`
+	ActorID    string    `json:"actor_id"`
+	Action     string    `json:"action"`
+	TargetUser string    `json:"target_user,omitempty"`
+	MessageID  *int64    `json:"message_id,omitempty"`
+	Details    string    `json:"details,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Moderation checks every inbound message against the channel's settings,
+// word lists and mutes, and keeps the report queue and audit trail. Staff
+// are exempt from mutes, slow mode and link blocking.
+type Moderation struct {
+	db *sql.DB
+
+	// IsStaff reports whether a sender is staff; nil treats nobody as staff.
+	IsStaff func(userID string) bool
+
+	mu       sync.Mutex
+	nextPost map[string]time.Time       // channel + "\x00" + user -> end of their slow-mode wait
+	pruned   time.Time                  // when nextPost was last cleared of past waits
+	words    map[string]map[string]bool // language -> words; nil until loaded
+}
+
+// NewModeration returns a Moderation backed by db. With a nil db every
+// message passes.
+func NewModeration(db *sql.DB) *Moderation {
+	return &Moderation{db: db, nextPost: make(map[string]time.Time)}
+}
+
+func (mod *Moderation) isStaff(userID string) bool {
+	return mod.IsStaff != nil && mod.IsStaff(userID)
+}
+
+// Check applies the channel's rules to m before it is stored, masking
+// listed words in place. New posts are also checked against mutes and slow
+// mode; edits only against the content rules.
+func (mod *Moderation) Check(m *Message, isNew bool) error {
+	_, err := mod.check(m, isNew)
+	return err
+}
+
+// check is Check for a post about to be saved. A new post under slow mode
+// takes the sender's slot; release gives it back if the post is not saved
+// after all, so only saved posts start the wait.
+func (mod *Moderation) check(m *Message, isNew bool) (release func(), err error) {
+	release = func() {}
+	if mod.db == nil {
+		return release, nil
+	}
+	settings, err := mod.Settings(m.Channel)
+	if err != nil {
+		return release, err
+	}
+	staff := mod.isStaff(m.SenderID)
+
+	if settings.BlockLinks && !staff && linkPattern.MatchString(m.Body) {
+		return release, ErrLinksBlocked
+	}
+	words, err := mod.wordSet(settings.Languages)
+	if err != nil {
+		return release, err
+	}
+
+	if isNew && !staff {
+		mute, err := mod.activeMute(m.Channel, m.SenderID)
+		if err != nil {
+			return release, err
+		}
+		if mute != nil {
+			if mute.Until != nil {
+				return release, fmt.Errorf("%w until %s", ErrMuted, mute.Until.Format("15:04 Jan 2"))
+			}
+			return release, ErrMuted
+		}
+		if release, err = mod.slowMode(m.Channel, m.SenderID, settings.SlowModeSeconds); err != nil {
+			return release, err
+		}
+	}
+	m.Body = maskWords(m.Body, words)
+	return release, nil
+}
+
+// slowMode allows one post per interval per user and takes the slot for
+// this one, returning a func that gives it back.
+func (mod *Moderation) slowMode(channel, userID string, seconds int) (func(), error) {
+	if seconds <= 0 {
+		return func() {}, nil
+	}
+	key := channel + "\x00" + userID
+	now := time.Now()
+	mod.mu.Lock()
+	defer mod.mu.Unlock()
+	if wait := mod.nextPost[key].Sub(now); wait > 0 {
+		return func() {}, fmt.Errorf("%w: wait %d seconds", ErrSlowMode, int(wait.Seconds())+1)
+	}
+	if now.Sub(mod.pruned) > time.Minute {
+		for k, until := range mod.nextPost {
+			if until.Before(now) {
+				delete(mod.nextPost, k)
+			}
+		}
+		mod.pruned = now
+	}
+	prev, until := mod.nextPost[key], now.Add(time.Duration(seconds)*time.Second)
+	mod.nextPost[key] = until
+	return func() {
+		mod.mu.Lock()
+		defer mod.mu.Unlock()
+		if mod.nextPost[key].Equal(until) {
+			mod.nextPost[key] = prev
+		}
+	}, nil
+}
+
+// maskWords replaces every listed word in body with asterisks. Words are
+// matched whole and case-insensitively, in any script.
+func maskWords(body string, words map[string]bool) string {
+	if len(words) == 0 {
+		return body
+	}
+	isWordRune := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r) }
+
+	var out strings.Builder
+	start := -1
+	flush := func(end int) {
+		word := body[start:end]
+		if words[strings.ToLower(word)] {
+			out.WriteString(strings.Repeat("*", utf8.RuneCountInString(word)))
+		} else {
+			out.WriteString(word)
+		}
+		start = -1
+	}
+	for i, r := range body {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			flush(i)
+		}
+		out.WriteRune(r)
+	}
+	if start >= 0 {
+		flush(len(body))
+	}
+	return out.String()
+}
+
+// ===== Settings =====
+
+// Settings returns a channel's moderation settings, or the defaults.
+func (mod *Moderation) Settings(channel string) (ChannelSettings, error) {
+	s := ChannelSettings{Channel: channel, Languages: []string{}}
+	if mod.db == nil {
+		return s, nil
+	}
+	var updatedBy sql.NullString
+	var updatedAt time.Time
+	err := mod.db.QueryRow(
+		`SELECT block_links, slow_mode_seconds, languages, updated_by, updated_at
+		 FROM channel_moderation WHERE channel = $1`, channel,
+	).Scan(&s.BlockLinks, &s.SlowModeSeconds, pq.Array(&s.Languages), &updatedBy, &updatedAt)
+	if err == sql.ErrNoRows {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	s.UpdatedBy = updatedBy.String
+	s.UpdatedAt = &updatedAt
+	return s, nil
+}
+
+// SetSettings stores a channel's moderation settings.
+func (mod *Moderation) SetSettings(s ChannelSettings, actorID string) error {
+	if s.SlowModeSeconds < 0 {
+		s.SlowModeSeconds = 0
+	}
+	if s.Languages == nil {
+		s.Languages = []string{}
+	}
+	_, err := mod.db.Exec(
+		`INSERT INTO channel_moderation (channel, block_links, slow_mode_seconds, languages, updated_by, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, NOW())
+		 ON CONFLICT (channel) DO UPDATE SET block_links = EXCLUDED.block_links,
+			slow_mode_seconds = EXCLUDED.slow_mode_seconds, languages = EXCLUDED.languages,
+			updated_by = EXCLUDED.updated_by, updated_at = NOW()`,
+		s.Channel, s.BlockLinks, s.SlowModeSeconds, pq.Array(s.Languages), actorID,
+	)
+	if err != nil {
+		return err
+	}
+	return mod.Audit(s.Channel, actorID, "settings", "", nil,
+		fmt.Sprintf("block_links=%t slow_mode=%ds languages=%s", s.BlockLinks, s.SlowModeSeconds, strings.Join(s.Languages, ",")))
+}
+
+// ===== Word lists =====
+
+// Words returns the word lists by language.
+func (mod *Moderation) Words() (map[string][]string, error) {
+	lists := make(map[string][]string)
+	rows, err := mod.db.Query(`SELECT language, word FROM moderation_words ORDER BY language, word`)
+	if err != nil {
+		return lists, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var language, word string
+		if err := rows.Scan(&language, &word); err != nil {
+			return lists, err
+		}
+		lists[language] = append(lists[language], word)
+	}
+	return lists, rows.Err()
+}
+
+// AddWords adds words to a language's list.
+func (mod *Moderation) AddWords(language string, words []string, actorID string) error {
+	return mod.changeWords(language, words, actorID, true)
+}
+
+// RemoveWords removes words from a language's list.
+func (mod *Moderation) RemoveWords(language string, words []string, actorID string) error {
+	return mod.changeWords(language, words, actorID, false)
+}
+
+func (mod *Moderation) changeWords(language string, words []string, actorID string, add bool) error {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if language == "" {
+		return errors.New("language is required")
+	}
+	cleaned := []string{}
+	for _, w := range words {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+			cleaned = append(cleaned, w)
+		}
+	}
+	if len(cleaned) == 0 {
+		return errors.New("no words given")
+	}
+
+	var err error
+	if add {
+		_, err = mod.db.Exec(
+			`INSERT INTO moderation_words (language, word, created_by)
+			 SELECT $1, unnest($2::text[]), $3 ON CONFLICT DO NOTHING`,
+			language, pq.Array(cleaned), actorID,
+		)
+	} else {
+		_, err = mod.db.Exec(
+			`DELETE FROM moderation_words WHERE language = $1 AND word = ANY($2)`, language, pq.Array(cleaned),
+		)
+	}
+	if err != nil {
+		return err
+	}
+
+	mod.mu.Lock()
+	mod.words = nil
+	mod.mu.Unlock()
+
+	action := "words-removed"
+	if add {
+		action = "words-added"
+	}
+	return mod.Audit("", actorID, action, "", nil, fmt.Sprintf("%s: %d words", language, len(cleaned)))
+}
+
+// wordSet merges the lists of the given languages, or all of them.
+func (mod *Moderation) wordSet(languages []string) (map[string]bool, error) {
+	mod.mu.Lock()
+	defer mod.mu.Unlock()
+	if mod.words == nil {
+		rows, err := mod.db.Query(`SELECT language, word FROM moderation_words`)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		loaded := make(map[string]map[string]bool)
+		for rows.Next() {
+			var language, word string
+			if err := rows.Scan(&language, &word); err != nil {
+				return nil, err
+			}
+			if loaded[language] == nil {
+				loaded[language] = make(map[string]bool)
+			}
+			loaded[language][word] = true
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		mod.words = loaded
+	}
+
+	if len(languages) == 0 {
+		languages = make([]string, 0, len(mod.words))
+		for language := range mod.words {
+			languages = append(languages, language)
+		}
+	}
+	set := make(map[string]bool)
+	for _, language := range languages {
+		for w := range mod.words[language] {
+			set[w] = true
+		}
+	}
+	return set, nil
+}
+
+// ===== Mutes =====
+
+// Mute stops userID posting in channel for d, or indefinitely if d is 0.
+func (mod *Moderation) Mute(channel, userID string, d time.Duration, reason, actorID string) (Mute, error) {
+	m := Mute{Channel: channel, UserID: userID, Reason: reason, CreatedBy: actorID, CreatedAt: time.Now()}
+	if d > 0 {
+		until := m.CreatedAt.Add(d)
+		m.Until = &until
+	}
+	_, err := mod.db.Exec(
+		`INSERT INTO channel_mutes (channel, user_id, until, reason, created_by, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 ON CONFLICT (channel, user_id) DO UPDATE SET until = EXCLUDED.until, reason = EXCLUDED.reason,
+			created_by = EXCLUDED.created_by, created_at = EXCLUDED.created_at`,
+		m.Channel, m.UserID, m.Until, m.Reason, m.CreatedBy, m.CreatedAt,
+	)
+	if err != nil {
+		return m, err
+	}
+	details := "indefinitely"
+	if m.Until != nil {
+		details = "for " + d.String()
+	}
+	if reason != "" {
+		details += ": " + reason
+	}
+	return m, mod.Audit(channel, actorID, "mute", userID, nil, details)
+}
+
+// Unmute lifts a mute.
+func (mod *Moderation) Unmute(channel, userID, actorID string) error {
+	if _, err := mod.db.Exec(`DELETE FROM channel_mutes WHERE channel = $1 AND user_id = $2`, channel, userID); err != nil {
+		return err
+	}
+	return mod.Audit(channel, actorID, "unmute", userID, nil, "")
+}
+
+// Mutes lists the active mutes of a channel.
+func (mod *Moderation) Mutes(channel string) ([]Mute, error) {
+	return mod.queryMutes(`channel = $1`, channel)
+}
+
+func (mod *Moderation) activeMute(channel, userID string) (*Mute, error) {
+	mutes, err := mod.queryMutes(`channel = $1 AND user_id = $2`, channel, userID)
+	if err != nil || len(mutes) == 0 {
+		return nil, err
+	}
+	return &mutes[0], nil
+}
+
+func (mod *Moderation) queryMutes(where string, args ...interface{}) ([]Mute, error) {
+	rows, err := mod.db.Query(
+		`SELECT channel, user_id, until, reason, created_by, created_at FROM channel_mutes
+		 WHERE `+where+` AND (until IS NULL OR until > NOW()) ORDER BY created_at`, args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	mutes := []Mute{}
+	for rows.Next() {
+		var m Mute
+		var until sql.NullTime
+		if err := rows.Scan(&m.Channel, &m.UserID, &until, &m.Reason, &m.CreatedBy, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		if until.Valid {
+			m.Until = &until.Time
+		}
+		mutes = append(mutes, m)
+	}
+	return mutes, rows.Err()
+}
+
+// ===== Reports =====
+
+const reportColumns = `id, message_id, channel, reporter_id, reason, status, COALESCE(reviewed_by, ''),
+	reviewed_at, resolution, created_at`
+
+func scanReport(row rowScanner) (Report, error) {
+	var r Report
+	var reviewedAt sql.NullTime
+	err := row.Scan(&r.ID, &r.MessageID, &r.Channel, &r.ReporterID, &r.Reason, &r.Status, &r.ReviewedBy,
+		&reviewedAt, &r.Resolution, &r.CreatedAt)
+	if reviewedAt.Valid {
+		r.ReviewedAt = &reviewedAt.Time
+	}
+	return r, err
+}
+
+// Reports lists reports with the given status (all if empty), optionally
+// limited to one channel, oldest first.
+func (mod *Moderation) Reports(status, channel string) ([]Report, error) {
+	rows, err := mod.db.Query(
+		`SELECT `+reportColumns+` FROM message_reports
+		 WHERE ($1 = '' OR status = $1) AND ($2 = '' OR channel = $2)
+		 ORDER BY created_at`, status, channel,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reports := []Report{}
+	for rows.Next() {
+		r, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, rows.Err()
+}
+
+// Report returns one report.
+func (mod *Moderation) Report(id int64) (Report, error) {
+	r, err := scanReport(mod.db.QueryRow(`SELECT `+reportColumns+` FROM message_reports WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return r, ErrNotFound
+	}
+	return r, err
+}
+
+func (mod *Moderation) fileReport(m Message, reporterID, reason string) (Report, error) {
+	r, err := scanReport(mod.db.QueryRow(
+		`INSERT INTO message_reports (message_id, channel, reporter_id, reason)
+		 VALUES ($1, $2, $3, $4) ON CONFLICT (message_id, reporter_id) DO NOTHING
+		 RETURNING `+reportColumns,
+		m.ID, m.Channel, reporterID, reason,
+	))
+	if err == sql.ErrNoRows {
+		return r, ErrAlreadyReported
+	}
+	return r, err
+}
+
+func (mod *Moderation) closeReport(id int64, status, actorID, resolution string) error {
+	res, err := mod.db.Exec(
+		`UPDATE message_reports SET status = $2, reviewed_by = $3, reviewed_at = NOW(), resolution = $4
+		 WHERE id = $1 AND status = 'pending'`, id, status, actorID, resolution,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrReportClosed
+	}
+	return nil
+}
+
+// ===== Audit trail =====
+
+// Audit records a moderator action.
+func (mod *Moderation) Audit(channel, actorID, action, targetUser string, messageID *int64, details string) error {
+	if mod.db == nil {
+		return nil
+	}
+	_, err := mod.db.Exec(
+		`INSERT INTO moderation_audit (channel, actor_id, action, target_user, message_id, details)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		channel, actorID, action, targetUser, messageID, details,
+	)
+	return err
+}
+
+// AuditLog returns the latest limit audit entries, newest first, for one
+// channel or all of them.
+func (mod *Moderation) AuditLog(channel string, limit int) ([]AuditEntry, error) {
+	rows, err := mod.db.Query(
+		`SELECT id, channel, actor_id, action, target_user, message_id, details, created_at
+		 FROM moderation_audit WHERE ($1 = '' OR channel = $1)
+		 ORDER BY created_at DESC, id DESC LIMIT $2`, channel, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var messageID sql.NullInt64
+		if err := rows.Scan(&e.ID, &e.Channel, &e.ActorID, &e.Action, &e.TargetUser, &messageID, &e.Details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if messageID.Valid {
+			e.MessageID = &messageID.Int64
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,126 @@
+package messaging
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// PageQuery selects one page of a channel. At most one of Before, After
+// and Since is used, in that order of precedence; with none set the page
+// holds the latest messages.
+type PageQuery struct {
+	Before         int64     // messages with an ID below this cursor
+	After          int64     // messages with an ID above this cursor
+	Since          time.Time // jump to the first messages at or after this time
+	Limit          int
+	IncludeReplies bool // also return thread replies, e.g. for catch-up
+}
+
+// Page is a run of consecutive messages, oldest first, with the cursors to
+// fetch the neighbouring pages.
+type Page struct {
+	Messages []Message `json:"messages"`
+	Before   int64     `json:"before,omitempty"` // pass as Before for older messages
+	After    int64     `json:"after,omitempty"`  // pass as After for newer messages
+	HasOlder bool      `json:"has_older"`
+	HasNewer bool      `json:"has_newer"`
+}
+
+// Page returns one page of channel according to q.
+func (s *Store) Page(channel string, q PageQuery) (Page, error) {
+	page := Page{Messages: make([]Message, 0)}
+	if s.db == nil {
+		return page, nil
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultPageSize
+	}
+	if q.Limit > MaxPageSize {
+		q.Limit = MaxPageSize
+	}
+
+	scope := `m.channel = $1`
+	if !q.IncludeReplies {
+		scope += ` AND m.reply_to_id IS NULL`
+	}
+	args := []interface{}{channel}
+
+	// Forward pages read ascending from the cursor; the others read
+	// descending and are reversed.
+	where, forward := scope, false
+	switch {
+	case q.Before > 0:
+		args = append(args, q.Before)
+		where += ` AND m.id < $2`
+	case q.After > 0:
+		args = append(args, q.After)
+		where += ` AND m.id > $2`
+		forward = true
+	case !q.Since.IsZero():
+		args = append(args, q.Since)
+		where += ` AND m.created_at >= $2`
+		forward = true
+	}
+
+	order := `DESC`
+	if forward {
+		order = `ASC`
+	}
+	args = append(args, q.Limit+1)
+	query := `SELECT ` + messageColumns + ` FROM messages m WHERE ` + where +
+		` ORDER BY m.id ` + order + ` LIMIT $` + strconv.Itoa(len(args))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return page, err
+		}
+		page.Messages = append(page.Messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+
+	more := len(page.Messages) > q.Limit
+	if more {
+		page.Messages = page.Messages[:q.Limit]
+	}
+	if !forward {
+		for i, j := 0, len(page.Messages)-1; i < j; i, j = i+1, j-1 {
+			page.Messages[i], page.Messages[j] = page.Messages[j], page.Messages[i]
+		}
+	}
+	if len(page.Messages) == 0 {
+		return page, nil
+	}
+
+	page.Before = page.Messages[0].ID
+	page.After = page.Messages[len(page.Messages)-1].ID
+	if forward {
+		page.HasNewer = more
+		page.HasOlder, err = s.exists(scope+` AND m.id < $2`, channel, page.Before)
+	} else {
+		page.HasOlder = more
+		page.HasNewer, err = s.exists(scope+` AND m.id > $2`, channel, page.After)
+	}
+	if err != nil {
+		return page, err
+	}
+	return page, s.loadReactions(page.Messages)
+}
+
+func (s *Store) exists(where string, args ...interface{}) (bool, error) {
+	var found bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM messages m WHERE `+where+`)`, args...).Scan(&found)
+	return found, err
+}
@@ -0,0 +1,278 @@
+package messaging
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Service is the entry point used by the meeting and discussion adapters:
+// it validates, persists and fans out messages and changes to them.
+type Service struct {
+	Store      *Store
+	Hub        *Hub
+	Moderation *Moderation
+
+	// Directory resolves mentions and channel members; without it mentions
+	// are ignored and unread counts are not pushed.
+	Directory Directory
+	// OnMention, if set, is called for each user a new message mentions.
+	OnMention func(m Message, userID string)
+}
+
+// NewService returns a Service storing messages in db.
+func NewService(db *sql.DB) *Service {
+	return &Service{Store: NewStore(db), Hub: NewHub(), Moderation: NewModeration(db)}
+}
+
+// Post moderates m, stores it with a server-side timestamp and delivers it
+// to the channel's subscribers. A reply must target a message in the same
+// channel. The stored message is returned.
+func (s *Service) Post(m Message) (Message, error) {
+	if err := m.validate(); err != nil {
+		return m, err
+	}
+	release, err := s.Moderation.check(&m, true)
+	if err != nil {
+		return m, err
+	}
+	if m.ReplyToID != nil {
+		parent, err := s.Store.Get(*m.ReplyToID)
+		if err != nil || parent.Channel != m.Channel || parent.Deleted {
+			release()
+			return m, ErrBadReply
+		}
+		// Replies always attach to the top-level message.
+		if parent.ReplyToID != nil {
+			m.ReplyToID = parent.ReplyToID
+		}
+	}
+	s.resolveMentions(&m)
+	m.CreatedAt = time.Now()
+	if err := s.Store.Save(&m); err != nil {
+		release()
+		return m, err
+	}
+	s.Hub.Publish(Event{Type: EventMessage, Message: m, ActorID: m.SenderID})
+	go s.notifyNew(m)
+	return m, nil
+}
+
+// History returns the latest limit top-level messages of channel, oldest
+// first.
+func (s *Service) History(channel string, limit int) ([]Message, error) {
+	return s.Store.History(channel, limit)
+}
+
+// load fetches a message and checks it belongs to channel.
+func (s *Service) load(channel string, id int64) (Message, error) {
+	m, err := s.Store.Get(id)
+	if err != nil {
+		return m, err
+	}
+	if m.Channel != channel {
+		return m, ErrNotFound
+	}
+	return m, nil
+}
+
+// update reloads a changed message and publishes it as eventType.
+func (s *Service) update(id int64, eventType string, actor Actor) (Message, error) {
+	m, err := s.Store.Get(id)
+	if err != nil {
+		return m, err
+	}
+	s.Hub.Publish(Event{Type: eventType, Message: m, ActorID: actor.ID})
+	return m, nil
+}
+
+// Edit changes the body of the actor's own message.
+func (s *Service) Edit(channel string, id int64, actor Actor, body string) (Message, error) {
+	m, err := s.load(channel, id)
+	if err != nil {
+		return m, err
+	}
+	if m.Deleted {
+		return m, ErrDeleted
+	}
+	if m.SenderID != actor.ID {
+		return m, ErrForbidden
+	}
+	body = strings.TrimSpace(body)
+	if body == "" && len(m.Attachments) == 0 {
+		return m, ErrEmptyMessage
+	}
+	if len(body) > MaxBodyLength {
+		return m, ErrMessageTooLong
+	}
+	edited := Message{Channel: channel, SenderID: actor.ID, Body: body}
+	if err := s.Moderation.Check(&edited, false); err != nil {
+		return m, err
+	}
+	if err := s.Store.Edit(id, actor.ID, edited.Body); err != nil {
+		return m, err
+	}
+	return s.update(id, EventEdited, actor)
+}
+
+// Delete soft-deletes a message. Authors may delete their own messages and
+// staff may delete any.
+func (s *Service) Delete(channel string, id int64, actor Actor) (Message, error) {
+	m, err := s.load(channel, id)
+	if err != nil {
+		return m, err
+	}
+	if m.Deleted {
+		return m, ErrDeleted
+	}
+	if m.SenderID != actor.ID && !actor.IsStaff {
+		return m, ErrForbidden
+	}
+	if err := s.Store.SoftDelete(id, actor.ID); err != nil {
+		return m, err
+	}
+	if m.SenderID != actor.ID {
+		if err := s.Moderation.Audit(channel, actor.ID, "delete", m.SenderID, &id, ""); err != nil {
+			return m, err
+		}
+	}
+	return s.update(id, EventDeleted, actor)
+}
+
+// React toggles the actor's emoji reaction on a message.
+func (s *Service) React(channel string, id int64, actor Actor, emoji string) (Message, error) {
+	if !AllowedReactions[emoji] {
+		return Message{}, ErrBadReaction
+	}
+	m, err := s.load(channel, id)
+	if err != nil {
+		return m, err
+	}
+	if m.Deleted {
+		return m, ErrDeleted
+	}
+	if err := s.Store.ToggleReaction(id, actor.ID, emoji); err != nil {
+		return m, err
+	}
+	return s.update(id, EventReaction, actor)
+}
+
+// Pin pins or unpins a message. Only staff may pin.
+func (s *Service) Pin(channel string, id int64, actor Actor, pinned bool) (Message, error) {
+	if !actor.IsStaff {
+		return Message{}, ErrForbidden
+	}
+	m, err := s.load(channel, id)
+	if err != nil {
+		return m, err
+	}
+	if m.Deleted && pinned {
+		return m, ErrDeleted
+	}
+	if err := s.Store.SetPinned(id, actor.ID, pinned); err != nil {
+		return m, err
+	}
+	action := "unpin"
+	if pinned {
+		action = "pin"
+	}
+	if err := s.Moderation.Audit(channel, actor.ID, action, m.SenderID, &id, ""); err != nil {
+		return m, err
+	}
+	return s.update(id, EventPinned, actor)
+}
+
+// Report files the actor's report about a message for staff review.
+func (s *Service) Report(channel string, id int64, actor Actor, reason string) (Report, error) {
+	m, err := s.load(channel, id)
+	if err != nil {
+		return Report{}, err
+	}
+	if m.Deleted {
+		return Report{}, ErrDeleted
+	}
+	reason = strings.TrimSpace(reason)
+	if len(reason) > MaxBodyLength {
+		return Report{}, ErrMessageTooLong
+	}
+	return s.Moderation.fileReport(m, actor.ID, reason)
+}
+
+// ReviewReport closes a pending report. ReviewDelete deletes the message,
+// ReviewMute also mutes its author for muteFor (0 = indefinitely) and
+// ReviewDismiss leaves it. Every review is audited.
+func (s *Service) ReviewReport(id int64, actor Actor, action string, muteFor time.Duration, note string) (Report, error) {
+	if !actor.IsStaff {
+		return Report{}, ErrForbidden
+	}
+	r, err := s.Moderation.Report(id)
+	if err != nil {
+		return r, err
+	}
+	if r.Status != ReportPending {
+		return r, ErrReportClosed
+	}
+	m, err := s.Store.Get(r.MessageID)
+	if err != nil {
+		return r, err
+	}
+
+	status := ReportActioned
+	switch action {
+	case ReviewDismiss:
+		status = ReportDismissed
+	case ReviewDelete, ReviewMute:
+		if !m.Deleted {
+			if _, err := s.Delete(r.Channel, m.ID, actor); err != nil {
+				return r, err
+			}
+		}
+		if action == ReviewMute {
+			if _, err := s.Moderation.Mute(r.Channel, m.SenderID, muteFor, note, actor.ID); err != nil {
+				return r, err
+			}
+		}
+	default:
+		return r, fmt.Errorf("unknown review action %q", action)
+	}
+
+	resolution := action
+	if note != "" {
+		resolution += ": " + note
+	}
+	if err := s.Moderation.closeReport(id, status, actor.ID, resolution); err != nil {
+		return r, err
+	}
+	if err := s.Moderation.Audit(r.Channel, actor.ID, "review-"+action, m.SenderID, &m.ID, fmt.Sprintf("report %d", id)); err != nil {
+		return r, err
+	}
+	return s.Moderation.Report(id)
+}
+
+// SetAttachments replaces the attachments of a message, e.g. to add a
+// transcript once it is ready. It is used by server-side jobs, not users.
+func (s *Service) SetAttachments(channel string, id int64, attachments []Attachment) (Message, error) {
+	m, err := s.load(channel, id)
+	if err != nil {
+		return m, err
+	}
+	if m.Deleted {
+		return m, ErrDeleted
+	}
+	if err := s.Store.SetAttachments(id, attachments); err != nil {
+		return m, err
+	}
+	return s.update(id, EventAttached, Actor{})
+}
+
+// Page returns one page of channel history.
+func (s *Service) Page(channel string, q PageQuery) (Page, error) {
+	return s.Store.Page(channel, q)
+}
+
+// CatchUp returns what a reconnecting client missed after lastSeenID,
+// replies included, oldest first.
+func (s *Service) CatchUp(channel string, lastSeenID int64) (Page, error) {
+	return s.Store.Page(channel, PageQuery{After: lastSeenID, Limit: MaxPageSize, IncludeReplies: true})
+}
@@ -0,0 +1,313 @@
+package messaging
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+// Store persists messages in the messages table.
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore returns a Store backed by db. A nil db gives a Store that keeps
+// nothing, which is useful in development.
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+const messageColumns = `m.id, m.channel, m.sender_id, m.sender_name, m.kind, m.body, m.attachments,
+	m.reply_to_id, (SELECT COUNT(*) FROM messages r WHERE r.reply_to_id = m.id AND r.deleted_at IS NULL),
+	m.edited, m.deleted_at IS NOT NULL, COALESCE(m.deleted_by, ''),
+	m.pinned_at IS NOT NULL, COALESCE(m.pinned_by, ''), m.created_at, m.updated_at,
+	ARRAY(SELECT mm.user_id FROM message_mentions mm WHERE mm.message_id = m.id ORDER BY mm.user_id)`
+
+// Save inserts m with its mentions, all or nothing, and fills in its ID.
+func (s *Store) Save(m *Message) error {
+	if s.db == nil {
+		return nil
+	}
+	attachments, err := json.Marshal(m.Attachments)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var id int64
+	err = tx.QueryRow(
+		`INSERT INTO messages (channel, sender_id, sender_name, kind, body, attachments, reply_to_id, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		m.Channel, m.SenderID, m.SenderName, m.Kind, m.Body, attachments, m.ReplyToID, m.CreatedAt,
+	).Scan(&id)
+	if err != nil {
+		return err
+	}
+	if len(m.Mentions) > 0 {
+		_, err = tx.Exec(
+			`INSERT INTO message_mentions (message_id, user_id) SELECT $1, unnest($2::text[]) ON CONFLICT DO NOTHING`,
+			id, pq.Array(m.Mentions),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	m.ID = id
+	return nil
+}
+
+// Get loads one message with its reactions.
+func (s *Store) Get(id int64) (Message, error) {
+	m, err := s.Original(id)
+	m.redact()
+	return m, err
+}
+
+// Original returns a message as Get does, but keeps the content of a
+// deleted one, for moderators reviewing it.
+func (s *Store) Original(id int64) (Message, error) {
+	if s.db == nil {
+		return Message{}, ErrNotFound
+	}
+	m, err := scanOriginal(s.db.QueryRow(`SELECT `+messageColumns+` FROM messages m WHERE m.id = $1`, id))
+	if err == sql.ErrNoRows {
+		return m, ErrNotFound
+	}
+	if err != nil {
+		return m, err
+	}
+	messages := []Message{m}
+	if err := s.loadReactions(messages); err != nil {
+		return m, err
+	}
+	return messages[0], nil
+}
+
+// History returns the latest limit top-level messages of a channel, oldest
+// first. A limit of 0 returns the whole channel.
+func (s *Store) History(channel string, limit int) ([]Message, error) {
+	return s.query(`m.channel = $1 AND m.reply_to_id IS NULL`, []interface{}{channel}, limit)
+}
+
+// Thread returns the replies to a message, oldest first.
+func (s *Store) Thread(parentID int64) ([]Message, error) {
+	return s.query(`m.reply_to_id = $1`, []interface{}{parentID}, 0)
+}
+
+// Pinned returns the pinned messages of a channel, oldest first.
+func (s *Store) Pinned(channel string) ([]Message, error) {
+	return s.query(`m.channel = $1 AND m.pinned_at IS NOT NULL AND m.deleted_at IS NULL`, []interface{}{channel}, 0)
+}
+
+// query selects messages matching where (using args as $1..$n), newest
+// limit first, and returns them oldest first with reactions loaded.
+func (s *Store) query(where string, args []interface{}, limit int) ([]Message, error) {
+	messages := make([]Message, 0)
+	if s.db == nil {
+		return messages, nil
+	}
+
+	query := `SELECT ` + messageColumns + ` FROM messages m WHERE ` + where + ` ORDER BY m.id DESC`
+	if limit > 0 {
+		args = append(args, limit)
+		query += ` LIMIT $` + strconv.Itoa(len(args))
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			log.Println("scan message row:", err)
+			continue
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Reverse order
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, s.loadReactions(messages)
+}
+
+// loadReactions fills in the reactions of messages.
+func (s *Store) loadReactions(messages []Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(messages))
+	index := make(map[int64]int, len(messages))
+	for i, m := range messages {
+		ids[i] = m.ID
+		index[m.ID] = i
+	}
+
+	rows, err := s.db.Query(
+		`SELECT message_id, emoji, user_id FROM message_reactions
+		 WHERE message_id = ANY($1) ORDER BY created_at`, pq.Array(ids),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var emoji, userID string
+		if err := rows.Scan(&id, &emoji, &userID); err != nil {
+			return err
+		}
+		m := &messages[index[id]]
+		m.Reactions[emoji] = append(m.Reactions[emoji], userID)
+	}
+	return rows.Err()
+}
+
+// Edit replaces the body of a message, keeping the old body in
+// message_edits.
+func (s *Store) Edit(id int64, editorID, body string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`INSERT INTO message_edits (message_id, body, edited_by)
+		 SELECT id, body, $2 FROM messages WHERE id = $1`, id, editorID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE messages SET body = $2, edited = TRUE, updated_at = NOW() WHERE id = $1`, id, body)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Edits returns the previous versions of a message, oldest first.
+func (s *Store) Edits(id int64) ([]Edit, error) {
+	edits := make([]Edit, 0)
+	rows, err := s.db.Query(
+		`SELECT message_id, body, edited_by, edited_at FROM message_edits
+		 WHERE message_id = $1 ORDER BY edited_at`, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e Edit
+		if err := rows.Scan(&e.MessageID, &e.Body, &e.EditedBy, &e.EditedAt); err != nil {
+			return nil, err
+		}
+		edits = append(edits, e)
+	}
+	return edits, rows.Err()
+}
+
+// SoftDelete marks a message deleted; its content stays in the table.
+func (s *Store) SoftDelete(id int64, by string) error {
+	_, err := s.db.Exec(
+		`UPDATE messages SET deleted_at = NOW(), deleted_by = $2, updated_at = NOW() WHERE id = $1`, id, by,
+	)
+	return err
+}
+
+// SetPinned pins or unpins a message.
+func (s *Store) SetPinned(id int64, by string, pinned bool) error {
+	var err error
+	if pinned {
+		_, err = s.db.Exec(`UPDATE messages SET pinned_at = NOW(), pinned_by = $2 WHERE id = $1`, id, by)
+	} else {
+		_, err = s.db.Exec(`UPDATE messages SET pinned_at = NULL, pinned_by = NULL WHERE id = $1`, id)
+	}
+	return err
+}
+
+// SetAttachments replaces a message's attachment metadata.
+func (s *Store) SetAttachments(id int64, attachments []Attachment) error {
+	data, err := json.Marshal(attachments)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`UPDATE messages SET attachments = $2 WHERE id = $1`, id, data)
+	return err
+}
+
+// ToggleReaction adds the user's reaction, or removes it if present.
+func (s *Store) ToggleReaction(id int64, userID, emoji string) error {
+	res, err := s.db.Exec(
+		`DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3`, id, userID, emoji,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	_, err = s.db.Exec(
+		`INSERT INTO message_reactions (message_id, user_id, emoji) VALUES ($1, $2, $3)
+		 ON CONFLICT DO NOTHING`, id, userID, emoji,
+	)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMessage(row rowScanner) (Message, error) {
+	m, err := scanOriginal(row)
+	m.redact()
+	return m, err
+}
+
+// scanOriginal reads a message without redacting a deleted one.
+func scanOriginal(row rowScanner) (Message, error) {
+	var m Message
+	var attachments []byte
+	var replyTo sql.NullInt64
+	var updated sql.NullTime
+	err := row.Scan(&m.ID, &m.Channel, &m.SenderID, &m.SenderName, &m.Kind, &m.Body, &attachments,
+		&replyTo, &m.ReplyCount, &m.Edited, &m.Deleted, &m.DeletedBy,
+		&m.Pinned, &m.PinnedBy, &m.CreatedAt, &updated, pq.Array(&m.Mentions))
+	if err != nil {
+		return m, err
+	}
+	if replyTo.Valid {
+		m.ReplyToID = &replyTo.Int64
+	}
+	if updated.Valid {
+		m.UpdatedAt = &updated.Time
+	}
+	m.Reactions = map[string][]string{}
+	m.Attachments = []Attachment{}
+	if m.Mentions == nil {
+		m.Mentions = []string{}
+	}
+	if len(attachments) > 0 {
+		if err := json.Unmarshal(attachments, &m.Attachments); err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
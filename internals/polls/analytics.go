@@ -0,0 +1,464 @@
+package polls
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Vote is one stored vote, for exports and analytics. Ballots of anonymous
+// polls have no VoterID and carry the poll's creation time.
+type Vote struct {
+	PollID    string    `json:"pollId"`
+	VoterID   string    `json:"voterId,omitempty"`
+	Answer    Answer    `json:"answer"`
+	VotedAt   time.Time `json:"votedAt"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+// AnswerText renders an answer to p for exports: the chosen options, in
+// order for ranked polls, the rating, the text or the number.
+func (p *Poll) AnswerText(a Answer) string {
+	switch p.Kind {
+	case KindRating:
+		if a.Rating == 0 {
+			return ""
+		}
+		return strconv.Itoa(a.Rating) + "/" + strconv.Itoa(MaxRating)
+	case KindText, KindWordCloud:
+		return a.Text
+	case KindNumeric:
+		if a.Number == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*a.Number, 'f', -1, 64)
+	}
+	labels := make([]string, 0, len(a.Choices))
+	for _, c := range a.Choices {
+		if c >= 0 && c < len(p.Options) {
+			labels = append(labels, p.Options[c])
+		}
+	}
+	if p.Kind == KindRanked {
+		return strings.Join(labels, " > ")
+	}
+	return strings.Join(labels, "; ")
+}
+
+// TimeStats is a distribution of response times, in seconds.
+type TimeStats struct {
+	Count   int          `json:"count"`
+	Mean    float64      `json:"mean"`
+	Median  float64      `json:"median"`
+	P25     float64      `json:"p25"`
+	P75     float64      `json:"p75"`
+	P90     float64      `json:"p90"`
+	Min     float64      `json:"min"`
+	Max     float64      `json:"max"`
+	Buckets []TimeBucket `json:"buckets"`
+}
+
+// TimeBucket counts the responses of up to UpTo seconds, and over the
+// previous bucket's limit. The last bucket has no limit.
+type TimeBucket struct {
+	Label string  `json:"label"`
+	UpTo  float64 `json:"upTo,omitempty"`
+	Count int     `json:"count"`
+}
+
+var timeBucketLimits = []float64{5, 10, 20, 30, 60, 120, 300}
+
+// timeStats sums up response times in seconds, or returns nil if there are
+// none.
+func timeStats(seconds []float64) *TimeStats {
+	if len(seconds) == 0 {
+		return nil
+	}
+	sorted := append([]float64(nil), seconds...)
+	sort.Float64s(sorted)
+	stats := &TimeStats{
+		Count:  len(sorted),
+		Median: percentile(sorted, 0.5),
+		P25:    percentile(sorted, 0.25),
+		P75:    percentile(sorted, 0.75),
+		P90:    percentile(sorted, 0.9),
+		Min:    round3(sorted[0]),
+		Max:    round3(sorted[len(sorted)-1]),
+	}
+	sum := 0.0
+	for _, s := range sorted {
+		sum += s
+	}
+	stats.Mean = round3(sum / float64(len(sorted)))
+
+	prev := 0.0
+	for _, limit := range timeBucketLimits {
+		stats.Buckets = append(stats.Buckets, TimeBucket{Label: secondsLabel(prev) + "–" + secondsLabel(limit), UpTo: limit})
+		prev = limit
+	}
+	stats.Buckets = append(stats.Buckets, TimeBucket{Label: secondsLabel(prev) + "+"})
+	for _, s := range sorted {
+		i := sort.SearchFloat64s(timeBucketLimits, s)
+		stats.Buckets[i].Count++
+	}
+	return stats
+}
+
+// percentile interpolates the q-th percentile of sorted values.
+func percentile(sorted []float64, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return round3(sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo)))
+}
+
+func secondsLabel(s float64) string {
+	if s >= 60 {
+		return strconv.Itoa(int(s/60)) + "m"
+	}
+	return strconv.Itoa(int(s)) + "s"
+}
+
+func round3(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+// ItemStats is the item analysis of one graded question. Difficulty is the
+// share of responses that were correct. Discrimination is the correlation
+// between getting this item right and the respondent's score on the other
+// items of the same set (the course's graded polls, or the quiz), from -1
+// to 1; higher means the item better separates strong and weak students.
+// Either is nil when there is too little data.
+type ItemStats struct {
+	ID             string     `json:"id"`
+	Question       string     `json:"question"`
+	Kind           string     `json:"kind"`
+	Responses      int        `json:"responses"`
+	Correct        int        `json:"correct"`
+	Difficulty     *float64   `json:"difficulty"`
+	Discrimination *float64   `json:"discrimination"`
+	ResponseTime   *TimeStats `json:"responseTime,omitempty"`
+}
+
+// minDiscriminationResponses is the fewest identified respondents an item
+// needs before its discrimination is computed.
+const minDiscriminationResponses = 5
+
+// discrimination fills in the Discrimination of items from who answered
+// what correctly: byStudent maps a student to their graded responses by
+// item ID.
+func discrimination(items []*ItemStats, byStudent map[string]map[string]bool) {
+	for _, item := range items {
+		var xs, ys []float64
+		for _, responses := range byStudent {
+			correct, ok := responses[item.ID]
+			if !ok {
+				continue
+			}
+			rest := 0
+			for id, c := range responses {
+				if c && id != item.ID {
+					rest++
+				}
+			}
+			x := 0.0
+			if correct {
+				x = 1
+			}
+			xs = append(xs, x)
+			ys = append(ys, float64(rest))
+		}
+		if len(xs) < minDiscriminationResponses {
+			continue
+		}
+		if r, ok := correlation(xs, ys); ok {
+			r = round3(r)
+			item.Discrimination = &r
+		}
+	}
+}
+
+// correlation is the Pearson correlation of xs and ys; ok is false if
+// either does not vary.
+func correlation(xs, ys []float64) (float64, bool) {
+	n := float64(len(xs))
+	var sx, sy float64
+	for i := range xs {
+		sx += xs[i]
+		sy += ys[i]
+	}
+	mx, my := sx/n, sy/n
+	var cov, vx, vy float64
+	for i := range xs {
+		dx, dy := xs[i]-mx, ys[i]-my
+		cov += dx * dy
+		vx += dx * dx
+		vy += dy * dy
+	}
+	if vx == 0 || vy == 0 {
+		return 0, false
+	}
+	return cov / math.Sqrt(vx*vy), true
+}
+
+func (item *ItemStats) setDifficulty() {
+	if item.Responses > 0 {
+		d := round3(float64(item.Correct) / float64(item.Responses))
+		item.Difficulty = &d
+	}
+}
+
+// PollReport is a poll with its votes and analysis. Item is set for graded
+// polls.
+type PollReport struct {
+	Poll         Poll       `json:"poll"`
+	Votes        []Vote     `json:"votes"`
+	Item         *ItemStats `json:"item,omitempty"`
+	ResponseTime *TimeStats `json:"responseTime,omitempty"`
+}
+
+// QuizReport is a quiz with every answer, the final standings and an item
+// analysis of each question.
+type QuizReport struct {
+	Quiz         Quiz         `json:"quiz"`
+	Answers      []QuizAnswer `json:"answers"`
+	Leaderboard  []Standing   `json:"leaderboard"`
+	Items        []*ItemStats `json:"items"`
+	ResponseTime *TimeStats   `json:"responseTime,omitempty"`
+}
+
+// HistoryEntry is one poll or quiz in a student's history. Answer is left
+// out for anonymous polls; Correct is set for graded answers.
+type HistoryEntry struct {
+	Kind            string    `json:"kind"` // poll or quiz
+	ID              string    `json:"id"`
+	Title           string    `json:"title"`
+	At              time.Time `json:"at"`
+	Answered        bool      `json:"answered"`
+	Anonymous       bool      `json:"anonymous,omitempty"`
+	Answer          *Answer   `json:"answer,omitempty"`
+	AnswerText      string    `json:"answerText,omitempty"`
+	Correct         *bool     `json:"correct,omitempty"`
+	ResponseSeconds *float64  `json:"responseSeconds,omitempty"`
+	Score           *int      `json:"score,omitempty"`
+	Rank            *int      `json:"rank,omitempty"`
+	QuestionsRight  *int      `json:"questionsRight,omitempty"`
+}
+
+// StudentHistory is one student's participation in the polls and quizzes
+// of a scope.
+type StudentHistory struct {
+	ParticipantID  string         `json:"participantId"`
+	Name           string         `json:"name"`
+	PollsOffered   int            `json:"pollsOffered"`
+	PollsAnswered  int            `json:"pollsAnswered"`
+	Participation  float64        `json:"participation"`
+	GradedAnswered int            `json:"gradedAnswered"`
+	GradedCorrect  int            `json:"gradedCorrect"`
+	QuizzesOffered int            `json:"quizzesOffered"`
+	QuizzesTaken   int            `json:"quizzesTaken"`
+	QuizPoints     int            `json:"quizPoints"`
+	Entries        []HistoryEntry `json:"entries"`
+}
+
+// Report is everything known about the polls and quizzes of a scope.
+type Report struct {
+	Scope        string           `json:"scope"`
+	GeneratedAt  time.Time        `json:"generatedAt"`
+	Polls        []PollReport     `json:"polls"`
+	Quizzes      []QuizReport     `json:"quizzes"`
+	Items        []*ItemStats     `json:"items"`
+	ResponseTime *TimeStats       `json:"responseTime,omitempty"`
+	Students     []StudentHistory `json:"students"`
+}
+
+// pollReport analyses one poll from its votes.
+func pollReport(p Poll, votes []Vote) PollReport {
+	r := PollReport{Poll: p, Votes: votes}
+	var seconds []float64
+	for _, v := range votes {
+		if !p.Anonymous {
+			seconds = append(seconds, v.VotedAt.Sub(p.CreatedAt).Seconds())
+		}
+	}
+	r.ResponseTime = timeStats(seconds)
+	if _, graded := p.Grade(Answer{}); graded {
+		r.Item = &ItemStats{ID: p.ID, Question: p.Question, Kind: p.Kind, Responses: len(votes), ResponseTime: r.ResponseTime}
+		for _, v := range votes {
+			if ok, _ := p.Grade(v.Answer); ok {
+				r.Item.Correct++
+			}
+		}
+		r.Item.setDifficulty()
+	}
+	return r
+}
+
+// quizReport analyses one quiz from its answers.
+func quizReport(q Quiz, answers []QuizAnswer) QuizReport {
+	r := QuizReport{Quiz: q, Answers: answers, Leaderboard: leaderboard(answers, len(q.Questions)-1)}
+	byStudent := make(map[string]map[string]bool)
+	itemSeconds := make([][]float64, len(q.Questions))
+	var seconds []float64
+	for i, question := range q.Questions {
+		r.Items = append(r.Items, &ItemStats{ID: q.ID + "#" + strconv.Itoa(i+1), Question: question.Question, Kind: question.Kind})
+	}
+	for _, a := range answers {
+		if a.QuestionIndex < 0 || a.QuestionIndex >= len(r.Items) {
+			continue
+		}
+		item := r.Items[a.QuestionIndex]
+		item.Responses++
+		if a.Correct {
+			item.Correct++
+		}
+		if byStudent[a.ParticipantID] == nil {
+			byStudent[a.ParticipantID] = make(map[string]bool)
+		}
+		byStudent[a.ParticipantID][item.ID] = a.Correct
+		s := float64(a.ElapsedMs) / 1000
+		itemSeconds[a.QuestionIndex] = append(itemSeconds[a.QuestionIndex], s)
+		seconds = append(seconds, s)
+	}
+	for i, item := range r.Items {
+		item.setDifficulty()
+		item.ResponseTime = timeStats(itemSeconds[i])
+	}
+	discrimination(r.Items, byStudent)
+	r.ResponseTime = timeStats(seconds)
+	return r
+}
+
+// buildReport analyses the polls and quizzes of scope. roster maps the
+// students expected to take part to their names, and only they get a
+// history; without one, everyone who took part does. participants holds
+// who voted in each anonymous poll.
+func buildReport(scope string, polls []Poll, votes map[string][]Vote, participants map[string][]string,
+	quizzes []Quiz, answers map[string][]QuizAnswer, roster map[string]string) Report {
+	rep := Report{Scope: scope, GeneratedAt: time.Now(), Polls: []PollReport{}, Quizzes: []QuizReport{}, Items: []*ItemStats{},
+		Students: []StudentHistory{}}
+	histories := make(map[string]*StudentHistory)
+	for id, name := range roster {
+		histories[id] = &StudentHistory{ParticipantID: id, Name: name, Entries: []HistoryEntry{}}
+	}
+	if roster == nil {
+		add := func(id, name string) {
+			if h := histories[id]; h == nil {
+				histories[id] = &StudentHistory{ParticipantID: id, Name: name, Entries: []HistoryEntry{}}
+			} else if h.Name == "" {
+				h.Name = name
+			}
+		}
+		for _, vs := range votes {
+			for _, v := range vs {
+				if v.VoterID != "" {
+					add(v.VoterID, "")
+				}
+			}
+		}
+		for _, ids := range participants {
+			for _, id := range ids {
+				add(id, "")
+			}
+		}
+		for _, as := range answers {
+			for _, a := range as {
+				add(a.ParticipantID, a.Name)
+			}
+		}
+	}
+
+	// Polls run oldest first, so histories read in order.
+	sort.SliceStable(polls, func(i, j int) bool { return polls[i].CreatedAt.Before(polls[j].CreatedAt) })
+	byStudent := make(map[string]map[string]bool)
+	var seconds []float64
+	for _, p := range polls {
+		pr := pollReport(p, votes[p.ID])
+		rep.Polls = append(rep.Polls, pr)
+		if pr.Item != nil {
+			rep.Items = append(rep.Items, pr.Item)
+		}
+		voted := make(map[string]*Vote)
+		for i := range pr.Votes {
+			v := &pr.Votes[i]
+			if v.VoterID != "" {
+				voted[v.VoterID] = v
+				seconds = append(seconds, v.VotedAt.Sub(p.CreatedAt).Seconds())
+			}
+		}
+		for _, id := range participants[p.ID] {
+			voted[id] = nil
+		}
+		for id, h := range histories {
+			v, answered := voted[id]
+			entry := HistoryEntry{Kind: "poll", ID: p.ID, Title: p.Question, At: p.CreatedAt, Answered: answered, Anonymous: p.Anonymous}
+			if v != nil {
+				a := v.Answer
+				entry.Answer, entry.AnswerText = &a, p.AnswerText(a)
+				s := round3(v.VotedAt.Sub(p.CreatedAt).Seconds())
+				entry.ResponseSeconds = &s
+				if correct, graded := p.Grade(v.Answer); graded {
+					entry.Correct = &correct
+					h.GradedAnswered++
+					if correct {
+						h.GradedCorrect++
+					}
+					if byStudent[id] == nil {
+						byStudent[id] = make(map[string]bool)
+					}
+					byStudent[id][p.ID] = correct
+				}
+			}
+			h.PollsOffered++
+			if answered {
+				h.PollsAnswered++
+			}
+			h.Entries = append(h.Entries, entry)
+		}
+	}
+	discrimination(rep.Items, byStudent)
+
+	sort.SliceStable(quizzes, func(i, j int) bool { return quizzes[i].CreatedAt.Before(quizzes[j].CreatedAt) })
+	for _, q := range quizzes {
+		qr := quizReport(q, answers[q.ID])
+		rep.Quizzes = append(rep.Quizzes, qr)
+		rep.Items = append(rep.Items, qr.Items...)
+		for _, a := range qr.Answers {
+			seconds = append(seconds, float64(a.ElapsedMs)/1000)
+		}
+		standings := make(map[string]Standing)
+		for _, st := range qr.Leaderboard {
+			standings[st.ParticipantID] = st
+		}
+		for id, h := range histories {
+			st, took := standings[id]
+			entry := HistoryEntry{Kind: "quiz", ID: q.ID, Title: q.Title, At: q.CreatedAt, Answered: took}
+			h.QuizzesOffered++
+			if took {
+				score, rank, right := st.Score, st.Rank, st.Correct
+				entry.Score, entry.Rank, entry.QuestionsRight = &score, &rank, &right
+				h.QuizzesTaken++
+				h.QuizPoints += st.Score
+			}
+			h.Entries = append(h.Entries, entry)
+		}
+	}
+	rep.ResponseTime = timeStats(seconds)
+
+	for _, h := range histories {
+		if h.PollsOffered > 0 {
+			h.Participation = round3(float64(h.PollsAnswered) / float64(h.PollsOffered))
+		}
+		sort.SliceStable(h.Entries, func(i, j int) bool { return h.Entries[i].At.Before(h.Entries[j].At) })
+		rep.Students = append(rep.Students, *h)
+	}
+	sort.Slice(rep.Students, func(i, j int) bool {
+		if rep.Students[i].Name != rep.Students[j].Name {
+			return rep.Students[i].Name < rep.Students[j].Name
+		}
+		return rep.Students[i].ParticipantID < rep.Students[j].ParticipantID
+	})
+	return rep
+}
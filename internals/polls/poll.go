@@ -0,0 +1,326 @@
+package polls
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Poll statuses.
+const (
+	StatusOpen   = "open"
+	StatusClosed = "closed"
+)
+
+// Poll kinds. Each kind takes its own Answer shape and has its own
+// Summary of the results.
+const (
+	KindSingle    = "single"    // one choice
+	KindMulti     = "multi"     // any number of choices, up to MaxChoices
+	KindRanked    = "ranked"    // choices in order of preference, tallied by instant runoff
+	KindRating    = "rating"    // a rating from 1 to MaxRating
+	KindWordCloud = "wordcloud" // a few words, counted into a word cloud
+	KindText      = "text"      // a free-text response
+	KindNumeric   = "numeric"   // a number, correct within Tolerance
+)
+
+const (
+	// MaxOptions caps the number of options of a poll.
+	MaxOptions = 10
+	// MaxRating is the top of the rating scale.
+	MaxRating = 5
+	// MaxMinResponses caps the response threshold of a poll.
+	MaxMinResponses = 1000
+
+	maxWordCloudLen = 100
+	maxTextLen      = 1000
+)
+
+var (
+	ErrUnavailable  = errors.New("polls are unavailable")
+	ErrNotFound     = errors.New("poll not found")
+	ErrForbidden    = errors.New("only staff can do that")
+	ErrInvalidPoll  = errors.New("a poll needs a question, a known kind and, for choice polls, 2 to 10 options")
+	ErrClosed       = errors.New("this poll is not open")
+	ErrBadOption    = errors.New("invalid poll option")
+	ErrBadAnswer    = errors.New("invalid answer for this poll")
+	ErrAlreadyVoted = errors.New("you have already voted")
+	ErrNotVoter     = errors.New("sign in with a registered ID to vote")
+)
+
+// Poll is a question put to a meeting room or a course. Results, Total and
+// Summary are derived from the stored votes, one per voter. With
+// AllowChange a voter may replace their vote while the poll is open.
+//
+// An Anonymous poll records who voted apart from the ballots, so no answer
+// can be traced to a voter; its votes cannot be changed. HideResults keeps
+// the results from participants until the poll closes, and no one sees
+// them before MinResponses votes are in. Redact applies both.
+//
+// Results counts votes per option for choice kinds (first preferences for
+// ranked polls) and per rating for rating polls, whose Options are the
+// labels "1" to "5". CorrectAnswer grades single-choice polls,
+// CorrectAnswers multi-select ones and CorrectNumber numeric ones.
+type Poll struct {
+	ID             string     `json:"id"`
+	Scope          string     `json:"scope"`
+	Kind           string     `json:"kind"`
+	Question       string     `json:"question"`
+	Options        []string   `json:"options"`
+	MaxChoices     int        `json:"maxChoices,omitempty"`
+	CorrectAnswer  *int       `json:"correctAnswer,omitempty"`
+	CorrectAnswers []int      `json:"correctAnswers,omitempty"`
+	CorrectNumber  *float64   `json:"correctNumber,omitempty"`
+	Tolerance      float64    `json:"tolerance,omitempty"`
+	AllowChange    bool       `json:"allowVoteChange"`
+	Anonymous      bool       `json:"anonymous"`
+	HideResults    bool       `json:"hideResults"`
+	MinResponses   int        `json:"minResponses,omitempty"`
+	ResultsHidden  bool       `json:"resultsHidden,omitempty"`
+	Status         string     `json:"status"`
+	Results        []int      `json:"results"`
+	Total          int        `json:"totalVotes"`
+	Summary        *Summary   `json:"summary,omitempty"`
+	CreatedBy      string     `json:"createdBy"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	ClosedBy       string     `json:"closedBy,omitempty"`
+	ClosedAt       *time.Time `json:"closedAt,omitempty"`
+}
+
+// Closed reports whether the poll no longer takes votes.
+func (p *Poll) Closed() bool {
+	return p.Status == StatusClosed
+}
+
+// ResultsVisible reports whether the results may be shown: never before
+// MinResponses votes are in and, with HideResults, only to staff until the
+// poll closes.
+func (p *Poll) ResultsVisible(isStaff bool) bool {
+	if p.Total < p.MinResponses {
+		return false
+	}
+	return !p.HideResults || isStaff || p.Closed()
+}
+
+// Redact blanks the results and summary if they may not be shown, leaving
+// the vote count, and sets ResultsHidden.
+func (p *Poll) Redact(isStaff bool) {
+	if p.ResultsVisible(isStaff) {
+		return
+	}
+	p.Results = make([]int, len(p.Options))
+	p.Summary = nil
+	p.ResultsHidden = true
+}
+
+// RedactSnapshot blanks a snapshot of p taken before MinResponses votes
+// were in.
+func (p *Poll) RedactSnapshot(s *Snapshot) {
+	if s.Total >= p.MinResponses {
+		return
+	}
+	s.Results = make([]int, len(s.Results))
+	s.Summary = nil
+}
+
+// Snapshot is a poll's results at one moment, kept for later review. One
+// is taken when a poll closes; staff can take more while it is open.
+type Snapshot struct {
+	ID      int64     `json:"id"`
+	PollID  string    `json:"pollId"`
+	Results []int     `json:"results"`
+	Total   int       `json:"totalVotes"`
+	Summary *Summary  `json:"summary,omitempty"`
+	Reason  string    `json:"reason"` // closed or manual
+	TakenBy string    `json:"takenBy"`
+	TakenAt time.Time `json:"takenAt"`
+}
+
+// Actor is whoever creates, votes in or closes a poll.
+type Actor struct {
+	ID      string
+	Name    string
+	IsStaff bool
+}
+
+// MeetingScope names the polls of a meeting room.
+func MeetingScope(roomID string) string {
+	return "meeting:" + roomID
+}
+
+// CourseScope names the polls of a course.
+func CourseScope(courseCode string) string {
+	return "course:" + courseCode
+}
+
+// ScopeRoom returns the meeting room of a meeting scope.
+func ScopeRoom(scope string) (string, bool) {
+	if !strings.HasPrefix(scope, "meeting:") {
+		return "", false
+	}
+	return strings.TrimPrefix(scope, "meeting:"), true
+}
+
+// Answer is one voter's response. Choices holds the chosen option indexes
+// for single, multi and ranked polls (in order of preference for ranked);
+// the other kinds use Rating, Text or Number.
+type Answer struct {
+	Choices []int    `json:"choices,omitempty"`
+	Rating  int      `json:"rating,omitempty"`
+	Text    string   `json:"text,omitempty"`
+	Number  *float64 `json:"number,omitempty"`
+}
+
+// hasChoices reports whether the poll's answers are option indexes.
+func (p *Poll) hasChoices() bool {
+	return p.Kind == KindSingle || p.Kind == KindMulti || p.Kind == KindRanked
+}
+
+// normalize trims the question and options and checks the poll's shape
+// for its kind.
+func (p *Poll) normalize() error {
+	if p.Kind == "" {
+		p.Kind = KindSingle
+	}
+	p.Question = strings.TrimSpace(p.Question)
+	if p.Question == "" {
+		return ErrInvalidPoll
+	}
+	options := make([]string, 0, len(p.Options))
+	for _, o := range p.Options {
+		if o = strings.TrimSpace(o); o != "" {
+			options = append(options, o)
+		}
+	}
+	p.Options = options
+
+	switch p.Kind {
+	case KindSingle, KindMulti, KindRanked:
+		if len(p.Options) < 2 || len(p.Options) > MaxOptions {
+			return ErrInvalidPoll
+		}
+	case KindRating:
+		p.Options = make([]string, MaxRating)
+		for i := range p.Options {
+			p.Options[i] = strconv.Itoa(i + 1)
+		}
+	case KindWordCloud, KindText, KindNumeric:
+		p.Options = []string{}
+	default:
+		return ErrInvalidPoll
+	}
+
+	if p.Kind != KindSingle || (p.CorrectAnswer != nil && (*p.CorrectAnswer < 0 || *p.CorrectAnswer >= len(p.Options))) {
+		p.CorrectAnswer = nil
+	}
+	if p.Kind == KindMulti {
+		if p.MaxChoices < 0 || p.MaxChoices > len(p.Options) {
+			p.MaxChoices = 0
+		}
+		p.CorrectAnswers = uniqueChoices(p.CorrectAnswers, len(p.Options))
+	} else {
+		p.MaxChoices = 0
+		p.CorrectAnswers = nil
+	}
+	if p.Kind != KindNumeric || (p.CorrectNumber != nil && (math.IsNaN(*p.CorrectNumber) || math.IsInf(*p.CorrectNumber, 0))) {
+		p.CorrectNumber = nil
+	}
+	if p.Kind != KindNumeric || p.Tolerance < 0 || math.IsNaN(p.Tolerance) || math.IsInf(p.Tolerance, 0) {
+		p.Tolerance = 0
+	}
+	if p.Anonymous {
+		p.AllowChange = false
+	}
+	p.MinResponses = min(max(p.MinResponses, 0), MaxMinResponses)
+	p.ResultsHidden = false
+	return nil
+}
+
+// uniqueChoices returns the valid indexes of choices, sorted and without
+// duplicates.
+func uniqueChoices(choices []int, n int) []int {
+	seen := make(map[int]bool)
+	out := []int{}
+	for _, c := range choices {
+		if c >= 0 && c < n && !seen[c] {
+			seen[c] = true
+			out = append(out, c)
+		}
+	}
+	sort.Ints(out)
+	return out
+}
+
+// check validates a against the poll's kind and normalizes it: multi
+// choices are sorted, text is trimmed and fields the kind does not use are
+// cleared.
+func (p *Poll) check(a *Answer) error {
+	if p.hasChoices() {
+		n := len(a.Choices)
+		if n == 0 || (p.Kind == KindSingle && n != 1) || (p.Kind == KindMulti && p.MaxChoices > 0 && n > p.MaxChoices) {
+			return ErrBadOption
+		}
+		seen := make(map[int]bool)
+		for _, c := range a.Choices {
+			if c < 0 || c >= len(p.Options) || seen[c] {
+				return ErrBadOption
+			}
+			seen[c] = true
+		}
+		if p.Kind == KindMulti {
+			sort.Ints(a.Choices)
+		}
+		*a = Answer{Choices: a.Choices}
+		return nil
+	}
+
+	switch p.Kind {
+	case KindRating:
+		if a.Rating < 1 || a.Rating > MaxRating {
+			return ErrBadAnswer
+		}
+		*a = Answer{Rating: a.Rating}
+	case KindWordCloud, KindText:
+		text := strings.TrimSpace(a.Text)
+		limit := maxTextLen
+		if p.Kind == KindWordCloud {
+			limit = maxWordCloudLen
+		}
+		if text == "" || len(text) > limit {
+			return ErrBadAnswer
+		}
+		*a = Answer{Text: text}
+	case KindNumeric:
+		if a.Number == nil || math.IsNaN(*a.Number) || math.IsInf(*a.Number, 0) {
+			return ErrBadAnswer
+		}
+		*a = Answer{Number: a.Number}
+	}
+	return nil
+}
+
+// Grade reports whether a is correct. graded is false when the poll has no
+// correct answer to compare with.
+func (p *Poll) Grade(a Answer) (correct, graded bool) {
+	switch {
+	case p.Kind == KindSingle && p.CorrectAnswer != nil:
+		return len(a.Choices) == 1 && a.Choices[0] == *p.CorrectAnswer, true
+	case p.Kind == KindMulti && len(p.CorrectAnswers) > 0:
+		got := uniqueChoices(a.Choices, len(p.Options))
+		if len(got) != len(p.CorrectAnswers) {
+			return false, true
+		}
+		for i := range got {
+			if got[i] != p.CorrectAnswers[i] {
+				return false, true
+			}
+		}
+		return true, true
+	case p.Kind == KindNumeric && p.CorrectNumber != nil:
+		return a.Number != nil && math.Abs(*a.Number-*p.CorrectNumber) <= p.Tolerance+1e-9, true
+	}
+	return false, false
+}
@@ -0,0 +1,276 @@
+package polls
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Quiz statuses. A quiz starts in the lobby, then alternates between a
+// question taking answers and a review of its results until it finishes.
+const (
+	QuizLobby    = "lobby"
+	QuizRunning  = "question"
+	QuizReview   = "review"
+	QuizFinished = "finished"
+)
+
+const (
+	// MaxQuizQuestions caps the length of a quiz.
+	MaxQuizQuestions = 50
+	// DefaultTimeLimit is the seconds a question runs for unless set.
+	DefaultTimeLimit = 20
+	// DefaultPoints is the most a correct answer can earn unless set.
+	DefaultPoints = 1000
+
+	minTimeLimit   = 5
+	maxTimeLimit   = 300
+	maxPoints      = 5000
+	streakBonus    = 100 // per correct answer in a row after the first
+	maxStreakBonus = 500
+)
+
+var (
+	ErrInvalidQuiz = errors.New("a quiz needs a title and 1 to 50 questions, each with a correct answer")
+	ErrQuizState   = errors.New("the quiz is not at that step")
+	ErrTimeUp      = errors.New("time is up for this question")
+)
+
+// QuizQuestion is one question of a quiz. It takes the same answers as a
+// single-choice, multi-select or numeric poll and must say which answer
+// is correct.
+type QuizQuestion struct {
+	Kind           string   `json:"kind"`
+	Question       string   `json:"question"`
+	Options        []string `json:"options"`
+	MaxChoices     int      `json:"maxChoices,omitempty"`
+	CorrectAnswer  *int     `json:"correctAnswer,omitempty"`
+	CorrectAnswers []int    `json:"correctAnswers,omitempty"`
+	CorrectNumber  *float64 `json:"correctNumber,omitempty"`
+	Tolerance      float64  `json:"tolerance,omitempty"`
+	TimeLimit      int      `json:"timeLimit"` // seconds
+	Points         int      `json:"points"`
+}
+
+// poll returns the question as a poll, to reuse its checks and grading.
+func (q QuizQuestion) poll() Poll {
+	return Poll{
+		Kind:           q.Kind,
+		Question:       q.Question,
+		Options:        q.Options,
+		MaxChoices:     q.MaxChoices,
+		CorrectAnswer:  q.CorrectAnswer,
+		CorrectAnswers: q.CorrectAnswers,
+		CorrectNumber:  q.CorrectNumber,
+		Tolerance:      q.Tolerance,
+	}
+}
+
+// HideAnswer clears the correct answer, for students while the question
+// is running.
+func (q *QuizQuestion) HideAnswer() {
+	q.CorrectAnswer = nil
+	q.CorrectAnswers = nil
+	q.CorrectNumber = nil
+}
+
+// Quiz is a timed, scored run of questions in a course or meeting room.
+// Current is the index of the running or last question, -1 in the lobby.
+// The server runs each question's countdown; answers after Deadline are
+// refused.
+type Quiz struct {
+	ID                string         `json:"id"`
+	Scope             string         `json:"scope"`
+	Title             string         `json:"title"`
+	Questions         []QuizQuestion `json:"questions,omitempty"`
+	QuestionCount     int            `json:"questionCount"`
+	Status            string         `json:"status"`
+	Current           int            `json:"current"`
+	QuestionStartedAt *time.Time     `json:"questionStartedAt,omitempty"`
+	Deadline          *time.Time     `json:"deadline,omitempty"`
+	CreatedBy         string         `json:"createdBy"`
+	CreatedAt         time.Time      `json:"createdAt"`
+	FinishedAt        *time.Time     `json:"finishedAt,omitempty"`
+
+	// CurrentQuestion is the running or last question, filled in for
+	// views that leave out Questions.
+	CurrentQuestion *QuizQuestion `json:"question,omitempty"`
+}
+
+// normalize trims the title and checks every question, filling in the
+// default time limit and points.
+func (q *Quiz) normalize() error {
+	q.Title = strings.TrimSpace(q.Title)
+	if q.Title == "" || len(q.Questions) == 0 || len(q.Questions) > MaxQuizQuestions {
+		return ErrInvalidQuiz
+	}
+	for i := range q.Questions {
+		qq := &q.Questions[i]
+		p := qq.poll()
+		if p.Kind != "" && p.Kind != KindSingle && p.Kind != KindMulti && p.Kind != KindNumeric {
+			return ErrInvalidQuiz
+		}
+		if err := p.normalize(); err != nil {
+			return ErrInvalidQuiz
+		}
+		if _, graded := p.Grade(Answer{}); !graded {
+			return ErrInvalidQuiz
+		}
+		qq.Kind, qq.Question, qq.Options, qq.MaxChoices = p.Kind, p.Question, p.Options, p.MaxChoices
+		qq.CorrectAnswer, qq.CorrectAnswers, qq.CorrectNumber, qq.Tolerance = p.CorrectAnswer, p.CorrectAnswers, p.CorrectNumber, p.Tolerance
+		if qq.TimeLimit == 0 {
+			qq.TimeLimit = DefaultTimeLimit
+		}
+		if qq.TimeLimit < minTimeLimit || qq.TimeLimit > maxTimeLimit {
+			return ErrInvalidQuiz
+		}
+		if qq.Points == 0 {
+			qq.Points = DefaultPoints
+		}
+		if qq.Points < 0 || qq.Points > maxPoints {
+			return ErrInvalidQuiz
+		}
+	}
+	q.QuestionCount = len(q.Questions)
+	return nil
+}
+
+// QuizAnswer is one participant's answer to one question, scored when it
+// arrives. Streak counts the correct answers in a row ending here.
+type QuizAnswer struct {
+	QuizID        string    `json:"quizId"`
+	QuestionIndex int       `json:"questionIndex"`
+	ParticipantID string    `json:"participantId"`
+	Name          string    `json:"name"`
+	Answer        Answer    `json:"answer"`
+	Correct       bool      `json:"correct"`
+	ElapsedMs     int       `json:"elapsedMs"`
+	Points        int       `json:"points"`
+	Streak        int       `json:"streak"`
+	AnsweredAt    time.Time `json:"answeredAt"`
+}
+
+// score sets the points of a: a correct answer earns between half and all
+// of the question's points, less the slower it came, plus a bonus for
+// each correct answer in a row before it.
+func (a *QuizAnswer) score(q QuizQuestion, prevStreak int) {
+	a.Points, a.Streak = 0, 0
+	if !a.Correct {
+		return
+	}
+	a.Streak = prevStreak + 1
+	limit := float64(q.TimeLimit * 1000)
+	elapsed := math.Min(math.Max(float64(a.ElapsedMs), 0), limit)
+	a.Points = int(math.Round(float64(q.Points) * (1 - elapsed/limit/2)))
+	a.Points += min(streakBonus*(a.Streak-1), maxStreakBonus)
+}
+
+// QuestionStats sums up the answers to one question. While the question
+// runs only Answered is filled in.
+type QuestionStats struct {
+	Index    int      `json:"index"`
+	Answered int      `json:"answered"`
+	Correct  int      `json:"correct"`
+	Results  []int    `json:"results,omitempty"`
+	Summary  *Summary `json:"summary,omitempty"`
+}
+
+// questionStats counts the answers to question idx of q.
+func (q *Quiz) questionStats(idx int, answers []QuizAnswer) QuestionStats {
+	stats := QuestionStats{Index: idx}
+	p := q.Questions[idx].poll()
+	given := []Answer{}
+	for _, a := range answers {
+		if a.QuestionIndex != idx {
+			continue
+		}
+		given = append(given, a.Answer)
+		if a.Correct {
+			stats.Correct++
+		}
+	}
+	p.aggregate(given)
+	stats.Answered = len(given)
+	stats.Results, stats.Summary = p.Results, p.Summary
+	return stats
+}
+
+// Standing is one participant's place on a quiz leaderboard. Streak is
+// the run of correct answers up to the last finished question;
+// LastCorrect and LastPoints are their result on it.
+type Standing struct {
+	Rank          int    `json:"rank"`
+	ParticipantID string `json:"participantId"`
+	Name          string `json:"name"`
+	Score         int    `json:"score"`
+	Correct       int    `json:"correct"`
+	Answered      int    `json:"answered"`
+	Streak        int    `json:"streak"`
+	BestStreak    int    `json:"bestStreak"`
+	LastCorrect   bool   `json:"lastCorrect"`
+	LastPoints    int    `json:"lastPoints"`
+}
+
+// leaderboard ranks everyone who answered, highest score first. upTo is
+// the last question counted; participants tied on score share a rank.
+func leaderboard(answers []QuizAnswer, upTo int) []Standing {
+	byID := make(map[string]*Standing)
+	order := []string{}
+	for _, a := range answers {
+		if a.QuestionIndex > upTo {
+			continue
+		}
+		s := byID[a.ParticipantID]
+		if s == nil {
+			s = &Standing{ParticipantID: a.ParticipantID}
+			byID[a.ParticipantID] = s
+			order = append(order, a.ParticipantID)
+		}
+		s.Name = a.Name
+		s.Score += a.Points
+		s.Answered++
+		if a.Correct {
+			s.Correct++
+		}
+		s.BestStreak = max(s.BestStreak, a.Streak)
+		if a.QuestionIndex == upTo {
+			s.Streak, s.LastCorrect, s.LastPoints = a.Streak, a.Correct, a.Points
+		}
+	}
+
+	board := make([]Standing, 0, len(order))
+	for _, id := range order {
+		board = append(board, *byID[id])
+	}
+	sort.SliceStable(board, func(i, j int) bool {
+		if board[i].Score != board[j].Score {
+			return board[i].Score > board[j].Score
+		}
+		if board[i].Correct != board[j].Correct {
+			return board[i].Correct > board[j].Correct
+		}
+		return board[i].Name < board[j].Name
+	})
+	for i := range board {
+		if i > 0 && board[i].Score == board[i-1].Score {
+			board[i].Rank = board[i-1].Rank
+		} else {
+			board[i].Rank = i + 1
+		}
+	}
+	return board
+}
+
+// QuizResult is a participant's final result in a finished quiz, kept for
+// their record.
+type QuizResult struct {
+	QuizID     string       `json:"quizId"`
+	QuizTitle  string       `json:"quizTitle"`
+	Scope      string       `json:"scope"`
+	Standing   Standing     `json:"standing"`
+	Questions  int          `json:"questions"`
+	Answers    []QuizAnswer `json:"answers,omitempty"`
+	FinishedAt time.Time    `json:"finishedAt"`
+}
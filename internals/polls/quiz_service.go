@@ -0,0 +1,295 @@
+package polls
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Quiz event types passed to Service.Publish.
+const (
+	EventQuizCreated  = "quiz-created"
+	EventQuizQuestion = "quiz-question"
+	EventQuizAnswer   = "quiz-answers"
+	EventQuizReview   = "quiz-review"
+	EventQuizFinished = "quiz-finished"
+)
+
+// CreateQuiz puts a quiz in the lobby of scope. Only staff can create
+// quizzes.
+func (s *Service) CreateQuiz(scope string, actor Actor, q Quiz) (Quiz, error) {
+	if s.Store == nil {
+		return q, ErrUnavailable
+	}
+	if !actor.IsStaff {
+		return q, ErrForbidden
+	}
+	if err := q.normalize(); err != nil {
+		return q, err
+	}
+	q.ID = "quiz-" + uuid.New().String()
+	q.Scope = scope
+	q.Status = QuizLobby
+	q.Current = -1
+	q.QuestionStartedAt, q.Deadline, q.FinishedAt = nil, nil, nil
+	q.CreatedBy = actor.ID
+	if err := s.Store.CreateQuiz(&q); err != nil {
+		return q, err
+	}
+	s.publish(Event{Type: EventQuizCreated, Quiz: q})
+	return q, nil
+}
+
+// GetQuiz returns a quiz. A question whose countdown ran out while no
+// timer was watching it, such as across a restart, is ended first.
+func (s *Service) GetQuiz(id string) (Quiz, error) {
+	if s.Store == nil {
+		return Quiz{}, ErrUnavailable
+	}
+	q, err := s.Store.GetQuiz(id)
+	if err != nil {
+		return q, err
+	}
+	if q.Status == QuizRunning && q.Deadline != nil && time.Now().After(*q.Deadline) {
+		if ended, err := s.endQuestion(id, q.Current); err == nil {
+			return ended, nil
+		} else if !errors.Is(err, ErrQuizState) {
+			return q, err
+		}
+		return s.Store.GetQuiz(id)
+	}
+	return q, nil
+}
+
+// ListQuizzes returns the quizzes of scope, newest first; with active
+// only the unfinished ones.
+func (s *Service) ListQuizzes(scope string, active bool) ([]Quiz, error) {
+	if s.Store == nil {
+		return nil, ErrUnavailable
+	}
+	return s.Store.ListQuizzes(scope, active)
+}
+
+// quizFor loads a quiz of scope for a staff action.
+func (s *Service) quizFor(scope, id string, actor Actor) (Quiz, error) {
+	q, err := s.GetQuiz(id)
+	if err != nil {
+		return q, err
+	}
+	if q.Scope != scope {
+		return q, ErrNotFound
+	}
+	if !actor.IsStaff {
+		return q, ErrForbidden
+	}
+	return q, nil
+}
+
+// NextQuestion starts the next question of a quiz in the lobby or between
+// questions, or finishes the quiz after its last question. The question
+// ends by itself when its time limit runs out. Only staff can move a quiz
+// on.
+func (s *Service) NextQuestion(scope, id string, actor Actor) (Quiz, error) {
+	q, err := s.quizFor(scope, id, actor)
+	if err != nil {
+		return q, err
+	}
+	if q.Status != QuizLobby && q.Status != QuizReview {
+		return q, ErrQuizState
+	}
+	idx := q.Current + 1
+	if idx >= len(q.Questions) {
+		return s.finish(q)
+	}
+
+	limit := time.Duration(q.Questions[idx].TimeLimit) * time.Second
+	startedAt := time.Now()
+	deadline := startedAt.Add(limit)
+	if err := s.Store.StartQuestion(id, q.Current, idx, startedAt, deadline); err != nil {
+		return q, err
+	}
+	q.Status, q.Current = QuizRunning, idx
+	q.QuestionStartedAt, q.Deadline = &startedAt, &deadline
+
+	s.timersMu.Lock()
+	if s.timers == nil {
+		s.timers = make(map[string]*time.Timer)
+	}
+	s.timers[id] = time.AfterFunc(limit, func() {
+		if _, err := s.endQuestion(id, idx); err != nil && !errors.Is(err, ErrQuizState) {
+			log.Println("quiz countdown:", err)
+		}
+	})
+	s.timersMu.Unlock()
+
+	s.publish(Event{Type: EventQuizQuestion, Quiz: q})
+	return q, nil
+}
+
+// EndQuestion ends the running question of a quiz before its time runs
+// out. Only staff can end questions early.
+func (s *Service) EndQuestion(scope, id string, actor Actor) (Quiz, error) {
+	q, err := s.quizFor(scope, id, actor)
+	if err != nil {
+		return q, err
+	}
+	if q.Status != QuizRunning {
+		return q, ErrQuizState
+	}
+	return s.endQuestion(id, q.Current)
+}
+
+// endQuestion stops question idx taking answers and publishes its results
+// with the leaderboard so far.
+func (s *Service) endQuestion(id string, idx int) (Quiz, error) {
+	s.timersMu.Lock()
+	if t := s.timers[id]; t != nil {
+		t.Stop()
+		delete(s.timers, id)
+	}
+	s.timersMu.Unlock()
+
+	if err := s.Store.EndQuestion(id, idx); err != nil {
+		return Quiz{}, err
+	}
+	q, err := s.Store.GetQuiz(id)
+	if err != nil {
+		return q, err
+	}
+	answers, err := s.Store.QuizAnswers(id, "")
+	if err != nil {
+		return q, err
+	}
+	stats := q.questionStats(idx, answers)
+	s.publish(Event{Type: EventQuizReview, Quiz: q, Stats: &stats, Leaderboard: leaderboard(answers, idx)})
+	return q, nil
+}
+
+// FinishQuiz ends a quiz, cutting short a running question, and keeps
+// every participant's final result. Only staff can finish quizzes.
+func (s *Service) FinishQuiz(scope, id string, actor Actor) (Quiz, error) {
+	q, err := s.quizFor(scope, id, actor)
+	if err != nil {
+		return q, err
+	}
+	if q.Status == QuizRunning {
+		if q, err = s.endQuestion(id, q.Current); err != nil {
+			return q, err
+		}
+	}
+	return s.finish(q)
+}
+
+// finish ranks the answers of a quiz between questions, when no more can
+// arrive, and stores the results together with the finished status.
+func (s *Service) finish(q Quiz) (Quiz, error) {
+	answers, err := s.Store.QuizAnswers(q.ID, "")
+	if err != nil {
+		return q, err
+	}
+	board := leaderboard(answers, q.Current)
+	if err := s.Store.FinishQuiz(q, board); err != nil {
+		return q, err
+	}
+	if q, err = s.Store.GetQuiz(q.ID); err != nil {
+		return q, err
+	}
+	s.publish(Event{Type: EventQuizFinished, Quiz: q, Leaderboard: board})
+	return q, nil
+}
+
+// Answer records voter's answer to question idx of a quiz of scope,
+// scored by correctness, the time it took since the question started and
+// the voter's streak. Each voter answers a question once, before its time
+// runs out. The published event only counts the answers; scores are
+// revealed when the question ends.
+func (s *Service) Answer(scope, id string, voter Actor, idx int, a Answer) (QuizAnswer, error) {
+	if voter.ID == "" {
+		return QuizAnswer{}, ErrNotVoter
+	}
+	q, err := s.GetQuiz(id)
+	if err != nil {
+		return QuizAnswer{}, err
+	}
+	if q.Scope != scope {
+		return QuizAnswer{}, ErrNotFound
+	}
+	if q.Current != idx || (q.Status != QuizRunning && q.Status != QuizReview) {
+		return QuizAnswer{}, ErrQuizState
+	}
+	now := time.Now()
+	if q.Status != QuizRunning || q.Deadline == nil || now.After(*q.Deadline) {
+		return QuizAnswer{}, ErrTimeUp
+	}
+	question := q.Questions[idx]
+	p := question.poll()
+	if err := p.check(&a); err != nil {
+		return QuizAnswer{}, err
+	}
+
+	qa := QuizAnswer{
+		QuizID:        id,
+		QuestionIndex: idx,
+		ParticipantID: voter.ID,
+		Name:          voter.Name,
+		Answer:        a,
+		ElapsedMs:     int(now.Sub(*q.QuestionStartedAt) / time.Millisecond),
+	}
+	qa.Correct, _ = p.Grade(a)
+	prevStreak := 0
+	if idx > 0 {
+		if prevStreak, err = s.Store.Streak(id, idx-1, voter.ID); err != nil {
+			return qa, err
+		}
+	}
+	qa.score(question, prevStreak)
+	if err := s.Store.AddQuizAnswer(&qa); err != nil {
+		return qa, err
+	}
+
+	n, err := s.Store.CountAnswers(id, idx)
+	if err != nil {
+		return qa, err
+	}
+	s.publish(Event{Type: EventQuizAnswer, Quiz: q, Voter: voter, Stats: &QuestionStats{Index: idx, Answered: n}})
+	return qa, nil
+}
+
+// Leaderboard returns the standings of a quiz up to its last finished
+// question.
+func (s *Service) Leaderboard(id string) ([]Standing, error) {
+	q, err := s.GetQuiz(id)
+	if err != nil {
+		return nil, err
+	}
+	answers, err := s.Store.QuizAnswers(id, "")
+	if err != nil {
+		return nil, err
+	}
+	upTo := q.Current
+	if q.Status == QuizRunning {
+		upTo--
+	}
+	return leaderboard(answers, upTo), nil
+}
+
+// Results returns the saved results of a finished quiz, or with a
+// participantID that participant's results in every quiz. For one quiz
+// and one participant, their answers are included.
+func (s *Service) Results(quizID, participantID string) ([]QuizResult, error) {
+	if s.Store == nil {
+		return nil, ErrUnavailable
+	}
+	results, err := s.Store.Results(quizID, participantID)
+	if err != nil || quizID == "" || participantID == "" {
+		return results, err
+	}
+	for i := range results {
+		if results[i].Answers, err = s.Store.QuizAnswers(quizID, participantID); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,287 @@
+package polls
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const quizColumns = `id, scope, title, questions, status, current_index, question_started_at, deadline,
+	created_by, created_at, finished_at`
+
+func scanQuiz(row rowScanner) (Quiz, error) {
+	var q Quiz
+	var questions []byte
+	var startedAt, deadline, finishedAt sql.NullTime
+	err := row.Scan(&q.ID, &q.Scope, &q.Title, &questions, &q.Status, &q.Current, &startedAt, &deadline,
+		&q.CreatedBy, &q.CreatedAt, &finishedAt)
+	if err != nil {
+		return q, err
+	}
+	if err := json.Unmarshal(questions, &q.Questions); err != nil {
+		return q, err
+	}
+	q.QuestionCount = len(q.Questions)
+	if startedAt.Valid {
+		q.QuestionStartedAt = &startedAt.Time
+	}
+	if deadline.Valid {
+		q.Deadline = &deadline.Time
+	}
+	if finishedAt.Valid {
+		q.FinishedAt = &finishedAt.Time
+	}
+	return q, nil
+}
+
+// CreateQuiz stores a new quiz.
+func (s *Store) CreateQuiz(q *Quiz) error {
+	questions, err := json.Marshal(q.Questions)
+	if err != nil {
+		return err
+	}
+	return s.db.QueryRow(
+		`INSERT INTO quizzes (id, scope, title, questions, status, current_index, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING created_at`,
+		q.ID, q.Scope, q.Title, questions, q.Status, q.Current, q.CreatedBy,
+	).Scan(&q.CreatedAt)
+}
+
+// GetQuiz loads a quiz.
+func (s *Store) GetQuiz(id string) (Quiz, error) {
+	q, err := scanQuiz(s.db.QueryRow(`SELECT `+quizColumns+` FROM quizzes WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return q, ErrNotFound
+	}
+	return q, err
+}
+
+// ListQuizzes returns the quizzes of scope, newest first. With active only
+// unfinished ones are returned.
+func (s *Store) ListQuizzes(scope string, active bool) ([]Quiz, error) {
+	rows, err := s.db.Query(
+		`SELECT `+quizColumns+` FROM quizzes
+		 WHERE scope = $1 AND (NOT $2 OR status <> 'finished')
+		 ORDER BY created_at DESC`, scope, active,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Quiz{}
+	for rows.Next() {
+		q, err := scanQuiz(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, q)
+	}
+	return list, rows.Err()
+}
+
+// StartQuestion moves a quiz from the lobby or the review of question
+// from to question idx. It returns ErrQuizState if the quiz has moved on.
+func (s *Store) StartQuestion(id string, from, idx int, startedAt, deadline time.Time) error {
+	res, err := s.db.Exec(
+		`UPDATE quizzes SET status = 'question', current_index = $3, question_started_at = $4, deadline = $5
+		 WHERE id = $1 AND current_index = $2 AND status IN ('lobby', 'review')`,
+		id, from, idx, startedAt.UTC(), deadline.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrQuizState
+	}
+	return nil
+}
+
+// EndQuestion stops question idx taking answers. It returns ErrQuizState
+// if the question already ended.
+func (s *Store) EndQuestion(id string, idx int) error {
+	res, err := s.db.Exec(
+		`UPDATE quizzes SET status = 'review' WHERE id = $1 AND current_index = $2 AND status = 'question'`, id, idx,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrQuizState
+	}
+	return nil
+}
+
+// FinishQuiz marks a quiz between questions finished and stores the final
+// standing of everyone in it, both or neither. It returns ErrQuizState if
+// the quiz has moved on from q.Current or already finished.
+func (s *Store) FinishQuiz(q Quiz, board []Standing) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(
+		`UPDATE quizzes SET status = 'finished', deadline = NULL, finished_at = NOW()
+		 WHERE id = $1 AND current_index = $2 AND status IN ('lobby', 'review')`, q.ID, q.Current,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrQuizState
+	}
+	for _, st := range board {
+		if _, err := tx.Exec(
+			`INSERT INTO quiz_results (quiz_id, participant_id, participant_name, rank, score, correct,
+				answered, best_streak, questions)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			 ON CONFLICT (quiz_id, participant_id) DO UPDATE SET
+				participant_name = EXCLUDED.participant_name, rank = EXCLUDED.rank, score = EXCLUDED.score,
+				correct = EXCLUDED.correct, answered = EXCLUDED.answered, best_streak = EXCLUDED.best_streak,
+				questions = EXCLUDED.questions`,
+			q.ID, st.ParticipantID, st.Name, st.Rank, st.Score, st.Correct, st.Answered, st.BestStreak, len(q.Questions),
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Streak returns participantID's streak after question idx, 0 if they
+// did not answer it correctly.
+func (s *Store) Streak(quizID string, idx int, participantID string) (int, error) {
+	var streak int
+	err := s.db.QueryRow(
+		`SELECT streak FROM quiz_answers WHERE quiz_id = $1 AND question_index = $2 AND participant_id = $3`,
+		quizID, idx, participantID,
+	).Scan(&streak)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return streak, err
+}
+
+// AddQuizAnswer records a scored answer to the running question. It
+// returns ErrAlreadyVoted for a second answer and ErrTimeUp if the
+// question is no longer running, checked in the same statement.
+func (s *Store) AddQuizAnswer(a *QuizAnswer) error {
+	answer, err := json.Marshal(a.Answer)
+	if err != nil {
+		return err
+	}
+	err = s.db.QueryRow(
+		`INSERT INTO quiz_answers (quiz_id, question_index, participant_id, participant_name, answer,
+			correct, elapsed_ms, points, streak)
+		 SELECT id, $2, $3, $4, $5, $6, $7, $8, $9 FROM quizzes
+		 WHERE id = $1 AND status = 'question' AND current_index = $2
+		 ON CONFLICT (quiz_id, question_index, participant_id) DO NOTHING
+		 RETURNING answered_at`,
+		a.QuizID, a.QuestionIndex, a.ParticipantID, a.Name, answer, a.Correct, a.ElapsedMs, a.Points, a.Streak,
+	).Scan(&a.AnsweredAt)
+	if err != sql.ErrNoRows {
+		return err
+	}
+	var answered bool
+	if err := s.db.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM quiz_answers WHERE quiz_id = $1 AND question_index = $2 AND participant_id = $3)`,
+		a.QuizID, a.QuestionIndex, a.ParticipantID,
+	).Scan(&answered); err != nil {
+		return err
+	}
+	if answered {
+		return ErrAlreadyVoted
+	}
+	return ErrTimeUp
+}
+
+// CountAnswers returns how many answered question idx.
+func (s *Store) CountAnswers(quizID string, idx int) (int, error) {
+	var n int
+	err := s.db.QueryRow(
+		`SELECT COUNT(*) FROM quiz_answers WHERE quiz_id = $1 AND question_index = $2`, quizID, idx,
+	).Scan(&n)
+	return n, err
+}
+
+const quizAnswerColumns = `a.quiz_id, a.question_index, a.participant_id, a.participant_name, a.answer,
+	a.correct, a.elapsed_ms, a.points, a.streak, a.answered_at`
+
+func scanQuizAnswers(rows *sql.Rows) ([]QuizAnswer, error) {
+	defer rows.Close()
+	answers := []QuizAnswer{}
+	for rows.Next() {
+		var a QuizAnswer
+		var raw []byte
+		if err := rows.Scan(&a.QuizID, &a.QuestionIndex, &a.ParticipantID, &a.Name, &raw, &a.Correct,
+			&a.ElapsedMs, &a.Points, &a.Streak, &a.AnsweredAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, &a.Answer); err != nil {
+			return nil, err
+		}
+		answers = append(answers, a)
+	}
+	return answers, rows.Err()
+}
+
+// QuizAnswers returns the answers to a quiz by question, in the order they
+// came. A participantID limits them to one participant.
+func (s *Store) QuizAnswers(quizID, participantID string) ([]QuizAnswer, error) {
+	rows, err := s.db.Query(
+		`SELECT `+quizAnswerColumns+` FROM quiz_answers a
+		 WHERE a.quiz_id = $1 AND ($2 = '' OR a.participant_id = $2)
+		 ORDER BY a.question_index, a.answered_at, a.participant_id`, quizID, participantID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanQuizAnswers(rows)
+}
+
+// ScopeQuizAnswers returns the answers to every quiz of scope, by quiz ID.
+func (s *Store) ScopeQuizAnswers(scope string) (map[string][]QuizAnswer, error) {
+	rows, err := s.db.Query(
+		`SELECT `+quizAnswerColumns+` FROM quiz_answers a JOIN quizzes q ON q.id = a.quiz_id
+		 WHERE q.scope = $1
+		 ORDER BY a.quiz_id, a.question_index, a.answered_at, a.participant_id`, scope,
+	)
+	if err != nil {
+		return nil, err
+	}
+	answers, err := scanQuizAnswers(rows)
+	if err != nil {
+		return nil, err
+	}
+	byQuiz := make(map[string][]QuizAnswer)
+	for _, a := range answers {
+		byQuiz[a.QuizID] = append(byQuiz[a.QuizID], a)
+	}
+	return byQuiz, nil
+}
+
+// Results returns the saved results of a quiz by rank, or, with a
+// participantID, that participant's results in every quiz, newest first.
+func (s *Store) Results(quizID, participantID string) ([]QuizResult, error) {
+	rows, err := s.db.Query(
+		`SELECT r.quiz_id, q.title, q.scope, r.participant_id, r.participant_name, r.rank, r.score,
+			r.correct, r.answered, r.best_streak, r.questions, r.finished_at
+		 FROM quiz_results r JOIN quizzes q ON q.id = r.quiz_id
+		 WHERE ($1 = '' OR r.quiz_id = $1) AND ($2 = '' OR r.participant_id = $2)
+		 ORDER BY r.finished_at DESC, r.rank, r.participant_name`, quizID, participantID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []QuizResult{}
+	for rows.Next() {
+		var r QuizResult
+		st := &r.Standing
+		if err := rows.Scan(&r.QuizID, &r.QuizTitle, &r.Scope, &st.ParticipantID, &st.Name, &st.Rank, &st.Score,
+			&st.Correct, &st.Answered, &st.BestStreak, &r.Questions, &r.FinishedAt); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
@@ -0,0 +1,249 @@
+package polls
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types passed to Service.Publish.
+const (
+	EventCreated = "poll-created"
+	EventVote    = "poll-vote"
+	EventClosed  = "poll-ended"
+)
+
+// Event is a change to a poll or quiz. Voter and Answer are set for votes;
+// quiz events carry the Quiz, and Stats and Leaderboard as they apply.
+type Event struct {
+	Type   string
+	Poll   Poll
+	Voter  Actor
+	Answer Answer
+
+	Quiz        Quiz
+	Stats       *QuestionStats
+	Leaderboard []Standing
+}
+
+// Scope returns the scope of the poll or quiz the event is about.
+func (e Event) Scope() string {
+	if e.Quiz.ID != "" {
+		return e.Quiz.Scope
+	}
+	return e.Poll.Scope
+}
+
+// Service creates, runs and closes polls and quizzes. Transports set
+// Publish to fan events out to the sockets of each scope.
+type Service struct {
+	Store   *Store
+	Publish func(e Event)
+
+	timersMu sync.Mutex
+	timers   map[string]*time.Timer // quiz ID -> countdown of its running question
+}
+
+// NewService returns a Service storing polls in db. Without a database
+// every call returns ErrUnavailable.
+func NewService(db *sql.DB) *Service {
+	if db == nil {
+		return &Service{}
+	}
+	return &Service{Store: NewStore(db)}
+}
+
+func (s *Service) publish(e Event) {
+	if s.Publish != nil {
+		s.Publish(e)
+	}
+}
+
+// Create opens a poll in scope. Only staff can create polls; the ID is
+// generated here, never taken from the client.
+func (s *Service) Create(scope string, actor Actor, p Poll) (Poll, error) {
+	if s.Store == nil {
+		return p, ErrUnavailable
+	}
+	if !actor.IsStaff {
+		return p, ErrForbidden
+	}
+	if err := p.normalize(); err != nil {
+		return p, err
+	}
+	p.ID = "poll-" + uuid.New().String()
+	p.Scope = scope
+	p.Status = StatusOpen
+	p.CreatedBy = actor.ID
+	p.ClosedBy, p.ClosedAt = "", nil
+	if err := s.Store.Create(&p); err != nil {
+		return p, err
+	}
+	p.aggregate(nil)
+	s.publish(Event{Type: EventCreated, Poll: p})
+	return p, nil
+}
+
+// Get returns a poll with its results.
+func (s *Service) Get(id string) (Poll, error) {
+	if s.Store == nil {
+		return Poll{}, ErrUnavailable
+	}
+	return s.Store.Get(id)
+}
+
+// List returns the polls of scope, newest first, optionally by status.
+func (s *Service) List(scope, status string) ([]Poll, error) {
+	if s.Store == nil {
+		return nil, ErrUnavailable
+	}
+	return s.Store.List(scope, status)
+}
+
+// Vote records voter's answer to an open poll of scope and publishes the
+// new results, counted again from the stored votes. Voters need an ID;
+// each has one vote, which they can change only if the poll allows it.
+// Anonymous votes are published without the voter.
+func (s *Service) Vote(scope, id string, voter Actor, a Answer) (Poll, error) {
+	if voter.ID == "" {
+		return Poll{}, ErrNotVoter
+	}
+	p, err := s.Get(id)
+	if err != nil {
+		return p, err
+	}
+	if p.Scope != scope {
+		return p, ErrNotFound
+	}
+	if p.Closed() {
+		return p, ErrClosed
+	}
+	if err := p.check(&a); err != nil {
+		return p, err
+	}
+	if p.Anonymous {
+		err = s.Store.AddAnonymousVote(p.ID, voter.ID, a)
+	} else {
+		err = s.Store.AddVote(p.ID, voter.ID, a, p.AllowChange)
+	}
+	if err != nil {
+		return p, err
+	}
+	if p, err = s.Store.Get(id); err != nil {
+		return p, err
+	}
+	// The voter and their answer never travel together for anonymous polls.
+	e := Event{Type: EventVote, Poll: p}
+	if !p.Anonymous {
+		e.Voter, e.Answer = voter, a
+	}
+	s.publish(e)
+	return p, nil
+}
+
+// Close stops a poll of scope taking votes, keeps a snapshot of its final
+// results and publishes them. Only staff can close polls.
+func (s *Service) Close(scope, id string, actor Actor) (Poll, error) {
+	p, err := s.Get(id)
+	if err != nil {
+		return p, err
+	}
+	if p.Scope != scope {
+		return p, ErrNotFound
+	}
+	if !actor.IsStaff {
+		return p, ErrForbidden
+	}
+	if err := s.Store.Close(id, actor.ID); err != nil {
+		return p, err
+	}
+	if p, err = s.Store.Get(id); err != nil {
+		return p, err
+	}
+	if _, err := s.Store.SaveSnapshot(p, StatusClosed, actor.ID); err != nil {
+		return p, err
+	}
+	s.publish(Event{Type: EventClosed, Poll: p})
+	return p, nil
+}
+
+// Snapshot keeps the current results of a poll. Only staff can take
+// snapshots.
+func (s *Service) Snapshot(id string, actor Actor) (Snapshot, error) {
+	p, err := s.Get(id)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if !actor.IsStaff {
+		return Snapshot{}, ErrForbidden
+	}
+	return s.Store.SaveSnapshot(p, "manual", actor.ID)
+}
+
+// VotesBy returns voterID's answers in the polls of scope, by poll ID.
+func (s *Service) VotesBy(scope, voterID string) (map[string]Answer, error) {
+	if s.Store == nil {
+		return nil, ErrUnavailable
+	}
+	return s.Store.VotesBy(scope, voterID)
+}
+
+// Snapshots returns the snapshots of a poll, oldest first.
+func (s *Service) Snapshots(id string) ([]Snapshot, error) {
+	if s.Store == nil {
+		return nil, ErrUnavailable
+	}
+	return s.Store.Snapshots(id)
+}
+
+// PollReport returns a poll with its votes and analysis.
+func (s *Service) PollReport(id string) (PollReport, error) {
+	if s.Store == nil {
+		return PollReport{}, ErrUnavailable
+	}
+	p, err := s.Store.Get(id)
+	if err != nil {
+		return PollReport{}, err
+	}
+	votes, err := s.Store.Votes("", id)
+	if err != nil {
+		return PollReport{}, err
+	}
+	return pollReport(p, votes), nil
+}
+
+// Report analyses every poll and quiz of scope. roster maps the students
+// expected to take part to their names; with a nil roster everyone who
+// took part is listed.
+func (s *Service) Report(scope string, roster map[string]string) (Report, error) {
+	if s.Store == nil {
+		return Report{}, ErrUnavailable
+	}
+	polls, err := s.Store.List(scope, "")
+	if err != nil {
+		return Report{}, err
+	}
+	list, err := s.Store.Votes(scope, "")
+	if err != nil {
+		return Report{}, err
+	}
+	votes := make(map[string][]Vote)
+	for _, v := range list {
+		votes[v.PollID] = append(votes[v.PollID], v)
+	}
+	participants, err := s.Store.Participants(scope)
+	if err != nil {
+		return Report{}, err
+	}
+	quizzes, err := s.Store.ListQuizzes(scope, false)
+	if err != nil {
+		return Report{}, err
+	}
+	answers, err := s.Store.ScopeQuizAnswers(scope)
+	if err != nil {
+		return Report{}, err
+	}
+	return buildReport(scope, polls, votes, participants, quizzes, answers, roster), nil
+}
@@ -0,0 +1,414 @@
+package polls
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Store persists polls, votes and result snapshots in Postgres.
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore returns a Store backed by db.
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+const pollColumns = `p.id, p.scope, p.kind, p.question, p.options, p.max_choices, p.correct_answer,
+	p.correct_answers, p.correct_number, p.tolerance, p.allow_change, p.anonymous, p.hide_results,
+	p.min_responses, p.status,
+	p.created_by, p.created_at, COALESCE(p.closed_by, ''), p.closed_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPoll(row rowScanner) (Poll, error) {
+	var p Poll
+	var correct sql.NullInt64
+	var correctAnswers pq.Int64Array
+	var correctNumber sql.NullFloat64
+	var closedAt sql.NullTime
+	err := row.Scan(&p.ID, &p.Scope, &p.Kind, &p.Question, pq.Array(&p.Options), &p.MaxChoices, &correct,
+		&correctAnswers, &correctNumber, &p.Tolerance, &p.AllowChange, &p.Anonymous, &p.HideResults,
+		&p.MinResponses, &p.Status,
+		&p.CreatedBy, &p.CreatedAt, &p.ClosedBy, &closedAt)
+	if err != nil {
+		return p, err
+	}
+	if correct.Valid {
+		c := int(correct.Int64)
+		p.CorrectAnswer = &c
+	}
+	for _, c := range correctAnswers {
+		p.CorrectAnswers = append(p.CorrectAnswers, int(c))
+	}
+	if correctNumber.Valid {
+		p.CorrectNumber = &correctNumber.Float64
+	}
+	if closedAt.Valid {
+		p.ClosedAt = &closedAt.Time
+	}
+	return p, nil
+}
+
+// Create stores a new poll.
+func (s *Store) Create(p *Poll) error {
+	var correct, correctNumber interface{}
+	if p.CorrectAnswer != nil {
+		correct = *p.CorrectAnswer
+	}
+	if p.CorrectNumber != nil {
+		correctNumber = *p.CorrectNumber
+	}
+	correctAnswers := make(pq.Int64Array, len(p.CorrectAnswers))
+	for i, c := range p.CorrectAnswers {
+		correctAnswers[i] = int64(c)
+	}
+	return s.db.QueryRow(
+		`INSERT INTO polls (id, scope, kind, question, options, max_choices, correct_answer,
+			correct_answers, correct_number, tolerance, allow_change, anonymous, hide_results,
+			min_responses, status, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16) RETURNING created_at`,
+		p.ID, p.Scope, p.Kind, p.Question, pq.Array(p.Options), p.MaxChoices, correct,
+		correctAnswers, correctNumber, p.Tolerance, p.AllowChange, p.Anonymous, p.HideResults,
+		p.MinResponses, p.Status, p.CreatedBy,
+	).Scan(&p.CreatedAt)
+}
+
+// Get loads a poll with its results.
+func (s *Store) Get(id string) (Poll, error) {
+	p, err := scanPoll(s.db.QueryRow(`SELECT `+pollColumns+` FROM polls p WHERE p.id = $1`, id))
+	if err == sql.ErrNoRows {
+		return p, ErrNotFound
+	} else if err != nil {
+		return p, err
+	}
+	return p, s.tally(&p)
+}
+
+// List returns the polls of scope with their results, newest first. An
+// empty status returns polls in any status.
+func (s *Store) List(scope, status string) ([]Poll, error) {
+	rows, err := s.db.Query(
+		`SELECT `+pollColumns+` FROM polls p
+		 WHERE p.scope = $1 AND ($2 = '' OR p.status = $2)
+		 ORDER BY p.created_at DESC`, scope, status,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Poll{}
+	for rows.Next() {
+		p, err := scanPoll(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range list {
+		if err := s.tally(&list[i]); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// tally recomputes a poll's results from its votes.
+func (s *Store) tally(p *Poll) error {
+	rows, err := s.db.Query(
+		`SELECT option_index, answer FROM poll_votes WHERE poll_id = $1 ORDER BY updated_at, voter_id`, p.ID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	answers := []Answer{}
+	for rows.Next() {
+		a, err := scanAnswer(rows)
+		if err != nil {
+			return err
+		}
+		answers = append(answers, a)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	p.aggregate(answers)
+	return nil
+}
+
+// scanAnswer reads an option_index, answer pair. Votes stored before
+// answers were kept in full only have the option index.
+func scanAnswer(row rowScanner) (Answer, error) {
+	var a Answer
+	var idx int
+	var raw []byte
+	if err := row.Scan(&idx, &raw); err != nil {
+		return a, err
+	}
+	if raw == nil {
+		a.Choices = []int{idx}
+		return a, nil
+	}
+	return a, json.Unmarshal(raw, &a)
+}
+
+// AddVote records voterID's answer in an open poll. With allowChange a
+// second vote replaces the first; otherwise it returns ErrAlreadyVoted. It
+// returns ErrClosed if the poll is not open, checked in the same statement
+// so a vote cannot land after the poll closes.
+func (s *Store) AddVote(pollID, voterID string, a Answer, allowChange bool) error {
+	// option_index keeps the first choice for simple per-option queries.
+	option := -1
+	if len(a.Choices) > 0 {
+		option = a.Choices[0]
+	}
+	answer, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	onConflict := `DO NOTHING`
+	if allowChange {
+		onConflict = `DO UPDATE SET option_index = EXCLUDED.option_index, answer = EXCLUDED.answer, updated_at = NOW()`
+	}
+	res, err := s.db.Exec(
+		`INSERT INTO poll_votes (poll_id, voter_id, option_index, answer)
+		 SELECT id, $2, $3, $4 FROM polls WHERE id = $1 AND status = 'open'
+		 ON CONFLICT (poll_id, voter_id) `+onConflict,
+		pollID, voterID, option, answer,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	var open bool
+	if err := s.db.QueryRow(`SELECT status = 'open' FROM polls WHERE id = $1`, pollID).Scan(&open); err == sql.ErrNoRows {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	if !open {
+		return ErrClosed
+	}
+	return ErrAlreadyVoted
+}
+
+// AddAnonymousVote records that voterID voted in an open anonymous poll
+// and, in the same transaction, stores their answer as a ballot with a
+// random ID and the poll's own timestamps, so it cannot be traced back to
+// them by ID or by time. It returns ErrAlreadyVoted for a second vote and
+// ErrClosed if the poll is not open.
+func (s *Store) AddAnonymousVote(pollID, voterID string, a Answer) error {
+	option := -1
+	if len(a.Choices) > 0 {
+		option = a.Choices[0]
+	}
+	answer, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`INSERT INTO poll_participants (poll_id, voter_id)
+		 SELECT id, $2 FROM polls WHERE id = $1 AND status = 'open'
+		 ON CONFLICT (poll_id, voter_id) DO NOTHING`, pollID, voterID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var open bool
+		if err := tx.QueryRow(`SELECT status = 'open' FROM polls WHERE id = $1`, pollID).Scan(&open); err == sql.ErrNoRows {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		if !open {
+			return ErrClosed
+		}
+		return ErrAlreadyVoted
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO poll_votes (poll_id, voter_id, option_index, answer, created_at, updated_at)
+		 SELECT id, $2, $3, $4, created_at, created_at FROM polls WHERE id = $1`,
+		pollID, "ballot-"+uuid.New().String(), option, answer,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// VotesBy returns voterID's answers in the polls of scope, by poll ID.
+// Anonymous polls they voted in map to an empty Answer.
+func (s *Store) VotesBy(scope, voterID string) (map[string]Answer, error) {
+	rows, err := s.db.Query(
+		`SELECT v.poll_id, v.option_index, v.answer FROM poll_votes v JOIN polls p ON p.id = v.poll_id
+		 WHERE p.scope = $1 AND v.voter_id = $2
+		 UNION ALL
+		 SELECT pp.poll_id, -1, '{}'::jsonb FROM poll_participants pp JOIN polls p ON p.id = pp.poll_id
+		 WHERE p.scope = $1 AND pp.voter_id = $2`, scope, voterID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	votes := make(map[string]Answer)
+	for rows.Next() {
+		var id string
+		var idx int
+		var raw []byte
+		if err := rows.Scan(&id, &idx, &raw); err != nil {
+			return nil, err
+		}
+		a := Answer{Choices: []int{idx}}
+		if raw != nil {
+			a = Answer{}
+			if err := json.Unmarshal(raw, &a); err != nil {
+				return nil, err
+			}
+		}
+		votes[id] = a
+	}
+	return votes, rows.Err()
+}
+
+// Votes returns every vote in the polls of scope, or in one poll with a
+// pollID, oldest first. Anonymous ballots come without a voter.
+func (s *Store) Votes(scope, pollID string) ([]Vote, error) {
+	rows, err := s.db.Query(
+		`SELECT v.poll_id, CASE WHEN p.anonymous THEN '' ELSE v.voter_id END, v.option_index, v.answer,
+			v.created_at, v.updated_at
+		 FROM poll_votes v JOIN polls p ON p.id = v.poll_id
+		 WHERE ($1 = '' OR p.scope = $1) AND ($2 = '' OR p.id = $2)
+		 ORDER BY v.poll_id, v.created_at, v.voter_id`, scope, pollID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	votes := []Vote{}
+	for rows.Next() {
+		var v Vote
+		var idx int
+		var raw []byte
+		if err := rows.Scan(&v.PollID, &v.VoterID, &idx, &raw, &v.VotedAt, &v.ChangedAt); err != nil {
+			return nil, err
+		}
+		v.Answer = Answer{Choices: []int{idx}}
+		if raw != nil {
+			v.Answer = Answer{}
+			if err := json.Unmarshal(raw, &v.Answer); err != nil {
+				return nil, err
+			}
+		}
+		votes = append(votes, v)
+	}
+	return votes, rows.Err()
+}
+
+// Participants returns who voted in each anonymous poll of scope, by poll
+// ID.
+func (s *Store) Participants(scope string) (map[string][]string, error) {
+	rows, err := s.db.Query(
+		`SELECT pp.poll_id, pp.voter_id FROM poll_participants pp JOIN polls p ON p.id = pp.poll_id
+		 WHERE p.scope = $1 ORDER BY pp.poll_id, pp.voter_id`, scope,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	participants := make(map[string][]string)
+	for rows.Next() {
+		var pollID, voterID string
+		if err := rows.Scan(&pollID, &voterID); err != nil {
+			return nil, err
+		}
+		participants[pollID] = append(participants[pollID], voterID)
+	}
+	return participants, rows.Err()
+}
+
+// Close marks a poll closed. It returns ErrClosed if it already was.
+func (s *Store) Close(id, by string) error {
+	res, err := s.db.Exec(
+		`UPDATE polls SET status = 'closed', closed_by = $2, closed_at = NOW()
+		 WHERE id = $1 AND status = 'open'`, id, by,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrClosed
+	}
+	return nil
+}
+
+// SaveSnapshot stores the current results of p.
+func (s *Store) SaveSnapshot(p Poll, reason, by string) (Snapshot, error) {
+	snap := Snapshot{PollID: p.ID, Results: p.Results, Total: p.Total, Summary: p.Summary, Reason: reason, TakenBy: by}
+	results, err := json.Marshal(p.Results)
+	if err != nil {
+		return snap, err
+	}
+	var summary []byte
+	if p.Summary != nil {
+		if summary, err = json.Marshal(p.Summary); err != nil {
+			return snap, err
+		}
+	}
+	err = s.db.QueryRow(
+		`INSERT INTO poll_snapshots (poll_id, results, summary, total, reason, taken_by)
+		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, taken_at`,
+		p.ID, results, summary, p.Total, reason, by,
+	).Scan(&snap.ID, &snap.TakenAt)
+	return snap, err
+}
+
+// Snapshots returns the snapshots of a poll, oldest first.
+func (s *Store) Snapshots(pollID string) ([]Snapshot, error) {
+	rows, err := s.db.Query(
+		`SELECT id, poll_id, results, summary, total, reason, taken_by, taken_at
+		 FROM poll_snapshots WHERE poll_id = $1 ORDER BY taken_at, id`, pollID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	snaps := []Snapshot{}
+	for rows.Next() {
+		var snap Snapshot
+		var results, summary []byte
+		var takenAt time.Time
+		if err := rows.Scan(&snap.ID, &snap.PollID, &results, &summary, &snap.Total, &snap.Reason, &snap.TakenBy, &takenAt); err != nil {
+			return nil, err
+		}
+		snap.TakenAt = takenAt
+		if err := json.Unmarshal(results, &snap.Results); err != nil {
+			return nil, err
+		}
+		if summary != nil {
+			if err := json.Unmarshal(summary, &snap.Summary); err != nil {
+				return nil, err
+			}
+		}
+		snaps = append(snaps, snap)
+	}
+	return snaps, rows.Err()
+}
@@ -0,0 +1,256 @@
+package polls
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	maxCloudWords = 50
+	maxResponses  = 200
+)
+
+// Summary is the kind-specific view of a poll's results.
+type Summary struct {
+	// Ranked polls: one round per elimination, and the winner once an
+	// option holds a majority of the ballots still in play.
+	Rounds []RankedRound `json:"rounds,omitempty"`
+	Winner *int          `json:"winner,omitempty"`
+
+	// Rating polls.
+	Average *float64 `json:"average,omitempty"`
+
+	// Word clouds: the most used words, most frequent first.
+	Words []WordCount `json:"words,omitempty"`
+
+	// Text polls: the newest responses first.
+	Responses []string `json:"responses,omitempty"`
+
+	// Numeric polls.
+	Numeric *NumericStats `json:"numeric,omitempty"`
+}
+
+// RankedRound is one instant-runoff round: each option's votes, counting
+// every ballot for its highest-ranked option still in the race, and the
+// options knocked out at the end of the round.
+type RankedRound struct {
+	Counts     []int `json:"counts"`
+	Eliminated []int `json:"eliminated,omitempty"`
+}
+
+// WordCount is one word of a word cloud.
+type WordCount struct {
+	Word  string `json:"word"`
+	Count int    `json:"count"`
+}
+
+// NumericStats sums up the answers to a numeric poll. Correct is set when
+// the poll has a correct number.
+type NumericStats struct {
+	Mean    float64 `json:"mean"`
+	Median  float64 `json:"median"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+	Correct *int    `json:"correct,omitempty"`
+}
+
+// aggregate fills Results, Total and Summary from the poll's answers,
+// oldest first.
+func (p *Poll) aggregate(answers []Answer) {
+	p.Results = make([]int, len(p.Options))
+	p.Total = len(answers)
+	p.Summary = nil
+
+	switch p.Kind {
+	case KindSingle, KindMulti:
+		for _, a := range answers {
+			for _, c := range a.Choices {
+				if c >= 0 && c < len(p.Results) {
+					p.Results[c]++
+				}
+			}
+		}
+
+	case KindRanked:
+		ballots := make([][]int, 0, len(answers))
+		for _, a := range answers {
+			if len(a.Choices) > 0 {
+				ballots = append(ballots, a.Choices)
+				if c := a.Choices[0]; c >= 0 && c < len(p.Results) {
+					p.Results[c]++
+				}
+			}
+		}
+		rounds, winner := instantRunoff(ballots, len(p.Options))
+		p.Summary = &Summary{Rounds: rounds, Winner: winner}
+
+	case KindRating:
+		sum := 0
+		for _, a := range answers {
+			if a.Rating >= 1 && a.Rating <= MaxRating {
+				p.Results[a.Rating-1]++
+				sum += a.Rating
+			}
+		}
+		p.Summary = &Summary{}
+		if p.Total > 0 {
+			avg := math.Round(float64(sum)/float64(p.Total)*100) / 100
+			p.Summary.Average = &avg
+		}
+
+	case KindWordCloud:
+		p.Summary = &Summary{Words: wordCloud(answers)}
+
+	case KindText:
+		responses := []string{}
+		for i := len(answers) - 1; i >= 0 && len(responses) < maxResponses; i-- {
+			if answers[i].Text != "" {
+				responses = append(responses, answers[i].Text)
+			}
+		}
+		p.Summary = &Summary{Responses: responses}
+
+	case KindNumeric:
+		p.Summary = &Summary{Numeric: p.numericStats(answers)}
+	}
+}
+
+// instantRunoff tallies ranked ballots of n options. Each round counts
+// every ballot for its highest-ranked option still in the race; an option
+// with more than half of those ballots wins, otherwise the options with
+// the fewest votes are eliminated. A tie between every remaining option
+// ends without a winner.
+func instantRunoff(ballots [][]int, n int) ([]RankedRound, *int) {
+	rounds := []RankedRound{}
+	active := make([]bool, n)
+	remaining := n
+	for i := range active {
+		active[i] = true
+	}
+
+	for remaining > 0 {
+		round := RankedRound{Counts: make([]int, n)}
+		inPlay := 0
+		for _, ballot := range ballots {
+			for _, c := range ballot {
+				if c >= 0 && c < n && active[c] {
+					round.Counts[c]++
+					inPlay++
+					break
+				}
+			}
+		}
+		if inPlay == 0 {
+			rounds = append(rounds, round)
+			return rounds, nil
+		}
+
+		lowest := -1
+		for c := 0; c < n; c++ {
+			if !active[c] {
+				continue
+			}
+			if round.Counts[c]*2 > inPlay {
+				rounds = append(rounds, round)
+				winner := c
+				return rounds, &winner
+			}
+			if lowest < 0 || round.Counts[c] < lowest {
+				lowest = round.Counts[c]
+			}
+		}
+		for c := 0; c < n; c++ {
+			if active[c] && round.Counts[c] == lowest {
+				round.Eliminated = append(round.Eliminated, c)
+			}
+		}
+		rounds = append(rounds, round)
+		if len(round.Eliminated) == remaining {
+			return rounds, nil
+		}
+		for _, c := range round.Eliminated {
+			active[c] = false
+		}
+		remaining -= len(round.Eliminated)
+	}
+	return rounds, nil
+}
+
+// stopWords are left out of word clouds.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true,
+	"by": true, "for": true, "from": true, "i": true, "in": true, "is": true, "it": true, "of": true,
+	"on": true, "or": true, "so": true, "that": true, "the": true, "this": true, "to": true,
+	"was": true, "we": true, "with": true, "you": true,
+}
+
+// wordCloud counts the words of the answers, case-insensitively, skipping
+// stop words and single letters. Each answer counts a word once.
+func wordCloud(answers []Answer) []WordCount {
+	counts := make(map[string]int)
+	for _, a := range answers {
+		seen := make(map[string]bool)
+		words := strings.FieldsFunc(strings.ToLower(a.Text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\'' && r != '-'
+		})
+		for _, w := range words {
+			w = strings.Trim(w, "'-")
+			if len([]rune(w)) < 2 || stopWords[w] || seen[w] {
+				continue
+			}
+			seen[w] = true
+			counts[w]++
+		}
+	}
+	words := make([]WordCount, 0, len(counts))
+	for w, n := range counts {
+		words = append(words, WordCount{Word: w, Count: n})
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if words[i].Count != words[j].Count {
+			return words[i].Count > words[j].Count
+		}
+		return words[i].Word < words[j].Word
+	})
+	if len(words) > maxCloudWords {
+		words = words[:maxCloudWords]
+	}
+	return words
+}
+
+// numericStats sums up the numbers answered, or returns nil if there are
+// none.
+func (p *Poll) numericStats(answers []Answer) *NumericStats {
+	values := make([]float64, 0, len(answers))
+	correct := 0
+	for _, a := range answers {
+		if a.Number == nil {
+			continue
+		}
+		values = append(values, *a.Number)
+		if ok, _ := p.Grade(a); ok {
+			correct++
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	sort.Float64s(values)
+	stats := &NumericStats{Min: values[0], Max: values[len(values)-1]}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	stats.Mean = sum / float64(len(values))
+	if mid := len(values) / 2; len(values)%2 == 1 {
+		stats.Median = values[mid]
+	} else {
+		stats.Median = (values[mid-1] + values[mid]) / 2
+	}
+	if p.CorrectNumber != nil {
+		stats.Correct = &correct
+	}
+	return stats
+}
@@ -117,6 +117,379 @@ func createTables() {
 			upload_time TIMESTAMP NOT NULL,
 			file_size BIGINT
 		);`,
+
+		`CREATE TABLE IF NOT EXISTS meeting_attendance (
+			id SERIAL PRIMARY KEY,
+			room_id VARCHAR(100) NOT NULL,
+			user_id VARCHAR(100) NOT NULL,
+			user_name VARCHAR(255),
+			is_staff BOOLEAN DEFAULT FALSE,
+			joined_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			left_at TIMESTAMP
+		);`,
+		`CREATE INDEX IF NOT EXISTS meeting_attendance_room_joined
+			ON meeting_attendance (room_id, joined_at);`,
+		`ALTER TABLE meeting_attendance ADD COLUMN IF NOT EXISTS seen_at TIMESTAMP;`,
+
+		`CREATE TABLE IF NOT EXISTS messages (
+			id BIGSERIAL PRIMARY KEY,
+			channel VARCHAR(200) NOT NULL,
+			sender_id VARCHAR(100) NOT NULL,
+			sender_name VARCHAR(255) NOT NULL,
+			kind VARCHAR(20) NOT NULL DEFAULT 'text',
+			body TEXT NOT NULL DEFAULT '',
+			attachments JSONB NOT NULL DEFAULT '[]',
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP
+		);`,
+		`CREATE INDEX IF NOT EXISTS messages_channel_id ON messages (channel, id);`,
+		`ALTER TABLE messages
+			ADD COLUMN IF NOT EXISTS reply_to_id BIGINT REFERENCES messages(id),
+			ADD COLUMN IF NOT EXISTS edited BOOLEAN NOT NULL DEFAULT FALSE,
+			ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP,
+			ADD COLUMN IF NOT EXISTS deleted_by VARCHAR(100),
+			ADD COLUMN IF NOT EXISTS pinned_at TIMESTAMP,
+			ADD COLUMN IF NOT EXISTS pinned_by VARCHAR(100);`,
+		`CREATE INDEX IF NOT EXISTS messages_reply_to ON messages (reply_to_id);`,
+		`CREATE TABLE IF NOT EXISTS message_edits (
+			id BIGSERIAL PRIMARY KEY,
+			message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+			body TEXT NOT NULL,
+			edited_by VARCHAR(100) NOT NULL,
+			edited_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE TABLE IF NOT EXISTS message_reactions (
+			message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+			user_id VARCHAR(100) NOT NULL,
+			emoji VARCHAR(32) NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (message_id, user_id, emoji)
+		);`,
+
+		`CREATE TABLE IF NOT EXISTS course_enrollments (
+			course_code VARCHAR(50) NOT NULL,
+			user_id VARCHAR(100) NOT NULL,
+			display_name VARCHAR(255),
+			role VARCHAR(20) NOT NULL DEFAULT 'student',
+			enrolled_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (course_code, user_id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS discussion_channels (
+			id SERIAL PRIMARY KEY,
+			course_code VARCHAR(50) NOT NULL,
+			slug VARCHAR(100) NOT NULL,
+			title VARCHAR(255) NOT NULL,
+			kind VARCHAR(20) NOT NULL DEFAULT 'topic',
+			ref VARCHAR(100),
+			created_by VARCHAR(100) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (course_code, slug)
+		);`,
+
+		`CREATE TABLE IF NOT EXISTS message_mentions (
+			message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+			user_id VARCHAR(100) NOT NULL,
+			PRIMARY KEY (message_id, user_id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS message_mentions_user ON message_mentions (user_id);`,
+		`CREATE TABLE IF NOT EXISTS channel_read_cursors (
+			channel VARCHAR(200) NOT NULL,
+			user_id VARCHAR(100) NOT NULL,
+			last_read_id BIGINT NOT NULL DEFAULT 0,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (channel, user_id)
+		);`,
+		`ALTER TABLE course_enrollments
+			ADD COLUMN IF NOT EXISTS email VARCHAR(255),
+			ADD COLUMN IF NOT EXISTS notify_mentions BOOLEAN NOT NULL DEFAULT FALSE;`,
+
+		`CREATE TABLE IF NOT EXISTS moderation_words (
+			language VARCHAR(10) NOT NULL,
+			word VARCHAR(100) NOT NULL,
+			created_by VARCHAR(100) NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (language, word)
+		);`,
+		`CREATE TABLE IF NOT EXISTS channel_moderation (
+			channel VARCHAR(200) PRIMARY KEY,
+			block_links BOOLEAN NOT NULL DEFAULT FALSE,
+			slow_mode_seconds INT NOT NULL DEFAULT 0,
+			languages TEXT[] NOT NULL DEFAULT '{}',
+			updated_by VARCHAR(100),
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE TABLE IF NOT EXISTS channel_mutes (
+			channel VARCHAR(200) NOT NULL,
+			user_id VARCHAR(100) NOT NULL,
+			until TIMESTAMP,
+			reason TEXT NOT NULL DEFAULT '',
+			created_by VARCHAR(100) NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (channel, user_id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS message_reports (
+			id BIGSERIAL PRIMARY KEY,
+			message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+			channel VARCHAR(200) NOT NULL,
+			reporter_id VARCHAR(100) NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			reviewed_by VARCHAR(100),
+			reviewed_at TIMESTAMP,
+			resolution TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (message_id, reporter_id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS message_reports_status ON message_reports (status, created_at);`,
+		`CREATE TABLE IF NOT EXISTS moderation_audit (
+			id BIGSERIAL PRIMARY KEY,
+			channel VARCHAR(200) NOT NULL DEFAULT '',
+			actor_id VARCHAR(100) NOT NULL,
+			action VARCHAR(50) NOT NULL,
+			target_user VARCHAR(100) NOT NULL DEFAULT '',
+			message_id BIGINT,
+			details TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE INDEX IF NOT EXISTS moderation_audit_channel ON moderation_audit (channel, created_at);`,
+
+		`CREATE TABLE IF NOT EXISTS dm_conversations (
+			id BIGSERIAL PRIMARY KEY,
+			pair_key VARCHAR(210) UNIQUE,
+			title VARCHAR(200) NOT NULL DEFAULT '',
+			created_by VARCHAR(100) NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE TABLE IF NOT EXISTS dm_members (
+			conversation_id BIGINT NOT NULL REFERENCES dm_conversations(id) ON DELETE CASCADE,
+			user_id VARCHAR(100) NOT NULL,
+			joined_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (conversation_id, user_id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS dm_members_user ON dm_members (user_id);`,
+		`CREATE TABLE IF NOT EXISTS office_hours (
+			id BIGSERIAL PRIMARY KEY,
+			staff_id VARCHAR(100) NOT NULL,
+			course_code VARCHAR(50) NOT NULL,
+			title VARCHAR(200) NOT NULL DEFAULT '',
+			mode VARCHAR(20) NOT NULL DEFAULT 'chat',
+			opened_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			closed_at TIMESTAMP
+		);`,
+		`CREATE TABLE IF NOT EXISTS office_hours_queue (
+			id BIGSERIAL PRIMARY KEY,
+			session_id BIGINT NOT NULL REFERENCES office_hours(id) ON DELETE CASCADE,
+			student_id VARCHAR(100) NOT NULL,
+			topic TEXT NOT NULL DEFAULT '',
+			status VARCHAR(20) NOT NULL DEFAULT 'waiting',
+			conversation_id BIGINT REFERENCES dm_conversations(id),
+			room_id VARCHAR(200),
+			joined_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			called_at TIMESTAMP,
+			finished_at TIMESTAMP
+		);`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS office_hours_queue_active
+			ON office_hours_queue (session_id, student_id) WHERE status IN ('waiting', 'called');`,
+
+		// Polls are scoped to a meeting room ("meeting:<room>") or a course
+		// ("course:<code>"); results are counted from poll_votes.
+		`CREATE TABLE IF NOT EXISTS polls (
+			id VARCHAR(100) PRIMARY KEY,
+			scope VARCHAR(255) NOT NULL,
+			question TEXT NOT NULL,
+			options TEXT[] NOT NULL,
+			correct_answer INT,
+			status VARCHAR(20) NOT NULL DEFAULT 'open',
+			created_by VARCHAR(100) NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			closed_by VARCHAR(100),
+			closed_at TIMESTAMP
+		);`,
+		`CREATE INDEX IF NOT EXISTS polls_scope ON polls (scope, status, created_at);`,
+		`CREATE TABLE IF NOT EXISTS poll_votes (
+			poll_id VARCHAR(100) NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+			voter_id VARCHAR(100) NOT NULL,
+			option_index INT NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (poll_id, voter_id)
+		);`,
+		`ALTER TABLE polls ADD COLUMN IF NOT EXISTS allow_change BOOLEAN NOT NULL DEFAULT FALSE;`,
+		`ALTER TABLE poll_votes ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;`,
+		// Poll kinds; answers other than a single choice are kept in answer.
+		`ALTER TABLE polls
+			ADD COLUMN IF NOT EXISTS kind VARCHAR(20) NOT NULL DEFAULT 'single',
+			ADD COLUMN IF NOT EXISTS max_choices INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS correct_answers INT[] NOT NULL DEFAULT '{}',
+			ADD COLUMN IF NOT EXISTS correct_number DOUBLE PRECISION,
+			ADD COLUMN IF NOT EXISTS tolerance DOUBLE PRECISION NOT NULL DEFAULT 0;`,
+		`ALTER TABLE poll_votes ADD COLUMN IF NOT EXISTS answer JSONB;`,
+		`CREATE TABLE IF NOT EXISTS poll_snapshots (
+			id BIGSERIAL PRIMARY KEY,
+			poll_id VARCHAR(100) NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+			results JSONB NOT NULL,
+			total INT NOT NULL,
+			reason VARCHAR(20) NOT NULL,
+			taken_by VARCHAR(100) NOT NULL,
+			taken_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`ALTER TABLE poll_snapshots ADD COLUMN IF NOT EXISTS summary JSONB;`,
+		// Anonymous polls keep who voted in poll_participants, apart from
+		// their ballots in poll_votes.
+		`ALTER TABLE polls
+			ADD COLUMN IF NOT EXISTS anonymous BOOLEAN NOT NULL DEFAULT FALSE,
+			ADD COLUMN IF NOT EXISTS hide_results BOOLEAN NOT NULL DEFAULT FALSE,
+			ADD COLUMN IF NOT EXISTS min_responses INT NOT NULL DEFAULT 0;`,
+		`CREATE TABLE IF NOT EXISTS poll_participants (
+			poll_id VARCHAR(100) NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+			voter_id VARCHAR(100) NOT NULL,
+			PRIMARY KEY (poll_id, voter_id)
+		);`,
+
+		// Timed quizzes. Answers are scored as they arrive; quiz_results keeps
+		// each participant's final standing once a quiz finishes.
+		`CREATE TABLE IF NOT EXISTS quizzes (
+			id VARCHAR(100) PRIMARY KEY,
+			scope VARCHAR(255) NOT NULL,
+			title TEXT NOT NULL,
+			questions JSONB NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'lobby',
+			current_index INT NOT NULL DEFAULT -1,
+			question_started_at TIMESTAMP,
+			deadline TIMESTAMP,
+			created_by VARCHAR(100) NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			finished_at TIMESTAMP
+		);`,
+		`CREATE INDEX IF NOT EXISTS quizzes_scope ON quizzes (scope, status, created_at);`,
+		`CREATE TABLE IF NOT EXISTS quiz_answers (
+			quiz_id VARCHAR(100) NOT NULL REFERENCES quizzes(id) ON DELETE CASCADE,
+			question_index INT NOT NULL,
+			participant_id VARCHAR(100) NOT NULL,
+			participant_name VARCHAR(255) NOT NULL DEFAULT '',
+			answer JSONB NOT NULL,
+			correct BOOLEAN NOT NULL,
+			elapsed_ms INT NOT NULL,
+			points INT NOT NULL,
+			streak INT NOT NULL,
+			answered_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (quiz_id, question_index, participant_id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS quiz_results (
+			quiz_id VARCHAR(100) NOT NULL REFERENCES quizzes(id) ON DELETE CASCADE,
+			participant_id VARCHAR(100) NOT NULL,
+			participant_name VARCHAR(255) NOT NULL DEFAULT '',
+			rank INT NOT NULL,
+			score INT NOT NULL,
+			correct INT NOT NULL,
+			answered INT NOT NULL,
+			best_streak INT NOT NULL,
+			questions INT NOT NULL,
+			finished_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (quiz_id, participant_id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS quiz_results_participant ON quiz_results (participant_id, finished_at);`,
+
+		// Exam question bank. Exams are drawn from it by blueprint.
+		`CREATE TABLE IF NOT EXISTS exam_questions (
+			id BIGSERIAL PRIMARY KEY,
+			topic VARCHAR(100) NOT NULL,
+			tags TEXT[] NOT NULL DEFAULT '{}',
+			difficulty VARCHAR(10) NOT NULL DEFAULT 'medium',
+			bloom_level VARCHAR(20) NOT NULL DEFAULT 'understand',
+			kind VARCHAR(20) NOT NULL DEFAULT 'single',
+			question TEXT NOT NULL,
+			options TEXT[] NOT NULL,
+			correct_answer INT NOT NULL,
+			explanation TEXT NOT NULL DEFAULT '',
+			created_by VARCHAR(100) NOT NULL,
+			updated_by VARCHAR(100),
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE INDEX IF NOT EXISTS exam_questions_topic ON exam_questions (topic, difficulty, bloom_level);`,
+		`CREATE INDEX IF NOT EXISTS exam_questions_tags ON exam_questions USING GIN (tags);`,
+		// What each question is worth, and the answer key of the kinds
+		// beyond single choice.
+		`ALTER TABLE exam_questions ADD COLUMN IF NOT EXISTS points DOUBLE PRECISION NOT NULL DEFAULT 1;`,
+		`ALTER TABLE exam_questions ADD COLUMN IF NOT EXISTS spec JSONB NOT NULL DEFAULT '{}';`,
+
+		// Exams drawn from the bank, and students' attempts at them. Each
+		// attempt keeps its own copy of the questions with their answers.
+		`CREATE TABLE IF NOT EXISTS exams (
+			id VARCHAR(100) PRIMARY KEY,
+			course_code VARCHAR(100) NOT NULL DEFAULT '',
+			title TEXT NOT NULL,
+			blueprint JSONB NOT NULL,
+			time_limit INT NOT NULL,
+			max_attempts INT NOT NULL DEFAULT 0,
+			reveal VARCHAR(10) NOT NULL DEFAULT 'submit',
+			opens_at TIMESTAMP,
+			closes_at TIMESTAMP,
+			created_by VARCHAR(100) NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE INDEX IF NOT EXISTS exams_course ON exams (course_code, created_at);`,
+		`CREATE TABLE IF NOT EXISTS exam_attempts (
+			id VARCHAR(100) PRIMARY KEY,
+			exam_id VARCHAR(100) REFERENCES exams(id) ON DELETE CASCADE,
+			student_id VARCHAR(100) NOT NULL,
+			title TEXT NOT NULL DEFAULT '',
+			questions JSONB NOT NULL,
+			answers JSONB NOT NULL DEFAULT '{}',
+			status VARCHAR(20) NOT NULL DEFAULT 'in_progress',
+			started_at TIMESTAMP NOT NULL,
+			deadline TIMESTAMP NOT NULL,
+			submitted_at TIMESTAMP,
+			score DOUBLE PRECISION NOT NULL DEFAULT 0,
+			max_score DOUBLE PRECISION NOT NULL DEFAULT 0,
+			results JSONB,
+			reveal VARCHAR(10) NOT NULL DEFAULT 'submit',
+			reveal_at TIMESTAMP
+		);`,
+		`CREATE INDEX IF NOT EXISTS exam_attempts_student ON exam_attempts (student_id, started_at);`,
+		`CREATE INDEX IF NOT EXISTS exam_attempts_exam ON exam_attempts (exam_id, student_id);`,
+		// The exam_attempts_open index is built by exams.Service, once it has
+		// graded older duplicate attempts in progress.
+		// Practice attempts never reveal their answers.
+		`UPDATE exam_attempts SET reveal = 'never' WHERE exam_id IS NULL AND reveal <> 'never';`,
+
+		// One-time copy of the old meeting chat (chat_messages) and discussion
+		// (chat_history) tables into messages, while messages is still empty.
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM messages) THEN
+				INSERT INTO messages (channel, sender_id, sender_name, kind, body, created_at)
+				SELECT 'meeting:' || COALESCE(room_id, 'default'), sender_id, sender_name, 'text', message, timestamp
+				FROM chat_messages ORDER BY timestamp;
+
+				IF to_regclass('chat_history') IS NOT NULL THEN
+					INSERT INTO messages (channel, sender_id, sender_name, kind, body, attachments, created_at)
+					SELECT 'discussion:general', sender, sender, type,
+						CASE WHEN type = 'audio' THEN '' ELSE content END,
+						CASE WHEN type = 'audio' THEN jsonb_build_array(jsonb_build_object('url', content)) ELSE '[]'::jsonb END,
+						timestamp
+					FROM chat_history ORDER BY timestamp;
+				END IF;
+			END IF;
+		END $$;`,
+
+		// The old discussion was one room with no course. Its messages move
+		// to the general channel of course "legacy", which staff can read
+		// and students can be enrolled in.
+		`DO $$
+		DECLARE legacy_id INT;
+		BEGIN
+			IF EXISTS (SELECT 1 FROM messages WHERE channel = 'discussion:general') THEN
+				INSERT INTO discussion_channels (course_code, slug, title, kind, created_by)
+				VALUES ('legacy', 'general', 'General (archived)', 'general', 'system')
+				ON CONFLICT (course_code, slug) DO NOTHING;
+				SELECT id INTO legacy_id FROM discussion_channels WHERE course_code = 'legacy' AND slug = 'general';
+
+				UPDATE messages SET channel = 'discussion:' || legacy_id WHERE channel = 'discussion:general';
+				UPDATE message_reports SET channel = 'discussion:' || legacy_id WHERE channel = 'discussion:general';
+				UPDATE channel_read_cursors SET channel = 'discussion:' || legacy_id WHERE channel = 'discussion:general';
+			END IF;
+		END $$;`,
 	}
 
 	for _, q := range queries {